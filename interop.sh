@@ -0,0 +1,782 @@
+#!/bin/sh
+#
+# Run the same operations with mygit and with git in throwaway repositories
+# and check that both produce the same objects and output, byte for byte.
+# The go tests compare against git through matchGit too, this script checks
+# the built binary end to end.
+#
+# Skipped when git is not on PATH. Usage: ./interop.sh
+
+set -e # Exit early if any commands fail
+
+if ! command -v git >/dev/null 2>&1; then
+  echo "git not found, skipping interop checks"
+  exit 0
+fi
+
+root="$(cd "$(dirname "$0")" && pwd)"
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+
+mygit="$work/mygit"
+(cd "$root" && go build -buildvcs="false" -o "$mygit" ./cmd/mygit)
+
+# keep the user's configuration out of the comparison
+export GIT_CONFIG_NOSYSTEM=1 HOME="$work"
+export GIT_AUTHOR_NAME="Interop" GIT_AUTHOR_EMAIL="interop@example.com"
+export GIT_COMMITTER_NAME="Interop" GIT_COMMITTER_EMAIL="interop@example.com"
+
+failures=0
+
+# check <name> <expected> <actual>
+check() {
+  if [ "$2" = "$3" ]; then
+    echo "ok   $1"
+  else
+    echo "FAIL $1"
+    echo "     git:   $2"
+    echo "     mygit: $3"
+    failures=$((failures + 1))
+  fi
+}
+
+# a worktree with the entries whose order or content is easy to get wrong:
+# names sorting differently as trees ("a" vs "a.b" and "a-b"), an empty file,
+# binary data with NUL bytes and CRs, no trailing newline, an executable and a
+# symlink, plus directories git leaves out for having no files
+repo="$work/repo"
+git init -q "$repo"
+cd "$repo"
+git config user.name "$GIT_AUTHOR_NAME"
+git config user.email "$GIT_AUTHOR_EMAIL"
+
+mkdir -p a/nested a-b
+echo "inside a" > a/file
+echo "deeper" > a/nested/file
+echo "dash" > a-b/file
+echo "dot" > a.b
+echo "dash file" > a-c
+: > empty
+printf 'no trailing newline' > partial
+printf 'bin\000ary\r\n\000\377data' > binary
+head -c 200000 /dev/urandom > large
+printf '#!/bin/sh\necho hi\n' > script
+chmod +x script
+ln -s a/file link
+mkdir -p hollow/nested kept
+: > kept/.gitkeep
+
+# hash-object
+for file in a/file empty partial binary large script; do
+  check "hash-object $file" "$(git hash-object "$file")" "$("$mygit" hash-object "$file")"
+done
+git init -q --object-format=sha256 "$work/sha256"
+cp large "$work/sha256/large"
+check "hash-object sha256" "$(git -C "$work/sha256" hash-object large)" \
+  "$(cd "$work/sha256" && "$mygit" hash-object large)"
+check "hash-object -w" "$(git hash-object large)" "$("$mygit" hash-object -w large)"
+check "cat-file -p blob" "$(git cat-file -p "$(git hash-object large)" | cksum)" \
+  "$("$mygit" cat-file -p "$(git hash-object large)" | cksum)"
+
+# write-tree, without an index mygit hashes the worktree while git hashes the
+# index so every file is added first
+actual_tree="$("$mygit" write-tree)"
+git add -A
+expected_tree="$(git write-tree)"
+check "write-tree" "$expected_tree" "$actual_tree"
+check "write-tree from the index" "$expected_tree" "$("$mygit" write-tree)"
+check "tree bytes" "$(git cat-file tree "$expected_tree" | cksum)" \
+  "$(git cat-file tree "$actual_tree" 2>/dev/null | cksum)"
+check "ls-tree" "$(git ls-tree "$expected_tree")" "$("$mygit" ls-tree "$expected_tree")"
+check "ls-tree --name-only" "$(git ls-tree --name-only "$expected_tree")" \
+  "$("$mygit" ls-tree --name-only "$expected_tree")"
+
+# unusual names are quoted C-style, bytes above 0x7f unless core.quotepath
+# is off
+quoted="$work/quoted"
+git init -q "$quoted"
+printf 'tab' > "$quoted/$(printf 'a\tb')"
+printf 'utf-8' > "$quoted/café"
+printf 'quote' > "$quoted/q\"uote"
+git -C "$quoted" add -A
+quoted_tree="$(git -C "$quoted" write-tree)"
+check "ls-tree quoted" "$(git -C "$quoted" ls-tree "$quoted_tree")" "$(cd "$quoted" && "$mygit" ls-tree "$quoted_tree")"
+git -C "$quoted" config core.quotepath false
+check "ls-tree core.quotepath=false" "$(git -C "$quoted" ls-tree "$quoted_tree")" \
+  "$(cd "$quoted" && "$mygit" ls-tree "$quoted_tree")"
+
+# add invalidates the cached trees of a/ and a/nested/ only, write-tree must
+# then leave the same index as git
+echo "changed" > a/nested/file
+"$mygit" add a/nested/file
+cp .git/index "$work/index"
+check "add then write-tree" "$(GIT_INDEX_FILE="$work/index" git write-tree)" "$("$mygit" write-tree)"
+check "index bytes" "$(cksum < "$work/index")" "$(cksum < .git/index)"
+echo "deeper" > a/nested/file
+git add -A
+
+# version 4 compresses each path against the previous one, version 3 adds
+# extended flags, both must be read and written back as they are
+git update-index --skip-worktree a.b
+for version in 3 4; do
+  git update-index --index-version "$version"
+  git write-tree > /dev/null
+  cp .git/index "$work/index"
+  check "write-tree index v$version" "$expected_tree" "$("$mygit" write-tree)"
+  check "index v$version bytes" "$(cksum < "$work/index")" "$(cksum < .git/index)"
+done
+git update-index --no-skip-worktree a.b
+git update-index --index-version 2
+
+# commit-tree, the commit is rebuilt by git with the same dates and must have
+# the same sha
+parent="$(git commit-tree -m "parent" "$expected_tree")"
+commit="$("$mygit" commit-tree "$expected_tree" -p "$parent" -m "interop commit")"
+author_date="$(git cat-file -p "$commit" | sed -n 's/^author .*> //p')"
+committer_date="$(git cat-file -p "$commit" | sed -n 's/^committer .*> //p')"
+expected_commit="$(GIT_AUTHOR_DATE="$author_date" GIT_COMMITTER_DATE="$committer_date" \
+  git commit-tree "$expected_tree" -p "$parent" -m "interop commit")"
+check "commit-tree" "$expected_commit" "$commit"
+check "cat-file -p commit" "$(git cat-file -p "$commit")" "$("$mygit" cat-file -p "$commit")"
+check "cat-file -t commit" "$(git cat-file -t "$commit")" "$("$mygit" cat-file -t "$commit")"
+
+# fixed dates, zones with minutes and the formats GIT_*_DATE accepts
+for date in "1700000000 +0530" "1700000000 -0930" "Thu, 07 Apr 2005 22:13:13 +0200" "2005-04-07T22:13:13-0130"; do
+  check "commit-tree at $date" \
+    "$(GIT_AUTHOR_DATE="$date" GIT_COMMITTER_DATE="$date" git commit-tree "$expected_tree" -m "dated")" \
+    "$(GIT_AUTHOR_DATE="$date" GIT_COMMITTER_DATE="$date" "$mygit" commit-tree "$expected_tree" -m "dated")"
+done
+
+# unpack-objects, every object of a pack written by git comes out loose
+git cat-file --batch-all-objects --batch-check='%(objectname)' | git pack-objects -q --stdout > "$work/all.pack"
+git init -q "$work/unpacked"
+(cd "$work/unpacked" && "$mygit" -q unpack-objects < "$work/all.pack")
+check "unpack-objects" "$(git cat-file --batch-all-objects --batch-check)" \
+  "$(git -C "$work/unpacked" cat-file --batch-all-objects --batch-check)"
+
+# cat-file --batch-check on packed objects, deltas included, whose type and
+# size come from their headers
+history="$work/history"
+git init -q "$history"
+for i in $(seq 1 30); do
+  seq 1 "$((i * 50))" > "$history/numbers"
+  git -C "$history" add numbers
+  git -C "$history" commit -qm "commit $i"
+done
+git -C "$history" repack -adfq
+check "cat-file --batch-check packed" "$(git -C "$history" cat-file --batch-all-objects --batch-check)" \
+  "$(cd "$history" && "$mygit" cat-file --batch-check --batch-all-objects)"
+check "stats commits" "$(git -C "$history" rev-list --all --count)" \
+  "$(cd "$history" && "$mygit" stats | sed -n 's/^commits: *//p')"
+
+# the refs a bare repository advertises, packed and annotated tags included
+git -C "$history" tag -a -m "annotated" v1 HEAD~3
+git -C "$history" branch side HEAD~1
+git clone -q --bare "$history" "$work/history.git"
+check "serve-head" "$(git -C "$work/history.git" ls-remote --symref .)" \
+  "$("$mygit" --git-dir="$work/history.git" serve-head)"
+git -C "$work/history.git" symbolic-ref HEAD refs/heads/side
+expected="$(git -C "$work/history.git" ls-remote --symref .)"
+git -C "$work/history.git" symbolic-ref HEAD refs/heads/master
+check "serve-head side" "$expected" "$("$mygit" --git-dir="$work/history.git" serve-head side)"
+
+# fast-export streams, annotated tag included, imported by the other tool
+git init -q "$work/imported-by-git"
+(cd "$history" && "$mygit" fast-export --all) | git -C "$work/imported-by-git" fast-import --quiet
+check "mygit fast-export | git fast-import" "$(git -C "$history" show-ref)" "$(git -C "$work/imported-by-git" show-ref)"
+git init -q "$work/imported-by-mygit"
+git -C "$history" fast-export --all | (cd "$work/imported-by-mygit" && "$mygit" -q fast-import >/dev/null)
+check "git fast-export | mygit fast-import" "$(git -C "$history" show-ref)" "$(git -C "$work/imported-by-mygit" show-ref)"
+
+# git cloning and fetching through mygit upload-pack, the fetch only gets
+# the commit, tree and blob the clone does not have
+git -c protocol.version=0 clone -q --no-local --upload-pack="$mygit upload-pack" "$history" "$work/served"
+check "clone over upload-pack" "$(git -C "$history" rev-parse master side v1)" \
+  "$(git -C "$work/served" rev-parse origin/master origin/side v1)"
+check "served clone fsck" "" "$(git -C "$work/served" fsck --full 2>&1)"
+echo "31" > "$history/numbers"
+git -C "$history" commit -qam "commit 31"
+git -C "$work/served" -c protocol.version=0 fetch -q --upload-pack="$mygit upload-pack"
+check "fetch over upload-pack" "$(git -C "$history" rev-parse HEAD)" "$(git -C "$work/served" rev-parse origin/master)"
+check "fetched objects" "3" "$(git -C "$work/served" count-objects | cut -d' ' -f1)"
+
+# protocol v2, ls-refs filtered by ref prefixes as git upload-pack does it,
+# then a clone and a fetch of one branch
+request='0014command=ls-refs\n00010009peel\n000csymrefs\n001aref-prefix refs/tags/\n001cref-prefix refs/heads/s\n0000'
+check "ls-refs ref-prefix" \
+  "$(printf "$request" | GIT_PROTOCOL=version=2 git upload-pack "$history" | grep -a ' refs/')" \
+  "$(printf "$request" | GIT_PROTOCOL=version=2 "$mygit" upload-pack "$history" | grep -a ' refs/')"
+git -c protocol.version=2 clone -q --no-local --upload-pack="$mygit upload-pack" "$history" "$work/served-v2"
+check "clone over protocol v2" "$(git -C "$history" rev-parse master side v1)" \
+  "$(git -C "$work/served-v2" rev-parse origin/master origin/side v1)"
+git -C "$history" branch -q v2-fetch master
+git -C "$work/served-v2" -c protocol.version=2 fetch -q --upload-pack="$mygit upload-pack" origin v2-fetch
+check "fetch over protocol v2" "$(git -C "$history" rev-parse v2-fetch)" "$(git -C "$work/served-v2" rev-parse FETCH_HEAD)"
+git -C "$history" branch -q -D v2-fetch
+
+# a bundle written by git is cloned by mygit with the refs git clone writes,
+# then one written by mygit is read back by git
+git -C "$history" bundle create -q "$work/history.bundle" --all
+"$mygit" -q clone "$work/history.bundle" "$work/from-bundle"
+git clone -q "$work/history.bundle" "$work/git-from-bundle"
+check "clone from bundle" "$(git -C "$work/git-from-bundle" for-each-ref --format='%(objectname) %(refname)' refs/heads refs/remotes/origin/master refs/remotes/origin/side refs/tags)" \
+  "$(git -C "$work/from-bundle" for-each-ref --format='%(objectname) %(refname)' refs/heads refs/remotes/origin/master refs/remotes/origin/side refs/tags)"
+check "bundle clone worktree" "$(cat "$history/numbers")" "$(cat "$work/from-bundle/numbers")"
+(cd "$history" && "$mygit" bundle create "$work/mygit.bundle" master side)
+check "bundle verify" "$(git -C "$history" bundle list-heads "$work/history.bundle" refs/heads/master refs/heads/side)" \
+  "$(cd "$history" && git bundle verify -q "$work/mygit.bundle" > /dev/null 2>&1 && git bundle list-heads "$work/mygit.bundle")"
+
+# the same repository over smart HTTP, cloned by git and by mygit
+"$mygit" serve-http --listen 127.0.0.1:0 "$history" 2> "$work/serve.log" &
+server=$!
+for _ in $(seq 1 50); do
+  url="$(sed -n 's|^Serving .* on \(http://.*\)$|\1|p' "$work/serve.log")"
+  [ -n "$url" ] && break
+  sleep 0.1
+done
+git clone -q "$url" "$work/http-git"
+(cd "$work" && "$mygit" -q clone "$url" http-mygit)
+# a clone failing after the repository was created, a missing branch, leaves
+# nothing behind unless --keep, and the next clone rolls the leftover back
+(cd "$work" && "$mygit" -q clone --branch missing "$url" http-failed 2>/dev/null) || true
+check "failed clone cleaned up" "absent" "$([ -e "$work/http-failed" ] && echo present || echo absent)"
+(cd "$work" && "$mygit" -q clone --keep --branch missing "$url" http-failed 2>/dev/null) || true
+check "failed clone kept" "present" "$([ -e "$work/http-failed/.git/clone-in-progress" ] && echo present || echo absent)"
+(cd "$work" && "$mygit" -q clone "$url" http-failed)
+check "clone after failed clone" "$(git -C "$history" rev-parse master) absent" \
+  "$(git -C "$work/http-failed" rev-parse HEAD) $([ -e "$work/http-failed/.git/clone-in-progress" ] && echo present || echo absent)"
+# the clone follows the annotated tag include-tag sent, then fetch asks for
+# a new one pointing to a commit it has already
+check "mygit clone follows tags" "$(git -C "$history" show-ref --tags)" "$(git -C "$work/http-mygit" show-ref --tags)"
+git -C "$history" tag -a -m "later" later HEAD~1
+# FETCH_HEAD lists the branch the current one merges from first, like git's
+git -C "$work/http-mygit" config branch.master.remote origin
+git -C "$work/http-mygit" config branch.master.merge refs/heads/master
+git -C "$work/http-git" fetch -q
+(cd "$work/http-mygit" && "$mygit" -q fetch)
+check "FETCH_HEAD" "$(cat "$work/http-git/.git/FETCH_HEAD")" "$(cat "$work/http-mygit/.git/FETCH_HEAD")"
+check "fetch follows tags" "$(git -C "$history" show-ref --tags)" "$(git -C "$work/http-mygit" show-ref --tags)"
+git -C "$history" tag -d later >/dev/null
+check "FETCH_HEAD resolved" "$(git -C "$history" cat-file -p master)" "$(cd "$work/http-mygit" && "$mygit" cat-file -p FETCH_HEAD)"
+# remote-head against git's reading of the symref, before and after HEAD moves
+for branch in master side; do
+  git -C "$history" symbolic-ref HEAD "refs/heads/$branch"
+  check "remote-head $branch" "$(git ls-remote --symref "$url" HEAD | sed -n 's|^ref: refs/heads/\(.*\)	HEAD$|\1|p')" \
+    "$("$mygit" remote-head "$url")"
+done
+git -C "$history" symbolic-ref HEAD refs/heads/master
+kill "$server"
+check "clone over http" "$(git -C "$history" rev-parse master side v1)" \
+  "$(git -C "$work/http-git" rev-parse origin/master origin/side v1)"
+check "mygit clone over http" "$(git -C "$history" rev-parse master)" "$(git -C "$work/http-mygit" rev-parse HEAD)"
+
+# verify-worktree, against the commit checked out and then with local edits
+(cd "$work/served" && "$mygit" verify-worktree >/dev/null && echo "exit 0" || echo "exit $?") > "$work/verify"
+echo "local" > "$work/served/extra"
+echo "1" > "$work/served/numbers"
+(cd "$work/served" && "$mygit" verify-worktree && echo "exit 0" || echo "exit $?") >> "$work/verify"
+check "verify-worktree" "$(printf 'exit 0\nA\textra\nM\tnumbers\nexit 1')" "$(cat "$work/verify")"
+
+# well-known objects, hashed rather than hardcoded
+check "well-known empty-tree" "$(git hash-object -t tree /dev/null)" "$("$mygit" well-known empty-tree)"
+check "well-known empty-blob" "$(git hash-object -t blob /dev/null)" "$("$mygit" well-known empty-blob)"
+
+# merge-file, the exit code is the number of conflicts
+merge="$work/merge"
+mkdir "$merge"
+seq 1 20 > "$merge/base"
+sed 's/^3$/three/; 10s/.*/ten-ours/; 17s/.*/seventeen-ours/' "$merge/base" > "$merge/ours"
+sed '6d; 10s/.*/ten-theirs/; 17s/.*/seventeen-theirs/' "$merge/base" > "$merge/theirs"
+sed 's/^12$/twelve/' "$merge/base" > "$merge/clean"
+for other in clean theirs; do
+  expected="$(cd "$merge" && git merge-file -p ours base "$other" && echo "exit 0" || echo "exit $?")"
+  actual="$(cd "$merge" && "$mygit" merge-file -p ours base "$other" && echo "exit 0" || echo "exit $?")"
+  check "merge-file $other" "$expected" "$actual"
+done
+
+# apply with git diff --binary patches, a delta to an image-like blob, a
+# created and a deleted file, then reversed back to the original
+binary="$work/binary"
+git init -q "$binary"
+head -c 5000 /dev/urandom > "$binary/image.png"
+printf 'old\0binary' > "$binary/deleted.bin"
+git -C "$binary" add .
+git -C "$binary" commit -qm "binary files"
+{ head -c 2000 "$binary/image.png"; printf 'edited\0\1\2'; tail -c 2900 "$binary/image.png"; } > "$binary/edited"
+mv "$binary/edited" "$binary/image.png"
+head -c 300 /dev/urandom > "$binary/created.bin"
+git -C "$binary" rm -q deleted.bin
+git -C "$binary" add .
+git -C "$binary" diff --cached --binary > "$work/binary.patch"
+expected="$(git -C "$binary" write-tree)"
+git -C "$binary" reset -q --hard
+(cd "$binary" && "$mygit" apply "$work/binary.patch")
+git -C "$binary" add -A
+check "apply binary patch" "$expected" "$(git -C "$binary" write-tree)"
+(cd "$binary" && "$mygit" apply -R "$work/binary.patch")
+git -C "$binary" add -A
+check "apply binary patch reversed" "$(git -C "$binary" rev-parse HEAD^{tree})" "$(git -C "$binary" write-tree)"
+
+# ahead-behind over a diverged history, one side having merged the other once
+diverged="$work/diverged"
+git init -q "$diverged"
+git -C "$diverged" commit -q --allow-empty -m base
+git -C "$diverged" branch other
+for i in 1 2 3; do git -C "$diverged" commit -q --allow-empty -m "ours $i"; done
+git -C "$diverged" checkout -q other
+for i in 1 2; do git -C "$diverged" commit -q --allow-empty -m "theirs $i"; done
+git -C "$diverged" checkout -q master
+git -C "$diverged" merge -q --no-edit other
+git -C "$diverged" checkout -q other
+git -C "$diverged" commit -q --allow-empty -m "theirs 3"
+for range in "master other" "other master" "master master"; do
+  set -- $range
+  check "ahead-behind $1 $2" "$(git -C "$diverged" rev-list --left-right --count "$1...$2")" \
+    "$(cd "$diverged" && "$mygit" ahead-behind "$1" "$2")"
+done
+
+# rewrite-identities against git filter-branch with the same mapping, the
+# trees are kept while the commits by the old email get new shas
+rewrite="$work/rewrite"
+git clone -q -b master "$diverged" "$rewrite"
+git -C "$rewrite" branch -q other origin/other
+git clone -q -b master "$rewrite" "$work/filtered"
+git -C "$work/filtered" branch -q other origin/other
+email="$(git -C "$rewrite" log -1 --format=%ae)"
+printf 'Renamed <renamed@example.com> <%s>\n' "$email" > "$work/rewrite.mailmap"
+(cd "$rewrite" && "$mygit" -q rewrite-identities "$work/rewrite.mailmap" > "$work/rewrite.map")
+FILTER_BRANCH_SQUELCH_WARNING=1 git -C "$work/filtered" filter-branch -f --env-filter "
+  if [ \"\$GIT_AUTHOR_EMAIL\" = '$email' ]; then GIT_AUTHOR_NAME=Renamed GIT_AUTHOR_EMAIL=renamed@example.com; fi
+  if [ \"\$GIT_COMMITTER_EMAIL\" = '$email' ]; then GIT_COMMITTER_NAME=Renamed GIT_COMMITTER_EMAIL=renamed@example.com; fi
+" -- master other > /dev/null 2>&1
+check "rewrite-identities" "$(git -C "$work/filtered" rev-parse master other)" "$(git -C "$rewrite" rev-parse master other)"
+check "rewrite-identities trees" "$(git -C "$diverged" log --format=%T master)" "$(git -C "$rewrite" log --format=%T master)"
+check "rewrite-identities map" "$(git -C "$diverged" rev-list --all | wc -l)" "$(wc -l < "$work/rewrite.map")"
+
+# locks, a stale index.lock is reported and removed with --force while a
+# fresh one, as a running git would hold, is kept and stops writers
+locks="$work/locks"
+git init -q "$locks"
+echo "locked" > "$locks/file"
+touch -d "2 hours ago" "$locks/.git/index.lock"
+touch "$locks/.git/config.lock"
+(cd "$locks" && "$mygit" locks && echo "exit 0" || echo "exit $?") > "$work/locks.out"
+(cd "$locks" && "$mygit" -q locks --force && echo "exit 0" || echo "exit $?") >> "$work/locks.out"
+check "locks" "$(printf 'stale\t2h0m0s\t.git/index.lock\nheld\t0s\t.git/config.lock\nexit 1\nstale\t2h0m0s\t.git/index.lock\nheld\t0s\t.git/config.lock\nexit 0')" \
+  "$(cat "$work/locks.out")"
+(cd "$locks" && "$mygit" sparse-checkout set dir 2>/dev/null && echo "written" || echo "refused") > "$work/locks.out"
+touch "$locks/.git/index.lock"
+(cd "$locks" && "$mygit" add file 2>/dev/null && echo "written" || echo "refused") >> "$work/locks.out"
+check "held locks respected" "$(printf 'refused\nrefused')" "$(cat "$work/locks.out")"
+
+# the capabilities mygit sends when cloning, as the server logs them, then a
+# server advertising sha256 which mygit refuses before writing anything
+formats="$work/formats"
+git init -q "$formats"
+git -C "$formats" commit -q --allow-empty -m "formats"
+"$mygit" -v serve-http --listen 127.0.0.1:0 "$formats" 2> "$work/formats.log" &
+server=$!
+for _ in $(seq 1 50); do
+  url="$(sed -n 's|^Serving .* on \(http://.*\)$|\1|p' "$work/formats.log")"
+  [ -n "$url" ] && break
+  sleep 0.1
+done
+(cd "$work" && "$mygit" -q clone "$url" formats-sha1)
+check "clone capabilities" "agent=mygit/0.1 object-format=sha1" \
+  "$(sed -n 's/^Client capabilities: .*\(agent=[^ ]*\) \(object-format=[^ ]*\).*$/\1 \2/p' "$work/formats.log")"
+git -C "$formats" config core.repositoryformatversion 1
+git -C "$formats" config extensions.objectformat sha256
+actual="$(cd "$work" && "$mygit" -q clone "$url" formats-sha256 2>&1 || true)"
+kill "$server"
+check "clone sha256 refused" \
+  "Error for clone: Object format is not supported.: the remote repository uses sha256, only sha1 repositories can be fetched absent" \
+  "$actual $([ -e "$work/formats-sha256" ] && echo present || echo absent)"
+
+# convert-layout from the deltified packs of git to loose objects, to a
+# single pack git reads back, and loose again without losing any object
+layout="$work/layout"
+git clone -q --bare --no-local "$history" "$layout"
+git -C "$layout" cat-file --batch-all-objects --batch-check > "$work/layout.before"
+"$mygit" -q --git-dir="$layout" convert-layout --to=loose
+check "convert-layout loose" "0 $(wc -l < "$work/layout.before")" \
+  "$(ls "$layout/objects/pack" | wc -l) $(find "$layout/objects" -type f | wc -l)"
+"$mygit" -q --git-dir="$layout" convert-layout --to=packed
+check "convert-layout packed" "0 $(wc -l < "$work/layout.before")" \
+  "$(find "$layout/objects" -path '*/pack' -prune -o -type f -print | wc -l) $(git -C "$layout" verify-pack -v "$layout"/objects/pack/*.idx | grep -c ' [0-9]* [0-9]* [0-9]*$')"
+check "convert-layout packed fsck" "" "$(git -C "$layout" fsck --strict 2>&1)"
+"$mygit" -q --git-dir="$layout" convert-layout --to=loose
+check "convert-layout round trip" "$(cat "$work/layout.before")" "$(git -C "$layout" cat-file --batch-all-objects --batch-check)"
+
+# log -- <path> over a history where only some commits touch each path,
+# with a merged side branch, dates apart so both walks order alike
+scoped="$work/scoped"
+git init -q "$scoped"
+tick=1700000000
+commit() {
+  tick=$((tick + 60))
+  GIT_AUTHOR_DATE="@$tick +0000" GIT_COMMITTER_DATE="@$tick +0000" git -C "$scoped" "$@"
+}
+mkdir "$scoped/dir"
+echo a > "$scoped/a"; echo x > "$scoped/dir/x"; git -C "$scoped" add .; commit commit -qm "add a and dir/x"
+echo b > "$scoped/b"; git -C "$scoped" add .; commit commit -qm "add b"
+echo y >> "$scoped/dir/x"; commit commit -qam "edit dir/x"
+git -C "$scoped" checkout -qb side
+echo s >> "$scoped/a"; commit commit -qam "edit a on side"
+echo t > "$scoped/b"; commit commit -qam "edit b on side"
+git -C "$scoped" checkout -q master
+echo z > "$scoped/dir/z"; git -C "$scoped" add .; commit commit -qm "add dir/z"
+commit merge -q --no-edit side
+echo aa >> "$scoped/a"; commit commit -qam "edit a"
+for paths in "a" "dir" "dir/" "b dir/z" "dir/x a" "missing"; do
+  check "log -- $paths" "$(git -C "$scoped" log --format='%h %s' -- $paths)" \
+    "$(cd "$scoped" && "$mygit" log --oneline -- $paths)"
+done
+
+# log --follow over a file renamed twice, once into a directory, with its
+# content unchanged by the renames
+git -C "$scoped" mv a renamed
+commit commit -qm "rename a"
+echo more >> "$scoped/renamed"; commit commit -qam "edit renamed"
+git -C "$scoped" mv renamed dir/moved
+commit commit -qm "move renamed into dir"
+check "log --follow" "$(git -C "$scoped" log --follow --format='%h %s' -- dir/moved)" \
+  "$(cd "$scoped" && "$mygit" log --follow --oneline -- dir/moved)"
+check "log without --follow" "1" "$(cd "$scoped" && "$mygit" log --oneline -- dir/moved | wc -l)"
+
+# diff and diff --cached with a change staged and another made on top of it,
+# a staged new file, an unstaged deletion and a mode change
+staged="$work/staged"
+git init -q "$staged"
+seq 1 20 > "$staged/numbers"
+mkdir "$staged/dir"
+echo x > "$staged/dir/x"
+echo gone > "$staged/gone"
+git -C "$staged" add .
+git -C "$staged" commit -qm "numbers"
+sed -i 's/^5$/five/' "$staged/numbers"
+git -C "$staged" add numbers
+sed -i 's/^15$/fifteen/' "$staged/numbers"
+echo new > "$staged/dir/new"
+git -C "$staged" add dir/new
+rm "$staged/gone"
+chmod +x "$staged/dir/x"
+for args in "--cached" "" "-- dir"; do
+  check "diff $args" "$(git -C "$staged" diff $args)" "$(cd "$staged" && "$mygit" diff $args)"
+done
+check "diff --cached shows staged only" "-5 +five" \
+  "$(cd "$staged" && "$mygit" diff --cached | grep '^[-+][0-9f]' | tr '\n' ' ' | sed 's/ $//')"
+
+# whitespace: a change of indentation and trailing spaces hidden by diff -w,
+# the amount of it by -b, and apply fixing or refusing added trailing spaces
+git -C "$staged" add -A
+git -C "$staged" commit -qm "staged"
+sed -i 's/^2$/  2  /; s/^3$/3 \t/; s/^7$/seven/' "$staged/numbers"
+printf 'x \n' > "$staged/dir/x"
+for args in "-w" "-b" "--ignore-all-space" ""; do
+  check "diff $args" "$(git -C "$staged" diff $args)" "$(cd "$staged" && "$mygit" diff $args)"
+done
+check "diff -w hides whitespace-only files" "numbers" \
+  "$(cd "$staged" && "$mygit" diff -w | sed -n 's|^diff --git a/\([^ ]*\) .*|\1|p')"
+git -C "$staged" diff > "$work/whitespace.patch"
+git -C "$staged" checkout -q .
+for action in fix error; do
+  expected="$(cd "$staged" && git apply --whitespace=$action "$work/whitespace.patch" 2>/dev/null || true; git diff; git checkout -q .)"
+  actual="$(cd "$staged" && "$mygit" apply --whitespace=$action "$work/whitespace.patch" 2>/dev/null || true; git diff; git checkout -q .)"
+  check "apply --whitespace=$action" "$expected" "$actual"
+done
+
+# pack-objects over twenty versions of a file stored as deltas of each other,
+# the pack is far smaller, git indexes it and every object reads back the same
+similar="$work/similar"
+git init -q "$similar"
+seq 1 2000 > "$similar/numbers"
+for i in $(seq 1 20); do
+  sed -i "$((i * 50))s/.*/edit $i/" "$similar/numbers"
+  git -C "$similar" add numbers
+  git -C "$similar" commit -qm "edit $i"
+done
+git -C "$similar" rev-list --objects --all > "$work/similar.objects"
+"$mygit" -q --git-dir="$similar/.git" pack-objects --window=0 "$work/whole" < "$work/similar.objects" > /dev/null
+"$mygit" -q --git-dir="$similar/.git" pack-objects --depth=5 "$work/deltas" < "$work/similar.objects" > /dev/null
+whole_size="$(cat "$work"/whole-*.pack | wc -c)"
+deltas_size="$(cat "$work"/deltas-*.pack | wc -c)"
+check "pack-objects deltas smaller" "yes" "$([ "$((deltas_size * 4))" -lt "$whole_size" ] && echo yes || echo "no: $deltas_size of $whole_size")"
+git verify-pack -v "$work"/deltas-*.idx > "$work/deltas.verify"
+check "pack-objects deltas verified by git" "$(wc -l < "$work/similar.objects")" \
+  "$(grep -c '^[0-9a-f]\{40\} ' "$work/deltas.verify")"
+check "pack-objects depth" "chain length = 5" "$(sed -n 's/^\(chain length = [0-9]*\):.*/\1/p' "$work/deltas.verify" | tail -1)"
+git init -q "$work/unpacked-similar"
+(cd "$work/unpacked-similar" && "$mygit" -q unpack-objects "$work"/deltas-*.pack)
+check "pack-objects deltas read back" "$(git -C "$similar" cat-file --batch-all-objects --batch-check)" \
+  "$(git -C "$work/unpacked-similar" cat-file --batch-all-objects --batch-check)"
+
+# compare-packs, git's pack of ref-deltas holds the same objects as the
+# whole and ofs-delta ones, one with objects left out does not
+git -C "$similar" pack-objects -q "$work/git-similar" < "$work/similar.objects" > /dev/null
+check "compare-packs repacked" "exit 0" \
+  "$("$mygit" -q compare-packs "$work"/git-similar-*.pack "$work"/deltas-*.pack && echo "exit 0" || echo "exit $?")"
+check "compare-packs whole" "exit 0" \
+  "$("$mygit" -q compare-packs "$work"/whole-*.pack "$work"/git-similar-*.pack && echo "exit 0" || echo "exit $?")"
+head -n 3 "$work/similar.objects" | git -C "$similar" pack-objects -q "$work/partial" > /dev/null
+check "compare-packs missing objects" "$(tail -n +4 "$work/similar.objects" | cut -d' ' -f1 | sort | sed 's/^/-/')
+exit 1" "$("$mygit" -q compare-packs "$work"/deltas-*.pack "$work"/partial-*.pack && echo "exit 0" || echo "exit $?")"
+
+# branch -d deletes a branch merged into HEAD, refuses one with commits of
+# its own until -D, and never deletes the checked out branch
+branches="$work/branches"
+git init -q "$branches"
+git -C "$branches" commit -q --allow-empty -m base
+git -C "$branches" branch merged
+git -C "$branches" checkout -q -b unmerged
+git -C "$branches" commit -q --allow-empty -m "work in progress"
+git -C "$branches" checkout -q master
+check "branch" "$(git -C "$branches" branch)" "$(cd "$branches" && "$mygit" branch)"
+check "branch -d merged" "Deleted branch merged (was $(git -C "$branches" rev-parse --short merged))." \
+  "$(cd "$branches" && "$mygit" branch -d merged)"
+check "branch -d unmerged refused" "refused $(git -C "$branches" rev-parse unmerged)" \
+  "$(cd "$branches" && "$mygit" -q branch -d unmerged 2>/dev/null || echo refused) $(git -C "$branches" rev-parse unmerged)"
+check "branch -d checked out refused" "refused" "$(cd "$branches" && "$mygit" -q branch -D master 2>/dev/null || echo refused)"
+check "branch -D unmerged" "Deleted branch unmerged (was $(git -C "$branches" rev-parse --short unmerged))." \
+  "$(cd "$branches" && "$mygit" branch -D unmerged)"
+check "branch after deletes" "* master" "$(git -C "$branches" branch)"
+
+# introduced-objects of a commit adding one file: its blob and the trees up
+# to the root, nothing of the untouched directories
+git -C "$branches" commit -q --allow-empty -m "empty"
+mkdir -p "$branches/dir/sub" "$branches/other"
+echo kept > "$branches/other/file"
+echo old > "$branches/dir/sub/old"
+git -C "$branches" add .
+git -C "$branches" commit -qm "directories"
+echo new > "$branches/dir/sub/new"
+git -C "$branches" add .
+git -C "$branches" commit -qm "add one file"
+check "introduced-objects" "$(git -C "$branches" rev-list --objects HEAD --not HEAD^ | tail -n +2)" \
+  "$(cd "$branches" && "$mygit" introduced-objects HEAD)"
+check "introduced-objects one file" " dir dir/sub dir/sub/new" \
+  "$(cd "$branches" && "$mygit" introduced-objects HEAD | cut -d' ' -f2 | tr '\n' ' ' | sed 's/ $//')"
+check "introduced-objects root" "$(git -C "$branches" rev-list --objects HEAD~3 | tail -n +2)" \
+  "$(cd "$branches" && "$mygit" introduced-objects "$(git -C "$branches" rev-parse HEAD~3)")"
+
+# manifest of a nested tree, "dir.txt" sorting before "dir/" like in the index
+echo text > "$branches/dir.txt"
+printf '#!/bin/sh\n' > "$branches/run.sh"
+chmod +x "$branches/run.sh"
+ln -s dir/sub/old "$branches/link"
+git -C "$branches" add .
+git -C "$branches" commit -qm "manifest"
+check "manifest" "$(git -C "$branches" ls-tree -r --format='%(objectmode) %(objectname)%x09%(path)' HEAD)" \
+  "$(cd "$branches" && "$mygit" manifest HEAD)"
+check "manifest --null" "$(git -C "$branches" ls-tree -r -z --format='%(objectmode) %(objectname)%x09%(path)' HEAD | tr '\0' '|')" \
+  "$(cd "$branches" && "$mygit" manifest --null HEAD | tr '\0' '|')"
+
+# write-tree without an index hashes the files of each directory on -j
+# workers or mygit.jobs ones, the tree is the same whatever their number
+jobs="$work/jobs"
+mkdir -p "$jobs/a/b"
+for i in $(seq 1 40); do echo "$i" > "$jobs/f$i"; echo "$i" > "$jobs/a/b/g$i"; done
+git init -q "$work/jobs-git"
+expected="$(cp -r "$jobs/a" "$jobs"/f* "$work/jobs-git" && git -C "$work/jobs-git" add . && git -C "$work/jobs-git" write-tree)"
+git init -q "$jobs"
+for n in 1 4; do
+  check "write-tree -j $n" "$expected" "$(cd "$jobs" && "$mygit" -j "$n" write-tree --dry-run)"
+done
+check "write-tree mygit.jobs" "$expected" "$(cd "$jobs" && git config mygit.jobs 3 && "$mygit" write-tree --dry-run)"
+check "invalid mygit.jobs" "refused" \
+  "$(cd "$jobs" && git config mygit.jobs many && "$mygit" -q write-tree --dry-run 2>/dev/null || echo refused)"
+
+# check-ref-format against git, the answer is the exit code
+for refname in refs/heads/main main @ refs/heads/a..b refs/heads/.hidden refs/heads/x.lock \
+  "refs/heads/a b" "refs/heads/a@{1}" refs/heads/end. refs/heads/ "refs/*/x" "refs/heads/a^" "refs//heads"; do
+  for opts in "" --allow-onelevel --refspec-pattern; do
+    check "check-ref-format $opts $refname" "$(git check-ref-format $opts "$refname" && echo valid || echo invalid)" \
+      "$("$mygit" check-ref-format $opts "$refname" && echo valid || echo invalid)"
+  done
+done
+check "check-ref-format --normalize" "$(git check-ref-format --normalize //refs//heads//main)" \
+  "$("$mygit" check-ref-format --normalize //refs//heads//main)"
+check "branch with an invalid name refused" "refused" \
+  "$(cd "$branches" && "$mygit" -q branch "bad..name" 2>/dev/null || echo refused)"
+check "update-ref with an invalid name refused" "refused" \
+  "$(cd "$branches" && "$mygit" -q update-ref "refs/heads/a@{1}" HEAD 2>/dev/null || echo refused)"
+
+# commit-tree -s adds the sign-off like git commit -s: into an existing
+# trailer block without a second blank line, once, and after a blank line
+# when there is no block
+signoff="$work/signoff"
+git init -q "$signoff"
+empty_tree="$(git -C "$signoff" write-tree)"
+for msg in "subject" "subject
+
+Acked-by: Someone <someone@example.com>" "subject
+
+body
+
+Signed-off-by: Interop <interop@example.com>" "subject
+
+Signed-off-by: Interop <interop@example.com>
+Acked-by: Someone <someone@example.com>"; do
+  git -C "$signoff" commit -q --allow-empty -s -m "$msg"
+  check "commit-tree -s $(echo "$msg" | tail -n 1)" "$(git -C "$signoff" cat-file commit HEAD | sed '1,/^$/d')" \
+    "$(cd "$signoff" && git cat-file commit "$("$mygit" commit-tree "$empty_tree" -s -m "$msg")" | sed '1,/^$/d')"
+done
+printf '# not in the message\nTemplate subject\n\n\nTemplate body\n' > "$work/template"
+git -C "$signoff" config commit.template "$work/template"
+check "commit-tree commit.template" "$(printf 'Template subject\n\nTemplate body')" \
+  "$(cd "$signoff" && git cat-file commit "$("$mygit" commit-tree "$empty_tree")" | sed '1,/^$/d')"
+
+# reachability-path walks from the refs to a nested blob, through the trees
+# holding it, and tells when nothing reaches an object
+reach="$work/reach"
+git init -q "$reach"
+mkdir -p "$reach/a/b"
+echo deep > "$reach/a/b/file"
+git -C "$reach" add .
+git -C "$reach" commit -qm "nested"
+git -C "$reach" branch -q topic
+git -C "$reach" checkout -q --detach
+git -C "$reach" branch -q -D master
+check "reachability-path nested blob" \
+  "refs/heads/topic -> commit $(git -C "$reach" rev-parse topic) -> tree $(git -C "$reach" rev-parse topic^{tree}) -> tree $(git -C "$reach" rev-parse topic:a) (a) -> tree $(git -C "$reach" rev-parse topic:a/b) (a/b) -> blob $(git -C "$reach" rev-parse topic:a/b/file) (a/b/file)" \
+  "$(cd "$reach" && "$mygit" reachability-path "$(git rev-parse topic:a/b/file)")"
+lost="$(echo lost | git -C "$reach" hash-object -w --stdin)"
+check "reachability-path unreachable" "unreachable" "$(cd "$reach" && "$mygit" reachability-path "$lost" || true)"
+
+# a conflicted merge leaves stages 1 to 3 in the index, ls-files shows them
+# like git, write-tree refuses them and add resolves the path
+conflict="$work/conflict"
+git init -q -b main "$conflict"
+echo base > "$conflict/f"
+echo base > "$conflict/g"
+git -C "$conflict" add .
+git -C "$conflict" commit -qm base
+git -C "$conflict" checkout -qb other
+echo theirs > "$conflict/f"
+git -C "$conflict" rm -q g
+git -C "$conflict" commit -qam theirs
+git -C "$conflict" checkout -q main
+echo ours > "$conflict/f"
+echo ours > "$conflict/g"
+git -C "$conflict" commit -qam ours
+git -C "$conflict" merge -q other > /dev/null 2>&1 || true
+for opts in "" -s -u; do
+  check "ls-files $opts with conflicts" "$(git -C "$conflict" ls-files $opts)" "$(cd "$conflict" && "$mygit" ls-files $opts)"
+done
+check "write-tree with conflicts refused" "refused" \
+  "$(cd "$conflict" && "$mygit" -q write-tree --dry-run 2>/dev/null || echo refused)"
+cp "$conflict/.git/index" "$work/conflict-index"
+git -C "$conflict" add f
+expected="$(git -C "$conflict" ls-files -s)"
+cp "$work/conflict-index" "$conflict/.git/index"
+check "add resolves a conflict" "$expected" "$(cd "$conflict" && "$mygit" add f && git ls-files -s)"
+
+# checkout --ours/--theirs puts that side in the worktree and stages it
+# resolved, a side the path lacks is refused
+for side in ours:2 theirs:3; do
+  cp "$work/conflict-index" "$conflict/.git/index"
+  sha="$(git -C "$conflict" rev-parse ":${side#*:}:f")"
+  check "checkout --${side%:*}" "$(printf "100644 %s 0\tf\n%s" "$sha" "$sha")" \
+    "$(cd "$conflict" && "$mygit" -q checkout "--${side%:*}" f && git ls-files -s f && git hash-object f)"
+done
+check "checkout --theirs without their side refused" "refused" \
+  "$(cd "$conflict" && "$mygit" -q checkout --theirs g 2>/dev/null || echo refused)"
+
+# reflog reads git's reflogs, newest first, and --json gives each entry as a
+# record with the operation read from its message
+reflog="$work/reflog"
+git init -q -b main "$reflog"
+echo one > "$reflog/f"
+git -C "$reflog" add f
+git -C "$reflog" commit -qm one
+echo two > "$reflog/f"
+git -C "$reflog" commit -qam two
+git -C "$reflog" checkout -qb topic
+git -C "$reflog" commit -q --amend -m "two amended"
+git -C "$reflog" reset -q --hard HEAD~1
+echo three > "$reflog/g"
+git -C "$reflog" add g
+git -C "$reflog" commit -qm three
+git -C "$reflog" checkout -q main
+git -C "$reflog" merge -q topic
+git -C "$reflog" update-ref -m "custom move" HEAD HEAD~1
+check "reflog" "$(git -C "$reflog" reflog show --no-abbrev)" "$(cd "$reflog" && "$mygit" reflog)"
+check "reflog show topic" "$(git -C "$reflog" reflog show --no-abbrev topic)" "$(cd "$reflog" && "$mygit" reflog show topic)"
+check "reflog --json operations" "other merge checkout commit reset commit checkout commit commit" \
+  "$(cd "$reflog" && "$mygit" --json reflog | grep '"operation"' | cut -d'"' -f4 | tr '\n' ' ' | sed 's/ $//')"
+check "reflog --json new" "$(git -C "$reflog" reflog --format=%H)" \
+  "$(cd "$reflog" && "$mygit" --json reflog | grep '"new"' | cut -d'"' -f4)"
+check "reflog --json committer" "$GIT_COMMITTER_NAME $GIT_COMMITTER_EMAIL" \
+  "$(cd "$reflog" && "$mygit" --json reflog | grep -m 2 -E '"(name|email)"' | cut -d'"' -f4 | tr '\n' ' ' | sed 's/ $//')"
+
+# pathspecs are relative to the directory mygit runs from, ":/" and
+# ":(top)" are from the root and wildcards match like git's
+for repo in pathspec-git pathspec; do
+  git init -q "$work/$repo"
+  mkdir -p "$work/$repo/src/lib" "$work/$repo/docs"
+  echo a > "$work/$repo/src/a.c"
+  echo b > "$work/$repo/src/lib/b.c"
+  echo c > "$work/$repo/src/lib/c.h"
+  echo d > "$work/$repo/docs/d.md"
+  echo t > "$work/$repo/top.c"
+done
+pathspec_git="$work/pathspec-git"
+pathspec="$work/pathspec"
+for specs in "lib/b.c :/docs" "*.h" "../top.c" ":(top,glob)src/*.c"; do
+  check "add $specs from a subdirectory" "$(cd "$pathspec_git/src" && git add $specs && git ls-files -s)" \
+    "$(cd "$pathspec/src" && "$mygit" add $specs && git ls-files -s)"
+done
+echo more >> "$pathspec_git/top.c"
+echo more >> "$pathspec/top.c"
+check "diff :/ from a subdirectory" "$(cd "$pathspec_git/src" && git diff -- :/top.c)" \
+  "$(cd "$pathspec/src" && "$mygit" diff -- :/top.c)"
+check "add outside the repository refused" "refused" \
+  "$(cd "$pathspec/src" && "$mygit" -q add ../../elsewhere 2>/dev/null || echo refused)"
+
+# patch-id is the same for the same change made on different bases, git's
+# own for the patches read from stdin and for commits
+patchid="$work/patchid"
+git init -q -b main "$patchid"
+printf '1\n2\n3\n4\n5\n6\n7\n8\n9\n' > "$patchid/f"
+echo other > "$patchid/g"
+git -C "$patchid" add .
+git -C "$patchid" commit -qm base
+git -C "$patchid" checkout -qb topic
+printf '0\n' > "$patchid/g"
+sed -i 's/^8$/eight/' "$patchid/f"
+git -C "$patchid" commit -qam "change"
+git -C "$patchid" checkout -q main
+printf 'new first line\n' | cat - "$patchid/f" > "$patchid/f.new" && mv "$patchid/f.new" "$patchid/f"
+git -C "$patchid" commit -qam "another base"
+git -C "$patchid" cherry-pick topic > /dev/null
+picked="$(cd "$patchid" && "$mygit" patch-id main | cut -d' ' -f1)"
+check "patch-id on different bases" "$(cd "$patchid" && "$mygit" patch-id topic | cut -d' ' -f1)" "$picked"
+check "patch-id commit" "$(git -C "$patchid" show main | git patch-id)" "$(cd "$patchid" && "$mygit" patch-id main)"
+for opts in "" --stable --verbatim; do
+  check "patch-id $opts from log -p" "$(git -C "$patchid" log -p --all | git patch-id $opts)" \
+    "$(git -C "$patchid" log -p --all | "$mygit" patch-id $opts)"
+done
+
+# sparse-checkout marks what it leaves out skip-worktree in the index, git
+# sees the same index as after its own sparse-checkout and add keeps them
+sparse="$work/sparse"
+git init -q "$sparse"
+mkdir -p "$sparse/a" "$sparse/b"
+echo x > "$sparse/a/x"
+echo é > "$sparse/a/é"
+echo y > "$sparse/b/y"
+echo top > "$sparse/top"
+git -C "$sparse" add .
+git -C "$sparse" commit -qm "sparse"
+git clone -q "$sparse" "$sparse-git"
+git -C "$sparse-git" sparse-checkout set --no-cone /a/
+(cd "$sparse" && "$mygit" sparse-checkout set /a/ && "$mygit" add .)
+check "sparse-checkout skip-worktree" "$(git -C "$sparse-git" ls-files -t)" "$(git -C "$sparse" ls-files -t)"
+check "sparse-checkout worktree" "$(cd "$sparse-git" && find . -path ./.git -prune -o -type f -print | sort)" \
+  "$(cd "$sparse" && find . -path ./.git -prune -o -type f -print | sort)"
+check "sparse-checkout write-tree" "$(git -C "$sparse" rev-parse HEAD^{tree})" "$(cd "$sparse" && "$mygit" write-tree)"
+
+if [ "$failures" -gt 0 ]; then
+  echo "$failures interop checks failed"
+  exit 1
+fi
+echo "all interop checks passed"
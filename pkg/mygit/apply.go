@@ -0,0 +1,650 @@
+package mygit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	InvalidPatch = errors.New("Input cannot be parsed into a patch.")
+)
+
+// how many context lines a hunk may ignore at each end when it does not
+// apply as is, like patch's default fuzz factor
+const maxFuzz = 2
+
+// FilePatch is the change of one file in a unified diff
+type FilePatch struct {
+	OldName string // empty for a created file
+	NewName string // empty for a deleted file
+	OldMode string
+	NewMode string
+	OldHash string // from the index line, possibly abbreviated
+	NewHash string
+	Hunks   []Hunk
+	Binary  *BinaryPatch // set instead of the hunks by git diff --binary
+	Source  string       // the patch file, for messages
+}
+
+type Hunk struct {
+	OldStart int
+	NewStart int
+	Lines    []HunkLine
+}
+
+// BinaryPatch is the content of a "GIT binary patch", the hunk producing the
+// new file and the one producing the old file back
+type BinaryPatch struct {
+	Forward *BinaryHunk
+	Reverse *BinaryHunk // git always writes it, nil if missing
+}
+
+// BinaryHunk is the whole new content, or a delta against the old one in the
+// pack format, inflated
+type BinaryHunk struct {
+	Delta bool
+	Data  []byte
+}
+
+// HunkLine is one line of a hunk, Text ends with a newline unless the
+// patch marked it as missing
+type HunkLine struct {
+	Kind EditKind
+	Text string
+	Line int // in the patch, counting from 1
+}
+
+// https://git-scm.com/docs/git-diff#_generating_patch_text_with_p
+// ParsePatch reads the files of a unified diff, with or without git's
+// extended headers. Text around the diffs, like a commit message, is skipped.
+func ParsePatch(data []byte) ([]*FilePatch, error) {
+	lines := splitLines(data)
+	patches := []*FilePatch{}
+	var current *FilePatch
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = &FilePatch{}
+			if a, b, ok := gitDiffNames(line); ok {
+				current.OldName, current.NewName = a, b
+			}
+			patches = append(patches, current)
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if current == nil || len(current.Hunks) > 0 {
+				current = &FilePatch{}
+				patches = append(patches, current)
+			}
+			current.OldName = patchName(line[4:])
+			current.NewName = patchName(strings.TrimRight(lines[i+1], "\n")[4:])
+			i++
+		case current == nil:
+			continue
+		case strings.HasPrefix(line, "new file mode "):
+			current.OldName, current.NewMode = "", strings.TrimPrefix(line, "new file mode ")
+		case strings.HasPrefix(line, "deleted file mode "):
+			current.NewName, current.OldMode = "", strings.TrimPrefix(line, "deleted file mode ")
+		case strings.HasPrefix(line, "old mode "):
+			current.OldMode = strings.TrimPrefix(line, "old mode ")
+		case strings.HasPrefix(line, "new mode "):
+			current.NewMode = strings.TrimPrefix(line, "new mode ")
+		case strings.HasPrefix(line, "rename from "):
+			current.OldName = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			current.NewName = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "index "):
+			hashes, _, _ := strings.Cut(strings.TrimPrefix(line, "index "), " ")
+			current.OldHash, current.NewHash, _ = strings.Cut(hashes, "..")
+		case strings.HasPrefix(line, "Binary files "):
+			return nil, fmt.Errorf("%w: binary patches need their data, made by git diff --binary", InvalidPatch)
+		case line == "GIT binary patch":
+			binaryPatch, next, err := parseBinaryPatch(lines, i+1)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", current.name(), err)
+			}
+			current.Binary = binaryPatch
+			i = next - 1
+		case strings.HasPrefix(line, "@@ "):
+			hunk, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			current.Hunks = append(current.Hunks, hunk)
+			i = next - 1
+		}
+	}
+	for _, p := range patches {
+		if p.OldName == "" && p.NewName == "" {
+			return nil, fmt.Errorf("%w: a file has no name", InvalidPatch)
+		}
+	}
+	return patches, nil
+}
+
+// parseHunk reads the hunk whose header is lines[at], returning the index of
+// the line after it. The header line counts tell where the hunk ends.
+func parseHunk(lines []string, at int) (Hunk, int, error) {
+	header := strings.TrimRight(lines[at], "\n")
+	var oldCount, newCount int
+	hunk := Hunk{}
+	ranges := strings.Fields(strings.TrimPrefix(header, "@@ "))
+	if len(ranges) < 2 {
+		return hunk, 0, fmt.Errorf("%w: bad hunk header %q", InvalidPatch, header)
+	}
+	var err error
+	if hunk.OldStart, oldCount, err = parseRange(ranges[0], "-"); err != nil {
+		return hunk, 0, fmt.Errorf("%w: bad hunk header %q", InvalidPatch, header)
+	}
+	if hunk.NewStart, newCount, err = parseRange(ranges[1], "+"); err != nil {
+		return hunk, 0, fmt.Errorf("%w: bad hunk header %q", InvalidPatch, header)
+	}
+
+	i := at + 1
+	for ; i < len(lines) && (oldCount > 0 || newCount > 0); i++ {
+		line := lines[i]
+		if line == "\n" {
+			line = " \n" // some editors strip the space of empty context lines
+		}
+		kind := EditKind(line[0])
+		switch kind {
+		case EditEqual:
+			oldCount--
+			newCount--
+		case EditDelete:
+			oldCount--
+		case EditInsert:
+			newCount--
+		default:
+			return hunk, 0, fmt.Errorf("%w: unexpected line %q in hunk %q", InvalidPatch, strings.TrimRight(line, "\n"), header)
+		}
+		hunk.Lines = append(hunk.Lines, HunkLine{Kind: kind, Text: line[1:], Line: i + 1})
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], `\`) {
+			last := &hunk.Lines[len(hunk.Lines)-1]
+			last.Text = strings.TrimSuffix(last.Text, "\n")
+			i++
+		}
+	}
+	if oldCount != 0 || newCount != 0 {
+		return hunk, 0, fmt.Errorf("%w: truncated hunk %q", InvalidPatch, header)
+	}
+	return hunk, i, nil
+}
+
+// parseBinaryPatch reads the forward hunk at lines[at] and the reverse one
+// following it if any, returning the index of the line after them
+func parseBinaryPatch(lines []string, at int) (*BinaryPatch, int, error) {
+	forward, next, err := parseBinaryHunk(lines, at)
+	if err != nil {
+		return nil, 0, err
+	}
+	binaryPatch := &BinaryPatch{Forward: forward}
+	if next < len(lines) && (strings.HasPrefix(lines[next], "literal ") || strings.HasPrefix(lines[next], "delta ")) {
+		if binaryPatch.Reverse, next, err = parseBinaryHunk(lines, next); err != nil {
+			return nil, 0, err
+		}
+	}
+	return binaryPatch, next, nil
+}
+
+// parseBinaryHunk reads "literal <size>" or "delta <size>" then the base85
+// lines of the deflated data up to an empty line
+func parseBinaryHunk(lines []string, at int) (*BinaryHunk, int, error) {
+	if at >= len(lines) {
+		return nil, 0, fmt.Errorf("%w: truncated binary patch", InvalidPatch)
+	}
+	header := strings.TrimRight(lines[at], "\n")
+	kind, sizeField, _ := strings.Cut(header, " ")
+	size, err := strconv.Atoi(sizeField)
+	if (kind != "literal" && kind != "delta") || err != nil {
+		return nil, 0, fmt.Errorf("%w: bad binary hunk header %q", InvalidPatch, header)
+	}
+
+	deflated, i := []byte{}, at+1
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\n")
+		if line == "" {
+			i++
+			break
+		}
+		chunk, err := decodeBase85Line(line)
+		if err != nil {
+			return nil, 0, err
+		}
+		deflated = append(deflated, chunk...)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(deflated))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: binary hunk %q: %w", InvalidPatch, header, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: binary hunk %q: %w", InvalidPatch, header, err)
+	}
+	if len(data) != size {
+		return nil, 0, fmt.Errorf("%w: binary hunk %q inflates to %d bytes", InvalidPatch, header, len(data))
+	}
+	return &BinaryHunk{Delta: kind == "delta", Data: data}, i, nil
+}
+
+// the digits of git's base85, see base85.c
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// decodeBase85Line decodes a line of a binary hunk, its first letter is the
+// number of bytes, A-Z for 1 to 26 and a-z for 27 to 52, then every 5 digits
+// encode 4 bytes, big-endian
+func decodeBase85Line(line string) ([]byte, error) {
+	size := 0
+	switch c := line[0]; {
+	case 'A' <= c && c <= 'Z':
+		size = int(c-'A') + 1
+	case 'a' <= c && c <= 'z':
+		size = int(c-'a') + 27
+	}
+	encoded := line[1:]
+	if size == 0 || len(encoded) != (size+3)/4*5 {
+		return nil, fmt.Errorf("%w: bad binary hunk line %q", InvalidPatch, line)
+	}
+
+	decoded := make([]byte, 0, len(encoded)/5*4)
+	for chunk := range len(encoded) / 5 {
+		value := uint64(0)
+		for _, c := range []byte(encoded[chunk*5 : chunk*5+5]) {
+			digit := strings.IndexByte(base85Alphabet, c)
+			if digit == -1 {
+				return nil, fmt.Errorf("%w: bad binary hunk line %q", InvalidPatch, line)
+			}
+			value = value*85 + uint64(digit)
+		}
+		if value > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: bad binary hunk line %q", InvalidPatch, line)
+		}
+		decoded = binary.BigEndian.AppendUint32(decoded, uint32(value))
+	}
+	return decoded[:size], nil
+}
+
+// parseRange reads "-start,count", the count defaults to 1
+func parseRange(field, sign string) (int, int, error) {
+	field, ok := strings.CutPrefix(field, sign)
+	if !ok {
+		return 0, 0, InvalidPatch
+	}
+	start, count, found := strings.Cut(field, ",")
+	if !found {
+		count = "1"
+	}
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, err := strconv.Atoi(count)
+	return s, c, err
+}
+
+// gitDiffNames splits "diff --git a/<old> b/<new>", names with spaces are
+// only recovered when both sides are the same
+func gitDiffNames(line string) (string, string, bool) {
+	names := strings.TrimPrefix(line, "diff --git ")
+	if half := len(names) / 2; len(names)%2 == 1 && names[half] == ' ' &&
+		strings.HasPrefix(names, "a/") && strings.HasPrefix(names[half+1:], "b/") {
+		return names[2:half], names[half+3:], true
+	}
+	a, b, ok := strings.Cut(names, " ")
+	if !ok {
+		return "", "", false
+	}
+	return patchName(a), patchName(b), true
+}
+
+// patchName strips the a/ or b/ prefix, the timestamp some diffs append and
+// maps /dev/null to no file
+func patchName(name string) string {
+	name, _, _ = strings.Cut(name, "\t")
+	if name == "/dev/null" {
+		return ""
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, `"`) {
+		name = unquoted
+	}
+	if _, rest, ok := strings.Cut(name, "/"); ok {
+		return rest
+	}
+	return name
+}
+
+// Reverse returns the patch undoing p
+func (p *FilePatch) Reverse() *FilePatch {
+	reversed := &FilePatch{OldName: p.NewName, NewName: p.OldName, OldMode: p.NewMode, NewMode: p.OldMode, OldHash: p.NewHash, NewHash: p.OldHash, Source: p.Source}
+	if p.Binary != nil {
+		reversed.Binary = &BinaryPatch{Forward: p.Binary.Reverse, Reverse: p.Binary.Forward}
+	}
+	for _, hunk := range p.Hunks {
+		r := Hunk{OldStart: hunk.NewStart, NewStart: hunk.OldStart}
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case EditDelete:
+				line.Kind = EditInsert
+			case EditInsert:
+				line.Kind = EditDelete
+			}
+			r.Lines = append(r.Lines, line)
+		}
+		reversed.Hunks = append(reversed.Hunks, r)
+	}
+	return reversed
+}
+
+// Apply runs the hunks over lines. Each hunk is looked for at its position
+// first, then at growing distances from it, then with less context.
+func (p *FilePatch) Apply(lines []string) ([]string, error) {
+	result := []string{}
+	done, shift := 0, 0 // lines of the input consumed, drift of the previous hunk
+	for _, hunk := range p.Hunks {
+		old, lead, trail := hunk.old()
+		at, fuzz := -1, 0
+		for ; fuzz <= maxFuzz && at == -1; fuzz++ {
+			skipLead, skipTrail := min(fuzz, lead), min(fuzz, trail)
+			want := old[skipLead : len(old)-skipTrail]
+			expected := max(hunk.OldStart-1, 0) + shift + skipLead
+			if len(old) == 0 {
+				expected = hunk.OldStart + shift // an insertion after that line
+			}
+			if at = findLines(lines, want, expected, done); at != -1 {
+				at -= skipLead
+				break
+			}
+		}
+		if at == -1 {
+			return nil, fmt.Errorf("patch does not apply to %s at line %d", p.name(), hunk.OldStart)
+		}
+		skipLead, skipTrail := min(fuzz, lead), min(fuzz, trail)
+
+		result = append(result, lines[done:at+skipLead]...)
+		for i, line := range hunk.Lines {
+			// context ignored by the fuzz is kept as it is in the file
+			switch {
+			case i < skipLead || i >= len(hunk.Lines)-skipTrail:
+				continue
+			case line.Kind == EditInsert:
+				result = append(result, line.Text)
+			case line.Kind == EditEqual:
+				result = append(result, lines[at+hunk.oldIndex(i)])
+			}
+		}
+		done = at + len(old) - skipTrail
+		shift = at - max(hunk.OldStart-1, 0)
+	}
+	return append(result, lines[done:]...), nil
+}
+
+// ApplyBinary returns what the binary patch turns old into. Like git, the
+// content is checked against the index line when it has full hashes.
+func (p *FilePatch) ApplyBinary(old []byte) ([]byte, error) {
+	hunk := p.Binary.Forward
+	if hunk == nil {
+		return nil, fmt.Errorf("%s: the binary patch cannot be reversed", p.name())
+	}
+	if p.OldName != "" && len(p.OldHash) == objectHasher.HexLen() && blobHash(old) != p.OldHash {
+		return nil, fmt.Errorf("patch does not apply to %s, it was made from %s", p.name(), p.OldHash)
+	}
+
+	result := hunk.Data
+	if hunk.Delta {
+		var err error
+		if result, err = applyDelta(old, bytes.NewBuffer(hunk.Data)); err != nil {
+			return nil, fmt.Errorf("patch does not apply to %s: %w", p.name(), err)
+		}
+	}
+	if p.NewName != "" && len(p.NewHash) == objectHasher.HexLen() && blobHash(result) != p.NewHash {
+		return nil, fmt.Errorf("binary patch to %s creates %s, not %s", p.name(), blobHash(result), p.NewHash)
+	}
+	return result, nil
+}
+
+// blobHash is the sha content has as a blob
+func blobHash(content []byte) string {
+	sha, _ := HashObject(&Blob{content: content})
+	return fmt.Sprintf("%x", sha)
+}
+
+// old returns the lines the hunk expects to find, with the number of context
+// lines before and after its changes
+func (h Hunk) old() ([]string, int, int) {
+	old := []string{}
+	for _, line := range h.Lines {
+		if line.Kind != EditInsert {
+			old = append(old, line.Text)
+		}
+	}
+	lead := 0
+	for lead < len(h.Lines) && h.Lines[lead].Kind == EditEqual {
+		lead++
+	}
+	trail := 0
+	for trail < len(h.Lines)-lead && h.Lines[len(h.Lines)-1-trail].Kind == EditEqual {
+		trail++
+	}
+	return old, lead, trail
+}
+
+// oldIndex is the position among the old lines of hunk line i
+func (h Hunk) oldIndex(i int) int {
+	index := 0
+	for _, line := range h.Lines[:i] {
+		if line.Kind != EditInsert {
+			index++
+		}
+	}
+	return index
+}
+
+// findLines returns where want appears in lines at or after from, the
+// closest match to expected winning, or -1
+func findLines(lines, want []string, expected, from int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(want) > len(lines) {
+			return false
+		}
+		for i, line := range want {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for distance := 0; distance <= len(lines); distance++ {
+		if matches(expected - distance) {
+			return expected - distance
+		}
+		if matches(expected + distance) {
+			return expected + distance
+		}
+	}
+	return -1
+}
+
+func (p *FilePatch) name() string {
+	if p.NewName != "" {
+		return p.NewName
+	}
+	return p.OldName
+}
+
+// WhitespaceAction is what apply does about added lines ending with
+// whitespace
+type WhitespaceAction string
+
+const (
+	WhitespaceNoWarn   WhitespaceAction = "nowarn"
+	WhitespaceWarn     WhitespaceAction = "warn" // git's default
+	WhitespaceFix      WhitespaceAction = "fix"  // the whitespace is removed
+	WhitespaceError    WhitespaceAction = "error"
+	WhitespaceErrorAll WhitespaceAction = "error-all" // every line is reported
+)
+
+// ParseWhitespaceAction reads the value of --whitespace or apply.whitespace,
+// with git's other names for the same actions
+func ParseWhitespaceAction(value string) (WhitespaceAction, error) {
+	switch value {
+	case "nowarn", "warn", "fix", "error", "error-all":
+		return WhitespaceAction(value), nil
+	case "strip":
+		return WhitespaceFix, nil
+	}
+	return "", fmt.Errorf("%w: unrecognized whitespace option '%s'", InvalidArgsError, value)
+}
+
+type ApplyOptions struct {
+	Check      bool // only report whether the patches apply
+	Reverse    bool
+	Whitespace WhitespaceAction // empty is WhitespaceWarn
+}
+
+// like git, only the first whitespace errors are reported one by one
+const squelchWhitespaceErrors = 5
+
+// checkWhitespace reports the lines p adds with trailing whitespace and
+// removes it with WhitespaceFix, returning how many there are. Lines are only
+// reported while there were fewer than squelchWhitespaceErrors before, but for
+// WhitespaceErrorAll.
+func checkWhitespace(p *FilePatch, action WhitespaceAction, before int) int {
+	count := 0
+	for _, hunk := range p.Hunks {
+		for i, line := range hunk.Lines {
+			text := strings.TrimSuffix(line.Text, "\n")
+			trimmed := strings.TrimRightFunc(text, isSpace)
+			if line.Kind != EditInsert || trimmed == text {
+				continue
+			}
+			if action == WhitespaceErrorAll || (action != WhitespaceNoWarn && before+count < squelchWhitespaceErrors) {
+				Infof("%s:%d: trailing whitespace.\n%s\n", p.Source, line.Line, text)
+			}
+			if action == WhitespaceFix {
+				hunk.Lines[i].Text = trimmed + line.Text[len(text):]
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// plural is "<count> <noun>" with an s unless count is one
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// ApplyPatches applies the patches to the files of the current directory.
+// Every patch is applied in memory first so nothing is written when one fails,
+// or when lines adding whitespace errors are an error.
+func ApplyPatches(patches []*FilePatch, opts ApplyOptions) error {
+	type change struct {
+		patch   *FilePatch
+		content []byte
+	}
+	changes, errorCount := []change{}, 0
+	if opts.Whitespace == "" {
+		opts.Whitespace = WhitespaceWarn
+	}
+	for _, p := range patches {
+		if opts.Reverse {
+			p = p.Reverse()
+		}
+		errorCount += checkWhitespace(p, opts.Whitespace, errorCount)
+		content := []byte{}
+		if p.OldName != "" {
+			var err error
+			if content, err = os.ReadFile(p.OldName); err != nil {
+				return fmt.Errorf("cannot read %s: %w", p.OldName, err)
+			}
+		} else if _, err := os.Lstat(p.NewName); err == nil {
+			return fmt.Errorf("%s already exists", p.NewName)
+		}
+
+		var result []byte
+		if p.Binary != nil {
+			var err error
+			if result, err = p.ApplyBinary(content); err != nil {
+				return err
+			}
+		} else {
+			lines, err := p.Apply(splitLines(content))
+			if err != nil {
+				return err
+			}
+			result = []byte(strings.Join(lines, ""))
+		}
+		if p.NewName == "" && len(result) > 0 {
+			return fmt.Errorf("%s is not empty after the patch that deletes it", p.OldName)
+		}
+		changes = append(changes, change{p, result})
+	}
+	if errorCount > 0 && opts.Whitespace != WhitespaceNoWarn {
+		if squelched := errorCount - squelchWhitespaceErrors; squelched > 0 && opts.Whitespace != WhitespaceErrorAll {
+			Infof("warning: squelched %s\n", plural(squelched, "whitespace error"))
+		}
+		adds := plural(errorCount, "line") + " add whitespace errors"
+		if errorCount == 1 {
+			adds = "1 line adds whitespace errors"
+		}
+		switch {
+		case opts.Whitespace == WhitespaceError || opts.Whitespace == WhitespaceErrorAll:
+			return errors.New(adds)
+		case opts.Whitespace == WhitespaceFix && !opts.Check:
+			Infof("warning: %s applied after fixing whitespace errors.\n", plural(errorCount, "line"))
+		default:
+			Infof("warning: %s.\n", adds)
+		}
+	}
+	if opts.Check {
+		return nil
+	}
+
+	for _, c := range changes {
+		p := c.patch
+		// a modified or renamed file keeps its permissions unless the patch sets them
+		perm := os.FileMode(0o644)
+		if p.OldName != "" {
+			if info, err := os.Stat(p.OldName); err == nil {
+				perm = info.Mode().Perm()
+			}
+			if p.OldName != p.NewName {
+				if err := os.Remove(p.OldName); err != nil {
+					return err
+				}
+			}
+		}
+		if p.NewName == "" {
+			continue
+		}
+		switch p.NewMode {
+		case "100755":
+			perm = 0o755
+		case "100644":
+			perm = 0o644
+		}
+		if err := os.MkdirAll(path.Dir(p.NewName), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p.NewName, c.content, perm); err != nil {
+			return err
+		}
+		// WriteFile keeps the permissions of an existing file
+		if err := os.Chmod(p.NewName, perm); err != nil {
+			return err
+		}
+		Verbosef("Applied patch to %s\n", p.NewName)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package mygit
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const applyPatch = `commit message around the diff
+
+diff --git a/file b/file
+index 0123456..789abcd 100644
+--- a/file
++++ b/file
+@@ -1,4 +1,4 @@
+ one
+-two
++2
+ three
+ four
+diff --git a/gone b/gone
+deleted file mode 100644
+--- a/gone
++++ /dev/null
+@@ -1 +0,0 @@
+-gone
+diff --git a/dir/new b/dir/new
+new file mode 100755
+--- /dev/null
++++ b/dir/new
+@@ -0,0 +1,2 @@
++new
++file
+`
+
+func TestApplyAndReverse(t *testing.T) {
+	testRepository(t)
+	before := map[string]string{"file": "one\ntwo\nthree\nfour\n", "gone": "gone\n", "change.patch": applyPatch}
+	writeFiles(t, before)
+
+	if output, err := runCommand(t, "apply", "change.patch"); err != nil || output != "" {
+		t.Fatalf("apply printed %q, %v", output, err)
+	}
+	files := readWorktree(t, "file", "gone", "dir/new")
+	if files["file"] != "one\n2\nthree\nfour\n" || files["gone"] != "" || files["dir/new"] != "new\nfile\n" {
+		t.Errorf("the patched worktree is %q", files)
+	}
+	if _, err := os.Stat("gone"); !os.IsNotExist(err) {
+		t.Error("the deleted file is still there")
+	}
+	if info, err := os.Stat("dir/new"); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("the created file has the mode %v, %v", info.Mode(), err)
+	}
+
+	// the patch no longer applies forward, it does in reverse
+	if _, err := runCommand(t, "apply", "--check", "change.patch"); err == nil {
+		t.Error("the applied patch checks again")
+	}
+	if _, err := runCommand(t, "apply", "--check", "-R", "change.patch"); err != nil {
+		t.Errorf("the reverse does not check: %v", err)
+	}
+	if _, err := runCommand(t, "apply", "--reverse", "change.patch"); err != nil {
+		t.Fatal(err)
+	}
+	files = readWorktree(t, "file", "gone")
+	if files["file"] != before["file"] || files["gone"] != before["gone"] {
+		t.Errorf("the reversed worktree is %q", files)
+	}
+	if _, err := os.Stat("dir/new"); !os.IsNotExist(err) {
+		t.Error("the created file is still there after the reverse")
+	}
+}
+
+func TestApplyFailingCheckChangesNothing(t *testing.T) {
+	testRepository(t)
+	// file applies, gone does not match the patch
+	before := map[string]string{"file": "one\ntwo\nthree\nfour\n", "gone": "changed\n", "change.patch": applyPatch}
+	writeFiles(t, before)
+
+	for _, args := range [][]string{{"apply", "--check", "change.patch"}, {"apply", "change.patch"}} {
+		if output, err := runCommand(t, args...); err == nil {
+			t.Errorf("%v printed %q and succeeded", args, output)
+		}
+		files := readWorktree(t, "file", "gone", "dir/new")
+		if files["file"] != before["file"] || files["gone"] != before["gone"] || files["dir/new"] != "" {
+			t.Errorf("%v left the worktree %q", args, files)
+		}
+	}
+}
+
+func TestApplyBinaryPatchFromGit(t *testing.T) {
+	testRepository(t)
+	random := rand.New(rand.NewSource(1))
+	image := make([]byte, 5000)
+	random.Read(image)
+	writeFiles(t, map[string]string{"image.png": string(image), "deleted.bin": "old\x00binary"})
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-q", "-m", "binary files")
+
+	// a delta to the image, a created and a deleted file
+	created := make([]byte, 300)
+	random.Read(created)
+	edited := string(image[:2000]) + "edited\x00\x01\x02" + string(image[2100:])
+	writeFiles(t, map[string]string{"image.png": edited, "created.bin": string(created)})
+	runGit(t, "rm", "-q", "deleted.bin")
+	runGit(t, "add", ".")
+	patch := filepath.Join(t.TempDir(), "binary.patch")
+	if err := os.WriteFile(patch, gitOutput(t, nil, "diff", "--cached", "--binary"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := runGit(t, "write-tree")
+	runGit(t, "reset", "-q", "--hard")
+
+	if _, err := runCommand(t, "apply", patch); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	if tree := runGit(t, "write-tree"); tree != want {
+		t.Errorf("the patched worktree is the tree %s, git patched %s", tree, want)
+	}
+	if _, err := runCommand(t, "apply", "-R", patch); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	if tree, want := runGit(t, "write-tree"), runGit(t, "rev-parse", "HEAD^{tree}"); tree != want {
+		t.Errorf("the reversed worktree is the tree %s, want %s", tree, want)
+	}
+}
@@ -0,0 +1,143 @@
+package mygit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+type ArchiveFormat string
+
+const (
+	TarFormat   ArchiveFormat = "tar"
+	TarGzFormat ArchiveFormat = "tar.gz"
+)
+
+// WriteArchive writes the content of the tree-ish at sha as a tar stream,
+// every path is nested under prefix.
+func WriteArchive(w io.Writer, sha string, format ArchiveFormat, prefix string) error {
+	tree, err := ReadTreeish(sha)
+	if err != nil {
+		return err
+	}
+
+	// like git, use the commit time when archiving a commit
+	modTime := time.Now()
+	if gitObj, err := ReadGitObject(sha); err == nil {
+		if commit, ok := gitObj.(*CommitAsBytes); ok {
+			if modTime, err = commit.CommitTime(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the gzip trailer is only written on Close, its error is the last one
+	var gz *gzip.Writer
+	switch format {
+	case TarFormat:
+	case TarGzFormat:
+		gz = gzip.NewWriter(w)
+		w = gz
+	default:
+		return InvalidArgsError
+	}
+
+	tw := tar.NewWriter(w)
+	if prefix != "" {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.TrimSuffix(prefix, "/") + "/",
+			Mode:     0o775,
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+	}
+	if err := archiveTree(tw, tree, prefix, modTime); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+func archiveTree(tw *tar.Writer, tree *Tree, prefix string, modTime time.Time) error {
+	entries, err := tree.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := path.Join(prefix, e.name)
+		header := &tar.Header{
+			Name:    name,
+			ModTime: modTime,
+		}
+
+		// like git, a submodule is an empty directory, its commit is in
+		// another repository
+		if e.kind == CommitKind {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 0o775
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.kind == TreeKind {
+			subtree, err := ReadTreeish(e.hash)
+			if err != nil {
+				return err
+			}
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 0o775
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if err := archiveTree(tw, subtree, name, modTime); err != nil {
+				return err
+			}
+			continue
+		}
+
+		blob, err := ReadGitObject(e.hash)
+		if err != nil {
+			return err
+		}
+		content := blob.Content()
+
+		switch e.mode {
+		case "120000": // symlink, the blob holds the target
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = string(content)
+			header.Mode = 0o777
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		case "100755":
+			header.Mode = 0o775
+		default:
+			header.Mode = 0o664
+		}
+
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
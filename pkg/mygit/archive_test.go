@@ -0,0 +1,137 @@
+package mygit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+type tarEntry struct {
+	typeflag byte
+	mode     int64
+	content  string // the target of a symlink
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]tarEntry {
+	t.Helper()
+	entries := map[string]tarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			content = []byte(header.Linkname)
+		}
+		entries[header.Name] = tarEntry{header.Typeflag, header.Mode, string(content)}
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		"README":      "read me\n",
+		"src/main.go": "package main\n",
+		"run.sh":      "#!/bin/sh\n",
+	})
+	if err := os.Chmod("run.sh", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src/main.go", "link"); err != nil {
+		t.Fatal(err)
+	}
+	commit := commitWorktree(t, "archived")
+
+	want := map[string]tarEntry{
+		"out/":            {tar.TypeDir, 0o775, ""},
+		"out/README":      {tar.TypeReg, 0o664, "read me\n"},
+		"out/link":        {tar.TypeSymlink, 0o777, "src/main.go"},
+		"out/run.sh":      {tar.TypeReg, 0o775, "#!/bin/sh\n"},
+		"out/src/":        {tar.TypeDir, 0o775, ""},
+		"out/src/main.go": {tar.TypeReg, 0o664, "package main\n"},
+	}
+	for _, format := range []ArchiveFormat{TarFormat, TarGzFormat} {
+		buf := bytes.Buffer{}
+		if err := WriteArchive(&buf, commit, format, "out/"); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var r io.Reader = &buf
+		if format == TarGzFormat {
+			gz, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		got := readTar(t, r)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s archive:\n got %v\nwant %v", format, got, want)
+		}
+	}
+}
+
+func TestWriteArchiveSubmodule(t *testing.T) {
+	testRepository(t)
+	blob, err := WriteContent(&Blob{content: []byte("file\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTreeFromFiles(map[string]entry{
+		"file":    {mode: "100644", hash: fmt.Sprintf("%x", blob)},
+		"lib/sub": {mode: "160000", hash: "1111111111111111111111111111111111111111"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteArchive(&buf, fmt.Sprintf("%x", tree), TarFormat, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]tarEntry{
+		"file":     {tar.TypeReg, 0o664, "file\n"},
+		"lib/":     {tar.TypeDir, 0o775, ""},
+		"lib/sub/": {tar.TypeDir, 0o775, ""},
+	}
+	if got := readTar(t, &buf); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("archive:\n got %v\nwant %v", got, want)
+	}
+}
+
+// failingWriter accepts limit bytes then fails
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, io.ErrShortWrite
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteArchiveReportsTruncation(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "some content\n"})
+	commit := commitWorktree(t, "archived")
+
+	// the whole tar fits in the gzip buffer, only its Close writes
+	if err := WriteArchive(&failingWriter{limit: 10}, commit, TarGzFormat, ""); err == nil {
+		t.Error("a truncated tar.gz was written without error")
+	}
+}
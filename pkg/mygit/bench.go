@@ -0,0 +1,195 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+// the fixtures are generated from a fixed seed, every run measures the same
+// files and the same packs
+const benchSeed = 1
+
+// fixture sizes in files when none are given
+var defaultBenchSizes = []int{100, 1000, 5000}
+
+// benchWords make text that compresses and deltifies like source code does
+var benchWords = strings.Fields("func return if else for range err nil package import type struct string int byte error ctx context len append make map")
+
+// GenerateFixture writes files text files under dir, spread over nested
+// directories, with the same content for the same seed
+func GenerateFixture(dir string, files int, seed int64) error {
+	random := rand.New(rand.NewSource(seed))
+	for i := range files {
+		name := filepath.Join(dir, fmt.Sprintf("d%02d/e%02d/file%05d.txt", i%16, i/16%8, i))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+		content := bytes.Buffer{}
+		for lines := 5 + random.Intn(200); lines > 0; lines-- {
+			for words := 1 + random.Intn(10); words > 0; words-- {
+				content.WriteString(benchWords[random.Intn(len(benchWords))] + " ")
+			}
+			content.WriteString("\n")
+		}
+		if err := os.WriteFile(name, content.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packFixture writes the objects of worktree and returns every object of the
+// repository packed
+func packFixture(ctx context.Context, worktree string) ([]byte, error) {
+	if _, _, err := BuildTreeFromDir(ctx, worktree, true); err != nil {
+		return nil, err
+	}
+	objects, err := AllObjects()
+	if err != nil {
+		return nil, err
+	}
+	pack := bytes.Buffer{}
+	if err := WritePack(&pack, objects); err != nil {
+		return nil, err
+	}
+	return pack.Bytes(), nil
+}
+
+// benchParseObjects unpacks raw b.N times, each time into an empty object
+// store
+func benchParseObjects(ctx context.Context, b *testing.B, raw []byte) error {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for range b.N {
+		// unpacking into a repository that has the objects would only check
+		// that they exist
+		b.StopTimer()
+		if err := os.RemoveAll(gitPath("objects")); err != nil {
+			return err
+		}
+		b.StartTimer()
+		if _, err := ParseObjects(ctx, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchBuildTree hashes worktree b.N times without writing the objects
+func benchBuildTree(ctx context.Context, b *testing.B, worktree string) error {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, _, err := BuildTreeFromDir(ctx, worktree, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBenchmark measures bench outside of go test, its error stops the
+// measure and is returned
+func runBenchmark(bench func(b *testing.B) error) (testing.BenchmarkResult, error) {
+	var benchErr error
+	result := testing.Benchmark(func(b *testing.B) {
+		if err := bench(b); err != nil {
+			benchErr = err
+			b.FailNow()
+		}
+	})
+	return result, benchErr
+}
+
+// RunBenchmarks measures ParseObjects and BuildTreeFromDir on fixtures of
+// each size, in a temporary repository that is removed afterwards. go test
+// -bench runs the same measures.
+func RunBenchmarks(ctx context.Context, sizes []int) error {
+	root, err := os.MkdirTemp("", "mygit-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+
+	previous := gitDir
+	defer func() {
+		gitDir = previous
+		dropObjectCaches()
+	}()
+
+	for _, files := range sizes {
+		// each size gets its own repository, the pack holds only its objects
+		gitDir = filepath.Join(root, fmt.Sprintf("repo-%d.git", files))
+		dropObjectCaches()
+		if err := InitRepository(); err != nil {
+			return err
+		}
+		worktree := filepath.Join(root, fmt.Sprintf("fixture-%d", files))
+		if err := GenerateFixture(worktree, files, benchSeed); err != nil {
+			return err
+		}
+		raw, err := packFixture(ctx, worktree)
+		if err != nil {
+			return err
+		}
+
+		result, err := runBenchmark(func(b *testing.B) error { return benchParseObjects(ctx, b, raw) })
+		if err != nil {
+			return err
+		}
+		printBenchmark(fmt.Sprintf("ParseObjects/%d", files), result)
+
+		result, err = runBenchmark(func(b *testing.B) error { return benchBuildTree(ctx, b, worktree) })
+		if err != nil {
+			return err
+		}
+		printBenchmark(fmt.Sprintf("BuildTreeFromDir/%d", files), result)
+	}
+	return nil
+}
+
+// printBenchmark prints one line of the report, with the allocations
+func printBenchmark(name string, result testing.BenchmarkResult) {
+	fmt.Printf("%-28s %s\t%s\n", name, result.String(), result.MemString())
+}
+
+// profile runs f writing a CPU profile to cpuFile and a heap profile taken
+// once it is done to memFile, each only when its name is not empty
+func profile(cpuFile, memFile string, f func() error) error {
+	if cpuFile != "" {
+		out, err := os.Create(cpuFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if err := pprof.StartCPUProfile(out); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	if memFile == "" {
+		return nil
+	}
+	out, err := os.Create(memFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// the profile shows what is still allocated, not the garbage
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(out); err != nil {
+		return err
+	}
+	return out.Close()
+}
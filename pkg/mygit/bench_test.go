@@ -0,0 +1,135 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run with go test -bench . -benchmem, go test -cpuprofile and -memprofile
+// profile them. The bench command runs the same measures from the binary,
+// with its own -cpuprofile and -memprofile.
+
+// fixture sizes in files
+var benchSizes = defaultBenchSizes
+
+// generateFixture writes a fixture of files files under dir
+func generateFixture(tb testing.TB, dir string, files int) {
+	tb.Helper()
+	if err := GenerateFixture(dir, files, benchSeed); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// benchRepository initializes a repository of its own for the benchmark,
+// the worktree is not entered
+func benchRepository(tb testing.TB) {
+	tb.Helper()
+	saved := gitDir
+	gitDir = filepath.Join(tb.TempDir(), ".git")
+	dropObjectCaches()
+	tb.Cleanup(func() {
+		gitDir = saved
+		dropObjectCaches()
+	})
+	if err := InitRepository(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// fixturePack generates a fixture of files files and returns its objects
+// packed, with the worktree they came from
+func fixturePack(tb testing.TB, files int) ([]byte, string) {
+	tb.Helper()
+	worktree := filepath.Join(tb.TempDir(), "fixture")
+	generateFixture(tb, worktree, files)
+	raw, err := packFixture(context.Background(), worktree)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return raw, worktree
+}
+
+func TestGenerateFixtureReproducible(t *testing.T) {
+	trees := []string{}
+	for range 2 {
+		benchRepository(t)
+		worktree := filepath.Join(t.TempDir(), "fixture")
+		generateFixture(t, worktree, 50)
+		_, sha, err := BuildTreeFromDir(context.Background(), worktree, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, fmt.Sprintf("%x", sha))
+	}
+	if trees[0] != trees[1] {
+		t.Errorf("the same seed generated the trees %s", trees)
+	}
+}
+
+func BenchmarkParseObjects(b *testing.B) {
+	for _, files := range benchSizes {
+		b.Run(fmt.Sprint(files), func(b *testing.B) {
+			benchRepository(b)
+			raw, _ := fixturePack(b, files)
+			if err := benchParseObjects(context.Background(), b, raw); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func BenchmarkBuildTreeFromDir(b *testing.B) {
+	for _, files := range benchSizes {
+		b.Run(fmt.Sprint(files), func(b *testing.B) {
+			benchRepository(b)
+			worktree := filepath.Join(b.TempDir(), "fixture")
+			generateFixture(b, worktree, files)
+			if err := benchBuildTree(context.Background(), b, worktree); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBenchProfiles(t *testing.T) {
+	testRepository(t)
+	// one iteration is enough to check the report and the profiles
+	benchtime := flag.Lookup("test.benchtime")
+	saved := benchtime.Value.String()
+	if err := benchtime.Value.Set("1x"); err != nil {
+		t.Fatal(err)
+	}
+	defer benchtime.Value.Set(saved)
+
+	output, err := runCommand(t, "bench", "-cpuprofile", "cpu.out", "-memprofile", "mem.out", "20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ParseObjects/20", "BuildTreeFromDir/20"} {
+		if !strings.Contains(output, name) {
+			t.Errorf("bench printed\n%s\nwithout %s", output, name)
+		}
+	}
+	for _, file := range []string{"cpu.out", "mem.out"} {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("no %s profile: %v", file, err)
+		}
+	}
+	// the measures leave the repository they ran from alone
+	if gitDir != ".git" {
+		t.Errorf("gitDir is %s after bench", gitDir)
+	}
+
+	if _, err := runCommand(t, "bench", "-cpuprofile"); !errors.Is(err, InvalidArgsError) {
+		t.Errorf("bench -cpuprofile without a file: %v", err)
+	}
+	if _, err := runCommand(t, "bench", "0"); !errors.Is(err, InvalidArgsError) {
+		t.Errorf("bench 0: %v", err)
+	}
+}
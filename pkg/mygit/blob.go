@@ -0,0 +1,152 @@
+package mygit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	InvalidBlob = errors.New("File at path cannot be parsed into a blob.")
+)
+
+// files bigger than this are hashed without reading them in memory
+const streamThreshold = 32 << 20
+
+type Blob struct {
+	content []byte
+}
+
+func (b *Blob) Kind() ObjectKind {
+	return BlobKind
+}
+func (b *Blob) Content() []byte {
+	return b.content
+}
+
+func (b *Blob) String() string {
+	return string(b.content)
+}
+
+func ReadBlobFromFile(path string) (*Blob, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		return nil, InvalidBlob
+	}
+
+	buf := bytes.Buffer{}
+
+	_, err = buf.ReadFrom(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blob{
+		content: buf.Bytes(),
+	}, nil
+}
+
+// HashFile returns the blob sha of the file at path, writing the blob only
+// when write is set. Big files are streamed through the hash and zlib.
+func HashFile(path string, write bool) (nilSha [20]byte, _ error) {
+	id, err := hashFile(path, write)
+	if err != nil {
+		return nilSha, err
+	}
+	return storedID(id)
+}
+
+// hashFile is HashFile with the id as long as the hash of the repository
+// makes it, hash-object prints ids which cannot be stored yet
+func hashFile(path string, write bool) ([]byte, error) {
+	if write {
+		if err := checkWritableFormat(); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, InvalidBlob
+	}
+	if stat.Size() < streamThreshold {
+		blob, err := ReadBlobFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !write {
+			id, _ := HashObject(blob)
+			return id, nil
+		}
+		sha, err := WriteContent(blob)
+		return sha[:], err
+	}
+
+	hasher := objectHasher.New()
+	var out io.Writer = hasher
+	var tmp *os.File
+	var compressed *zlib.Writer
+	if write {
+		// the final name is only known once the whole file is hashed
+		if tmp, err = os.CreateTemp(gitPath("objects"), "tmp_obj_"); err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		compressed = zlib.NewWriter(tmp)
+		out = io.MultiWriter(hasher, compressed)
+	}
+
+	fmt.Fprintf(out, "%s %d\x00", BlobKind, stat.Size())
+	n, err := io.Copy(out, io.LimitReader(file, stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+	if n != stat.Size() {
+		return nil, fmt.Errorf("%s changed size while being hashed", path)
+	}
+
+	id := hasher.Sum(nil)
+	if !write {
+		return id, nil
+	}
+
+	if err := compressed.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	sha := fmt.Sprintf("%x", id)
+	if err := os.Mkdir(gitPath("objects", sha[:2]), 0o755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), looseObjectPath(sha)); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
@@ -0,0 +1,86 @@
+package mygit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHashFileStreamsLargeFiles(t *testing.T) {
+	testRepository(t)
+	content := bytes.Repeat([]byte("a line of a file too large to be read at once\n"), streamThreshold/40)
+	if len(content) < streamThreshold {
+		t.Fatalf("the file has %d bytes only", len(content))
+	}
+	if err := os.WriteFile("large", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	buffered, _ := HashObject(&Blob{content: content})
+
+	streamed, err := HashFile("large", false)
+	if err != nil || !bytes.Equal(streamed[:], buffered) {
+		t.Fatalf("streamed %x, %v, want %x", streamed, err, buffered)
+	}
+	sha := fmt.Sprintf("%x", buffered)
+	if HasObject(sha) {
+		t.Error("hashing wrote the blob")
+	}
+
+	if written, err := HashFile("large", true); err != nil || !bytes.Equal(written[:], buffered) {
+		t.Fatalf("wrote %x, %v, want %x", written, err, buffered)
+	}
+	kind, data, err := readObject(sha)
+	if err != nil || kind != BlobKind || !bytes.Equal(data, content) {
+		t.Errorf("read back %s of %d bytes, %v", kind, len(data), err)
+	}
+	entries, err := os.ReadDir(gitPath("objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Errorf("%s was left in the objects", entry.Name())
+		}
+	}
+}
+
+func TestHashFileSmallFiles(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"small": "small\n"})
+	sha, err := HashFile("small", true)
+	if err != nil || fmt.Sprintf("%x", sha) != blobSha("small\n") {
+		t.Errorf("hashed %x, %v, want %s", sha, err, blobSha("small\n"))
+	}
+	if _, err := HashFile(".git", false); err != InvalidBlob {
+		t.Errorf("hashed a directory: %v", err)
+	}
+}
+
+func TestHashObjectMatchesGit(t *testing.T) {
+	testRepository(t)
+	large := make([]byte, 200000)
+	for i := range large {
+		large[i] = byte(i * 7 % 251)
+	}
+	writeFiles(t, map[string]string{
+		"empty":   "",
+		"partial": "no trailing newline",
+		"binary":  "bin\x00ary\r\n\x00\xffdata",
+		"large":   string(large),
+	})
+	for _, name := range []string{"empty", "partial", "binary", "large"} {
+		matchGit(t, "hash-object", name)
+	}
+
+	// git reads back what mygit writes
+	output, err := runCommand(t, "hash-object", "-w", "large")
+	sha := strings.TrimSpace(output)
+	if err != nil || sha != runGit(t, "hash-object", "large") {
+		t.Fatalf("hash-object -w printed %q, %v", output, err)
+	}
+	if content := gitOutput(t, nil, "cat-file", "blob", sha); !bytes.Equal(content, large) {
+		t.Errorf("git read back %d bytes, want %d", len(content), len(large))
+	}
+}
@@ -0,0 +1,96 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	BranchNotMerged   = errors.New("The branch is not fully merged")
+	BranchCheckedOut  = errors.New("Cannot delete the branch HEAD points to")
+	BranchNotFound    = errors.New("Branch not found")
+	BranchExists      = errors.New("A branch with this name already exists")
+	InvalidBranchName = errors.New("Not a valid branch name")
+)
+
+// BranchMerged tells whether the commit at the tip of branch is reachable
+// from HEAD or from another branch, deleting it then loses no commit
+func BranchMerged(branch string) (bool, error) {
+	name := "refs/heads/" + branch
+	tip, err := readRef(name)
+	if err != nil {
+		return false, err
+	}
+	branches, err := listBranches()
+	if err != nil {
+		return false, err
+	}
+	tips := []string{}
+	if head, err := ResolveRef("HEAD"); err == nil {
+		tips = append(tips, head)
+	}
+	for _, ref := range branches {
+		if ref.Name != name {
+			tips = append(tips, ref.Hash)
+		}
+	}
+	reachable, err := Ancestors(tips...)
+	if err != nil {
+		return false, err
+	}
+	return reachable[tip], nil
+}
+
+// DeleteBranch removes the branch and its reflog, returning the commit it
+// pointed to. Unless force, the branch must be merged as BranchMerged tells.
+// The branch HEAD points to is never deleted.
+func DeleteBranch(branch string, force bool) (string, error) {
+	name := "refs/heads/" + branch
+	tip, err := readRef(name)
+	if errors.Is(err, RefNotFound) {
+		return "", fmt.Errorf("%w: %s", BranchNotFound, branch)
+	}
+	if err != nil {
+		return "", err
+	}
+	if target, symbolic, err := ReadSymbolicRef("HEAD"); err != nil {
+		return "", err
+	} else if symbolic && target == name {
+		return "", fmt.Errorf("%w: %s", BranchCheckedOut, branch)
+	}
+	if !force {
+		merged, err := BranchMerged(branch)
+		if err != nil {
+			return "", err
+		}
+		if !merged {
+			return "", fmt.Errorf("%w: %s, delete it with -D to lose its commits", BranchNotMerged, branch)
+		}
+	}
+
+	tx := &RefTransaction{}
+	tx.Delete(name, tip)
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	if err := os.Remove(gitPath("logs", name)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return tip, nil
+}
+
+// CreateBranch adds the branch pointing to the commit at sha
+func CreateBranch(branch, sha string) error {
+	name := "refs/heads/" + branch
+	if err := checkRefName(name); err != nil || branch == "HEAD" || strings.HasPrefix(branch, "-") {
+		return fmt.Errorf("%w: %s", InvalidBranchName, branch)
+	}
+	if _, err := readRef(name); err == nil {
+		return fmt.Errorf("%w: %s", BranchExists, branch)
+	}
+	tx := &RefTransaction{}
+	tx.Create(name, sha)
+	return tx.Commit()
+}
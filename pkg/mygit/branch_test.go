@@ -0,0 +1,43 @@
+package mygit
+
+import (
+	"testing"
+)
+
+func TestBranchDeleteMatchesGit(t *testing.T) {
+	testRepository(t)
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "base")
+	runGit(t, "branch", "merged")
+	runGit(t, "checkout", "-q", "-b", "unmerged")
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "work in progress")
+	runGit(t, "checkout", "-q", "main")
+	matchGit(t, "branch")
+
+	// deleted as git reports it
+	want := "Deleted branch merged (was " + runGit(t, "rev-parse", "--short", "merged") + ")."
+	if output, err := runCommand(t, "branch", "-d", "merged"); err != nil || output != want+"\n" {
+		t.Errorf("branch -d merged printed %q, %v, want %q", output, err, want)
+	}
+
+	// refused like git does, until -D for the unmerged one
+	unmerged := runGit(t, "rev-parse", "unmerged")
+	for _, args := range [][]string{{"branch", "-d", "unmerged"}, {"branch", "-D", "main"}} {
+		if _, status := gitStatus(t, nil, args...); status == 0 {
+			t.Fatalf("git %v succeeded", args)
+		}
+		if _, err := runCommand(t, append([]string{"-q"}, args...)...); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+	if sha := runGit(t, "rev-parse", "unmerged"); sha != unmerged {
+		t.Errorf("unmerged moved to %s", sha)
+	}
+	want = "Deleted branch unmerged (was " + runGit(t, "rev-parse", "--short", "unmerged") + ")."
+	if output, err := runCommand(t, "branch", "-D", "unmerged"); err != nil || output != want+"\n" {
+		t.Errorf("branch -D unmerged printed %q, %v, want %q", output, err, want)
+	}
+	if branches := runGit(t, "branch"); branches != "* main" {
+		t.Errorf("git lists the branches\n%s", branches)
+	}
+}
@@ -0,0 +1,214 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// https://git-scm.com/docs/gitformat-bundle
+const bundleSignature = "# v2 git bundle\n"
+
+var (
+	InvalidBundle = errors.New("File cannot be parsed into a bundle.")
+)
+
+type Bundle struct {
+	Refs          []Ref
+	Prerequisites []string // commits the pack is built on, which it leaves out
+	Pack          []byte
+}
+
+// WriteBundle writes a bundle holding refs and every object reachable from them
+func WriteBundle(w io.Writer, refs []Ref) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString(bundleSignature)
+	tips := []string{}
+	for _, ref := range refs {
+		fmt.Fprintf(buf, "%s %s\n", ref.Hash, ref.Name)
+		tips = append(tips, ref.Hash)
+	}
+	buf.WriteString("\n")
+
+	objects, err := ReachableObjects(tips)
+	if err != nil {
+		return err
+	}
+	if err := WritePack(buf, objects); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+func ReadBundle(file string) (*Bundle, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	rest, found := bytes.CutPrefix(content, []byte(bundleSignature))
+	if !found {
+		return nil, InvalidBundle
+	}
+
+	bundle := &Bundle{}
+	for {
+		line, next, found := bytes.Cut(rest, []byte{'\n'})
+		if !found {
+			return nil, InvalidBundle
+		}
+		rest = next
+		if len(line) == 0 { // end of header, the packfile follows
+			break
+		}
+		if line[0] == '-' { // "-<sha> <comment>"
+			hash, _, _ := strings.Cut(string(line[1:]), " ")
+			if !isHash(hash) {
+				return nil, InvalidBundle
+			}
+			bundle.Prerequisites = append(bundle.Prerequisites, hash)
+			continue
+		}
+		hash, name, found := strings.Cut(string(line), " ")
+		if !found || !isHash(hash) {
+			return nil, InvalidBundle
+		}
+		bundle.Refs = append(bundle.Refs, Ref{Name: name, Hash: hash})
+	}
+	bundle.Pack = rest
+
+	return bundle, nil
+}
+
+// IsBundle tells whether file starts with the bundle signature
+func IsBundle(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	signature := make([]byte, len(bundleSignature))
+	if _, err := io.ReadFull(f, signature); err != nil {
+		return false
+	}
+	return string(signature) == bundleSignature
+}
+
+// checkPrerequisites fails unless the repository has every prerequisite
+func (b *Bundle) checkPrerequisites() error {
+	missing := []string{}
+	for _, hash := range b.Prerequisites {
+		if !HasObject(hash) {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Repository lacks the prerequisite commits %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Verify checks that the bundle can be unbundled in the repository: the
+// prerequisites are there, the pack checksum matches, every object of the
+// pack resolves and the refs point to objects the pack or the repository has.
+// Nothing is written.
+func (b *Bundle) Verify() error {
+	if err := b.checkPrerequisites(); err != nil {
+		return err
+	}
+	objects, err := PackObjectIDs(b.Pack)
+	if err != nil {
+		return fmt.Errorf("%w: %w", InvalidBundle, err)
+	}
+	for _, ref := range b.Refs {
+		if _, ok := objects[ref.Hash]; !ok && !HasObject(ref.Hash) {
+			return fmt.Errorf("%w: %s points to %s which is missing", InvalidBundle, ref.Name, ref.Hash)
+		}
+	}
+	return nil
+}
+
+// Unbundle writes the bundle objects to the repository and returns their count
+func (b *Bundle) Unbundle(ctx context.Context) (ObjectCounts, error) {
+	if err := b.checkPrerequisites(); err != nil {
+		return ObjectCounts{}, err
+	}
+	return unpackPack(ctx, b.Pack)
+}
+
+func cloneFromBundle(ctx context.Context, file, wanted, worktree string) (result CloneResult, _ error) {
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		return result, err
+	}
+	counts, err := bundle.Unbundle(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	headHash, branch := "", ""
+	for _, ref := range bundle.Refs {
+		if ref.Name == "HEAD" {
+			headHash = ref.Hash
+		}
+	}
+	// HEAD points to the requested branch, else to the first branch at the
+	// bundled HEAD, else to the first branch
+	for _, ref := range bundle.Refs {
+		if ref.Name == "HEAD" {
+			continue
+		}
+		if wanted != "" {
+			if ref.Name == "refs/heads/"+wanted || ref.Name == "refs/tags/"+wanted {
+				headHash, branch = ref.Hash, ref.Name
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(ref.Name, "refs/heads/") && (headHash == "" || ref.Hash == headHash) {
+			headHash, branch = ref.Hash, ref.Name
+			break
+		}
+	}
+	if branch == "" {
+		return result, fmt.Errorf("Bundle %s has no branch to check out", file)
+	}
+
+	// like any clone, the branches become remote-tracking refs and the
+	// checked out one is created with HEAD, the tags are kept as they are
+	t := RefTransaction{}
+	for _, ref := range bundle.Refs {
+		if ref.Name == "HEAD" || ref.Name == branch && strings.HasPrefix(branch, "refs/heads/") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(ref.Name, "refs/heads/"); ok {
+			t.Create("refs/remotes/origin/"+name, ref.Hash)
+		} else if strings.HasPrefix(ref.Name, "refs/tags/") {
+			t.Create(ref.Name, ref.Hash)
+		}
+	}
+	if err := t.Commit(); err != nil {
+		return result, err
+	}
+	if err := writeClonedHead(headHash, branch); err != nil {
+		return result, err
+	}
+	url, err := filepath.Abs(file)
+	if err != nil {
+		return result, err
+	}
+	if err := recordOrigin(url); err != nil {
+		return result, err
+	}
+	if err := Checkout(ctx, worktree, headHash); err != nil {
+		return result, err
+	}
+	return CloneResult{Head: headHash, Branch: branch, Objects: counts}, nil
+}
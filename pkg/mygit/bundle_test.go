@@ -0,0 +1,225 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTestBundle commits twice, tags the first commit and bundles main and
+// the tag in bundle.pack next to the repository
+func writeTestBundle(t *testing.T) (file string, refs []Ref) {
+	t.Helper()
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n"})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "two\n"})
+	second := commitWorktree(t, "second")
+	if err := UpdateRef("refs/tags/v1", first); err != nil {
+		t.Fatal(err)
+	}
+
+	refs = []Ref{{Name: "refs/heads/main", Hash: second}, {Name: "refs/tags/v1", Hash: first}}
+	file = filepath.Join(t.TempDir(), "repo.bundle")
+	buf := bytes.Buffer{}
+	if err := WriteBundle(&buf, refs); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file, refs
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	testRepository(t)
+	file, refs := writeTestBundle(t)
+	objects, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(bundle.Refs) != fmt.Sprint(refs) {
+		t.Errorf("refs %v, want %v", bundle.Refs, refs)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	result, err := Clone(context.Background(), CloneOptions{URL: file, Dir: clone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Head != refs[0].Hash || result.Branch != "refs/heads/main" {
+		t.Errorf("clone result %+v, want main at %s", result, refs[0].Hash)
+	}
+	if result.Objects.Commits != 2 || result.Objects.Trees != 3 || result.Objects.Blobs != 3 {
+		t.Errorf("clone counted %s", result.Objects)
+	}
+
+	chdir(t, clone)
+	for _, ref := range refs {
+		if hash, err := ResolveRef(ref.Name); err != nil || hash != ref.Hash {
+			t.Errorf("%s is %s, %v, want %s", ref.Name, hash, err, ref.Hash)
+		}
+	}
+	cloned, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(cloned) != fmt.Sprint(objects) {
+		t.Errorf("cloned objects %v, want %v", cloned, objects)
+	}
+	if content, err := os.ReadFile("file"); err != nil || string(content) != "two\n" {
+		t.Errorf("checked out file %q, %v", content, err)
+	}
+}
+
+func TestBundleVerifyRejectsDamage(t *testing.T) {
+	testRepository(t)
+	file, _ := writeTestBundle(t)
+	good, err := ReadBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte{}, good.Pack...)
+	flipped[len(flipped)/2] ^= 0xff
+	truncated := good.Pack[:len(good.Pack)-30]
+	// a consistent pack missing the objects of a ref
+	shortPack := bytes.Buffer{}
+	if err := WritePack(&shortPack, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, pack := range map[string][]byte{"flipped": flipped, "truncated": truncated, "short": shortPack.Bytes()} {
+		chdir(t, t.TempDir()) // outside the repository, whose objects would complete the pack
+		bundle := &Bundle{Refs: good.Refs, Pack: pack}
+		if err := bundle.Verify(); !errors.Is(err, InvalidBundle) {
+			t.Errorf("%s pack: %v, want InvalidBundle", name, err)
+		}
+	}
+}
+
+func TestBundlePrerequisites(t *testing.T) {
+	testRepository(t)
+	file, refs := writeTestBundle(t)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := "3333333333333333333333333333333333333333"
+	header := bundleSignature + "-" + missing + " a commit\n-" + refs[1].Hash + "\n"
+	data = append([]byte(header), data[len(bundleSignature):]...)
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{missing, refs[1].Hash}; fmt.Sprint(bundle.Prerequisites) != fmt.Sprint(want) {
+		t.Errorf("prerequisites %v, want %v", bundle.Prerequisites, want)
+	}
+	if err := bundle.Verify(); err == nil {
+		t.Error("a bundle whose prerequisite is missing was verified")
+	}
+	if _, err := bundle.Unbundle(context.Background()); err == nil {
+		t.Error("a bundle whose prerequisite is missing was unbundled")
+	}
+}
+
+func TestBundleSkipsGitlinks(t *testing.T) {
+	testRepository(t)
+	submodule := "1111111111111111111111111111111111111111"
+	blob, err := WriteContent(&Blob{content: []byte("file\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := WriteTreeFromFiles(map[string]entry{
+		"file":    {mode: "100644", hash: fmt.Sprintf("%x", blob)},
+		"lib/sub": {mode: "160000", hash: submodule},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := runCommand(t, "commit-tree", fmt.Sprintf("%x", tree), "-m", "with a submodule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := strings.TrimSpace(output)
+	if err := UpdateRef("refs/heads/main", commit); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := ReachableObjects([]string{commit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 4 || slices.Contains(objects, submodule) {
+		t.Errorf("reachable objects %v", objects)
+	}
+	file := filepath.Join(t.TempDir(), "repo.bundle")
+	if _, err := runCommand(t, "bundle", "create", file, "main"); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Verify(); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+}
+
+func TestBundleCloneLayout(t *testing.T) {
+	testRepository(t)
+	file, refs := writeTestBundle(t)
+	main, v1 := refs[0].Hash, refs[1].Hash
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second branch, at the tagged commit
+	bundle.Refs = append(bundle.Refs, Ref{Name: "refs/heads/topic", Hash: v1})
+	buf := bytes.Buffer{}
+	if err := WriteBundle(&buf, bundle.Refs); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if _, err := Clone(context.Background(), CloneOptions{URL: file, Dir: clone}); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, clone)
+	// the branches are remote-tracking refs, only the checked out one is local
+	output, err := runCommand(t, "for-each-ref", "--format=%(objectname) %(refname)")
+	want := main + " refs/heads/main\n" +
+		main + " refs/remotes/origin/main\n" +
+		v1 + " refs/remotes/origin/topic\n" +
+		v1 + " refs/tags/v1\n"
+	if err != nil || output != want {
+		t.Errorf("the refs are\n%s%v\nwant\n%s", output, err, want)
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url, _ := cfg.Get("remote.origin.url"); url != file {
+		t.Errorf("origin is %q, want %q", url, file)
+	}
+}
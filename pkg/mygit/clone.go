@@ -0,0 +1,899 @@
+package mygit
+
+// https://stefan.saasen.me/articles/git-clone-in-haskell-from-the-bottom-up/#reimplementing-git-clone-in-haskell-from-the-bottom-up
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type packFileKind byte
+
+const (
+	commit   packFileKind = 1
+	tree     packFileKind = 2
+	blob     packFileKind = 3
+	tag      packFileKind = 4
+	ofsDelta packFileKind = 6
+	refDelta packFileKind = 7
+)
+
+func parsePacketLine(r io.Reader) ([]byte, error) {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(string(lengthBytes), 16, 64)
+	if err != nil || length == 0 {
+		return nil, err
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("packet line has invalid length %d", length)
+	}
+	line := make([]byte, length-4)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return nil, fmt.Errorf("packet line doesnt' match declared length: %w", err)
+	}
+
+	return line, nil
+}
+
+// a flush packet separates sections and ends lists in pkt-line streams
+const flushPacket = "0000"
+
+func serializePackeLine(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+type RefAdvertisement struct {
+	URL          string // repository url after redirects
+	Refs         []Ref  // in advertised order, HEAD included
+	Capabilities []string
+}
+
+// Symref returns the target of a symbolic ref from the symref=<name>:<target> capabilities
+func (a *RefAdvertisement) Symref(name string) (string, bool) {
+	for _, capability := range a.Capabilities {
+		if value, found := strings.CutPrefix(capability, "symref="); found {
+			if source, target, found := strings.Cut(value, ":"); found && source == name {
+				return target, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (a *RefAdvertisement) Lookup(name string) (string, bool) {
+	for _, ref := range a.Refs {
+		if ref.Name == name {
+			return ref.Hash, true
+		}
+	}
+	return "", false
+}
+
+// Branch finds name among the advertised branches, then tags
+func (a *RefAdvertisement) Branch(name string) (string, string, error) {
+	for _, ref := range []string{"refs/heads/" + name, "refs/tags/" + name} {
+		if hash, ok := a.Lookup(ref); ok {
+			return hash, ref, nil
+		}
+	}
+	return "", "", fmt.Errorf("Remote branch %s not found", name)
+}
+
+// Head returns the sha and the branch the remote HEAD points to
+func (a *RefAdvertisement) Head() (string, string, error) {
+	headHash, hasHead := a.Lookup("HEAD")
+	if branch, ok := a.Symref("HEAD"); ok {
+		if hash, ok := a.Lookup(branch); ok {
+			return hash, branch, nil
+		}
+	}
+	if !hasHead {
+		return "", "", fmt.Errorf("[GetRefs]: Remote does not advertise HEAD")
+	}
+	// servers without the symref capability, guess from the sha like git does
+	branch := ""
+	for _, ref := range a.Refs {
+		if ref.Hash != headHash || !strings.HasPrefix(ref.Name, "refs/heads/") {
+			continue
+		}
+		if branch == "" || ref.Name == "refs/heads/main" || ref.Name == "refs/heads/master" {
+			branch = ref.Name
+		}
+	}
+	if branch == "" {
+		return "", "", fmt.Errorf("[GetRefs]: Remote HEAD %s does not match any branch", headHash)
+	}
+	return headHash, branch, nil
+}
+
+// normalizeRemoteURL drops trailing slashes so paths can be appended
+func normalizeRemoteURL(url string) string {
+	return strings.TrimRight(url, "/")
+}
+
+// https://git-scm.com/docs/http-protocol
+// GetRefs fetches the ref advertisement, following redirects and trying the
+// ".git" suffix when the plain url is not found
+func GetRefs(ctx context.Context, url string) (*RefAdvertisement, error) {
+	url = normalizeRemoteURL(url)
+	adv, status, err := getRefs(ctx, url)
+	if status == http.StatusNotFound && !strings.HasSuffix(url, ".git") {
+		adv, _, err = getRefs(ctx, url+".git")
+	}
+	return adv, err
+}
+
+func getRefs(ctx context.Context, url string) (*RefAdvertisement, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/info/refs?service=git-upload-pack", url), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, r.StatusCode, fmt.Errorf("[GetRefs]: Retrieving refs return %d status code %q", r.StatusCode, r.Status)
+	}
+
+	// skip line: 001e# service=git-upload-pack\n
+	_, err = parsePacketLine(r.Body)
+	if err != nil {
+		return nil, r.StatusCode, err
+	}
+	// skip line: 0000
+	_, err = parsePacketLine(r.Body)
+	if err != nil {
+		return nil, r.StatusCode, err
+	}
+
+	adv, err := parseRefAdvertisement(r.Body)
+	if err != nil {
+		return nil, r.StatusCode, err
+	}
+	// the client followed any redirect, upload-pack lives next to the final url
+	final := *r.Request.URL
+	final.RawQuery = ""
+	adv.URL = strings.TrimSuffix(final.String(), "/info/refs")
+	return adv, r.StatusCode, nil
+}
+
+// each line is "<sha> <refname>\n", the first one also carries the
+// capabilities after a NUL byte, a flush packet ends the list
+func parseRefAdvertisement(r io.Reader) (*RefAdvertisement, error) {
+	adv := &RefAdvertisement{}
+	for {
+		line, err := parsePacketLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == nil { // flush
+			break
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		if len(adv.Refs) == 0 && len(adv.Capabilities) == 0 {
+			var capabilities []byte
+			line, capabilities, _ = bytes.Cut(line, []byte{0})
+			adv.Capabilities = strings.Fields(string(capabilities))
+			// before the refs, whose ids would not parse otherwise
+			if _, err := adv.ObjectFormat(); err != nil {
+				return nil, err
+			}
+			if server, ok := adv.Capability("agent"); ok {
+				Verbosef("Server agent %s\n", server)
+			}
+		}
+		hash, name, found := strings.Cut(string(line), " ")
+		if !found || !isHash(hash) {
+			return nil, fmt.Errorf("[GetRefs]: Refs is not in the expected form")
+		}
+		if name == "capabilities^{}" { // empty repository
+			continue
+		}
+		adv.Refs = append(adv.Refs, Ref{Name: name, Hash: hash})
+	}
+	return adv, nil
+}
+
+// mygit identifies itself to servers with the agent capability
+const agent = "mygit/0.1"
+
+// Capability returns the value of capability name=value in the advertisement
+func (a *RefAdvertisement) Capability(name string) (string, bool) {
+	for _, capability := range a.Capabilities {
+		if value, found := strings.CutPrefix(capability, name+"="); found {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// ObjectFormat returns the object format of the remote repository, sha1 when
+// the server does not say. Only sha1 repositories can be fetched, as objects
+// are stored with 20 bytes ids.
+func (a *RefAdvertisement) ObjectFormat() (ObjectFormat, error) {
+	name, ok := a.Capability("object-format")
+	if !ok {
+		return SHA1, nil
+	}
+	format, err := ParseObjectFormat(name)
+	if err == nil && format != SHA1 {
+		err = fmt.Errorf("%w: the remote repository uses %s, only sha1 repositories can be fetched", UnsupportedObjectFormat, format)
+	}
+	return format, err
+}
+
+// capabilities requested on clone, only the ones the server advertises are sent
+var clientCapabilities = []string{"ofs-delta", "thin-pack", "no-progress", "include-tag", "agent=" + agent, "object-format=" + string(SHA1)}
+
+// Supports tells whether the server advertised capability, values after '=' are ignored
+func (a *RefAdvertisement) Supports(capability string) bool {
+	name, _, _ := strings.Cut(capability, "=")
+	for _, advertised := range a.Capabilities {
+		if advertised == name || strings.HasPrefix(advertised, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate returns the wanted capabilities the server supports
+func (a *RefAdvertisement) Negotiate(wanted []string) []string {
+	capabilities := []string{}
+	for _, capability := range wanted {
+		if a.Supports(capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+type UploadPackRequest struct {
+	Wants        []string
+	Capabilities []string
+	Depth        int      // 0 fetches the whole history
+	Filter       string   // object filter like "blob:none", needs the filter capability
+	Haves        []string // commits we have, the pack leaves out what they reach
+	// a negotiation round ends with a flush instead of "done", the server
+	// only acknowledges the haves it has too and sends no pack
+	KeepNegotiating bool
+}
+
+type UploadPackResponse struct {
+	Shallow []string // commits whose parents were not sent
+	Pack    []byte
+}
+
+// https://git-scm.com/docs/pack-protocol#_packfile_negotiation
+// capabilities may only appear on the first want line
+func (r UploadPackRequest) Encode() []byte {
+	body := &bytes.Buffer{}
+	for i, hash := range r.Wants {
+		want := "want " + hash
+		if i == 0 && len(r.Capabilities) > 0 {
+			want += " " + strings.Join(r.Capabilities, " ")
+		}
+		body.WriteString(serializePackeLine(want + "\n"))
+	}
+	if r.Depth > 0 {
+		body.WriteString(serializePackeLine(fmt.Sprintf("deepen %d\n", r.Depth)))
+	}
+	if r.Filter != "" {
+		body.WriteString(serializePackeLine("filter " + r.Filter + "\n"))
+	}
+	body.WriteString(flushPacket)
+	for _, hash := range r.Haves {
+		body.WriteString(serializePackeLine("have " + hash + "\n"))
+	}
+	if r.KeepNegotiating {
+		body.WriteString(flushPacket)
+	} else {
+		body.WriteString(serializePackeLine("done\n")) // end request
+	}
+	return body.Bytes()
+}
+
+// Ack is the server telling it has one of our haves
+type Ack struct {
+	Hash   string
+	Status string // "common" or "ready" with multi_ack_detailed, empty on the ack before the pack
+}
+
+// parseAcks reads the acknowledgments up to the NAK ending a negotiation
+// round, or the final ack sent before the pack
+func parseAcks(r io.Reader) ([]Ack, error) {
+	acks := []Ack{}
+	for {
+		line, err := parsePacketLine(r)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(string(line))
+		switch {
+		case len(fields) == 1 && fields[0] == "NAK":
+			return acks, nil
+		case len(fields) == 2 && fields[0] == "ACK" && isHash(fields[1]):
+			return append(acks, Ack{Hash: fields[1]}), nil
+		case len(fields) == 3 && fields[0] == "ACK" && isHash(fields[1]):
+			acks = append(acks, Ack{Hash: fields[1], Status: fields[2]})
+		default:
+			return nil, fmt.Errorf("Expecting 'ACK' or 'NAK' got %q", string(line))
+		}
+	}
+}
+
+func postUploadPack(ctx context.Context, url string, request UploadPackRequest) (*http.Response, error) {
+	body := bytes.NewBuffer(request.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/git-upload-pack", url), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		r.Body.Close()
+		return nil, fmt.Errorf("[UploadPack]: url/git-upload-pack return %d status code %q", r.StatusCode, r.Status)
+	}
+	return r, nil
+}
+
+// UploadPackRound sends one negotiation round and returns what the server
+// acknowledged. With no-done a server ready to send the pack does so right
+// after the round, the pack is returned too and no request with done is
+// needed.
+func UploadPackRound(ctx context.Context, url string, request UploadPackRequest) ([]Ack, *UploadPackResponse, error) {
+	request.KeepNegotiating = true
+	r, err := postUploadPack(ctx, url, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Body.Close()
+	acks, err := parseAcks(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	ready := slices.ContainsFunc(acks, func(ack Ack) bool { return ack.Status == "ready" })
+	if !ready || !slices.Contains(request.Capabilities, "no-done") {
+		return acks, nil, nil
+	}
+	resp := &UploadPackResponse{}
+	if resp.Pack, err = readPack(r.Body); err != nil {
+		return nil, nil, err
+	}
+	return acks, resp, nil
+}
+
+// https://git-scm.com/docs/http-protocol
+// return the bytes, when parsed we obtain the git objects
+func UploadPack(ctx context.Context, url string, request UploadPackRequest) (*UploadPackResponse, error) {
+	request.KeepNegotiating = false
+	r, err := postUploadPack(ctx, url, request)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	resp := &UploadPackResponse{}
+	if request.Depth > 0 {
+		// shallow-info section, ended by a flush
+		for {
+			line, err := parsePacketLine(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			if line == nil {
+				break
+			}
+			if hash, found := bytes.CutPrefix(bytes.TrimSpace(line), []byte("shallow ")); found {
+				resp.Shallow = append(resp.Shallow, string(hash))
+			}
+		}
+	}
+
+	if resp.Pack, err = readPack(r.Body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// readPack reads the pack ending a response, after the haves are
+// acknowledged again
+func readPack(r io.Reader) ([]byte, error) {
+	if _, err := parseAcks(r); err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	if _, err := io.Copy(body, r); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+type CloneOptions struct {
+	URL    string // remote url or path to a bundle
+	Dir    string // created, must not exist
+	Branch string // remote default branch when empty
+	Depth  int    // full history when 0
+	Filter string // partial clone filter, "blob:none" or "blob:limit=<n>"
+	Keep   bool   // leave the directory of a failed clone behind
+}
+
+type CloneResult struct {
+	Head    string
+	Branch  string // full ref name
+	Objects ObjectCounts
+}
+
+// marks a clone which has not completed yet, in the .git directory
+const cloneInProgress = "clone-in-progress"
+
+// incompleteClone tells whether dir holds a clone which failed or was killed
+func incompleteClone(dir string) bool {
+	_, err := os.Stat(path.Join(dir, ".git", cloneInProgress))
+	return err == nil
+}
+
+// Clone creates opts.Dir and clones the repository into it. The directory is
+// removed if the clone fails, unless opts.Keep is set, and one left behind by
+// an incomplete clone is rolled back before cloning again. It neither moves
+// to opts.Dir nor changes the repository of the caller, clones run one at a
+// time.
+func Clone(ctx context.Context, opts CloneOptions) (result CloneResult, err error) {
+	if opts.Dir, err = filepath.Abs(opts.Dir); err != nil {
+		return result, err
+	}
+	dir := opts.Dir
+
+	// a local bundle file is cloned without any network access
+	fromBundle := IsBundle(opts.URL)
+	if fromBundle {
+		if opts.URL, err = filepath.Abs(opts.URL); err != nil {
+			return result, err
+		}
+	}
+
+	if incompleteClone(dir) {
+		Infof("Removing incomplete clone in '%s'\n", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return result, err
+		}
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil && !opts.Keep {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	err = inRepository(path.Join(dir, ".git"), func() (err error) {
+		if err := InitRepository(); err != nil {
+			return err
+		}
+		if err := os.WriteFile(gitPath(cloneInProgress), []byte(opts.URL+"\n"), 0o644); err != nil {
+			return err
+		}
+
+		if fromBundle {
+			result, err = cloneFromBundle(ctx, opts.URL, opts.Branch, dir)
+		} else {
+			result, err = clonePlumbing(ctx, opts)
+		}
+		if err != nil {
+			return err
+		}
+		return os.Remove(gitPath(cloneInProgress))
+	})
+	return result, err
+}
+
+// ObjectCounts tells how many objects of each kind were unpacked, deltas are
+// counted with the kind of the object they resolve to and in Deltas
+type ObjectCounts struct {
+	Commits int
+	Trees   int
+	Blobs   int
+	Tags    int
+	Deltas  int
+}
+
+func (c *ObjectCounts) add(kind ObjectKind) {
+	switch kind {
+	case CommitKind:
+		c.Commits++
+	case TreeKind:
+		c.Trees++
+	case BlobKind:
+		c.Blobs++
+	case TagKind:
+		c.Tags++
+	}
+}
+
+func (c ObjectCounts) Total() int {
+	return c.Commits + c.Trees + c.Blobs + c.Tags
+}
+
+func (c ObjectCounts) String() string {
+	return fmt.Sprintf("%d objects (%d commits, %d trees, %d blobs, %d tags), resolved %d deltas",
+		c.Total(), c.Commits, c.Trees, c.Blobs, c.Tags, c.Deltas)
+}
+
+// https://codewords.recurse.com/issues/three/unpacking-git-packfiles
+// ParseObjects writes every object of the pack, cancelling ctx stops it
+// between two objects
+func ParseObjects(ctx context.Context, raw []byte) (counts ObjectCounts, _ error) {
+	if len(raw) < 12 {
+		return counts, fmt.Errorf("Pack file has incomplete header: expected len of at least 12, got %d", len(raw))
+	}
+
+	if !bytes.Equal([]byte{'P', 'A', 'C', 'K'}, raw[:4]) {
+		return counts, fmt.Errorf("Expected magic number 'PACK' got %x", raw[:4])
+	}
+	count := binary.BigEndian.Uint32(raw[8:12])
+	reader := bytes.NewReader(raw[12:])
+	// ofs-delta bases are referenced by their offset in the pack
+	offsets := map[int64]string{}
+	pending := []*pendingDelta{}
+	for range count {
+		if err := ctx.Err(); err != nil {
+			return counts, err
+		}
+		offset := int64(len(raw)) - int64(reader.Len())
+		sha, delta, err := ParseObject(reader, offset, offsets, &counts)
+		if err != nil {
+			return counts, err
+		}
+		if delta != nil {
+			pending = append(pending, delta)
+			continue
+		}
+		offsets[offset] = sha
+	}
+
+	// a thin pack deltifies against objects that are not in the pack, they
+	// must already be in the repository, or be resolved by a previous pass
+	for len(pending) > 0 {
+		unresolved := []*pendingDelta{}
+		for _, delta := range pending {
+			if err := ctx.Err(); err != nil {
+				return counts, err
+			}
+			base, ok := delta.base(offsets)
+			if !ok || !HasObject(base) {
+				unresolved = append(unresolved, delta)
+				continue
+			}
+			sha, err := delta.resolve(base, &counts)
+			if err != nil {
+				return counts, err
+			}
+			offsets[delta.offset] = sha
+		}
+		if len(unresolved) == len(pending) {
+			missing, _ := unresolved[0].base(offsets)
+			if missing == "" {
+				missing = fmt.Sprintf("at offset %d", unresolved[0].baseOffset)
+			}
+			return counts, fmt.Errorf("%w: %d deltas have a missing base, first one is %s", InvalidPackError, len(unresolved), missing)
+		}
+		pending = unresolved
+	}
+	return counts, nil
+}
+
+// a delta whose base was not available when it was read
+type pendingDelta struct {
+	offset     int64
+	baseSha    string // ref-delta
+	baseOffset int64  // ofs-delta
+	data       []byte
+}
+
+func (d *pendingDelta) base(offsets map[int64]string) (string, bool) {
+	if d.baseSha != "" {
+		return d.baseSha, true
+	}
+	sha, ok := offsets[d.baseOffset]
+	return sha, ok
+}
+
+func (d *pendingDelta) resolve(base string, counts *ObjectCounts) (string, error) {
+	obj, err := applyDeltaToObject(base, bytes.NewBuffer(d.data))
+	if err != nil {
+		return "", err
+	}
+	hash, err := WriteContent(obj)
+	if err != nil {
+		return "", err
+	}
+	counts.add(obj.Kind())
+	counts.Deltas++
+	return fmt.Sprintf("%x", hash), nil
+}
+
+// https://codewords.recurse.com/issues/three/unpacking-git-packfiles
+// ParseObject writes the object starting at offset in the pack and returns
+// its sha, deltas whose base is not available yet are returned as pending
+func ParseObject(r *bytes.Reader, offset int64, offsets map[int64]string, counts *ObjectCounts) (string, *pendingDelta, error) {
+	kind, size, err := parseObjectHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var obj GitObject
+	switch kind {
+	case ofsDelta, refDelta:
+		delta := &pendingDelta{offset: offset}
+		if kind == ofsDelta {
+			distance, err := readOfsDeltaOffset(r)
+			if err != nil {
+				return "", nil, err
+			}
+			delta.baseOffset = offset - int64(distance)
+		} else {
+			var sha [20]byte
+			if _, err := io.ReadFull(r, sha[:]); err != nil {
+				return "", nil, fmt.Errorf("Unvalid git sha in [REF_DELTA]: %w", err)
+			}
+			delta.baseSha = fmt.Sprintf("%x", sha)
+		}
+		data, err := decompress(r, size)
+		if err != nil {
+			return "", nil, err
+		}
+		delta.data = data.Bytes()
+
+		base, ok := delta.base(offsets)
+		if !ok || !HasObject(base) {
+			return "", delta, nil
+		}
+		sha, err := delta.resolve(base, counts)
+		return sha, nil, err
+
+	default:
+		data, err := decompress(r, size)
+		if err != nil {
+			return "", nil, err
+		}
+		switch kind {
+		case blob:
+			obj = &Blob{content: data.Bytes()}
+		case tree:
+			obj = &Tree{content: data.Bytes()}
+		case commit:
+			obj = &CommitAsBytes{content: data.Bytes()}
+		case tag:
+			obj = &Tag{content: data.Bytes()}
+		default:
+			return "", nil, fmt.Errorf("%w: unexpected object type %d", InvalidPackError, kind)
+		}
+	}
+
+	hash, err := WriteContent(obj)
+	if err != nil {
+		return "", nil, err
+	}
+	counts.add(obj.Kind())
+	return fmt.Sprintf("%x", hash), nil, nil
+}
+
+// applyDeltaToObject rebuilds a deltified object on top of the already written base
+func applyDeltaToObject(base string, data *bytes.Buffer) (GitObject, error) {
+	baseObj, err := ReadGitObject(base)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := applyDelta(baseObj.Content(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitObject(baseObj.Kind(), content)
+}
+
+// https://git-scm.com/docs/pack-format#_deltified_representation
+func applyDelta(base []byte, data *bytes.Buffer) ([]byte, error) {
+	srcSize, err := binary.ReadUvarint(data)
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, fmt.Errorf("Expected base object to have size %d, got %d", srcSize, uint32(len(base)))
+	}
+	dstSize, err := binary.ReadUvarint(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for {
+		b, err := data.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// read offset
+		if b&0x80 != 0 { // COPY
+			offset, size := 0, 0
+			for i := range 4 {
+				if b&(1<<i) != 0 {
+					b_, err := data.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					offset |= int(b_) << (i * 8)
+				}
+			}
+
+			for i := range 3 {
+				if b&(0x10<<i) != 0 {
+					b_, err := data.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					size |= int(b_) << (i * 8)
+				}
+			}
+			if size == 0 { // a zero size means 64KiB
+				size = 0x10000
+			}
+			if offset+size > len(base) {
+				return nil, fmt.Errorf("Delta copies past the end of the base object")
+			}
+			buf.Write(base[offset : offset+size])
+
+		} else if b != 0 { // ADD
+			_, err = io.CopyN(buf, data, int64(b&0x7f))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("Delta has reserved instruction 0")
+		}
+
+	}
+
+	if dstSize != uint64(buf.Len()) {
+		return nil, fmt.Errorf("Expected final object to have size %d, got %d", dstSize, buf.Len())
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(r io.Reader, size int64) (*bytes.Buffer, error) {
+	zReader, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zReader.Close()
+	buf := &bytes.Buffer{}
+	if _, err = io.CopyN(buf, zReader, size); err != nil {
+		return nil, err
+	}
+	// reading on to the end consumes the checksum of the stream, nothing at
+	// all was read yet for an empty object
+	extra, err := io.Copy(io.Discard, zReader)
+	if err != nil {
+		return nil, err
+	}
+	if extra > 0 {
+		return nil, fmt.Errorf("%w: object is %d bytes longer than its header says", InvalidPackError, extra)
+	}
+	return buf, nil
+}
+
+func parseObjectHeader(r io.ByteReader) (packFileKind, int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	kind, size := (b&0x70)>>4, int64(b&0x0F)
+	// 0 is invalid and 5 is reserved for future use
+	if kind == 0 || kind == 5 {
+		return 0, 0, fmt.Errorf("%w: invalid object type %d", InvalidPackError, kind)
+	}
+	if b&0x80 == 0 {
+		return packFileKind(kind), size, nil
+	}
+	shift := 4
+	for i := 0; ; i++ {
+		b_, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= int64(b_&0x7f) << shift
+		if b_&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return packFileKind(kind), size, err
+}
+
+// Checkout writes the tree of the commit at hash in the worktree directory,
+// cancelling ctx stops it between two entries
+func Checkout(ctx context.Context, worktree, hash string) error {
+	obj, err := ReadGitObject(hash)
+	if err != nil {
+		return err
+	}
+	data := obj.Content()
+	hash = string(data[bytes.IndexByte(data, '\x00')+6 : bytes.IndexByte(data, '\x0a')])
+
+	sparse, err := LoadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	return ParseTreeFromHash(ctx, worktree, "", hash, sparse)
+}
+
+// ParseTreeFromHash writes the files of the tree at hash under basepath in
+// the worktree, those out of the sparse patterns are left out
+func ParseTreeFromHash(ctx context.Context, worktree, basepath, hash string, sparse *SparsePatterns) error {
+	obj, err := ReadGitObject(hash)
+	if err != nil {
+		return err
+	}
+	entries, err := (&Tree{content: obj.Content()}).Entries()
+	if err != nil {
+		return err
+	}
+	blobs := []entry{}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		filename, fileHash, kind := path.Join(basepath, e.name), e.hash, e.kind
+		switch kind {
+		case TreeKind:
+			if err = ParseTreeFromHash(ctx, worktree, filename, fileHash, sparse); err != nil {
+				return err
+			}
+		case BlobKind:
+			if sparse.Includes(filename) {
+				blobs = append(blobs, e)
+			}
+		default:
+			fmt.Printf("Unknown %s\n", kind)
+			panic("unsupported for now")
+		}
+	}
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	dir := path.Join(worktree, basepath)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return forEachParallel(ctx, len(blobs), func(i int) error {
+		blob, err := ReadGitObject(blobs[i].hash)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(dir, blobs[i].name), blob.Content(), 0o644)
+	})
+}
@@ -0,0 +1,768 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// shaA and shaB are ids for refs whose objects are never read
+const (
+	shaA = "1111111111111111111111111111111111111111"
+	shaB = "2222222222222222222222222222222222222222"
+)
+
+// fixtureRemote answers smart HTTP requests with canned responses: the refs
+// and capabilities it advertises, then the same pack for every fetch. The
+// requests are recorded to be checked.
+type fixtureRemote struct {
+	refs         []Ref
+	capabilities []string
+	pack         []byte
+	// answers the upload-pack requests instead of sending the pack when set
+	uploadPack func(w http.ResponseWriter, body []byte)
+
+	mu       sync.Mutex
+	requests [][]byte // the bodies of the upload-pack requests
+}
+
+func (f *fixtureRemote) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		io.WriteString(w, serializePackeLine("# service=git-upload-pack\n")+flushPacket)
+		for i, ref := range f.refs {
+			line := ref.Hash + " " + ref.Name
+			if i == 0 {
+				line += "\x00" + strings.Join(f.capabilities, " ")
+			}
+			io.WriteString(w, serializePackeLine(line+"\n"))
+		}
+		io.WriteString(w, flushPacket)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.requests = append(f.requests, body)
+		f.mu.Unlock()
+		if f.uploadPack != nil {
+			f.uploadPack(w, body)
+			return
+		}
+		io.WriteString(w, serializePackeLine("NAK\n"))
+		w.Write(f.pack)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serve starts an HTTP server for the fixture and returns its url
+func (f *fixtureRemote) serve(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return server.URL + "/repo.git"
+}
+
+// newFixtureRemote makes a repository with two commits on master and a
+// branch, and advertises them with HEAD pointing to master. The pack holds
+// everything, the fixture is served from memory once the test moves to the
+// clone.
+func newFixtureRemote(t *testing.T) *fixtureRemote {
+	t.Helper()
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n"})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "two\n"})
+	second := commitWorktree(t, "second")
+
+	objects, err := ReachableObjects([]string{second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := bytes.Buffer{}
+	if err := WritePack(&pack, objects); err != nil {
+		t.Fatal(err)
+	}
+	return &fixtureRemote{
+		refs: []Ref{
+			{Name: "HEAD", Hash: second},
+			{Name: "refs/heads/feature", Hash: first},
+			{Name: "refs/heads/master", Hash: second},
+		},
+		capabilities: []string{"ofs-delta", "no-progress", "symref=HEAD:refs/heads/master", "agent=fixture"},
+		pack:         pack.Bytes(),
+	}
+}
+
+// cloneFixture clones url into a temporary directory, moves there and
+// returns the result
+func cloneFixture(t *testing.T, opts CloneOptions) CloneResult {
+	t.Helper()
+	opts.Dir = filepath.Join(t.TempDir(), "clone")
+	result, err := Clone(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, opts.Dir)
+	dropObjectCaches()
+	return result
+}
+
+func TestCloneDefaultBranch(t *testing.T) {
+	remote := newFixtureRemote(t)
+	master := remote.refs[2].Hash
+	url := remote.serve(t)
+
+	result := cloneFixture(t, CloneOptions{URL: url})
+	if result.Branch != "refs/heads/master" || result.Head != master {
+		t.Errorf("cloned %s at %s, want refs/heads/master at %s", result.Branch, result.Head, master)
+	}
+	if head, _ := os.ReadFile(gitPath("HEAD")); string(head) != "ref: refs/heads/master\n" {
+		t.Errorf("HEAD is %q", head)
+	}
+	for _, ref := range []string{"refs/heads/master", "refs/remotes/origin/master"} {
+		if hash, err := ResolveRef(ref); err != nil || hash != master {
+			t.Errorf("%s is %s, %v, want %s", ref, hash, err, master)
+		}
+	}
+	if _, err := ResolveRef("refs/heads/main"); err == nil {
+		t.Error("refs/heads/main was created")
+	}
+}
+
+func TestCloneDefaultBranchWithoutSymref(t *testing.T) {
+	remote := newFixtureRemote(t)
+	// without symref=HEAD the branch at the sha of HEAD is the default
+	remote.capabilities = []string{"ofs-delta"}
+	remote.refs[2].Name = "refs/heads/trunk"
+	url := remote.serve(t)
+
+	result := cloneFixture(t, CloneOptions{URL: url})
+	if result.Branch != "refs/heads/trunk" {
+		t.Errorf("cloned %s, want refs/heads/trunk", result.Branch)
+	}
+	if head, _ := os.ReadFile(gitPath("HEAD")); string(head) != "ref: refs/heads/trunk\n" {
+		t.Errorf("HEAD is %q", head)
+	}
+}
+
+func TestRefAdvertisementHead(t *testing.T) {
+	tests := []struct {
+		name         string
+		refs         []Ref
+		capabilities []string
+		branch       string // empty when Head fails
+	}{
+		{"symref", []Ref{{"HEAD", shaA}, {"refs/heads/dev", shaA}, {"refs/heads/main", shaA}}, []string{"symref=HEAD:refs/heads/dev"}, "refs/heads/dev"},
+		{"guess main", []Ref{{"HEAD", shaA}, {"refs/heads/a", shaA}, {"refs/heads/main", shaA}}, nil, "refs/heads/main"},
+		{"guess only branch", []Ref{{"HEAD", shaA}, {"refs/heads/b", shaB}, {"refs/heads/c", shaA}}, nil, "refs/heads/c"},
+		{"no HEAD", []Ref{{"refs/heads/main", shaA}}, nil, ""},
+		{"detached", []Ref{{"HEAD", shaA}, {"refs/heads/main", shaB}}, nil, ""},
+	}
+	for _, test := range tests {
+		adv := &RefAdvertisement{Refs: test.refs, Capabilities: test.capabilities}
+		_, branch, err := adv.Head()
+		if branch != test.branch || (err == nil) != (test.branch != "") {
+			t.Errorf("%s: Head() = %q, %v, want %q", test.name, branch, err, test.branch)
+		}
+	}
+}
+
+func TestUploadPackRequestCapabilities(t *testing.T) {
+	request := UploadPackRequest{
+		Wants:        []string{shaA, shaB},
+		Capabilities: []string{"ofs-delta", "no-progress", "agent=" + agent},
+	}
+	lines := strings.Split(string(request.Encode()), "\n")
+	if want := "0058want " + shaA + " ofs-delta no-progress agent=" + agent; lines[0] != want {
+		t.Errorf("first want line %q, want %q", lines[0], want)
+	}
+	if want := "0032want " + shaB; lines[1] != want {
+		t.Errorf("second want line %q, want %q", lines[1], want)
+	}
+}
+
+func TestCloneRequestsCapabilities(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	cloneFixture(t, CloneOptions{URL: url})
+
+	if len(remote.requests) != 1 {
+		t.Fatalf("%d upload-pack requests", len(remote.requests))
+	}
+	// only what the fixture advertises is asked for
+	first, err := parsePacketLine(bytes.NewReader(remote.requests[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "want " + remote.refs[2].Hash + " ofs-delta no-progress agent=" + agent + "\n"; string(first) != want {
+		t.Errorf("first want line %q, want %q", first, want)
+	}
+}
+
+func TestCloneObjectFormat(t *testing.T) {
+	remote := newFixtureRemote(t)
+	remote.capabilities = append(remote.capabilities, "object-format=sha1")
+	url := remote.serve(t)
+	cloneFixture(t, CloneOptions{URL: url})
+
+	// the format is echoed back once advertised, after the agent
+	first, err := parsePacketLine(bytes.NewReader(remote.requests[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := " agent=" + agent + " object-format=sha1\n"; !strings.HasSuffix(string(first), want) {
+		t.Errorf("first want line %q, want it to end with %q", first, want)
+	}
+
+	// refused before anything is written
+	remote = newFixtureRemote(t)
+	remote.capabilities = append(remote.capabilities, "object-format=sha256")
+	url = remote.serve(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+	if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir}); !errors.Is(err, UnsupportedObjectFormat) {
+		t.Errorf("cloning a sha256 repository: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the refused clone left %s: %v", dir, err)
+	}
+	if len(remote.requests) != 0 {
+		t.Errorf("%d upload-pack requests to a sha256 repository", len(remote.requests))
+	}
+}
+
+func TestParseObjectsResolvesOfsDeltas(t *testing.T) {
+	testRepository(t)
+	// versions of a file differing by a line are stored as deltas
+	content := strings.Repeat("a line long enough to be worth a delta\n", 100)
+	shas := []string{}
+	for i := range 4 {
+		writeFiles(t, map[string]string{"file": content + strings.Repeat("more\n", i)})
+		objects, err := ReachableObjects([]string{commitWorktree(t, "version")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shas = objects
+	}
+	pack := bytes.Buffer{}
+	if _, _, err := writePack(&pack, shas, PackOptions{Window: 10, Depth: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	testRepository(t)
+	counts, err := unpackPack(context.Background(), pack.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts.Deltas == 0 {
+		t.Error("the pack has no deltas to resolve")
+	}
+	if counts.Total() != len(shas) || counts.Commits != 4 || counts.Blobs != 4 {
+		t.Errorf("unpacked %s, want %d objects", counts, len(shas))
+	}
+	for _, sha := range shas {
+		kind, content, err := readObject(sha)
+		if err == nil {
+			err = checkObjectID(sha, kind, content)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// decodePacketLines splits a pkt-line stream, checking that every length
+// prefix covers its line exactly, flushes are returned as "0000"
+func decodePacketLines(t *testing.T, raw []byte) []string {
+	t.Helper()
+	lines := []string{}
+	for len(raw) > 0 {
+		if len(raw) < 4 {
+			t.Fatalf("truncated length prefix %q", raw)
+		}
+		if string(raw[:4]) == flushPacket {
+			lines, raw = append(lines, flushPacket), raw[4:]
+			continue
+		}
+		var length int
+		if _, err := fmt.Sscanf(string(raw[:4]), "%04x", &length); err != nil || length < 5 || length > len(raw) {
+			t.Fatalf("bad length prefix %q", raw[:4])
+		}
+		lines, raw = append(lines, string(raw[4:length])), raw[length:]
+	}
+	return lines
+}
+
+func TestUploadPackRequestFraming(t *testing.T) {
+	tests := []struct {
+		request UploadPackRequest
+		lines   []string
+	}{
+		{
+			UploadPackRequest{Wants: []string{shaA}},
+			[]string{"want " + shaA + "\n", flushPacket, "done\n"},
+		},
+		{
+			UploadPackRequest{Wants: []string{shaA, shaB}, Capabilities: []string{"shallow"}, Depth: 1, Filter: "blob:none"},
+			[]string{"want " + shaA + " shallow\n", "want " + shaB + "\n", "deepen 1\n", "filter blob:none\n", flushPacket, "done\n"},
+		},
+		{
+			UploadPackRequest{Wants: []string{shaA}, Haves: []string{shaB, shaA}},
+			[]string{"want " + shaA + "\n", flushPacket, "have " + shaB + "\n", "have " + shaA + "\n", "done\n"},
+		},
+		{
+			UploadPackRequest{Wants: []string{shaA}, Haves: []string{shaB}, KeepNegotiating: true},
+			[]string{"want " + shaA + "\n", flushPacket, "have " + shaB + "\n", flushPacket},
+		},
+	}
+	for _, test := range tests {
+		raw := test.request.Encode()
+		if got := decodePacketLines(t, raw); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.lines) {
+			t.Errorf("%q decodes to\n%q\nwant\n%q", raw, got, test.lines)
+		}
+	}
+}
+
+func TestCloneArguments(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	chdir(t, t.TempDir())
+
+	// only arguments starting with a dash are flags
+	if err := HandlerClone(context.Background(), CloneCmd, []string{"--branch", "master", url, "depth=3"}); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, "depth=3")
+	if hash, err := ResolveRef("HEAD"); err != nil || hash != remote.refs[0].Hash {
+		t.Errorf("HEAD is %s, %v, want %s", hash, err, remote.refs[0].Hash)
+	}
+
+	for _, args := range [][]string{
+		{"--timeout", "soon", url, "dir"},
+		{"--timeout=0s", url, "dir"},
+		{"--depth=0", url, "dir"},
+		{url},
+	} {
+		if err := HandlerClone(context.Background(), CloneCmd, args); !errors.Is(err, InvalidArgsError) {
+			t.Errorf("clone %v: %v, want %v", args, err, InvalidArgsError)
+		}
+	}
+}
+
+func TestCloneCancelledMidRequest(t *testing.T) {
+	remote := newFixtureRemote(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	// the pack never comes until the test is over
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		close(started)
+		<-release
+	}
+	url := remote.serve(t)
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	errs := make(chan error)
+	go func() {
+		_, err := Clone(ctx, CloneOptions{URL: url, Dir: filepath.Join(t.TempDir(), "clone")})
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("clone failed with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled clone is still waiting for the pack")
+	}
+}
+
+func TestCloneTimeout(t *testing.T) {
+	remote := newFixtureRemote(t)
+	release := make(chan struct{})
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		<-release
+	}
+	url := remote.serve(t)
+	t.Cleanup(func() { close(release) })
+	chdir(t, t.TempDir())
+
+	errs := make(chan error)
+	go func() {
+		errs <- HandlerClone(context.Background(), CloneCmd, []string{"--timeout", "100ms", url, "clone"})
+	}()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("clone failed with %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the clone outlived its timeout")
+	}
+	if _, err := os.Stat("clone"); !os.IsNotExist(err) {
+		t.Errorf("the clone was left behind: %v", err)
+	}
+}
+
+// remoteAt serves the fixture under /repo.git only, recording the requested
+// paths, and redirects /moved to it
+func remoteAt(t *testing.T, remote *fixtureRemote) (string, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	paths := &[]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*paths = append(*paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/moved/"):
+			target := *r.URL
+			target.Path = "/repo.git/" + strings.TrimPrefix(r.URL.Path, "/moved/")
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, "/repo.git/"):
+			remote.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, paths
+}
+
+func TestCloneURLs(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url, paths := remoteAt(t, remote)
+	for _, test := range []struct {
+		url   string
+		paths string
+	}{
+		{url + "/repo.git", "[GET /repo.git/info/refs POST /repo.git/git-upload-pack]"},
+		{url + "/repo.git//", "[GET /repo.git/info/refs POST /repo.git/git-upload-pack]"},
+		{url + "/repo", "[GET /repo/info/refs GET /repo.git/info/refs POST /repo.git/git-upload-pack]"},
+		{url + "/repo/", "[GET /repo/info/refs GET /repo.git/info/refs POST /repo.git/git-upload-pack]"},
+		// upload-pack is asked where the refs were found
+		{url + "/moved", "[GET /moved/info/refs GET /repo.git/info/refs POST /repo.git/git-upload-pack]"},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			*paths = nil
+			result := cloneFixture(t, CloneOptions{URL: test.url})
+			if result.Head != remote.refs[0].Hash {
+				t.Errorf("cloned %s, want %s", result.Head, remote.refs[0].Hash)
+			}
+			if fmt.Sprint(*paths) != test.paths {
+				t.Errorf("requested %v, want %s", *paths, test.paths)
+			}
+		})
+	}
+
+	if _, err := Clone(context.Background(), CloneOptions{URL: url + "/missing", Dir: filepath.Join(t.TempDir(), "clone")}); err == nil {
+		t.Error("cloned a missing repository")
+	}
+}
+
+func TestCloneAPI(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	caller, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "clone")
+
+	result, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir, Branch: "feature"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CloneResult{
+		Head:    remote.refs[1].Hash,
+		Branch:  "refs/heads/feature",
+		Objects: ObjectCounts{Commits: 2, Trees: 3, Blobs: 3},
+	}
+	if result != want {
+		t.Errorf("cloned %+v, want %+v", result, want)
+	}
+
+	// the caller stays where it was, in its own repository
+	if cwd, _ := os.Getwd(); cwd != caller {
+		t.Errorf("moved to %s", cwd)
+	}
+	if gitDir != ".git" {
+		t.Errorf("the repository is now %s", gitDir)
+	}
+	if hash, err := ResolveRef("refs/heads/feature"); err == nil {
+		t.Errorf("refs/heads/feature is %s in the repository of the caller", hash)
+	}
+	for name, content := range map[string]string{"file": "one\n", "dir/nested": "nested\n"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != content {
+			t.Errorf("%s holds %q, %v, want %q", name, data, err, content)
+		}
+	}
+	head, err := os.ReadFile(filepath.Join(dir, ".git", "HEAD"))
+	if err != nil || string(head) != "ref: refs/heads/feature\n" {
+		t.Errorf("HEAD of the clone is %q, %v", head, err)
+	}
+}
+
+func TestCloneRelativeDir(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	chdir(t, t.TempDir())
+
+	result, err := Clone(context.Background(), CloneOptions{URL: url, Dir: "clone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Head != remote.refs[0].Hash || result.Objects.Total() != 8 {
+		t.Errorf("cloned %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join("clone", ".git", "objects")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(".git"); err == nil {
+		t.Error("the clone was made in the current directory")
+	}
+}
+
+func TestCloneFailureRemovesDir(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+
+	if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir, Branch: "missing"}); err == nil {
+		t.Fatal("cloned a missing branch")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the failed clone left %s: %v", dir, err)
+	}
+
+	if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir, Branch: "missing", Keep: true}); err == nil {
+		t.Fatal("cloned a missing branch")
+	}
+	if !incompleteClone(dir) {
+		t.Error("the kept clone is not marked incomplete")
+	}
+	if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir}); err != nil {
+		t.Errorf("cloning over an incomplete clone: %v", err)
+	}
+}
+
+func TestCloneFailureAfterRefsRemovesDir(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	dir := filepath.Join(t.TempDir(), "clone")
+	master := remote.refs[2].Hash
+
+	// the pack is corrupt, by then HEAD and the branches are written
+	written := []string{}
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		remote.mu.Lock()
+		defer remote.mu.Unlock()
+		for _, name := range []string{"HEAD", "refs/heads/master", "refs/remotes/origin/master"} {
+			if data, err := os.ReadFile(filepath.Join(dir, ".git", name)); err == nil {
+				written = append(written, name+" "+strings.TrimSpace(string(data)))
+			}
+		}
+		io.WriteString(w, serializePackeLine("NAK\n"))
+		w.Write(remote.pack[:len(remote.pack)-1])
+	}
+	want := []string{"HEAD ref: refs/heads/master", "refs/heads/master " + master, "refs/remotes/origin/master " + master}
+
+	for _, keep := range []bool{false, true} {
+		remote.mu.Lock()
+		written = written[:0]
+		remote.mu.Unlock()
+		if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir, Keep: keep}); err == nil {
+			t.Fatal("cloned a corrupt pack")
+		}
+		remote.mu.Lock()
+		got := strings.Join(written, "\n")
+		remote.mu.Unlock()
+		if got != strings.Join(want, "\n") {
+			t.Errorf("when the pack was sent the clone had\n%s", got)
+		}
+		_, err := os.Stat(dir)
+		if !keep && !os.IsNotExist(err) {
+			t.Errorf("the failed clone left %s: %v", dir, err)
+		}
+		if keep && !incompleteClone(dir) {
+			t.Error("the kept clone is not marked incomplete")
+		}
+	}
+
+	// the next clone rolls the kept one back
+	remote.uploadPack = nil
+	if _, err := Clone(context.Background(), CloneOptions{URL: url, Dir: dir}); err != nil {
+		t.Fatalf("cloning over an incomplete clone: %v", err)
+	}
+	if incompleteClone(dir) {
+		t.Error("the clone is still marked incomplete")
+	}
+}
+
+func TestUnpackObjectsMatchesGit(t *testing.T) {
+	testRepository(t)
+	// a file growing over a few commits, git packs it with deltas
+	content := ""
+	for i := range 3 {
+		content += strings.Repeat(fmt.Sprintf("line %d of a file long enough to deltify\n", i), 20)
+		writeFiles(t, map[string]string{"file": content, fmt.Sprintf("dir/new%d", i): content})
+		runGit(t, "add", "-A")
+		runGit(t, "commit", "-q", "-m", fmt.Sprint("commit ", i))
+	}
+	names := gitOutput(t, nil, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)")
+	pack := gitOutput(t, names, "pack-objects", "-q", "--stdout")
+	want := runGit(t, "cat-file", "--batch-all-objects", "--batch-check")
+	packFile := filepath.Join(t.TempDir(), "all.pack")
+	if err := os.WriteFile(packFile, pack, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{}, {packFile}} {
+		// every object of the pack comes out loose in an empty repository
+		testRepository(t)
+		var err error
+		withStdin(t, pack, func() {
+			_, err = runCommand(t, append([]string{"-q", "unpack-objects"}, args...)...)
+		})
+		if err != nil {
+			t.Fatalf("unpack-objects %v: %v", args, err)
+		}
+		if got := runGit(t, "cat-file", "--batch-all-objects", "--batch-check"); got != want {
+			t.Errorf("unpack-objects %v wrote\n%s\ngit packed\n%s", args, got, want)
+		}
+	}
+}
+
+func TestParseObjectsThinPack(t *testing.T) {
+	testRepository(t)
+	base := "a file long enough for a delta to be worth it\n"
+	if _, err := WriteContent(&Blob{content: []byte(base)}); err != nil {
+		t.Fatal(err)
+	}
+	second, third := base+"second\n", base+"second\nthird\n"
+	pack, _ := rawPack(t,
+		// its base is in the repository only
+		rawObject{kind: refDelta, data: deltaOf(base, second), baseSha: blobSha(base)},
+		// its base comes later in the pack
+		rawObject{kind: refDelta, data: deltaOf(third, third+"fourth\n"), baseSha: blobSha(third)},
+		rawObject{kind: refDelta, data: deltaOf(second, third), baseSha: blobSha(second)},
+	)
+
+	counts, err := unpackPack(context.Background(), pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != (ObjectCounts{Blobs: 3, Deltas: 3}) {
+		t.Errorf("unpacked %s", counts)
+	}
+	for _, content := range []string{second, third, third + "fourth\n"} {
+		kind, data, err := readObject(blobSha(content))
+		if err != nil || kind != BlobKind || string(data) != content {
+			t.Errorf("%s is %s %q, %v", blobSha(content), kind, data, err)
+		}
+	}
+}
+
+func TestParseObjectsThinPackMissingBase(t *testing.T) {
+	testRepository(t)
+	base := "a file long enough for a delta to be worth it\n"
+	pack, _ := rawPack(t,
+		rawObject{kind: blob, data: []byte("whole\n")},
+		rawObject{kind: refDelta, data: deltaOf(base, base+"more\n"), baseSha: blobSha(base)},
+	)
+
+	_, err := unpackPack(context.Background(), pack)
+	if !errors.Is(err, InvalidPackError) || !strings.Contains(err.Error(), blobSha(base)) {
+		t.Errorf("unpacking without the base: %v", err)
+	}
+}
+
+func TestParseObjectHeader(t *testing.T) {
+	for _, kind := range []packFileKind{commit, tree, blob, tag, ofsDelta, refDelta} {
+		for _, size := range []int64{0, 15, 16, 1 << 20, 1<<35 + 3} {
+			header := encodeObjectHeader(kind, size)
+			gotKind, gotSize, err := parseObjectHeader(bytes.NewReader(header))
+			if err != nil || gotKind != kind || gotSize != size {
+				t.Errorf("%x parsed to %s %d, %v, want %s %d", header, gotKind, gotSize, err, kind, size)
+			}
+		}
+	}
+
+	for _, header := range [][]byte{{0x00}, {0x0f}, {0x50}, {0xd3, 0x01}} {
+		if _, _, err := parseObjectHeader(bytes.NewReader(header)); !errors.Is(err, InvalidPackError) {
+			t.Errorf("header %x: %v, want %v", header, err, InvalidPackError)
+		}
+	}
+}
+
+func TestParseObjectsInvalidType(t *testing.T) {
+	testRepository(t)
+	for _, kind := range []packFileKind{0, 5} {
+		pack, _ := rawPack(t, rawObject{kind: blob, data: []byte("fine\n")}, rawObject{kind: kind, data: []byte("corrupt\n")})
+		if _, err := unpackPack(context.Background(), pack); !errors.Is(err, InvalidPackError) {
+			t.Errorf("object of type %d: %v, want %v", kind, err, InvalidPackError)
+		}
+	}
+}
+
+func TestParseObjectsCounts(t *testing.T) {
+	testRepository(t)
+	content := "a file long enough for a delta to be worth it\n"
+	blobID, _ := hex.DecodeString(blobSha(content))
+	treeData := append([]byte("100644 file\x00"), blobID...)
+	treeSha, _ := HashObject(&Tree{content: treeData})
+	sig := Signature{Name: "Tester", Email: "tester@example.com", When: testTime}
+	commitObject := &Commit{tree: fmt.Sprintf("%x", treeSha), author: sig, committer: sig, message: "first\n"}
+	commitSha, _ := HashObject(commitObject)
+	tagData := fmt.Sprintf("object %x\ntype commit\ntag v1\ntagger Tester <tester@example.com> 1700000000 +0000\n\nfirst\n", commitSha)
+
+	pack, _ := rawPack(t,
+		rawObject{kind: commit, data: commitObject.Content()},
+		rawObject{kind: tag, data: []byte(tagData)},
+		rawObject{kind: tree, data: treeData},
+		rawObject{kind: blob, data: []byte(content)},
+		rawObject{kind: ofsDelta, data: deltaOf(content, content+"more\n"), base: 3},
+		rawObject{kind: refDelta, data: deltaOf(content, content+"other\n"), baseSha: blobSha(content)},
+	)
+	counts, err := unpackPack(context.Background(), pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ObjectCounts{Commits: 1, Trees: 1, Blobs: 3, Tags: 1, Deltas: 2}); counts != want {
+		t.Errorf("unpacked %+v, want %+v", counts, want)
+	}
+	if want := "6 objects (1 commits, 1 trees, 3 blobs, 1 tags), resolved 2 deltas"; counts.String() != want {
+		t.Errorf("reported %q, want %q", counts, want)
+	}
+	if kind, _, err := readObject(fmt.Sprintf("%x", commitSha)); err != nil || kind != CommitKind {
+		t.Errorf("the commit is %s, %v", kind, err)
+	}
+}
+
+func TestParseObjectsCancelled(t *testing.T) {
+	testRepository(t)
+	objects := []rawObject{}
+	for i := range 10 {
+		objects = append(objects, rawObject{kind: blob, data: []byte(fmt.Sprintf("blob %d\n", i))})
+	}
+	pack, _ := rawPack(t, objects...)
+
+	counts, err := unpackPack(&cancelAfter{Context: context.Background(), n: 4}, pack)
+	if !errors.Is(err, context.Canceled) || counts.Blobs != 4 {
+		t.Errorf("unpacked %s before cancelling, %v", counts, err)
+	}
+}
@@ -0,0 +1,3433 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InitCmd           = "init"
+	CatFileCmd        = "cat-file"
+	HashObjectCmd     = "hash-object"
+	LsTreeCmd         = "ls-tree"
+	WriteTreeCmd      = "write-tree"
+	CommitTreeCmd     = "commit-tree"
+	CloneCmd          = "clone"
+	FsckCmd           = "fsck"
+	LogCmd            = "log"
+	ArchiveCmd        = "archive"
+	BundleCmd         = "bundle"
+	HelpCmd           = "help"
+	DumpPackCmd       = "dump-pack"
+	ShowRefCmd        = "show-ref"
+	ForEachRefCmd     = "for-each-ref"
+	FastExportCmd     = "fast-export"
+	FastImportCmd     = "fast-import"
+	HeadCmd           = "head"
+	CommitGraphCmd    = "commit-graph"
+	RevertCmd         = "revert"
+	RebaseCmd         = "rebase"
+	ApplyCmd          = "apply"
+	VerifyCommitCmd   = "verify-commit"
+	VerifyTagCmd      = "verify-tag"
+	DiffTreeCmd       = "diff-tree"
+	SparseCheckoutCmd = "sparse-checkout"
+	ObjectPathCmd     = "object-path"
+	UpdateRefCmd      = "update-ref"
+	MergeFileCmd      = "merge-file"
+	WellKnownCmd      = "well-known"
+	BenchCmd          = "bench"
+	FetchCmd          = "fetch"
+	UnpackObjectsCmd  = "unpack-objects"
+	LostFoundCmd      = "lost-found"
+	RepoStatsCmd      = "stats"
+	AddCmd            = "add"
+	ServeHeadCmd      = "serve-head"
+	UploadPackCmd     = "upload-pack"
+	ServeHTTPCmd      = "serve-http"
+	VerifyWorktreeCmd = "verify-worktree"
+	AheadBehindCmd    = "ahead-behind"
+	RewriteIdentsCmd  = "rewrite-identities"
+	LocksCmd          = "locks"
+	ConvertLayoutCmd  = "convert-layout"
+	DiffCmd           = "diff"
+	PackObjectsCmd    = "pack-objects"
+	BranchCmd         = "branch"
+	IntroducedCmd     = "introduced-objects"
+	RemoteHeadCmd     = "remote-head"
+	ManifestCmd       = "manifest"
+	CheckRefFormatCmd = "check-ref-format"
+	ComparePacksCmd   = "compare-packs"
+	ReachPathCmd      = "reachability-path"
+	LsFilesCmd        = "ls-files"
+	CheckoutCmd       = "checkout"
+	ReflogCmd         = "reflog"
+	PatchIDCmd        = "patch-id"
+	CompletionCmd     = "completion"
+)
+
+type Handler func(ctx context.Context, name string, args []string) error
+
+type Command struct {
+	Handler Handler
+	Summary string // one line shown in the command list
+	Usage   string // synopsis of the arguments
+}
+
+type Commands map[string]Command
+
+var (
+	CommandNotFoundError    = errors.New("Command not found")
+	MismatchedError         = errors.New("Mismatched command")
+	InvalidArgsError        = errors.New("Invalid arguments")
+	MismatchedChecksumError = errors.New("Packfile checksum doesn't mach")
+	InvalidPackError        = errors.New("Invalid Packfile")
+)
+
+var availableCommands = Commands{
+	InitCmd: {
+		Handler: HandlerInit,
+		Summary: "Create an empty repository",
+		Usage:   "init [--object-format=(sha1 | sha256)]",
+	},
+	CatFileCmd: {
+		Handler: HandlerCatFile,
+		Summary: "Print the content, type or size of objects",
+		Usage:   "cat-file (-p | -t | -e) <object | rev:path>\n   or: cat-file --batch-check [--batch-all-objects]",
+	},
+	HashObjectCmd: {
+		Handler: HandlerHashObject,
+		Summary: "Compute the sha of a file, optionally writing the blob",
+		Usage:   "hash-object [-w] <file>",
+	},
+	LsTreeCmd: {
+		Handler: HandlerListTree,
+		Summary: "List the entries of a tree",
+		Usage:   "ls-tree [--name-only] [-z] <tree>",
+	},
+	WriteTreeCmd: {
+		Handler: HandlerWriteTree,
+		Summary: "Write the index, or the working directory without one, as a tree",
+		Usage:   "write-tree [--dry-run]",
+	},
+	CommitTreeCmd: {
+		Handler: HandlerCommitTree,
+		Summary: "Create a commit from a tree",
+		Usage:   "commit-tree [--dry-run] <tree> [-p <parent>]... [-m <message>] [-s] [--date <date>]",
+	},
+	CloneCmd: {
+		Handler: HandlerClone,
+		Summary: "Clone a repository over smart HTTP or from a bundle",
+		Usage:   "clone [--branch <name>] [--depth <n>] [--filter <spec>] [--timeout <duration>] [--keep] <url> <dir>",
+	},
+	FsckCmd: {
+		Handler: HandlerFsck,
+		Summary: "Check that commits survive a parse and serialize round trip",
+		Usage:   "fsck [--unreachable | --dangling] [<commit>...]",
+	},
+	LogCmd: {
+		Handler: HandlerLog,
+		Summary: "Show the commit history",
+		Usage:   "log [--oneline] [-n <count>] [--no-mailmap] [--follow] [<commit>...] [-- <path>...]",
+	},
+	ArchiveCmd: {
+		Handler: HandlerArchive,
+		Summary: "Export a tree as a tar archive",
+		Usage:   "archive [--format=tar|tar.gz] [--prefix=<dir>/] [-o <file>] <tree-ish>",
+	},
+	BundleCmd: {
+		Handler: HandlerBundle,
+		Summary: "Move objects and refs through a single file",
+		Usage:   "bundle create <file> (--all | <ref>...)\n   or: bundle (verify | list-heads | unbundle) <file>",
+	},
+	DumpPackCmd: {
+		Handler: HandlerDumpPack,
+		Summary: "Print the object headers of a packfile",
+		Usage:   "dump-pack (<file> | -)",
+	},
+	ShowRefCmd: {
+		Handler: HandlerShowRef,
+		Summary: "List references and the objects they point to",
+		Usage:   "show-ref [--head] [--heads] [--tags] [-s] [<pattern>...]\n   or: show-ref --verify [-s] <ref>...",
+	},
+	ForEachRefCmd: {
+		Handler: HandlerForEachRef,
+		Summary: "Print information about each reference",
+		Usage:   "for-each-ref [--format=<format>] [--count=<n>] [<pattern>...]",
+	},
+	FastExportCmd: {
+		Handler: HandlerFastExport,
+		Summary: "Write the history as a fast-import stream",
+		Usage:   "fast-export (--all | <ref>...)",
+	},
+	FastImportCmd: {
+		Handler: HandlerFastImport,
+		Summary: "Create objects and refs from a fast-import stream on stdin",
+		Usage:   "fast-import",
+	},
+	HeadCmd: {
+		Handler: HandlerHead,
+		Summary: "Show where HEAD points and repair it when dangling",
+		Usage:   "head [--repair [<branch>]]",
+	},
+	CommitGraphCmd: {
+		Handler: HandlerCommitGraph,
+		Summary: "Write or check the commit-graph file",
+		Usage:   "commit-graph (write | verify)",
+	},
+	RevertCmd: {
+		Handler: HandlerRevert,
+		Summary: "Create a commit undoing the changes of another one",
+		Usage:   "revert <commit>",
+	},
+	RebaseCmd: {
+		Handler: HandlerRebase,
+		Summary: "Replay the commits of the current branch on top of another one",
+		Usage:   "rebase (<upstream> | --continue)",
+	},
+	ApplyCmd: {
+		Handler: HandlerApply,
+		Summary: "Apply a unified diff, binary patches included, to the working tree",
+		Usage:   "apply [--check] [-R | --reverse] [--whitespace=<action>] [<patch>...]",
+	},
+	VerifyCommitCmd: {
+		Handler: HandlerVerifySignature,
+		Summary: "Check the GPG signature of commits",
+		Usage:   "verify-commit [-v] [--keyring <file>] <commit>...",
+	},
+	VerifyTagCmd: {
+		Handler: HandlerVerifySignature,
+		Summary: "Check the GPG signature of tags",
+		Usage:   "verify-tag [-v] [--keyring <file>] <tag>...",
+	},
+	DiffTreeCmd: {
+		Handler: HandlerDiffTree,
+		Summary: "Compare two trees, or a commit with its parent",
+		Usage:   "diff-tree [-r] [-p] [--root] <tree-ish> [<tree-ish>]",
+	},
+	SparseCheckoutCmd: {
+		Handler: HandlerSparseCheckout,
+		Summary: "Only write the files matching some patterns to the worktree",
+		Usage:   "sparse-checkout (init | list | reapply | disable)\n   or: sparse-checkout (set | add) <pattern>...",
+	},
+	ObjectPathCmd: {
+		Handler: HandlerObjectPath,
+		Summary: "Show where an object is stored, its loose file or its pack",
+		Usage:   "object-path <object>...",
+	},
+	UpdateRefCmd: {
+		Handler: HandlerUpdateRef,
+		Summary: "Update refs safely, checking their old value",
+		Usage:   "update-ref [--no-deref] (-d <ref> [<old>] | <ref> <new> [<old>])\n   or: update-ref [--no-deref] --stdin",
+	},
+	MergeFileCmd: {
+		Handler: HandlerMergeFile,
+		Summary: "Merge the changes from base to other into current",
+		Usage:   "merge-file [-p] [-L <current> [-L <base> [-L <other>]]] <current> <base> <other>",
+	},
+	WellKnownCmd: {
+		Handler: HandlerWellKnown,
+		Summary: "Print or write well-known objects like the empty tree",
+		Usage:   "well-known [-w] [empty-tree | empty-blob]...",
+	},
+	BenchCmd: {
+		Handler: HandlerBench,
+		Summary: "Measure unpacking and tree hashing on generated fixtures",
+		Usage:   "bench [-cpuprofile <file>] [-memprofile <file>] [<files>...]",
+	},
+	FetchCmd: {
+		Handler: HandlerFetch,
+		Summary: "Download the branches of a remote as remote-tracking refs",
+		Usage:   "fetch [<remote>]",
+	},
+	UnpackObjectsCmd: {
+		Handler: HandlerUnpackObjects,
+		Summary: "Write every object of a packfile, stdin by default, as loose objects",
+		Usage:   "unpack-objects [<pack>]",
+	},
+	LostFoundCmd: {
+		Handler: HandlerLostFound,
+		Summary: "List the commits no ref reaches anymore, to recover them",
+		Usage:   "lost-found [--write]",
+	},
+	RepoStatsCmd: {
+		Handler: HandlerRepoStats,
+		Summary: "Count the commits, refs and objects of the repository",
+		Usage:   "stats [--json]",
+	},
+	AddCmd: {
+		Handler: HandlerAdd,
+		Summary: "Stage files in the index",
+		Usage:   "add <pathspec>...",
+	},
+	ServeHeadCmd: {
+		Handler: HandlerServeHead,
+		Summary: "Set the default branch of a repository and show the refs it advertises",
+		Usage:   "serve-head [<branch>]",
+	},
+	UploadPackCmd: {
+		Handler: HandlerUploadPack,
+		Summary: "Send the objects a fetch asks for over stdin and stdout",
+		Usage:   "upload-pack [--stateless-rpc] [--advertise-refs] <directory>",
+	},
+	ServeHTTPCmd: {
+		Handler: HandlerServeHTTP,
+		Summary: "Serve the repository for clones and fetches over smart HTTP",
+		Usage:   "serve-http [--listen <address>] [<directory>]",
+	},
+	VerifyWorktreeCmd: {
+		Handler: HandlerVerifyWorktree,
+		Summary: "Check that the working directory matches a commit, exiting 1 when not",
+		Usage:   "verify-worktree [<commit>]",
+	},
+	AheadBehindCmd: {
+		Handler: HandlerAheadBehind,
+		Summary: "Count the commits each of two branches has that the other lacks",
+		Usage:   "ahead-behind <commit> <commit>",
+	},
+	RewriteIdentsCmd: {
+		Handler: HandlerRewriteIdentities,
+		Summary: "Rewrite authors and committers across history through a mailmap",
+		Usage:   "rewrite-identities [--dry-run] <mailmap>",
+	},
+	LocksCmd: {
+		Handler: HandlerLocks,
+		Summary: "Report the lock files of the repository, removing stale ones with --force",
+		Usage:   "locks [--force] [--older-than <duration>]",
+	},
+	ConvertLayoutCmd: {
+		Handler: HandlerConvertLayout,
+		Summary: "Store every object loose or in a single pack, checking them on the way",
+		Usage:   "convert-layout --to=loose|packed",
+	},
+	DiffCmd: {
+		Handler: HandlerDiff,
+		Summary: "Show the unstaged changes, or the staged ones with --cached",
+		Usage:   "diff [--cached|--staged] [-w|--ignore-all-space] [-b|--ignore-space-change] [-- <pathspec>...]",
+	},
+	PackObjectsCmd: {
+		Handler: HandlerPackObjects,
+		Summary: "Pack the objects listed on stdin, as deltas of each other where they are alike",
+		Usage:   "pack-objects [--window=<n>] [--depth=<n>] (--stdout | <base-name>) < <object-list>",
+	},
+	BranchCmd: {
+		Handler: HandlerBranch,
+		Summary: "List, create or delete branches, -d only deleting merged ones",
+		Usage:   "branch [--list] | branch <name> [<start-point>] | branch (-d | -D) <name>...",
+	},
+	IntroducedCmd: {
+		Handler: HandlerIntroducedObjects,
+		Summary: "List the trees and blobs a commit adds to those of its parents, as pack-objects reads them",
+		Usage:   "introduced-objects <commit>",
+	},
+	RemoteHeadCmd: {
+		Handler: HandlerRemoteHead,
+		Summary: "Show the default branch of a remote repository from its ref advertisement alone",
+		Usage:   "remote-head <url>",
+	},
+	ManifestCmd: {
+		Handler: HandlerManifest,
+		Summary: "List every file of a commit with its mode and sha, sorted by path",
+		Usage:   "manifest [--null] <commit>",
+	},
+	CheckRefFormatCmd: {
+		Handler: HandlerCheckRefFormat,
+		Summary: "Tell whether a name is valid for a ref, exiting with 1 when it is not",
+		Usage:   "check-ref-format [--normalize] [--[no-]allow-onelevel] [--refspec-pattern] <refname>",
+	},
+	ComparePacksCmd: {
+		Handler: HandlerComparePacks,
+		Summary: "Tell whether two packs hold the same objects, exiting with 1 and listing them when not",
+		Usage:   "compare-packs <pack> <pack>",
+	},
+	ReachPathCmd: {
+		Handler: HandlerReachPath,
+		Summary: "Show a chain of objects from a ref to an object, telling why it is kept",
+		Usage:   "reachability-path <object>",
+	},
+	LsFilesCmd: {
+		Handler: HandlerLsFiles,
+		Summary: "List the staged files, with their stage when unmerged",
+		Usage:   "ls-files [-s | --stage] [-u | --unmerged] [-z]",
+	},
+	CheckoutCmd: {
+		Handler: HandlerCheckout,
+		Summary: "Resolve unmerged paths with our or their side of the merge",
+		Usage:   "checkout (--ours | --theirs) [--] <pathspec>...",
+	},
+	ReflogCmd: {
+		Handler: HandlerReflog,
+		Summary: "Show the reflog of a ref, newest first, as records with --json",
+		Usage:   "reflog [show] [<ref>]",
+	},
+	PatchIDCmd: {
+		Handler: HandlerPatchID,
+		Summary: "Compute the patch-id of the patches read from stdin or of commits",
+		Usage:   "patch-id [--stable | --unstable | --verbatim] [<commit>...]",
+	},
+}
+
+// help and completion read availableCommands so they are registered once the map exists
+func init() {
+	availableCommands[HelpCmd] = Command{
+		Handler: HandlerHelp,
+		Summary: "Show the available commands or the usage of one",
+		Usage:   "help [<command>]",
+	}
+	availableCommands[CompletionCmd] = Command{
+		Handler: HandlerCompletion,
+		Summary: "Print a shell completion script",
+		Usage:   "completion (bash | zsh)",
+	}
+}
+
+func GetCommand(cmd string) (Handler, error) {
+	command, ok := availableCommands[cmd]
+	if !ok {
+		return nil, CommandNotFoundError
+	}
+	return command.Handler, nil
+}
+
+// CommandNames returns the registered commands sorted by name
+func CommandNames() []string {
+	names := []string{}
+	for name := range availableCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func PrintCommandList(w io.Writer) {
+	names := CommandNames()
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	fmt.Fprintln(w, "available commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "   %-*s   %s\n", width, name, availableCommands[name].Summary)
+	}
+}
+
+func HandlerHelp(ctx context.Context, name string, args []string) error {
+	if name != HelpCmd {
+		return MismatchedError
+	}
+
+	switch len(args) {
+	case 0:
+		fmt.Print(usage)
+		fmt.Println()
+		PrintCommandList(os.Stdout)
+	case 1:
+		command, ok := availableCommands[args[0]]
+		if !ok {
+			return CommandNotFoundError
+		}
+		fmt.Printf("usage: mygit %s\n\n%s\n", command.Usage, command.Summary)
+	default:
+		return InvalidArgsError
+	}
+
+	return nil
+}
+
+func HandlerInit(ctx context.Context, name string, args []string) error {
+	if name != InitCmd {
+		return MismatchedError
+	}
+
+	format := SHA1
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && strings.HasPrefix(args[0], "--object-format="):
+		var err error
+		if format, err = ParseObjectFormat(strings.TrimPrefix(args[0], "--object-format=")); err != nil {
+			return err
+		}
+	default:
+		return InvalidArgsError
+	}
+
+	if err := InitRepository(); err != nil {
+		return err
+	}
+	if err := InitObjectFormat(format); err != nil {
+		return err
+	}
+	fmt.Println("Initialized git directory")
+
+	return nil
+}
+
+func InitRepository() error {
+	for _, dir := range []string{gitDir, gitPath("objects"), gitPath("refs")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("Error creating directory: %w", err)
+		}
+	}
+
+	headFileContents := []byte("ref: refs/heads/main\n")
+	if err := os.WriteFile(gitPath("HEAD"), headFileContents, 0o644); err != nil {
+		return fmt.Errorf("Error writing file: %w", err)
+	}
+
+	return nil
+}
+
+func HandlerCatFile(ctx context.Context, name string, args []string) error {
+	if name != CatFileCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 0 {
+		return InvalidArgsError
+	}
+
+	if strings.HasPrefix(args[0], "--batch") {
+		return catFileBatchCheck(args)
+	}
+
+	if len(args) != 2 {
+		return InvalidArgsError
+	}
+
+	sha, err := ResolveRef(args[1])
+	switch verb := args[0]; {
+	case verb == "-e":
+		// the answer is the exit code, like git nothing is printed
+		if err != nil {
+			return ExitStatus(1)
+		}
+		if _, err := ReadGitObject(sha); err != nil {
+			return ExitStatus(1)
+		}
+
+	case err != nil:
+		return err
+
+	case verb == "-p":
+
+		gitObj, err := ReadGitObject(sha)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s", gitObj)
+
+	case verb == "-t":
+		kind, err := ObjectType(sha)
+		if err != nil {
+			return err
+		}
+		fmt.Println(kind)
+
+	default:
+		return InvalidArgsError
+	}
+
+	return nil
+}
+
+// print "<sha> <type> <size>" for every object in the repository with
+// --batch-all-objects, or for each sha read from stdin
+func catFileBatchCheck(args []string) error {
+	allObjects, batchCheck := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--batch-all-objects":
+			allObjects = true
+		case "--batch-check":
+			batchCheck = true
+		default:
+			return InvalidArgsError
+		}
+	}
+	if !batchCheck {
+		return InvalidArgsError
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	// with --json the answers are printed together at the end
+	checked := []ObjectInfoJSON{}
+	check := func(sha string) error {
+		kind, size, err := ObjectInfo(sha)
+		if errors.Is(err, ObjectNotFound) || os.IsNotExist(err) || err == InvalidObject {
+			if jsonOutput {
+				checked = append(checked, ObjectInfoJSON{Hash: sha, Missing: true})
+				return nil
+			}
+			_, err = fmt.Fprintf(out, "%s missing\n", sha)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			checked = append(checked, ObjectInfoJSON{Hash: sha, Type: string(kind), Size: size})
+			return nil
+		}
+		_, err = fmt.Fprintf(out, "%s %s %d\n", sha, kind, size)
+		return err
+	}
+	done := func() error {
+		if jsonOutput {
+			return writeJSON(out, checked)
+		}
+		return nil
+	}
+
+	if allObjects {
+		objects, err := AllObjects()
+		if err != nil {
+			return err
+		}
+		for _, sha := range objects {
+			if err := check(sha); err != nil {
+				return err
+			}
+		}
+		return done()
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		sha := strings.TrimSpace(scanner.Text())
+		if hash, err := ResolveRef(sha); err == nil {
+			sha = hash
+		}
+		if err := check(sha); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return done()
+}
+
+func HandlerHashObject(ctx context.Context, name string, args []string) error {
+	if name != HashObjectCmd {
+		return MismatchedError
+	}
+
+	if l := len(args); l < 1 || l > 2 {
+		return InvalidArgsError
+	}
+	writeToFile := false
+	objIndex := 0
+
+	switch verb := args[0]; verb {
+	case "-w":
+		objIndex = 1
+		writeToFile = true
+
+	default:
+		// nothing special for now
+	}
+
+	hash, err := hashFile(args[objIndex], writeToFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%x\n", hash)
+
+	return nil
+}
+
+func HandlerListTree(ctx context.Context, name string, args []string) error {
+	if name != LsTreeCmd {
+		return MismatchedError
+	}
+
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quotePath, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+
+	sha, format := "", TreeFormat{QuotePath: quotePath}
+	for _, arg := range args {
+		switch arg {
+		case "--name-only":
+			format.NameOnly = true
+		case "-z":
+			format.Zero = true
+		default:
+			if sha != "" || strings.HasPrefix(arg, "-") {
+				return InvalidArgsError
+			}
+			sha = arg
+		}
+	}
+	if sha == "" {
+		return InvalidArgsError
+	}
+
+	sha, err = ResolveRef(sha)
+	if err != nil {
+		return err
+	}
+	tree, err := ReadTreeish(sha)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		treeEntries, err := tree.Entries()
+		if err != nil {
+			return err
+		}
+		entries := []TreeEntryJSON{}
+		for _, e := range treeEntries {
+			entries = append(entries, newTreeEntryJSON(e))
+		}
+		return writeJSON(os.Stdout, entries)
+	}
+
+	formatted, err := tree.Format(format)
+	fmt.Printf("%s", formatted)
+
+	return err
+}
+
+func HandlerWriteTree(ctx context.Context, name string, args []string) error {
+	if name != WriteTreeCmd {
+		return MismatchedError
+	}
+
+	dryRun := false
+	for _, arg := range args {
+		if arg != "--dry-run" {
+			return InvalidArgsError
+		}
+		dryRun = true
+	}
+
+	// like git, what is staged when there is an index, the directories
+	// unchanged since the last write-tree come from its cache
+	if HasIndex() {
+		idx, err := ReadIndex()
+		if err != nil {
+			return err
+		}
+		sha, err := idx.WriteTree(!dryRun)
+		if err != nil {
+			return err
+		}
+		if !dryRun {
+			if err := idx.Write(); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%x\n", sha)
+		return nil
+	}
+
+	curDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	_, sha, err := BuildTreeFromDir(ctx, curDir, !dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%x\n", sha)
+
+	return nil
+}
+
+func detectParam(args []string, target string) *string {
+	size := len(args)
+	for i, arg := range args {
+		if arg == target && i != size-1 {
+			return &args[i+1]
+		}
+	}
+	return nil
+}
+
+func HandlerCommitTree(ctx context.Context, name string, args []string) error {
+	if name != CommitTreeCmd {
+		return MismatchedError
+	}
+
+	dryRun := slices.Contains(args, "--dry-run")
+	signoff := slices.Contains(args, "-s") || slices.Contains(args, "--signoff")
+	args = slices.DeleteFunc(args, func(arg string) bool {
+		return arg == "--dry-run" || arg == "-s" || arg == "--signoff"
+	})
+	if len(args) < 1 {
+		return InvalidArgsError
+	}
+
+	tree := args[0]
+	args = args[1:]
+	msg := detectParam(args, "-m")
+	parents := []string{}
+	for i, arg := range args {
+		if arg == "-p" && i != len(args)-1 {
+			parents = append(parents, args[i+1])
+		}
+	}
+
+	author, err := resolveSignature("AUTHOR")
+	if err != nil {
+		return err
+	}
+	// like git commit --date, only the author date changes
+	if date := detectParam(args, "--date"); date != nil {
+		if author.When, err = ParseGitDate(*date); err != nil {
+			return err
+		}
+	}
+	committer, err := resolveSignature("COMMITTER")
+	if err != nil {
+		return err
+	}
+	commit := &Commit{
+		tree:      tree,
+		parents:   parents,
+		author:    author,
+		committer: committer,
+		message:   "\n",
+	}
+	if msg != nil {
+		commit.message = strings.TrimSuffix(*msg, "\n") + "\n"
+	} else {
+		// without an editor the template is the message, as if saved unchanged
+		template, err := commitTemplate()
+		if err != nil {
+			return err
+		}
+		if template != "" {
+			if commit.message = CleanupMessage(template); commit.message == "" {
+				return EmptyMessage
+			}
+		}
+	}
+	if signoff {
+		commit.message = AppendSignoff(commit.message, committer.Name+" <"+committer.Email+">")
+	}
+
+	sha, err := storeObject(commit, !dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%x\n", sha)
+
+	return nil
+}
+
+// resolveSignature is who is the author or the committer, role being
+// "AUTHOR" or "COMMITTER", and when: GIT_<role>_NAME, GIT_<role>_EMAIL and
+// GIT_<role>_DATE override the configured identity and the current time
+func resolveSignature(role string) (Signature, error) {
+	name, email, err := resolveIdentity()
+	if err != nil {
+		return Signature{}, err
+	}
+	if value, ok := os.LookupEnv("GIT_" + role + "_NAME"); ok {
+		name = value
+	}
+	if value, ok := os.LookupEnv("GIT_" + role + "_EMAIL"); ok {
+		email = value
+	}
+	when := time.Now()
+	if value, ok := os.LookupEnv("GIT_" + role + "_DATE"); ok {
+		if when, err = ParseGitDate(value); err != nil {
+			return Signature{}, fmt.Errorf("GIT_%s_DATE: %w", role, err)
+		}
+	}
+	return Signature{Name: name, Email: email, When: when}, nil
+}
+
+// user.name and user.email from the config, falling back to the defaults
+func resolveIdentity() (string, string, error) {
+	author, email := "Antonio Petrillo", "Antonio Petrillo"
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	if name, ok := cfg.Get("user.name"); ok {
+		author = name
+	}
+	if mail, ok := cfg.Get("user.email"); ok {
+		email = mail
+	}
+	return author, email, nil
+}
+
+func HandlerClone(ctx context.Context, name string, args []string) error {
+	if name != CloneCmd {
+		return MismatchedError
+	}
+
+	opts, positional := CloneOptions{}, []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		// accept both "--flag value" and "--flag=value"
+		if (arg == "--timeout" || arg == "--branch" || arg == "-b" || arg == "--depth" || arg == "--filter") && i != len(args)-1 {
+			i++
+			arg += "=" + args[i]
+		}
+		flag, value, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case flag == "timeout":
+			// the same deadline as the global --timeout, for the clone only
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return InvalidArgsError
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		case flag == "branch" || flag == "b":
+			opts.Branch = value
+		case flag == "depth":
+			depth, err := strconv.Atoi(value)
+			if err != nil || depth < 1 {
+				return InvalidArgsError
+			}
+			opts.Depth = depth
+		case flag == "filter":
+			if err := ValidateFilter(value); err != nil {
+				return err
+			}
+			opts.Filter = value
+		case arg == "--keep":
+			opts.Keep = true
+		default:
+			return InvalidArgsError
+		}
+	}
+
+	if len(positional) != 2 {
+		return InvalidArgsError
+	}
+	opts.URL, opts.Dir = positional[0], positional[1]
+
+	Infof("Cloning into '%s'...\n", opts.Dir)
+	result, err := Clone(ctx, opts)
+	if err != nil {
+		return err
+	}
+	Infof("Received %s\n", result.Objects)
+	Infof("Checked out %s at %s\n", result.Branch, result.Head)
+	return nil
+}
+
+func clonePlumbing(ctx context.Context, opts CloneOptions) (result CloneResult, _ error) {
+	adv, err := GetRefs(ctx, opts.URL)
+	if err != nil {
+		return result, err
+	}
+	// the remote default branch is not necessarily main
+	hash, branch, err := adv.Head()
+	if err != nil {
+		return result, err
+	}
+	if opts.Branch != "" {
+		if hash, branch, err = adv.Branch(opts.Branch); err != nil {
+			return result, err
+		}
+	}
+	if err = writeClonedHead(hash, branch); err != nil {
+		return result, err
+	}
+	if err = recordOrigin(opts.URL); err != nil {
+		return result, err
+	}
+
+	capabilities := append([]string{}, clientCapabilities...)
+	if opts.Depth > 0 {
+		if !adv.Supports("shallow") {
+			return result, fmt.Errorf("Server does not support shallow clones")
+		}
+		capabilities = append(capabilities, "shallow")
+	}
+	if opts.Filter != "" {
+		if adv.Supports("filter") {
+			capabilities = append(capabilities, "filter")
+		} else {
+			// like git, fall back to a full clone
+			Infof("warning: filtering not recognized by server, ignoring\n")
+			opts.Filter = ""
+		}
+	}
+	Verbosef("Fetching %s (%s) from %s\n", branch, hash, adv.URL)
+	resp, err := UploadPack(ctx, adv.URL, UploadPackRequest{
+		Wants:        []string{hash},
+		Capabilities: adv.Negotiate(capabilities),
+		Depth:        opts.Depth,
+		Filter:       opts.Filter,
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(resp.Shallow) > 0 {
+		shallow := strings.Join(resp.Shallow, "\n") + "\n"
+		if err := os.WriteFile(gitPath("shallow"), []byte(shallow), 0o644); err != nil {
+			return result, err
+		}
+	}
+
+	Verbosef("Received packfile of %d bytes\n", len(resp.Pack))
+	counts, err := unpackPack(ctx, resp.Pack)
+	if err != nil {
+		return result, err
+	}
+	Verbosef("Unpacked %s\n", counts)
+
+	// the blobs left out by the filter are fetched for the checkout at once
+	// instead of one by one
+	if opts.Filter != "" {
+		if err := recordPromisor(opts.URL, opts.Filter); err != nil {
+			return result, err
+		}
+		missing, err := missingBlobs(hash)
+		if err != nil {
+			return result, err
+		}
+		if len(missing) > 0 {
+			fetched, err := FetchPromisedObjects(ctx, missing)
+			if err != nil {
+				return result, err
+			}
+			counts.Blobs += fetched.Blobs
+			counts.Deltas += fetched.Deltas
+		}
+	}
+
+	// the tags include-tag sent along, and those pointing to what was fetched
+	tags, err := followedTags(adv)
+	if err != nil {
+		return result, err
+	}
+	t := RefTransaction{}
+	for _, tag := range tags {
+		t.Create(tag.Name, tag.Hash)
+	}
+	if err := t.Commit(); err != nil {
+		return result, err
+	}
+
+	err = Checkout(ctx, opts.Dir, hash)
+	if err != nil {
+		return result, err
+	}
+
+	return CloneResult{Head: hash, Branch: branch, Objects: counts}, nil
+}
+
+// a branch gets HEAD pointing to it, and its remote-tracking ref, anything
+// else leaves HEAD detached
+func writeClonedHead(hash, ref string) error {
+	branch, found := strings.CutPrefix(ref, "refs/heads/")
+	if !found {
+		return UpdateRef("HEAD", hash)
+	}
+	t := RefTransaction{}
+	t.Create(ref, hash)
+	t.Create("refs/remotes/origin/"+branch, hash)
+	t.UpdateSymbolic("HEAD", ref)
+	return t.Commit()
+}
+
+// recordOrigin remembers where the clone came from so that fetch can go
+// back there, with the refspec git would write
+func recordOrigin(url string) error {
+	if err := SetConfig(gitPath("config"), "remote.origin.url", url); err != nil {
+		return err
+	}
+	return SetConfig(gitPath("config"), "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+}
+
+// check the trailing checksum then write every object in the pack
+func unpackPack(ctx context.Context, data []byte) (ObjectCounts, error) {
+	size := hashSize()
+	if len(data) < size {
+		return ObjectCounts{}, InvalidPackError
+	}
+	checksum := objectHasher.Sum(data[:len(data)-size])
+	if !bytes.Equal(checksum, data[len(data)-size:]) {
+		return ObjectCounts{}, fmt.Errorf("Mismatched hashes, want '%x' got '%x'", data[len(data)-size:], checksum)
+	}
+
+	return ParseObjects(ctx, data[:len(data)-size])
+}
+
+func HandlerBundle(ctx context.Context, name string, args []string) error {
+	if name != BundleCmd {
+		return MismatchedError
+	}
+
+	if len(args) < 2 {
+		return InvalidArgsError
+	}
+
+	switch verb, file := args[0], args[1]; verb {
+	case "create":
+		refs := []Ref{}
+		for _, arg := range args[2:] {
+			if arg == "--all" {
+				all, err := ListRefs()
+				if err != nil {
+					return err
+				}
+				refs = append(refs, all...)
+				arg = "HEAD"
+			}
+			refName, hash, err := ExpandRef(arg)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, arg)
+			}
+			refs = append(refs, Ref{Name: refName, Hash: hash})
+		}
+		if len(refs) == 0 {
+			return InvalidArgsError
+		}
+
+		out, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		if err := WriteBundle(out, refs); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+
+	case "verify", "list-heads", "unbundle":
+		if len(args) != 2 {
+			return InvalidArgsError
+		}
+		bundle, err := ReadBundle(file)
+		if err != nil {
+			return err
+		}
+		switch verb {
+		case "verify":
+			if err := bundle.Verify(); err != nil {
+				return err
+			}
+		case "unbundle":
+			if _, err := bundle.Unbundle(ctx); err != nil {
+				return err
+			}
+		}
+		for _, ref := range bundle.Refs {
+			fmt.Printf("%s %s\n", ref.Hash, ref.Name)
+		}
+		if verb == "verify" {
+			fmt.Printf("%s is okay\n", file)
+		}
+
+	default:
+		return InvalidArgsError
+	}
+
+	return nil
+}
+
+func HandlerArchive(ctx context.Context, name string, args []string) error {
+	if name != ArchiveCmd {
+		return MismatchedError
+	}
+
+	format, prefix, output, sha := TarFormat, "", "", ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case strings.HasPrefix(arg, "--format="):
+			format = ArchiveFormat(strings.TrimPrefix(arg, "--format="))
+		case strings.HasPrefix(arg, "--prefix="):
+			prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "--output="):
+			output = strings.TrimPrefix(arg, "--output=")
+		case arg == "-o" && i != len(args)-1:
+			i++
+			output = args[i]
+		case sha == "" && !strings.HasPrefix(arg, "-"):
+			sha = arg
+		default:
+			return InvalidArgsError
+		}
+	}
+	if sha == "" {
+		return InvalidArgsError
+	}
+
+	if output == "" {
+		return WriteArchive(os.Stdout, sha, format, prefix)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := WriteArchive(file, sha, format, prefix); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// walk the history of each ref (HEAD by default, unless only listing lost
+// objects) checking that every commit survives a parse and serialize round
+// trip, --unreachable and --dangling list the objects no ref or reflog reaches
+func HandlerFsck(ctx context.Context, name string, args []string) error {
+	if name != FsckCmd {
+		return MismatchedError
+	}
+
+	unreachable, dangling, refs := false, false, []string{}
+	for _, arg := range args {
+		switch arg {
+		case "--unreachable":
+			unreachable = true
+		case "--dangling":
+			dangling = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return InvalidArgsError
+			}
+			refs = append(refs, arg)
+		}
+	}
+	if len(refs) == 0 && !unreachable && !dangling {
+		refs = []string{"HEAD"}
+	}
+
+	if unreachable || dangling {
+		unreachableObjs, danglingObjs, err := UnreachableObjects()
+		if err != nil {
+			return err
+		}
+		report, label := unreachableObjs, "unreachable"
+		if !unreachable {
+			report, label = danglingObjs, "dangling"
+		}
+		for _, sha := range report {
+			kind, err := ObjectType(sha)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %s\n", label, kind, sha)
+		}
+	}
+
+	queue := []string{}
+	for _, ref := range refs {
+		hash, err := ResolveRef(ref)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, ref)
+		}
+		kind, err := ObjectType(hash)
+		if err != nil {
+			return err
+		}
+		if kind == TagKind {
+			if hash, _, err = PeelObject(hash); err != nil {
+				return err
+			}
+		}
+		queue = append(queue, hash)
+	}
+
+	// the commits are checked a generation at a time by parallel workers,
+	// reported in the order a single one would find them
+	seen, broken := map[string]bool{}, 0
+	for len(queue) > 0 {
+		generation := []string{}
+		for _, sha := range queue {
+			if !seen[sha] {
+				seen[sha] = true
+				generation = append(generation, sha)
+			}
+		}
+		verified, parents := make([]error, len(generation)), make([][]string, len(generation))
+		err := forEachParallel(ctx, len(generation), func(i int) error {
+			if verified[i] = VerifyCommitRoundTrip(generation[i]); verified[i] != nil {
+				return nil
+			}
+			gitObj, err := ReadGitObject(generation[i])
+			if err != nil {
+				return err
+			}
+			parents[i] = gitObj.(*CommitAsBytes).Parents()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		queue = []string{}
+		for i, sha := range generation {
+			if verified[i] != nil {
+				fmt.Printf("error in commit %s: %v\n", sha, verified[i])
+				broken++
+				continue
+			}
+			queue = append(queue, parents[i]...)
+		}
+	}
+
+	if broken > 0 {
+		return fmt.Errorf("%d broken commit(s)", broken)
+	}
+	return nil
+}
+
+func HandlerLog(ctx context.Context, name string, args []string) error {
+	if name != LogCmd {
+		return MismatchedError
+	}
+
+	opts, useMailmap, revs := LogOptions{}, true, []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--oneline":
+			opts.Oneline = true
+		case arg == "--use-mailmap":
+			useMailmap = true
+		case arg == "--no-mailmap" || arg == "--no-use-mailmap":
+			useMailmap = false
+		case arg == "-n" && i != len(args)-1:
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil {
+				return InvalidArgsError
+			}
+			opts.MaxCount = count
+		case strings.HasPrefix(arg, "--max-count="):
+			count, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-count="))
+			if err != nil {
+				return InvalidArgsError
+			}
+			opts.MaxCount = count
+		case arg == "--follow":
+			opts.Follow = true
+		case arg == "--":
+			opts.Paths = append(opts.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			revs = append(revs, arg)
+		}
+	}
+	if opts.Follow && len(opts.Paths) != 1 {
+		return fmt.Errorf("%w: --follow requires exactly one pathspec", InvalidArgsError)
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	tips := []string{}
+	for _, rev := range revs {
+		hash, err := ResolveRef(rev)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, rev)
+		}
+		tips = append(tips, hash)
+	}
+
+	if useMailmap {
+		cfg, err := LoadConfig(gitDir)
+		if err != nil {
+			return err
+		}
+		if opts.Mailmap, err = LoadMailmap(cfg); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		return WriteLogJSON(os.Stdout, tips, opts)
+	}
+	return WriteLog(os.Stdout, tips, opts)
+}
+
+func HandlerCompletion(ctx context.Context, name string, args []string) error {
+	if name != CompletionCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+
+	switch shell := args[0]; shell {
+	case "bash":
+		return WriteBashCompletion(os.Stdout)
+	case "zsh":
+		return WriteZshCompletion(os.Stdout)
+	case "refs": // used by the generated scripts
+		return WriteRefCompletions(os.Stdout)
+	default:
+		return InvalidArgsError
+	}
+}
+
+func HandlerDumpPack(ctx context.Context, name string, args []string) error {
+	if name != DumpPackCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+
+	var raw []byte
+	var err error
+	if args[0] == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return DumpPack(out, raw)
+}
+
+func HandlerShowRef(ctx context.Context, name string, args []string) error {
+	if name != ShowRefCmd {
+		return MismatchedError
+	}
+
+	head, heads, tags, verify, hashOnly := false, false, false, false, false
+	patterns := []string{}
+	for _, arg := range args {
+		switch arg {
+		case "--head":
+			head = true
+		case "--heads":
+			heads = true
+		case "--tags":
+			tags = true
+		case "--verify":
+			verify = true
+		case "-s", "--hash":
+			hashOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return InvalidArgsError
+			}
+			patterns = append(patterns, arg)
+		}
+	}
+
+	// with --json the refs are printed together once all are known
+	shown := []RefJSON{}
+	show := func(ref Ref) {
+		if jsonOutput {
+			shown = append(shown, RefJSON{Name: ref.Name, Hash: ref.Hash})
+		} else if hashOnly {
+			fmt.Println(ref.Hash)
+		} else {
+			fmt.Printf("%s %s\n", ref.Hash, ref.Name)
+		}
+	}
+
+	// --verify wants full ref names and fails on the first missing one
+	if verify {
+		if len(patterns) == 0 {
+			return InvalidArgsError
+		}
+		for _, refName := range patterns {
+			if refName != "HEAD" && !strings.HasPrefix(refName, "refs/") {
+				return fmt.Errorf("'%s' - not a valid ref", refName)
+			}
+			hash, err := readRef(refName)
+			if err == RefNotFound {
+				return fmt.Errorf("'%s' - not a valid ref", refName)
+			}
+			if err != nil {
+				return err
+			}
+			show(Ref{Name: refName, Hash: hash})
+		}
+		if jsonOutput {
+			return writeJSON(os.Stdout, shown)
+		}
+		return nil
+	}
+
+	refs, err := ListRefs()
+	if err != nil {
+		return err
+	}
+	if head {
+		if hash, err := readRef("HEAD"); err == nil {
+			refs = append([]Ref{{Name: "HEAD", Hash: hash}}, refs...)
+		}
+	}
+
+	found := false
+	for _, ref := range refs {
+		if ref.Name != "HEAD" && (heads || tags) {
+			isHead := strings.HasPrefix(ref.Name, "refs/heads/")
+			isTag := strings.HasPrefix(ref.Name, "refs/tags/")
+			if !(heads && isHead) && !(tags && isTag) {
+				continue
+			}
+		}
+		if !matchRefPatterns(ref.Name, patterns) {
+			continue
+		}
+		show(ref)
+		found = true
+	}
+	// like git, finding nothing is reported through the exit code only
+	if !found {
+		return ExitStatus(1)
+	}
+	if jsonOutput {
+		return writeJSON(os.Stdout, shown)
+	}
+	return nil
+}
+
+// a pattern matches whole trailing components: "main" matches
+// "refs/heads/main" but not "refs/heads/domain"
+func matchRefPatterns(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if name == pattern || strings.HasSuffix(name, "/"+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func HandlerForEachRef(ctx context.Context, name string, args []string) error {
+	if name != ForEachRefCmd {
+		return MismatchedError
+	}
+
+	format, count := defaultRefFormat, -1
+	patterns := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format" && i+1 < len(args):
+			i++
+			format = args[i]
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--count="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--count="))
+			if err != nil || n < 0 {
+				return InvalidArgsError
+			}
+			count = n
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+
+	refs, err := ListRefs()
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, ref := range refs {
+		if count == 0 {
+			break
+		}
+		if !matchRefPrefix(ref.Name, patterns) {
+			continue
+		}
+		line, err := FormatRef(format, ref)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, line)
+		count--
+	}
+	return nil
+}
+
+func HandlerFastExport(ctx context.Context, name string, args []string) error {
+	if name != FastExportCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 0 {
+		return InvalidArgsError
+	}
+
+	refs := []Ref{}
+	if len(args) == 1 && args[0] == "--all" {
+		all, err := ListRefs()
+		if err != nil {
+			return err
+		}
+		refs = all
+	} else {
+		for _, arg := range args {
+			refName, hash, err := ExpandRef(arg)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, arg)
+			}
+			if refName == "HEAD" {
+				if target, ok, _ := ReadSymbolicRef("HEAD"); ok {
+					refName = target
+				}
+			}
+			refs = append(refs, Ref{Name: refName, Hash: hash})
+		}
+	}
+
+	return FastExport(os.Stdout, refs)
+}
+
+func HandlerFastImport(ctx context.Context, name string, args []string) error {
+	if name != FastImportCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 0 {
+		return InvalidArgsError
+	}
+
+	stats, err := FastImport(os.Stdin)
+	if err != nil {
+		return err
+	}
+	Infof("Imported %d blobs, %d commits, %d tags, updated %d refs\n", stats.Blobs, stats.Commits, stats.Tags, stats.Refs)
+	return nil
+}
+
+func HandlerHead(ctx context.Context, name string, args []string) error {
+	if name != HeadCmd {
+		return MismatchedError
+	}
+
+	repair, branch := false, ""
+	switch {
+	case len(args) == 0:
+	case args[0] == "--repair" && len(args) <= 2:
+		repair = true
+		if len(args) == 2 {
+			branch = args[1]
+		}
+	default:
+		return InvalidArgsError
+	}
+
+	state, err := InspectHead()
+	if err != nil {
+		return err
+	}
+	fmt.Println(state)
+	if !repair {
+		if state.Broken() {
+			return ExitStatus(1)
+		}
+		return nil
+	}
+
+	// an explicit branch moves even a healthy HEAD
+	if !state.Broken() && branch == "" {
+		return nil
+	}
+	target, err := RepairHead(branch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("HEAD now points to %s\n", target)
+	return nil
+}
+
+func HandlerCommitGraph(ctx context.Context, name string, args []string) error {
+	if name != CommitGraphCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+	switch args[0] {
+	case "write":
+		count, err := WriteCommitGraph(ctx)
+		if err != nil {
+			return err
+		}
+		Infof("Wrote a commit-graph of %d commits\n", count)
+		return nil
+	case "verify":
+	default:
+		return InvalidArgsError
+	}
+
+	graph, err := loadCommitGraph()
+	if err != nil {
+		return err
+	}
+	if graph == nil {
+		return fmt.Errorf("no commit-graph in %s", gitPath("objects", "info"))
+	}
+	if err := VerifyCommitGraph(graph); err != nil {
+		return err
+	}
+	Infof("commit-graph holds %d valid commits\n", len(graph.shas))
+	return nil
+}
+
+func HandlerRevert(ctx context.Context, name string, args []string) error {
+	if name != RevertCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+	sha, err := ResolveRef(args[0])
+	if err != nil {
+		return err
+	}
+	sha, gitObj, err := PeelObject(sha)
+	if err != nil {
+		return err
+	}
+	if gitObj.Kind() != CommitKind {
+		return fmt.Errorf("%s is a %s, not a commit", args[0], gitObj.Kind())
+	}
+
+	result, err := Revert(ctx, sha)
+	if err != nil {
+		return err
+	}
+	if len(result.Conflicts) > 0 {
+		for _, path := range result.Conflicts {
+			fmt.Printf("CONFLICT: merge conflict in %s\n", path)
+		}
+		fmt.Printf("could not revert %s, fix the conflicts and commit the result\n", sha[:7])
+		return ExitStatus(1)
+	}
+
+	commit, err := ReadCommit(result.Commit)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] %s\n", result.Commit[:7], commit.Subject())
+	return nil
+}
+
+func HandlerRebase(ctx context.Context, name string, args []string) error {
+	if name != RebaseCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+	var result RebaseResult
+	var err error
+	if args[0] == "--continue" {
+		result, err = ContinueRebase(ctx)
+	} else {
+		onto, resolveErr := ResolveRef(args[0])
+		if resolveErr != nil {
+			return resolveErr
+		}
+		onto, gitObj, peelErr := PeelObject(onto)
+		if peelErr != nil {
+			return peelErr
+		}
+		if gitObj.Kind() != CommitKind {
+			return fmt.Errorf("%s is a %s, not a commit", args[0], gitObj.Kind())
+		}
+		result, err = Rebase(ctx, onto)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result.Stopped != "" {
+		for _, path := range result.Conflicts {
+			fmt.Printf("CONFLICT: merge conflict in %s\n", path)
+		}
+		fmt.Printf("could not apply %s, resolve the conflicts then run \"rebase --continue\"\n", result.Stopped[:7])
+		return ExitStatus(1)
+	}
+	if result.UpToDate {
+		fmt.Printf("Current branch %s is up to date.\n", shortRefName(result.Branch))
+		return nil
+	}
+	Verbosef("picked %d commits, skipped %d already upstream\n", result.Picked, result.Skipped)
+	fmt.Printf("Successfully rebased and updated %s.\n", result.Branch)
+	return nil
+}
+
+func HandlerApply(ctx context.Context, name string, args []string) error {
+	if name != ApplyCmd {
+		return MismatchedError
+	}
+
+	opts, whitespace, files := ApplyOptions{}, "", []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "--check":
+			opts.Check = true
+		case arg == "-R" || arg == "--reverse":
+			opts.Reverse = true
+		case arg == "--index" || arg == "--cached":
+			return fmt.Errorf("%s is not supported, there is no index", arg)
+		case strings.HasPrefix(arg, "--whitespace="):
+			whitespace = strings.TrimPrefix(arg, "--whitespace=")
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return InvalidArgsError
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) == 0 {
+		files = append(files, "-")
+	}
+	if whitespace == "" {
+		cfg, err := LoadConfig(gitDir)
+		if err != nil {
+			return err
+		}
+		whitespace, _ = cfg.Get("apply.whitespace")
+	}
+	if whitespace != "" {
+		var err error
+		if opts.Whitespace, err = ParseWhitespaceAction(whitespace); err != nil {
+			return err
+		}
+	}
+
+	patches := []*FilePatch{}
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+		parsed, err := ParsePatch(data)
+		if err != nil {
+			return err
+		}
+		for _, p := range parsed {
+			p.Source = file
+			if file == "-" {
+				p.Source = "<stdin>"
+			}
+		}
+		patches = append(patches, parsed...)
+	}
+	return ApplyPatches(patches, opts)
+}
+
+// HandlerVerifySignature serves both verify-commit and verify-tag, they only
+// differ in the kind of object they accept
+func HandlerVerifySignature(ctx context.Context, name string, args []string) error {
+	want := CommitKind
+	switch name {
+	case VerifyCommitCmd:
+	case VerifyTagCmd:
+		want = TagKind
+	default:
+		return MismatchedError
+	}
+
+	verbose, keyring, objects := false, "", []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case arg == "--keyring" && i != len(args)-1:
+			i++
+			keyring = args[i]
+		case strings.HasPrefix(arg, "--keyring="):
+			keyring = strings.TrimPrefix(arg, "--keyring=")
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			objects = append(objects, arg)
+		}
+	}
+	if len(objects) == 0 {
+		return InvalidArgsError
+	}
+
+	failed := false
+	for _, object := range objects {
+		sha, err := ResolveRef(object)
+		if err != nil {
+			return err
+		}
+		kind, content, err := readObject(sha)
+		if err != nil {
+			return err
+		}
+		if kind != want {
+			return fmt.Errorf("%s is a %s, not a %s", object, kind, want)
+		}
+		if verbose {
+			fmt.Printf("%s", content)
+		}
+
+		payload, signature, err := SplitSignature(kind, content)
+		if errors.Is(err, NotSigned) {
+			fmt.Printf("%s: no signature found\n", object)
+			failed = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		check, err := VerifySignature(ctx, payload, signature, keyring)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", object, check)
+		failed = failed || check.Status != SignatureGood
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+func HandlerDiffTree(ctx context.Context, name string, args []string) error {
+	if name != DiffTreeCmd {
+		return MismatchedError
+	}
+
+	recursive, patch, root, treeishes := false, false, false, []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "-r":
+			recursive = true
+		case arg == "-p" || arg == "--patch":
+			patch, recursive = true, true
+		case arg == "--root":
+			root = true
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			treeishes = append(treeishes, arg)
+		}
+	}
+	if len(treeishes) == 0 || len(treeishes) > 2 {
+		return InvalidArgsError
+	}
+
+	shas := []string{}
+	for _, treeish := range treeishes {
+		sha, err := ResolveRef(treeish)
+		if err != nil {
+			return err
+		}
+		shas = append(shas, sha)
+	}
+
+	// a single commit is compared with its first parent, the diff coming
+	// after the sha of the commit
+	header := ""
+	if len(shas) == 1 {
+		commit, err := ReadCommit(shas[0])
+		if err != nil {
+			return err
+		}
+		header = shas[0] + "\n"
+		switch {
+		case len(commit.parents) > 1:
+			return nil // like git, merges are not compared with a parent
+		case len(commit.parents) == 1:
+			shas = []string{commit.parents[0], shas[0]}
+		case root:
+			shas = []string{"", shas[0]}
+		default:
+			return nil
+		}
+	}
+
+	changes, err := DiffTrees(shas[0], shas[1], recursive)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quoteHigh, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	fmt.Fprint(out, header)
+	if patch {
+		return WritePatch(out, changes, quoteHigh, WhitespaceExact)
+	}
+	WriteRawDiff(out, changes, quoteHigh)
+	return nil
+}
+
+func HandlerSparseCheckout(ctx context.Context, name string, args []string) error {
+	if name != SparseCheckoutCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 0 {
+		return InvalidArgsError
+	}
+	verb, patterns := args[0], args[1:]
+	if (verb == "set" || verb == "add") != (len(patterns) > 0) {
+		return InvalidArgsError
+	}
+
+	switch verb {
+	case "init":
+		return SetSparseCheckout(defaultSparsePatterns)
+	case "set":
+		return SetSparseCheckout(patterns)
+	case "add", "list":
+		sparse, err := LoadSparseCheckout()
+		if err != nil {
+			return err
+		}
+		if sparse == nil {
+			return errors.New("this worktree is not sparse")
+		}
+		if verb == "list" {
+			fmt.Print(sparse)
+			return nil
+		}
+		return SetSparseCheckout(append(sparse.Patterns(), patterns...))
+	case "reapply":
+		return ReapplySparseCheckout()
+	case "disable":
+		return SetSparseCheckout(nil)
+	}
+	return InvalidArgsError
+}
+
+// HandlerObjectPath prints "loose <path>" or "packed <pack> <offset>" for
+// each object
+func HandlerObjectPath(ctx context.Context, name string, args []string) error {
+	if name != ObjectPathCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 0 {
+		return InvalidArgsError
+	}
+	for _, arg := range args {
+		sha, err := ResolveRef(arg)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, arg)
+		}
+		location, err := LocateObject(sha)
+		if err != nil {
+			return err
+		}
+		if location.Packed {
+			fmt.Printf("packed %s %d\n", location.Path, location.Offset)
+		} else {
+			fmt.Printf("loose %s\n", location.Path)
+		}
+	}
+	return nil
+}
+
+// HandlerUpdateRef updates one ref, or with --stdin applies in a single
+// transaction the commands read from stdin, one per line:
+//
+//	update <ref> <new> [<old>]
+//	create <ref> <new>
+//	delete <ref> [<old>]
+//	verify <ref> [<old>]
+//
+// An old value of zeros means the ref must not exist.
+func HandlerUpdateRef(ctx context.Context, name string, args []string) error {
+	if name != UpdateRefCmd {
+		return MismatchedError
+	}
+
+	deref, fromStdin, del, positional := true, false, false, []string{}
+	for _, arg := range args {
+		switch arg {
+		case "--no-deref":
+			deref = false
+		case "--stdin":
+			fromStdin = true
+		case "-d":
+			del = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	lines := [][]string{}
+	switch {
+	case fromStdin && !del && len(positional) == 0:
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+				lines = append(lines, fields)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	case fromStdin:
+		return InvalidArgsError
+	case del && (len(positional) == 1 || len(positional) == 2):
+		lines = append(lines, append([]string{"delete"}, positional...))
+	case !del && (len(positional) == 2 || len(positional) == 3):
+		lines = append(lines, append([]string{"update"}, positional...))
+	default:
+		return InvalidArgsError
+	}
+
+	// values may be any revision, zeros stay as they are
+	resolve := func(value string) (string, error) {
+		if value == zeroHash {
+			return value, nil
+		}
+		return ResolveRef(value)
+	}
+	// how many values each command takes, the ref included
+	arity := map[string][2]int{"update": {2, 3}, "create": {2, 2}, "delete": {1, 2}, "verify": {1, 2}}
+	t := RefTransaction{}
+	for _, fields := range lines {
+		verb, values := fields[0], fields[1:]
+		bounds, ok := arity[verb]
+		if !ok || len(values) < bounds[0] || len(values) > bounds[1] {
+			return fmt.Errorf("%w: update-ref: bad command %q", InvalidArgsError, strings.Join(fields, " "))
+		}
+
+		ref := values[0]
+		if deref {
+			var err error
+			if ref, err = DerefSymbolic(ref); err != nil {
+				return err
+			}
+		}
+		resolved := []string{}
+		for _, value := range values[1:] {
+			sha, err := resolve(value)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, value)
+			}
+			resolved = append(resolved, sha)
+		}
+		resolved = append(resolved, "", "")
+
+		switch verb {
+		case "update":
+			if resolved[0] == zeroHash {
+				t.Delete(ref, resolved[1])
+			} else {
+				t.Update(ref, resolved[0], resolved[1])
+			}
+		case "create":
+			t.Create(ref, resolved[0])
+		case "delete":
+			t.Delete(ref, resolved[0])
+		case "verify":
+			old := resolved[0]
+			if old == "" {
+				old = zeroHash
+			}
+			t.Verify(ref, old)
+		}
+	}
+	return t.Commit()
+}
+
+func HandlerMergeFile(ctx context.Context, name string, args []string) error {
+	if name != MergeFileCmd {
+		return MismatchedError
+	}
+
+	stdout, labels, files := false, []string{}, []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-p" || arg == "--stdout":
+			stdout = true
+		case arg == "-L" && i+1 < len(args) && len(labels) < 3:
+			labels = append(labels, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) != 3 {
+		return InvalidArgsError
+	}
+	// the files name the sides without a label
+	labels = append(labels, files[len(labels):]...)
+
+	contents := [3][]byte{}
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if isBinary(content) {
+			return fmt.Errorf("%w: cannot merge binary file %s", InvalidArgsError, file)
+		}
+		contents[i] = content
+	}
+
+	lines, conflicts := mergeLines(splitLines(contents[1]), splitLines(contents[0]), splitLines(contents[2]),
+		MergeLabels{Ours: labels[0], Theirs: labels[2]}, true)
+	merged := []byte(strings.Join(lines, ""))
+	if stdout {
+		if _, err := os.Stdout.Write(merged); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(files[0], merged, 0o644); err != nil {
+		return err
+	}
+	// like git, the exit code counts the conflicts
+	if conflicts > 0 {
+		return ExitStatus(min(conflicts, 127))
+	}
+	return nil
+}
+
+// HandlerWellKnown prints the sha of each named object, or every object with
+// its name when none is given
+func HandlerWellKnown(ctx context.Context, name string, args []string) error {
+	if name != WellKnownCmd {
+		return MismatchedError
+	}
+
+	write, names := false, []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "-w":
+			write = true
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			names = append(names, arg)
+		}
+	}
+	listAll := len(names) == 0
+	if listAll {
+		for known := range wellKnownObjects {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+	}
+
+	for _, known := range names {
+		build, ok := wellKnownObjects[known]
+		if !ok {
+			return fmt.Errorf("%w: unknown object %s", InvalidArgsError, known)
+		}
+		sha, err := build(write)
+		if err != nil {
+			return err
+		}
+		if listAll {
+			fmt.Printf("%x %s\n", sha, known)
+		} else {
+			fmt.Printf("%x\n", sha)
+		}
+	}
+	return nil
+}
+
+func HandlerBench(ctx context.Context, name string, args []string) error {
+	if name != BenchCmd {
+		return MismatchedError
+	}
+
+	cpuFile, memFile, sizes := "", "", []int{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-cpuprofile" && i+1 < len(args):
+			cpuFile = args[i+1]
+			i++
+		case arg == "-memprofile" && i+1 < len(args):
+			memFile = args[i+1]
+			i++
+		default:
+			files, err := strconv.Atoi(arg)
+			if err != nil || files <= 0 {
+				return InvalidArgsError
+			}
+			sizes = append(sizes, files)
+		}
+	}
+	if len(sizes) == 0 {
+		sizes = defaultBenchSizes
+	}
+	return profile(cpuFile, memFile, func() error { return RunBenchmarks(ctx, sizes) })
+}
+
+func HandlerFetch(ctx context.Context, name string, args []string) error {
+	if name != FetchCmd {
+		return MismatchedError
+	}
+
+	remote := "origin"
+	switch {
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		remote = args[0]
+	case len(args) > 0:
+		return InvalidArgsError
+	}
+
+	result, err := Fetch(ctx, remote)
+	if err != nil {
+		return err
+	}
+	if len(result.Refs) == 0 {
+		return nil
+	}
+	Verbosef("Received %s\n", result.Objects)
+
+	// laid out like git, the branch names padded to the longest one
+	width := 0
+	for _, ref := range result.Refs {
+		width = max(width, len(ref.Branch))
+	}
+	Infof("From %s\n", result.URL)
+	for _, ref := range result.Refs {
+		flag, summary, note := ' ', "", ""
+		switch {
+		case strings.HasPrefix(ref.Ref, "refs/tags/"):
+			flag, summary = '*', "[new tag]"
+		case ref.Old == "":
+			flag, summary = '*', "[new branch]"
+		case ref.Forced:
+			flag, summary, note = '+', ref.Old[:7]+"..."+ref.New[:7], "  (forced update)"
+		default:
+			summary = ref.Old[:7] + ".." + ref.New[:7]
+		}
+		local := strings.TrimPrefix(strings.TrimPrefix(ref.Ref, "refs/remotes/"), "refs/tags/")
+		Infof(" %c %-17s %-*s -> %s%s\n", flag, summary, width, ref.Branch, local, note)
+	}
+	return nil
+}
+
+func HandlerUnpackObjects(ctx context.Context, name string, args []string) error {
+	if name != UnpackObjectsCmd {
+		return MismatchedError
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case len(args) == 0:
+		data, err = io.ReadAll(os.Stdin)
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		data, err = os.ReadFile(args[0])
+	default:
+		return InvalidArgsError
+	}
+	if err != nil {
+		return err
+	}
+
+	counts, err := unpackPack(ctx, data)
+	if err != nil {
+		return err
+	}
+	Infof("Unpacked %s\n", counts)
+	return nil
+}
+
+// HandlerLostFound lists the dangling commits with their date and subject,
+// --write keeps each alive as refs/lost-found/<sha>
+func HandlerLostFound(ctx context.Context, name string, args []string) error {
+	if name != LostFoundCmd {
+		return MismatchedError
+	}
+
+	write := false
+	switch {
+	case len(args) == 1 && args[0] == "--write":
+		write = true
+	case len(args) > 0:
+		return InvalidArgsError
+	}
+
+	lost, err := LostCommits()
+	if err != nil {
+		return err
+	}
+	t := RefTransaction{}
+	for _, sha := range lost {
+		commit, err := ReadCommit(sha)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s %s\n", sha, commit.committer.When.Format("2006-01-02 15:04:05 -0700"), commit.Subject())
+		t.Create("refs/lost-found/"+sha, sha)
+	}
+	if !write {
+		return nil
+	}
+	return t.Commit()
+}
+
+func HandlerRepoStats(ctx context.Context, name string, args []string) error {
+	if name != RepoStatsCmd {
+		return MismatchedError
+	}
+
+	// --json after the command is kept from before the global flag existed
+	asJSON := jsonOutput
+	switch {
+	case len(args) == 1 && args[0] == "--json":
+		asJSON = true
+	case len(args) > 0:
+		return InvalidArgsError
+	}
+
+	stats, err := CollectRepoStats()
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		return stats.Write(os.Stdout)
+	}
+	return writeJSON(os.Stdout, stats)
+}
+
+func HandlerAdd(ctx context.Context, name string, args []string) error {
+	if name != AddCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 0 {
+		return InvalidArgsError
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			return InvalidArgsError
+		}
+	}
+
+	prefix, err := enterWorktree()
+	if err != nil {
+		return err
+	}
+	specs, err := ParsePathspecs(args, prefix)
+	if err != nil {
+		return err
+	}
+	idx, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	paths, err := expandPathspecs(idx, specs)
+	if err != nil {
+		return err
+	}
+	if err := stagePaths(idx, paths); err != nil {
+		return err
+	}
+	return idx.Write()
+}
+
+func HandlerServeHead(ctx context.Context, name string, args []string) error {
+	if name != ServeHeadCmd {
+		return MismatchedError
+	}
+
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		// clones check out the branch HEAD points to, it has to exist
+		if _, err := RepairHead(args[0]); err != nil {
+			return err
+		}
+	default:
+		return InvalidArgsError
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return WriteAdvertisedRefs(out)
+}
+
+func HandlerUploadPack(ctx context.Context, name string, args []string) error {
+	if name != UploadPackCmd {
+		return MismatchedError
+	}
+
+	opts, dir := UploadPackOptions{}, ""
+	for _, arg := range args {
+		switch {
+		case arg == "--stateless-rpc":
+			opts.StatelessRPC = true
+		case arg == "--advertise-refs" || arg == "--http-backend-info-refs":
+			opts.AdvertiseRefs = true
+		case strings.HasPrefix(arg, "-") || dir != "":
+			return InvalidArgsError
+		default:
+			dir = arg
+		}
+	}
+	if dir == "" {
+		return InvalidArgsError
+	}
+
+	// git runs it on the path given to clone
+	if err := useRepository(dir); err != nil {
+		return err
+	}
+	opts.ProtocolV2 = wantsProtocolV2(os.Getenv("GIT_PROTOCOL"))
+	return ServeUploadPack(ctx, os.Stdin, os.Stdout, opts)
+}
+
+func HandlerServeHTTP(ctx context.Context, name string, args []string) error {
+	if name != ServeHTTPCmd {
+		return MismatchedError
+	}
+
+	addr, dir := "127.0.0.1:8080", ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--listen" && i != len(args)-1:
+			i++
+			addr = args[i]
+		case strings.HasPrefix(arg, "--listen="):
+			addr = strings.TrimPrefix(arg, "--listen=")
+		case strings.HasPrefix(arg, "-") || dir != "":
+			return InvalidArgsError
+		default:
+			dir = arg
+		}
+	}
+	if dir != "" {
+		if err := useRepository(dir); err != nil {
+			return err
+		}
+	}
+
+	return ServeHTTP(ctx, addr, func(addr net.Addr) {
+		Infof("Serving %s on http://%s/\n", gitDir, addr)
+	})
+}
+
+func HandlerVerifyWorktree(ctx context.Context, name string, args []string) error {
+	if name != VerifyWorktreeCmd {
+		return MismatchedError
+	}
+
+	rev := "HEAD"
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		rev = args[0]
+	default:
+		return InvalidArgsError
+	}
+	sha, err := ResolveRef(rev)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, rev)
+	}
+
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quotePath, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+	sparse, err := LoadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	changes, err := DiffWorktree(ctx, dir, sha, sparse)
+	if err != nil {
+		return err
+	}
+	// like git diff --name-status, the exit code tells whether they match
+	for _, c := range changes {
+		fmt.Printf("%c\t%s\n", c.Status, QuotePath(c.Path, quotePath))
+	}
+	if len(changes) > 0 {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+func HandlerAheadBehind(ctx context.Context, name string, args []string) error {
+	if name != AheadBehindCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 2 {
+		return InvalidArgsError
+	}
+	commits := []string{}
+	for _, rev := range args {
+		sha, err := ResolveRef(rev)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, rev)
+		}
+		sha, gitObj, err := PeelObject(sha)
+		if err != nil {
+			return err
+		}
+		if gitObj.Kind() != CommitKind {
+			return fmt.Errorf("%s is a %s, not a commit", rev, gitObj.Kind())
+		}
+		commits = append(commits, sha)
+	}
+
+	ahead, behind, err := AheadBehind(commits[0], commits[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d\t%d\n", ahead, behind)
+	return nil
+}
+
+func HandlerRewriteIdentities(ctx context.Context, name string, args []string) error {
+	if name != RewriteIdentsCmd {
+		return MismatchedError
+	}
+
+	dryRun, positional := false, []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "--dry-run" || arg == "-n":
+			dryRun = true
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		return InvalidArgsError
+	}
+	mailmap, err := ReadMailmapFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	result, err := RewriteIdentities(mailmap, dryRun)
+	if err != nil {
+		return err
+	}
+	// old and new shas, like the commit-map of git filter-repo
+	for _, c := range result.Commits {
+		fmt.Printf("%s %s\n", c.Name, c.Hash)
+	}
+	for _, ref := range result.Refs {
+		Infof("Rewrote %s to %s\n", ref.Name, ref.Hash)
+	}
+	return nil
+}
+
+func HandlerLocks(ctx context.Context, name string, args []string) error {
+	if name != LocksCmd {
+		return MismatchedError
+	}
+
+	force, threshold := false, staleLockAge
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--older-than" && i != len(args)-1 {
+			i++
+			arg += "=" + args[i]
+		}
+		switch value, found := strings.CutPrefix(arg, "--older-than="); {
+		case arg == "--force" || arg == "-f":
+			force = true
+		case found:
+			var err error
+			if threshold, err = time.ParseDuration(value); err != nil || threshold < 0 {
+				return InvalidArgsError
+			}
+		default:
+			return InvalidArgsError
+		}
+	}
+
+	locks, err := FindLocks()
+	if err != nil {
+		return err
+	}
+	// a recent lock belongs to a command which may still be running, it is
+	// reported but never removed
+	stale := 0
+	for _, lock := range locks {
+		state := "held"
+		if lock.Stale(threshold) {
+			state = "stale"
+		}
+		fmt.Printf("%s\t%s\t%s\n", state, lock.Age.Round(time.Second), lock.Path)
+		if state != "stale" {
+			continue
+		}
+		if !force {
+			stale++
+			continue
+		}
+		if err := os.Remove(lock.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		Infof("Removed %s\n", lock.Path)
+	}
+	if stale > 0 {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+func HandlerConvertLayout(ctx context.Context, name string, args []string) error {
+	if name != ConvertLayoutCmd {
+		return MismatchedError
+	}
+
+	if len(args) == 2 && args[0] == "--to" {
+		args = []string{"--to=" + args[1]}
+	}
+	if len(args) != 1 || !strings.HasPrefix(args[0], "--to=") {
+		return InvalidArgsError
+	}
+	layout := ObjectLayout(strings.TrimPrefix(args[0], "--to="))
+	if layout != LooseLayout && layout != PackedLayout {
+		return InvalidArgsError
+	}
+
+	count, err := ConvertLayout(layout)
+	if err != nil {
+		return err
+	}
+	if layout == PackedLayout {
+		Infof("Packed %d objects\n", count)
+	} else {
+		Infof("Unpacked %d objects\n", count)
+	}
+	return nil
+}
+
+func HandlerDiff(ctx context.Context, name string, args []string) error {
+	if name != DiffCmd {
+		return MismatchedError
+	}
+
+	cached, ws, paths := false, WhitespaceExact, []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--cached" || arg == "--staged":
+			cached = true
+		case arg == "-w" || arg == "--ignore-all-space":
+			ws = IgnoreAllSpace
+		case arg == "-b" || arg == "--ignore-space-change":
+			ws = max(ws, IgnoreSpaceChange)
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			return InvalidArgsError
+		}
+	}
+
+	prefix, err := enterWorktree()
+	if err != nil {
+		return err
+	}
+	specs, err := ParsePathspecs(paths, prefix)
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quoteHigh, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+	idx, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var changes []TreeChange
+	if cached {
+		// an unborn branch has everything staged as new
+		head, err := ResolveRef("HEAD")
+		if err != nil && !errors.Is(err, RefNotFound) {
+			return err
+		}
+		changes, err = DiffIndex(head, idx)
+	} else {
+		changes, err = DiffIndexWorktree(ctx, dir, idx)
+	}
+	if err != nil {
+		return err
+	}
+	changes = slices.DeleteFunc(changes, func(c TreeChange) bool { return !matchesPathspecs(c.Path, specs) })
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if cached {
+		return WritePatch(out, changes, quoteHigh, ws)
+	}
+	return WriteWorktreePatch(out, changes, quoteHigh, ws, dir)
+}
+
+func HandlerPackObjects(ctx context.Context, name string, args []string) error {
+	if name != PackObjectsCmd {
+		return MismatchedError
+	}
+
+	// git's defaults
+	opts, stdout, base := PackOptions{Window: 10, Depth: 50, Paths: map[string]string{}}, false, ""
+	for _, arg := range args {
+		var err error
+		switch {
+		case arg == "--stdout":
+			stdout = true
+		case strings.HasPrefix(arg, "--window="):
+			opts.Window, err = strconv.Atoi(strings.TrimPrefix(arg, "--window="))
+		case strings.HasPrefix(arg, "--depth="):
+			opts.Depth, err = strconv.Atoi(strings.TrimPrefix(arg, "--depth="))
+		case strings.HasPrefix(arg, "-") || base != "":
+			return InvalidArgsError
+		default:
+			base = arg
+		}
+		if err != nil {
+			return InvalidArgsError
+		}
+	}
+	if stdout == (base != "") {
+		return InvalidArgsError
+	}
+
+	// lines are "<sha> [<path>]" like rev-list --objects prints them
+	objects, scanner := []string{}, bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		sha, path, _ := strings.Cut(scanner.Text(), " ")
+		if !isHash(sha) {
+			return fmt.Errorf("%w: expected an object name, got %q", InvalidArgsError, scanner.Text())
+		}
+		objects = append(objects, sha)
+		if path != "" {
+			opts.Paths[sha] = path
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if stdout {
+		out := bufio.NewWriter(os.Stdout)
+		if _, _, err := writePack(out, objects, opts); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+	packName, err := writePackFiles(base, objects, opts, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimPrefix(packName, base+"-"))
+	return nil
+}
+
+func HandlerBranch(ctx context.Context, name string, args []string) error {
+	if name != BranchCmd {
+		return MismatchedError
+	}
+
+	del, force, names := false, false, []string{}
+	for _, arg := range args {
+		switch arg {
+		case "-d", "--delete":
+			del = true
+		case "-D":
+			del, force = true, true
+		case "-f", "--force":
+			force = true
+		case "--list":
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return InvalidArgsError
+			}
+			names = append(names, arg)
+		}
+	}
+
+	switch {
+	case del:
+		if len(names) == 0 {
+			return InvalidArgsError
+		}
+		for _, branch := range names {
+			tip, err := DeleteBranch(branch, force)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Deleted branch %s (was %s).\n", branch, tip[:7])
+		}
+		return nil
+	case len(names) > 0:
+		if len(names) > 2 {
+			return InvalidArgsError
+		}
+		start := "HEAD"
+		if len(names) == 2 {
+			start = names[1]
+		}
+		sha, err := ResolveRef(start)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, start)
+		}
+		sha, gitObj, err := PeelObject(sha)
+		if err != nil {
+			return err
+		}
+		if gitObj.Kind() != CommitKind {
+			return fmt.Errorf("%s is a %s, not a commit", start, gitObj.Kind())
+		}
+		return CreateBranch(names[0], sha)
+	}
+
+	branches, err := listBranches()
+	if err != nil {
+		return err
+	}
+	head, err := InspectHead()
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if head.Target == "" && head.Hash != "" {
+		fmt.Fprintf(out, "* (HEAD detached at %s)\n", head.Hash[:7])
+	}
+	for _, ref := range branches {
+		marker := " "
+		if ref.Name == head.Target {
+			marker = "*"
+		}
+		fmt.Fprintf(out, "%s %s\n", marker, strings.TrimPrefix(ref.Name, "refs/heads/"))
+	}
+	return nil
+}
+
+func HandlerIntroducedObjects(ctx context.Context, name string, args []string) error {
+	if name != IntroducedCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		return InvalidArgsError
+	}
+	sha, err := ResolveRef(args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, args[0])
+	}
+	sha, gitObj, err := PeelObject(sha)
+	if err != nil {
+		return err
+	}
+	if gitObj.Kind() != CommitKind {
+		return fmt.Errorf("%s is a %s, not a commit", args[0], gitObj.Kind())
+	}
+
+	objects, err := IntroducedObjects(sha)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, o := range objects {
+		fmt.Fprintf(out, "%s %s\n", o.Sha, o.Path)
+	}
+	return nil
+}
+
+func HandlerRemoteHead(ctx context.Context, name string, args []string) error {
+	if name != RemoteHeadCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		return InvalidArgsError
+	}
+	adv, err := GetRefs(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	// the symref names HEAD even when its branch has no commit yet
+	branch, ok := adv.Symref("HEAD")
+	if !ok {
+		if _, branch, err = adv.Head(); err != nil {
+			return err
+		}
+	}
+	fmt.Println(strings.TrimPrefix(branch, "refs/heads/"))
+	return nil
+}
+
+func HandlerManifest(ctx context.Context, name string, args []string) error {
+	if name != ManifestCmd {
+		return MismatchedError
+	}
+
+	null, treeish := false, ""
+	for _, arg := range args {
+		switch {
+		case arg == "--null":
+			null = true
+		case strings.HasPrefix(arg, "-") || treeish != "":
+			return InvalidArgsError
+		default:
+			treeish = arg
+		}
+	}
+	if treeish == "" {
+		return InvalidArgsError
+	}
+	sha, err := ResolveRef(treeish)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, treeish)
+	}
+	files, err := FlattenTree(sha)
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quoteHigh, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths) // byte order, the same as the index and ls-tree -r
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, p := range paths {
+		e := files[p]
+		if null {
+			fmt.Fprintf(out, "%s %s\t%s\x00", e.mode, e.hash, p)
+		} else {
+			fmt.Fprintf(out, "%s %s\t%s\n", e.mode, e.hash, QuotePath(p, quoteHigh))
+		}
+	}
+	return nil
+}
+
+func HandlerCheckRefFormat(ctx context.Context, name string, args []string) error {
+	if name != CheckRefFormatCmd {
+		return MismatchedError
+	}
+
+	opts, refname, found := RefNameOptions{}, "", false
+	for i, arg := range args {
+		switch {
+		case arg == "--normalize" || arg == "--print":
+			opts.Normalize = true
+		case arg == "--allow-onelevel":
+			opts.AllowOneLevel = true
+		case arg == "--no-allow-onelevel":
+			opts.AllowOneLevel = false
+		case arg == "--refspec-pattern":
+			opts.RefspecPattern = true
+		case strings.HasPrefix(arg, "-") || i != len(args)-1:
+			return InvalidArgsError
+		default:
+			refname, found = arg, true
+		}
+	}
+	if !found {
+		return InvalidArgsError
+	}
+
+	// like git, an invalid name is told by the exit code alone
+	normalized, err := CheckRefFormat(refname, opts)
+	if err != nil {
+		Verbosef("%v\n", err)
+		return ExitStatus(1)
+	}
+	if opts.Normalize {
+		fmt.Println(normalized)
+	}
+	return nil
+}
+
+func HandlerComparePacks(ctx context.Context, name string, args []string) error {
+	if name != ComparePacksCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 2 {
+		return InvalidArgsError
+	}
+	packs := [2][]byte{}
+	for i, file := range args {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		packs[i] = raw
+	}
+	diff, err := ComparePacks(packs[0], packs[1])
+	if err != nil {
+		return err
+	}
+
+	// like diff, the objects only in the first pack are "-" and those only
+	// in the second "+"
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, sha := range diff.OnlyA {
+		fmt.Fprintf(out, "-%s\n", sha)
+	}
+	for _, sha := range diff.OnlyB {
+		fmt.Fprintf(out, "+%s\n", sha)
+	}
+	Infof("%d objects in both packs, %d only in %s, %d only in %s\n", diff.Common, len(diff.OnlyA), args[0], len(diff.OnlyB), args[1])
+	if len(diff.OnlyA) > 0 || len(diff.OnlyB) > 0 {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+func HandlerReachPath(ctx context.Context, name string, args []string) error {
+	if name != ReachPathCmd {
+		return MismatchedError
+	}
+
+	if len(args) != 1 {
+		return InvalidArgsError
+	}
+	sha, err := ResolveRef(args[0])
+	if err != nil {
+		return err
+	}
+	if !HasObject(sha) {
+		return fmt.Errorf("%w: %s", ObjectNotFound, sha)
+	}
+	root, steps, err := ReachabilityPath(sha)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Println("unreachable")
+		return ExitStatus(1)
+	}
+	chain := []string{root}
+	for _, step := range steps {
+		chain = append(chain, step.String())
+	}
+	fmt.Println(strings.Join(chain, " -> "))
+	return nil
+}
+
+func HandlerLsFiles(ctx context.Context, name string, args []string) error {
+	if name != LsFilesCmd {
+		return MismatchedError
+	}
+
+	stage, unmerged, null := false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--stage":
+			stage = true
+		case "-u", "--unmerged":
+			// like git, the stages are what tells the sides apart
+			stage, unmerged = true, true
+		case "-z":
+			null = true
+		default:
+			return InvalidArgsError
+		}
+	}
+	idx, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	quoteHigh, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, e := range idx.Entries {
+		if unmerged && e.Stage() == 0 {
+			continue
+		}
+		p, end := QuotePath(e.Path, quoteHigh), "\n"
+		if null {
+			p, end = e.Path, "\x00"
+		}
+		if stage {
+			fmt.Fprintf(out, "%06o %s %d\t", e.Mode, e.Hash, e.Stage())
+		}
+		fmt.Fprint(out, p+end)
+	}
+	return nil
+}
+
+func HandlerCheckout(ctx context.Context, name string, args []string) error {
+	if name != CheckoutCmd {
+		return MismatchedError
+	}
+
+	stage, paths := 0, []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--ours" && stage == 0:
+			stage = StageOurs
+		case arg == "--theirs" && stage == 0:
+			stage = StageTheirs
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if stage == 0 || len(paths) == 0 {
+		return InvalidArgsError
+	}
+
+	prefix, err := enterWorktree()
+	if err != nil {
+		return err
+	}
+	specs, err := ParsePathspecs(paths, prefix)
+	if err != nil {
+		return err
+	}
+	idx, err := ReadIndex()
+	if err != nil {
+		return err
+	}
+	// unlike git, which leaves them unmerged, the chosen side is staged
+	resolved, err := ResolveConflicts(idx, specs, stage)
+	if len(resolved) > 0 {
+		if err := idx.Write(); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	Infof("Resolved %d paths\n", len(resolved))
+	return nil
+}
+
+func HandlerReflog(ctx context.Context, name string, args []string) error {
+	if name != ReflogCmd {
+		return MismatchedError
+	}
+
+	if len(args) > 0 && args[0] == "show" {
+		args = args[1:]
+	}
+	refName := "HEAD"
+	switch {
+	case len(args) > 1 || len(args) == 1 && strings.HasPrefix(args[0], "-"):
+		return InvalidArgsError
+	case len(args) == 1:
+		refName = args[0]
+	}
+	// like git, the name given is the one shown, the reflog is the full one's
+	full := refName
+	if refName != "HEAD" {
+		var err error
+		if full, _, err = ExpandRef(refName); err != nil {
+			return fmt.Errorf("%w: %s", err, refName)
+		}
+	}
+	entries, err := ReadReflog(full)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		records := []ReflogEntryJSON{}
+		for i, e := range entries {
+			records = append(records, ReflogEntryJSON{
+				Selector:  fmt.Sprintf("%s@{%d}", refName, i),
+				Old:       e.Old,
+				New:       e.New,
+				Committer: newSignatureJSON(e.Committer),
+				Operation: e.Operation(),
+				Message:   e.Message,
+			})
+		}
+		return writeJSON(os.Stdout, records)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for i, e := range entries {
+		fmt.Fprintf(out, "%s %s@{%d}: %s\n", e.New, refName, i, e.Message)
+	}
+	return nil
+}
+
+func HandlerPatchID(ctx context.Context, name string, args []string) error {
+	if name != PatchIDCmd {
+		return MismatchedError
+	}
+
+	// like git, the config picks the mode unless a flag does
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return err
+	}
+	opts := PatchIDOptions{}
+	if opts.Stable, err = cfg.GetBool("patchid.stable", false); err != nil {
+		return err
+	}
+	if opts.Verbatim, err = cfg.GetBool("patchid.verbatim", false); err != nil {
+		return err
+	}
+	commits := []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "--stable":
+			opts.Stable, opts.Verbatim = true, false
+		case arg == "--unstable":
+			opts.Stable, opts.Verbatim = false, false
+		case arg == "--verbatim":
+			opts.Verbatim = true
+		case strings.HasPrefix(arg, "-"):
+			return InvalidArgsError
+		default:
+			commits = append(commits, arg)
+		}
+	}
+
+	ids := []PatchID{}
+	if len(commits) == 0 {
+		if ids, err = PatchIDs(os.Stdin, opts); err != nil {
+			return err
+		}
+	}
+	quoteHigh, err := cfg.GetBool("core.quotepath", true)
+	if err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		sha, err := ResolveRef(commit)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, commit)
+		}
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return err
+		}
+		// the change of the commit, against its first parent
+		parentTree := ""
+		if len(info.Parents) > 0 {
+			parent, err := ReadCommitInfo(info.Parents[0])
+			if err != nil {
+				return err
+			}
+			parentTree = parent.Tree
+		}
+		changes, err := DiffTrees(parentTree, info.Tree, true)
+		if err != nil {
+			return err
+		}
+		patch := bytes.Buffer{}
+		if err := WritePatch(&patch, changes, quoteHigh, WhitespaceExact); err != nil {
+			return err
+		}
+		found, err := PatchIDs(&patch, opts)
+		if err != nil {
+			return err
+		}
+		// an empty commit has no patch-id, like in git
+		for _, id := range found {
+			ids = append(ids, PatchID{ID: id.ID, Commit: sha})
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, id := range ids {
+		fmt.Fprintf(out, "%s %s\n", id.ID, id.Commit)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHelpListsCommands(t *testing.T) {
+	output := captureOutput(t, func() error {
+		return HandlerHelp(context.Background(), HelpCmd, nil)
+	})
+	if !strings.HasPrefix(output, usage) {
+		t.Errorf("help does not start with the usage: %q", output)
+	}
+	listed := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if name, summary, found := strings.Cut(strings.TrimSpace(line), "   "); found && strings.HasPrefix(line, "   ") {
+			listed[name] = strings.TrimSpace(summary)
+		}
+	}
+	for name, command := range availableCommands {
+		if listed[name] != command.Summary {
+			t.Errorf("help lists %s with %q, want %q", name, listed[name], command.Summary)
+		}
+	}
+	if len(listed) != len(availableCommands) {
+		t.Errorf("help lists %d commands, %d are registered", len(listed), len(availableCommands))
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	for name, command := range availableCommands {
+		if command.Summary == "" || !strings.HasPrefix(command.Usage, name) {
+			t.Errorf("%s has summary %q and usage %q", name, command.Summary, command.Usage)
+		}
+		output := captureOutput(t, func() error {
+			return HandlerHelp(context.Background(), HelpCmd, []string{name})
+		})
+		if want := "usage: mygit " + command.Usage + "\n\n" + command.Summary + "\n"; output != want {
+			t.Errorf("help %s printed %q, want %q", name, output, want)
+		}
+	}
+
+	if err := HandlerHelp(context.Background(), HelpCmd, []string{"missing"}); !errors.Is(err, CommandNotFoundError) {
+		t.Errorf("help of a missing command: %v", err)
+	}
+	if _, err := GetCommand("missing"); !errors.Is(err, CommandNotFoundError) {
+		t.Errorf("a missing command: %v", err)
+	}
+}
+
+// objectFiles counts the files under .git/objects
+func objectFiles(t *testing.T) int {
+	t.Helper()
+	count := 0
+	err := filepath.WalkDir(gitPath("objects"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// dryRunMatches runs args with --dry-run then without, and checks both print
+// the same sha while only the second writes objects
+func dryRunMatches(t *testing.T, args ...string) string {
+	t.Helper()
+	before := objectFiles(t)
+	dry, err := runCommand(t, append(args, "--dry-run")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written := objectFiles(t) - before; written != 0 {
+		t.Errorf("%v --dry-run wrote %d objects", args, written)
+	}
+	real, err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry != real || len(strings.TrimSpace(real)) != 40 {
+		t.Errorf("%v printed %q with --dry-run and %q without", args, dry, real)
+	}
+	sha := strings.TrimSpace(real)
+	if !HasObject(sha) {
+		t.Errorf("%v did not write %s", args, sha)
+	}
+	return sha
+}
+
+func TestDryRun(t *testing.T) {
+	testRepository(t)
+	t.Setenv("GIT_AUTHOR_DATE", "1700000000 +0000")
+	t.Setenv("GIT_COMMITTER_DATE", "1700000000 +0000")
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n"})
+
+	tree := dryRunMatches(t, "write-tree")
+	dryRunMatches(t, "commit-tree", tree, "-m", "first")
+
+	// from the index this time
+	if _, err := runCommand(t, "add", "file", "dir"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"dir/nested": "changed\n"})
+	if _, err := runCommand(t, "add", "dir/nested"); err != nil {
+		t.Fatal(err)
+	}
+	dryRunMatches(t, "write-tree")
+}
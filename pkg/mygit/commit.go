@@ -0,0 +1,289 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidCommit = errors.New("File at path cannot be parsed into a commit.")
+)
+
+// reaylly bad hack just for the clone cmd
+type CommitAsBytes struct {
+	content []byte
+}
+
+func (c *CommitAsBytes) Kind() ObjectKind {
+	return CommitKind
+}
+
+func (c *CommitAsBytes) Content() []byte {
+	return c.content
+}
+
+func (c *CommitAsBytes) String() string {
+	return string(c.content)
+}
+
+// TreeHash returns the sha from the "tree" header, which is always the first line
+func (c *CommitAsBytes) TreeHash() (string, error) {
+	line, _, _ := bytes.Cut(c.content, []byte{'\n'})
+	hash, found := bytes.CutPrefix(line, []byte("tree "))
+	if !found || len(hash) != objectHasher.HexLen() {
+		return "", InvalidCommit
+	}
+	return string(hash), nil
+}
+
+// Parents returns the sha of each "parent" header in order
+func (c *CommitAsBytes) Parents() []string {
+	parents := []string{}
+	for _, line := range bytes.Split(c.content, []byte{'\n'}) {
+		if len(line) == 0 { // end of headers
+			break
+		}
+		if hash, found := bytes.CutPrefix(line, []byte("parent ")); found {
+			parents = append(parents, string(hash))
+		}
+	}
+	return parents
+}
+
+// CommitTime returns the committer timestamp
+func (c *CommitAsBytes) CommitTime() (time.Time, error) {
+	for _, line := range bytes.Split(c.content, []byte{'\n'}) {
+		if len(line) == 0 { // end of headers
+			break
+		}
+		if ident, found := bytes.CutPrefix(line, []byte("committer ")); found {
+			fields := bytes.Fields(ident[bytes.LastIndexByte(ident, '>')+1:])
+			if len(fields) < 1 {
+				return time.Time{}, InvalidCommit
+			}
+			seconds, err := strconv.ParseInt(string(fields[0]), 10, 64)
+			if err != nil {
+				return time.Time{}, InvalidCommit
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, InvalidCommit
+}
+
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+	zone  string // offset as written in the object, kept for lossless round trips
+}
+
+func (s Signature) String() string {
+	zone := s.zone
+	if zone == "" {
+		zone = formatZone(s.When)
+	}
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), zone)
+}
+
+// parse "Name <email> 1700000000 +0100"
+func parseSignature(raw string) (Signature, error) {
+	open := strings.IndexByte(raw, '<')
+	close := strings.LastIndexByte(raw, '>')
+	if open == -1 || close < open {
+		return Signature{}, InvalidCommit
+	}
+	fields := strings.Fields(raw[close+1:])
+	if len(fields) != 2 {
+		return Signature{}, InvalidCommit
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, InvalidCommit
+	}
+	zone := fields[1]
+	offset, err := parseZone(zone)
+	if err != nil {
+		return Signature{}, InvalidCommit
+	}
+
+	return Signature{
+		Name:  strings.TrimSuffix(raw[:open], " "),
+		Email: raw[open+1 : close],
+		When:  time.Unix(seconds, 0).In(time.FixedZone("", offset)),
+		zone:  zone,
+	}, nil
+}
+
+type commitHeader struct {
+	key   string
+	value string // continuation lines joined with '\n'
+}
+
+type Commit struct {
+	tree      string
+	parents   []string
+	author    Signature
+	committer Signature
+	encoding  string         // charset of the message, empty means UTF-8
+	headers   []commitHeader // remaining headers, like gpgsig or mergetag
+	message   string         // raw, including the trailing newline
+}
+
+func (c *Commit) Kind() ObjectKind {
+	return CommitKind
+}
+
+func (c *Commit) Content() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("tree %s\n", c.tree))
+	for _, parent := range c.parents {
+		buf.WriteString(fmt.Sprintf("parent %s\n", parent))
+	}
+	buf.WriteString(fmt.Sprintf("author %s\n", c.author))
+	buf.WriteString(fmt.Sprintf("committer %s\n", c.committer))
+	if c.encoding != "" {
+		buf.WriteString(fmt.Sprintf("encoding %s\n", c.encoding))
+	}
+	for _, header := range c.headers {
+		value := strings.ReplaceAll(header.value, "\n", "\n ")
+		buf.WriteString(fmt.Sprintf("%s %s\n", header.key, value))
+	}
+	buf.WriteString("\n")
+	buf.WriteString(c.message)
+
+	return buf.Bytes()
+}
+
+func (c *Commit) String() string {
+	return string(c.Content())
+}
+
+// Message returns the commit message decoded to UTF-8 for display, the raw
+// bytes are kept untouched for the sha.
+func (c *Commit) Message() string {
+	switch strings.ToUpper(c.encoding) {
+	case "ISO-8859-1", "ISO8859-1", "LATIN1", "LATIN-1":
+		// every latin1 byte maps to the code point with the same value
+		decoded := strings.Builder{}
+		for i := 0; i < len(c.message); i++ {
+			decoded.WriteRune(rune(c.message[i]))
+		}
+		return decoded.String()
+	default:
+		return strings.ToValidUTF8(c.message, "\uFFFD")
+	}
+}
+
+// ParseCommit parses the body of a commit object, Content on the result
+// gives back the same bytes.
+func ParseCommit(content []byte) (*Commit, error) {
+	rawHeaders, message, found := bytes.Cut(content, []byte("\n\n"))
+	if !found {
+		return nil, InvalidCommit
+	}
+
+	headers := []commitHeader{}
+	for _, line := range strings.Split(string(rawHeaders), "\n") {
+		if continuation, ok := strings.CutPrefix(line, " "); ok {
+			if len(headers) == 0 {
+				return nil, InvalidCommit
+			}
+			headers[len(headers)-1].value += "\n" + continuation
+			continue
+		}
+		key, value, found := strings.Cut(line, " ")
+		if !found {
+			return nil, InvalidCommit
+		}
+		headers = append(headers, commitHeader{key, value})
+	}
+
+	c := &Commit{message: string(message)}
+	i := 0
+	next := func(key string) (string, bool) {
+		if i < len(headers) && headers[i].key == key {
+			i++
+			return headers[i-1].value, true
+		}
+		return "", false
+	}
+
+	var ok bool
+	if c.tree, ok = next("tree"); !ok {
+		return nil, InvalidCommit
+	}
+	for parent, ok := next("parent"); ok; parent, ok = next("parent") {
+		c.parents = append(c.parents, parent)
+	}
+	for _, sig := range []struct {
+		key string
+		dst *Signature
+	}{{"author", &c.author}, {"committer", &c.committer}} {
+		raw, ok := next(sig.key)
+		if !ok {
+			return nil, InvalidCommit
+		}
+		parsed, err := parseSignature(raw)
+		if err != nil {
+			return nil, err
+		}
+		*sig.dst = parsed
+	}
+	c.encoding, _ = next("encoding")
+	c.headers = headers[i:]
+
+	return c, nil
+}
+
+// ReadCommit reads and parses the commit at sha
+func ReadCommit(sha string) (*Commit, error) {
+	gitObj, err := ReadGitObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if gitObj.Kind() != CommitKind {
+		return nil, InvalidCommit
+	}
+	return ParseCommit(gitObj.Content())
+}
+
+func (c *Commit) Subject() string {
+	return messageSubject(c.Message())
+}
+
+// messageSubject returns the first paragraph of message on a single line,
+// like git's %s
+func messageSubject(message string) string {
+	paragraph, _, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	lines := strings.Split(strings.TrimRight(paragraph, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// VerifyCommitRoundTrip checks that parsing and serializing the commit at sha
+// gives back an object with the same sha
+func VerifyCommitRoundTrip(sha string) error {
+	gitObj, err := ReadGitObject(sha)
+	if err != nil {
+		return err
+	}
+	if gitObj.Kind() != CommitKind {
+		return InvalidCommit
+	}
+	commit, err := ParseCommit(gitObj.Content())
+	if err != nil {
+		return err
+	}
+	hash, _ := HashObject(commit)
+	if got := fmt.Sprintf("%x", hash); got != sha {
+		return fmt.Errorf("re-serialized commit hashes to %s", got)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package mygit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+var roundTripCommits = map[string]string{
+	"root": "tree " + testTree + "\n" +
+		"author A U Thor <author@example.com> 1700000000 +0000\n" +
+		"committer C O Mitter <committer@example.com> 1700000060 +0000\n\nroot\n",
+	"merge": "tree " + testTree + "\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"parent 3333333333333333333333333333333333333333\n" +
+		"author A U Thor <author@example.com> 1700000000 +0530\n" +
+		"committer C O Mitter <committer@example.com> 1700000060 -0930\n\nOctopus merge\n\nwith a body\n",
+	"signed": "tree " + testTree + "\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"author A U Thor <author@example.com> 1700000000 +0100\n" +
+		"committer C O Mitter <committer@example.com> 1700000060 +0100\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n =abcd\n -----END PGP SIGNATURE-----\n\nsigned\n",
+	"mergetag": "tree " + testTree + "\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"author A U Thor <author@example.com> 1700000000 +0000\n" +
+		"committer C O Mitter <committer@example.com> 1700000060 +0000\n" +
+		"mergetag object 2222222222222222222222222222222222222222\n type commit\n tag v1\n\nmerge a tag\n",
+	"encoded": "tree " + testTree + "\n" +
+		"author A U Thor <author@example.com> 1700000000 +0000\n" +
+		"committer C O Mitter <committer@example.com> 1700000060 +0000\n" +
+		"encoding ISO-8859-1\n\ncaf\xe9\n",
+	"zone -0000 and no message": "tree " + testTree + "\n" +
+		"author Nobody <> 0 -0000\n" +
+		"committer Nobody <> 0 -0000\n\n",
+	"unusual names": "tree " + testTree + "\n" +
+		"author  <a@b> 1700000000 +1400\n" +
+		"committer Name With <Brackets> <c@d> 1700000060 -1200\n\nno trailing newline",
+}
+
+func TestVerifyCommitRoundTrip(t *testing.T) {
+	testRepository(t)
+	for name, content := range roundTripCommits {
+		hash, err := WriteContent(&CommitAsBytes{content: []byte(content)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyCommitRoundTrip(fmt.Sprintf("%x", hash)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		commit, err := ParseCommit([]byte(content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(commit.Content()) != content {
+			t.Errorf("%s: serialized as\n%q\nwant\n%q", name, commit.Content(), content)
+		}
+	}
+}
+
+func TestParseCommitFields(t *testing.T) {
+	commit, err := ParseCommit([]byte(roundTripCommits["merge"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commit.parents) != 3 || commit.parents[2] != "3333333333333333333333333333333333333333" {
+		t.Errorf("parents %v", commit.parents)
+	}
+	if commit.author.Name != "A U Thor" || commit.author.Email != "author@example.com" {
+		t.Errorf("author %+v", commit.author)
+	}
+	if _, offset := commit.committer.When.Zone(); offset != -(9*3600 + 30*60) {
+		t.Errorf("committer zone offset %d", offset)
+	}
+	if commit.Subject() != "Octopus merge" {
+		t.Errorf("subject %q", commit.Subject())
+	}
+
+	signed, err := ParseCommit([]byte(roundTripCommits["signed"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed.headers) != 1 || signed.headers[0].key != "gpgsig" {
+		t.Errorf("headers %v", signed.headers)
+	}
+}
+
+func TestParseCommitRejectsMalformed(t *testing.T) {
+	for name, content := range map[string]string{
+		"no tree":         "author A <a> 0 +0000\ncommitter A <a> 0 +0000\n\nx\n",
+		"no committer":    "tree " + testTree + "\nauthor A <a> 0 +0000\n\nx\n",
+		"bad date":        "tree " + testTree + "\nauthor A <a> soon +0000\ncommitter A <a> 0 +0000\n\nx\n",
+		"no message":      "tree " + testTree + "\nauthor A <a> 0 +0000\ncommitter A <a> 0 +0000\n",
+		"dangling header": " continued\ntree " + testTree + "\n\nx\n",
+	} {
+		if _, err := ParseCommit([]byte(content)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestCommitEncoding(t *testing.T) {
+	testRepository(t)
+	content := roundTripCommits["encoded"]
+	hash, err := WriteContent(&CommitAsBytes{content: []byte(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := ReadCommit(fmt.Sprintf("%x", hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.encoding != "ISO-8859-1" {
+		t.Errorf("encoding %q", commit.encoding)
+	}
+	// displayed as UTF-8, kept as latin1 for the sha
+	if got := commit.Message(); got != "café\n" {
+		t.Errorf("message %q, want %q", got, "café\n")
+	}
+	if rehashed, _ := HashObject(commit); !bytes.Equal(rehashed, hash[:]) {
+		t.Errorf("re-serialized to %x, want %x", rehashed, hash)
+	}
+
+	// without an encoding header invalid UTF-8 is replaced for display only
+	utf8, err := ParseCommit([]byte(roundTripCommits["root"][:len(roundTripCommits["root"])-5] + "caf\xe9\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := utf8.Message(); got != "caf�\n" {
+		t.Errorf("message %q", got)
+	}
+	if string(utf8.Content()[len(utf8.Content())-5:]) != "caf\xe9\n" {
+		t.Errorf("raw message changed: %q", utf8.Content())
+	}
+}
+
+func TestCommitTreeMatchesGit(t *testing.T) {
+	testRepository(t)
+	t.Setenv("GIT_AUTHOR_DATE", "1700000000 +0000")
+	t.Setenv("GIT_COMMITTER_DATE", "1700000060 +0000")
+	writeFiles(t, map[string]string{"file": "content\n", "dir/nested": "nested\n"})
+	runGit(t, "add", "-A")
+	tree := runGit(t, "write-tree")
+
+	root := strings.TrimSpace(matchGit(t, "commit-tree", tree, "-m", "root"))
+	commit := strings.TrimSpace(matchGit(t, "commit-tree", tree, "-p", root, "-m", "interop commit\n\nwith a body"))
+	matchGit(t, "cat-file", "-p", commit)
+	matchGit(t, "cat-file", "-t", commit)
+}
+
+func TestCommitTreeDatesMatchGit(t *testing.T) {
+	testRepository(t)
+	tree := runGit(t, "write-tree")
+	// zones with minutes and the formats GIT_*_DATE accepts
+	for _, date := range []string{
+		"1700000000 +0530",
+		"1700000000 -0930",
+		"Thu, 07 Apr 2005 22:13:13 +0200",
+		"2005-04-07T22:13:13-0130",
+	} {
+		t.Setenv("GIT_AUTHOR_DATE", date)
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		matchGit(t, "commit-tree", tree, "-m", "dated")
+	}
+}
+
+func TestCommitTreeSignOffMatchesGit(t *testing.T) {
+	testRepository(t)
+	tree := runGit(t, "write-tree")
+	message := func(commit string) string {
+		t.Helper()
+		_, body, _ := strings.Cut(string(gitOutput(t, nil, "cat-file", "commit", commit)), "\n\n")
+		return body
+	}
+
+	// into an existing trailer block without a second blank line, once, and
+	// after a blank line when there is no block
+	for _, msg := range []string{
+		"subject",
+		"subject\n\nAcked-by: Someone <someone@example.com>",
+		"subject\n\nbody\n\nSigned-off-by: Tester <tester@example.com>",
+		"subject\n\nSigned-off-by: Tester <tester@example.com>\nAcked-by: Someone <someone@example.com>",
+	} {
+		runGit(t, "commit", "-q", "--allow-empty", "-s", "-m", msg)
+		output, err := runCommand(t, "commit-tree", tree, "-s", "-m", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := message(strings.TrimSpace(output)), message("HEAD"); got != want {
+			t.Errorf("commit-tree -s -m %q wrote\n%q\ngit commit -s wrote\n%q", msg, got, want)
+		}
+	}
+
+	// commit.template cleaned up like git stripspace does
+	template, content := filepath.Join(t.TempDir(), "template"), []byte("# not in the message\nTemplate subject\n\n\nTemplate body\n")
+	if err := os.WriteFile(template, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "config", "commit.template", template)
+	output, err := runCommand(t, "commit-tree", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(gitOutput(t, content, "stripspace", "--strip-comments"))
+	if got := message(strings.TrimSpace(output)); got != want {
+		t.Errorf("commit-tree with commit.template wrote\n%q\ngit stripspace leaves\n%q", got, want)
+	}
+}
@@ -0,0 +1,408 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	InvalidCommitGraph = errors.New("File cannot be parsed into a commit-graph.")
+)
+
+const (
+	graphNoParent      = 0x70000000
+	graphExtraParents  = 0x80000000
+	graphMaxGeneration = 0x3fffffff
+)
+
+// CommitInfo is what history walks need from a commit, it comes from the
+// commit-graph when the commit is in it
+type CommitInfo struct {
+	Tree       string
+	Parents    []string
+	Time       int64  // committer date, in seconds
+	Generation uint32 // topological level, 0 when unknown
+}
+
+// https://git-scm.com/docs/gitformat-commit-graph
+type CommitGraph struct {
+	shas  [][20]byte
+	data  []byte // CDAT chunk
+	edges []byte // EDGE chunk, for the parents after the first two
+}
+
+func ReadCommitGraph(file string) (*CommitGraph, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// signature, version 1, sha1, chunk count, base graph count
+	if len(data) < 8+20 || !bytes.Equal(data[:4], []byte("CGPH")) || data[4] != 1 || data[5] != 1 {
+		return nil, InvalidCommitGraph
+	}
+	chunkCount := int(data[6])
+	if len(data) < 8+(chunkCount+1)*12 {
+		return nil, InvalidCommitGraph
+	}
+	chunks := map[string][]byte{}
+	for i := range chunkCount {
+		row := data[8+i*12:]
+		start := binary.BigEndian.Uint64(row[4:12])
+		end := binary.BigEndian.Uint64(row[16:24])
+		if start > end || end > uint64(len(data)) {
+			return nil, InvalidCommitGraph
+		}
+		chunks[string(row[:4])] = data[start:end]
+	}
+
+	fanout, shas, commits := chunks["OIDF"], chunks["OIDL"], chunks["CDAT"]
+	if len(fanout) != 256*4 {
+		return nil, InvalidCommitGraph
+	}
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+	if len(shas) != count*20 || len(commits) != count*36 {
+		return nil, InvalidCommitGraph
+	}
+
+	graph := &CommitGraph{
+		shas:  make([][20]byte, count),
+		data:  commits,
+		edges: chunks["EDGE"],
+	}
+	for i := range count {
+		copy(graph.shas[i][:], shas[i*20:])
+	}
+	return graph, nil
+}
+
+func (g *CommitGraph) position(sha [20]byte) (int, bool) {
+	i := sort.Search(len(g.shas), func(i int) bool {
+		return bytes.Compare(g.shas[i][:], sha[:]) >= 0
+	})
+	return i, i < len(g.shas) && g.shas[i] == sha
+}
+
+// Lookup returns the graph data of the commit at sha
+func (g *CommitGraph) Lookup(sha string) (CommitInfo, bool, error) {
+	var key [20]byte
+	if _, err := hex.Decode(key[:], []byte(sha)); err != nil {
+		return CommitInfo{}, false, InvalidObject
+	}
+	i, ok := g.position(key)
+	if !ok {
+		return CommitInfo{}, false, nil
+	}
+	info, err := g.at(i)
+	return info, err == nil, err
+}
+
+// each CDAT row: tree, first and second parent positions, then generation
+// and commit time packed in 64 bits
+func (g *CommitGraph) at(i int) (CommitInfo, error) {
+	row := g.data[i*36 : (i+1)*36]
+	info := CommitInfo{Tree: fmt.Sprintf("%x", row[:20])}
+
+	parent := func(pos uint32) (string, error) {
+		if int(pos) >= len(g.shas) {
+			return "", InvalidCommitGraph
+		}
+		return fmt.Sprintf("%x", g.shas[pos]), nil
+	}
+	first, second := binary.BigEndian.Uint32(row[20:24]), binary.BigEndian.Uint32(row[24:28])
+	if first != graphNoParent {
+		sha, err := parent(first)
+		if err != nil {
+			return info, err
+		}
+		info.Parents = append(info.Parents, sha)
+	}
+	switch {
+	case second == graphNoParent:
+	case second&graphExtraParents != 0:
+		// octopus merges list the other parents in EDGE, the last one is flagged
+		for at := int(second&^graphExtraParents) * 4; ; at += 4 {
+			if at+4 > len(g.edges) {
+				return info, InvalidCommitGraph
+			}
+			edge := binary.BigEndian.Uint32(g.edges[at:])
+			sha, err := parent(edge &^ graphExtraParents)
+			if err != nil {
+				return info, err
+			}
+			info.Parents = append(info.Parents, sha)
+			if edge&graphExtraParents != 0 {
+				break
+			}
+		}
+	default:
+		sha, err := parent(second)
+		if err != nil {
+			return info, err
+		}
+		info.Parents = append(info.Parents, sha)
+	}
+
+	high, low := binary.BigEndian.Uint32(row[28:32]), binary.BigEndian.Uint32(row[32:36])
+	info.Generation = high >> 2
+	info.Time = int64(high&0x3)<<32 | int64(low)
+	return info, nil
+}
+
+// the commit-graph is read once per process, nil when there is none
+var commitGraph *CommitGraph
+var commitGraphLoaded bool
+
+func loadCommitGraph() (*CommitGraph, error) {
+	if commitGraphLoaded {
+		return commitGraph, nil
+	}
+	graph, err := ReadCommitGraph(gitPath("objects", "info", "commit-graph"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	commitGraph, commitGraphLoaded = graph, true
+	return graph, nil
+}
+
+// ReadCommitInfo returns the parents, tree and date of the commit at sha from
+// the commit-graph, parsing the commit when it is not in the graph
+func ReadCommitInfo(sha string) (CommitInfo, error) {
+	graph, err := loadCommitGraph()
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	if graph != nil {
+		if info, ok, err := graph.Lookup(sha); ok || err != nil {
+			return info, err
+		}
+	}
+	return parseCommitInfo(sha)
+}
+
+func parseCommitInfo(sha string) (CommitInfo, error) {
+	commit, err := ReadCommit(sha)
+	if err != nil {
+		return CommitInfo{}, err
+	}
+	return CommitInfo{
+		Tree:    commit.tree,
+		Parents: commit.parents,
+		Time:    commit.committer.When.Unix(),
+	}, nil
+}
+
+// VerifyCommitGraph checks every commit of the graph against the parsed
+// commit object, and that generation numbers grow from parents to children
+func VerifyCommitGraph(graph *CommitGraph) error {
+	for i, key := range graph.shas {
+		sha := fmt.Sprintf("%x", key)
+		info, err := graph.at(i)
+		if err != nil {
+			return fmt.Errorf("%w: commit %s", err, sha)
+		}
+		parsed, err := parseCommitInfo(sha)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", sha, err)
+		}
+		if info.Tree != parsed.Tree || info.Time != parsed.Time ||
+			fmt.Sprint(info.Parents) != fmt.Sprint(parsed.Parents) {
+			return fmt.Errorf("%w: commit %s does not match its object", InvalidCommitGraph, sha)
+		}
+		for _, parent := range info.Parents {
+			parentInfo, _, err := graph.Lookup(parent)
+			if err != nil {
+				return err
+			}
+			if parentInfo.Generation >= info.Generation {
+				return fmt.Errorf("%w: commit %s has generation %d, its parent %s has %d",
+					InvalidCommitGraph, sha, info.Generation, parent, parentInfo.Generation)
+			}
+		}
+	}
+	return nil
+}
+
+// WriteCommitGraph stores every commit reachable from HEAD and the refs in
+// objects/info/commit-graph and returns how many there are. Like git the file
+// is written beside the old one and renamed over it once complete.
+func WriteCommitGraph(ctx context.Context) (int, error) {
+	tips := []string{}
+	if hash, err := readRef("HEAD"); err == nil {
+		tips = append(tips, hash)
+	}
+	refs, err := ListRefs()
+	if err != nil {
+		return 0, err
+	}
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash)
+	}
+
+	commits := map[string]CommitInfo{}
+	queue := []string{}
+	for _, tip := range tips {
+		sha, gitObj, err := PeelObject(tip)
+		if err != nil {
+			return 0, err
+		}
+		// refs to trees and blobs have no history
+		if gitObj.Kind() == CommitKind {
+			queue = append(queue, sha)
+		}
+	}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		sha := queue[0]
+		queue = queue[1:]
+		if _, ok := commits[sha]; ok {
+			continue
+		}
+		// the graph being replaced may be stale, commits are parsed
+		info, err := parseCommitInfo(sha)
+		if err != nil {
+			return 0, err
+		}
+		for _, parent := range info.Parents {
+			// a shallow history cannot be described, every parent needs a position
+			if !HasObject(parent) {
+				return 0, fmt.Errorf("parent %s of commit %s is missing, cannot write a commit-graph", parent, sha)
+			}
+		}
+		commits[sha] = info
+		queue = append(queue, info.Parents...)
+	}
+
+	shas := make([][20]byte, 0, len(commits))
+	for sha := range commits {
+		var key [20]byte
+		hex.Decode(key[:], []byte(sha))
+		shas = append(shas, key)
+	}
+	sort.Slice(shas, func(i, j int) bool { return bytes.Compare(shas[i][:], shas[j][:]) < 0 })
+	positions := map[string]uint32{}
+	for i, key := range shas {
+		positions[fmt.Sprintf("%x", key)] = uint32(i)
+	}
+
+	generations := map[string]uint32{}
+	for sha := range commits {
+		commitGeneration(sha, commits, generations)
+	}
+
+	fanout := make([]byte, 256*4)
+	oids := make([]byte, 0, len(shas)*20)
+	data := make([]byte, 0, len(shas)*36)
+	edges := []byte{}
+	for _, key := range shas {
+		for b := int(key[0]); b < 256; b++ {
+			binary.BigEndian.PutUint32(fanout[b*4:], binary.BigEndian.Uint32(fanout[b*4:])+1)
+		}
+		oids = append(oids, key[:]...)
+
+		sha := fmt.Sprintf("%x", key)
+		info := commits[sha]
+		tree, err := hex.DecodeString(info.Tree)
+		if err != nil || len(tree) != 20 {
+			return 0, fmt.Errorf("%w: commit %s has tree %q", InvalidCommit, sha, info.Tree)
+		}
+		data = append(data, tree...)
+
+		first, second := uint32(graphNoParent), uint32(graphNoParent)
+		switch parents := info.Parents; {
+		case len(parents) == 1:
+			first = positions[parents[0]]
+		case len(parents) == 2:
+			first, second = positions[parents[0]], positions[parents[1]]
+		case len(parents) > 2:
+			// octopus merges list the other parents in EDGE, flagging the last one
+			first, second = positions[parents[0]], graphExtraParents|uint32(len(edges)/4)
+			for i, parent := range parents[1:] {
+				edge := positions[parent]
+				if i == len(parents)-2 {
+					edge |= graphExtraParents
+				}
+				edges = binary.BigEndian.AppendUint32(edges, edge)
+			}
+		}
+		data = binary.BigEndian.AppendUint32(data, first)
+		data = binary.BigEndian.AppendUint32(data, second)
+		data = binary.BigEndian.AppendUint32(data, generations[sha]<<2|uint32(info.Time>>32)&0x3)
+		data = binary.BigEndian.AppendUint32(data, uint32(info.Time))
+	}
+
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := []chunk{{"OIDF", fanout}, {"OIDL", oids}, {"CDAT", data}}
+	if len(edges) > 0 {
+		chunks = append(chunks, chunk{"EDGE", edges})
+	}
+
+	// signature, version 1, sha1, chunk count, no base graph
+	file := []byte{'C', 'G', 'P', 'H', 1, 1, byte(len(chunks)), 0}
+	offset := uint64(len(file) + (len(chunks)+1)*12)
+	for _, chunk := range chunks {
+		file = append(file, chunk.id...)
+		file = binary.BigEndian.AppendUint64(file, offset)
+		offset += uint64(len(chunk.data))
+	}
+	file = append(file, 0, 0, 0, 0)
+	file = binary.BigEndian.AppendUint64(file, offset)
+	for _, chunk := range chunks {
+		file = append(file, chunk.data...)
+	}
+	checksum := objectHasher.Sum(file)
+	file = append(file, checksum[:]...)
+
+	dir := gitPath("objects", "info")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	err = writeAtomically(filepath.Join(dir, "commit-graph"), 0o444, func(w io.Writer) error {
+		_, err := w.Write(file)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	commitGraph, commitGraphLoaded = nil, false
+	return len(shas), nil
+}
+
+// commitGeneration computes the topological level of sha, one more than its
+// highest parent, without recursing so long histories do not grow the stack
+func commitGeneration(sha string, commits map[string]CommitInfo, generations map[string]uint32) {
+	stack := []string{sha}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if _, ok := generations[top]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		generation, ready := uint32(1), true
+		for _, parent := range commits[top].Parents {
+			parentGeneration, ok := generations[parent]
+			if !ok {
+				stack = append(stack, parent)
+				ready = false
+				continue
+			}
+			generation = max(generation, parentGeneration+1)
+		}
+		if ready {
+			generations[top] = min(generation, graphMaxGeneration)
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
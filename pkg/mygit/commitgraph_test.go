@@ -0,0 +1,152 @@
+package mygit
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitHistory makes a history with git: a line of commits, two side branches
+// and an octopus merge of them, whose third parent needs the EDGE chunk
+func gitHistory(t *testing.T) {
+	t.Helper()
+	commit := func(name string) {
+		testTime = testTime.Add(time.Minute)
+		writeFiles(t, map[string]string{name: testTime.String() + "\n"})
+		runGit(t, "add", name)
+		runGit(t, "commit", "-qm", name, "--date", testTime.Format(time.RFC3339))
+	}
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	commit("first")
+	commit("second")
+	for _, side := range []string{"left", "right"} {
+		runGit(t, "checkout", "-qb", side, "main")
+		commit(side)
+		commit(side + "-again")
+	}
+	runGit(t, "checkout", "-q", "main")
+	commit("third")
+	runGit(t, "merge", "-q", "-m", "octopus", "left", "right")
+	commit("last")
+}
+
+// topologicalLevels computes the generation git stores in the CDAT chunk,
+// 1 for roots and one more than the highest parent for the others
+func topologicalLevels(t *testing.T) map[string]uint32 {
+	t.Helper()
+	levels := map[string]uint32{}
+	// rev-list --reverse lists the parents first
+	for _, line := range strings.Split(runGit(t, "rev-list", "--all", "--reverse", "--topo-order", "--parents"), "\n") {
+		fields := strings.Fields(line)
+		level := uint32(1)
+		for _, parent := range fields[1:] {
+			level = max(level, levels[parent]+1)
+		}
+		levels[fields[0]] = level
+	}
+	return levels
+}
+
+func TestCommitGraphWrittenByGit(t *testing.T) {
+	testRepository(t)
+	gitHistory(t)
+	runGit(t, "-c", "commitGraph.generationVersion=1", "commit-graph", "write", "--reachable", "--no-progress")
+	graph, err := ReadCommitGraph(gitPath("objects", "info", "commit-graph"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels := topologicalLevels(t)
+	if len(graph.shas) != len(levels) {
+		t.Fatalf("the graph has %d commits, want %d", len(graph.shas), len(levels))
+	}
+	octopus := false
+	for sha, level := range levels {
+		info, ok, err := graph.Lookup(sha)
+		if !ok || err != nil {
+			t.Fatalf("%s is not in the graph: %v", sha, err)
+		}
+		parsed, err := parseCommitInfo(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed.Generation = level
+		if info.Tree != parsed.Tree || info.Time != parsed.Time || strings.Join(info.Parents, " ") != strings.Join(parsed.Parents, " ") || info.Generation != level {
+			t.Errorf("%s is %+v in the graph, want %+v", sha, info, parsed)
+		}
+		octopus = octopus || len(info.Parents) == 3
+	}
+	if !octopus {
+		t.Error("the octopus merge was not read")
+	}
+	if err := VerifyCommitGraph(graph); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadCommitInfoFallsBack(t *testing.T) {
+	testRepository(t)
+	gitHistory(t)
+	runGit(t, "commit-graph", "write", "--reachable", "--no-progress")
+	// a commit made since is not in the graph
+	writeFiles(t, map[string]string{"later": "later\n"})
+	runGit(t, "add", "later")
+	runGit(t, "commit", "-qm", "later")
+	head := runGit(t, "rev-parse", "HEAD")
+	dropObjectCaches()
+
+	info, err := ReadCommitInfo(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := runGit(t, "rev-parse", "HEAD^"); strings.Join(info.Parents, " ") != want || info.Generation != 0 {
+		t.Errorf("read %+v, want the parent %s and no generation", info, want)
+	}
+	if info, err = ReadCommitInfo(runGit(t, "rev-parse", "HEAD^")); err != nil || info.Generation == 0 {
+		t.Errorf("the graph was not used: %+v, %v", info, err)
+	}
+}
+
+func TestWriteCommitGraph(t *testing.T) {
+	testRepository(t)
+	gitHistory(t)
+	count, err := WriteCommitGraph(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := topologicalLevels(t)
+	if count != len(levels) {
+		t.Errorf("wrote %d commits, want %d", count, len(levels))
+	}
+	file := gitPath("objects", "info", "commit-graph")
+	graph, err := ReadCommitGraph(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCommitGraph(graph); err != nil {
+		t.Error(err)
+	}
+	for sha, level := range levels {
+		if info, ok, err := graph.Lookup(sha); !ok || err != nil || info.Generation != level {
+			t.Errorf("%s has generation %d, %v, want %d", sha, info.Generation, err, level)
+		}
+	}
+
+	// git reads it, and writes the same file
+	runGit(t, "commit-graph", "verify", "--no-progress")
+	written, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(file)
+	runGit(t, "-c", "commitGraph.generationVersion=1", "commit-graph", "write", "--reachable", "--no-progress")
+	byGit, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(byGit) {
+		t.Errorf("mygit wrote %d bytes, git %d different ones", len(written), len(byGit))
+	}
+}
@@ -0,0 +1,162 @@
+package mygit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// PackObjectIDs resolves every object of the pack in memory, deltas against
+// objects of the pack or, for a thin pack, of the repository included, and
+// returns their shas with their kind. Nothing is written to the repository.
+func PackObjectIDs(raw []byte) (map[string]ObjectKind, error) {
+	if len(raw) < 32 || !bytes.Equal(raw[:4], []byte{'P', 'A', 'C', 'K'}) {
+		return nil, InvalidPackError
+	}
+	size := hashSize()
+	checksum := objectHasher.Sum(raw[:len(raw)-size])
+	if !bytes.Equal(checksum, raw[len(raw)-size:]) {
+		return nil, MismatchedChecksumError
+	}
+	data := raw[:len(raw)-size]
+
+	type resolved struct {
+		sha     string
+		kind    ObjectKind
+		content []byte
+	}
+	type delta struct {
+		offset     int64
+		baseSha    string // ref-delta
+		baseOffset int64  // ofs-delta
+		data       []byte
+	}
+	byOffset, bySha := map[int64]*resolved{}, map[string]*resolved{}
+	add := func(offset int64, kind ObjectKind, content []byte) {
+		gitObj, _ := newGitObject(kind, content)
+		hash, _ := HashObject(gitObj)
+		obj := &resolved{sha: fmt.Sprintf("%x", hash), kind: kind, content: content}
+		byOffset[offset], bySha[obj.sha] = obj, obj
+	}
+
+	count := binary.BigEndian.Uint32(data[8:12])
+	r := bytes.NewReader(data[12:])
+	pending := []delta{}
+	for range count {
+		offset := int64(len(data)) - int64(r.Len())
+		kind, size, err := parseObjectHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("object at offset %d: %w", offset, err)
+		}
+		d := delta{offset: offset}
+		switch kind {
+		case ofsDelta:
+			distance, err := readOfsDeltaOffset(r)
+			if err != nil {
+				return nil, err
+			}
+			d.baseOffset = offset - int64(distance)
+		case refDelta:
+			var sha [20]byte
+			if _, err := io.ReadFull(r, sha[:]); err != nil {
+				return nil, err
+			}
+			d.baseSha = fmt.Sprintf("%x", sha)
+		}
+		content, err := decompress(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("object at offset %d: %w", offset, err)
+		}
+		if kind == ofsDelta || kind == refDelta {
+			d.data = content.Bytes()
+			pending = append(pending, d)
+			continue
+		}
+		objKind, ok := packKindToKind[kind]
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected object type %d at offset %d", InvalidPackError, kind, offset)
+		}
+		add(offset, objKind, content.Bytes())
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", InvalidPackError, r.Len())
+	}
+
+	// a base may itself be a delta, or come later in the pack for ref-deltas
+	for len(pending) > 0 {
+		unresolved := []delta{}
+		for _, d := range pending {
+			base, ok := bySha[d.baseSha]
+			if d.baseSha == "" {
+				base, ok = byOffset[d.baseOffset]
+			}
+			if !ok {
+				unresolved = append(unresolved, d)
+				continue
+			}
+			content, err := applyDelta(base.content, bytes.NewBuffer(d.data))
+			if err != nil {
+				return nil, fmt.Errorf("object at offset %d: %w", d.offset, err)
+			}
+			add(d.offset, base.kind, content)
+		}
+		if len(unresolved) == len(pending) {
+			// a thin pack leaves bases out, they are in the repository
+			d := unresolved[0]
+			if d.baseSha == "" || !HasObject(d.baseSha) {
+				return nil, fmt.Errorf("%w: %d deltas have a missing base", InvalidPackError, len(unresolved))
+			}
+			kind, content, err := readObject(d.baseSha)
+			if err != nil {
+				return nil, err
+			}
+			bySha[d.baseSha] = &resolved{sha: d.baseSha, kind: kind, content: content}
+		}
+		pending = unresolved
+	}
+
+	objects := map[string]ObjectKind{}
+	for _, obj := range byOffset {
+		objects[obj.sha] = obj.kind
+	}
+	return objects, nil
+}
+
+// PackDifference is what ComparePacks finds, two packs holding the same
+// objects have none only in either whatever the way they are stored
+type PackDifference struct {
+	Common int      // objects in both packs
+	OnlyA  []string // sorted by sha
+	OnlyB  []string
+}
+
+// ComparePacks tells whether the packs a and b hold the same objects,
+// ignoring their order and which ones are deltas
+func ComparePacks(a, b []byte) (PackDifference, error) {
+	diff := PackDifference{}
+	objectsA, err := PackObjectIDs(a)
+	if err != nil {
+		return diff, err
+	}
+	objectsB, err := PackObjectIDs(b)
+	if err != nil {
+		return diff, err
+	}
+	for sha := range objectsA {
+		if _, ok := objectsB[sha]; ok {
+			diff.Common++
+		} else {
+			diff.OnlyA = append(diff.OnlyA, sha)
+		}
+	}
+	for sha := range objectsB {
+		if _, ok := objectsA[sha]; !ok {
+			diff.OnlyB = append(diff.OnlyB, sha)
+		}
+	}
+	slices.Sort(diff.OnlyA)
+	slices.Sort(diff.OnlyB)
+	return diff, nil
+}
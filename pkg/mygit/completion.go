@@ -0,0 +1,75 @@
+package mygit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const bashCompletion = `# bash completion for mygit, load with: source <(mygit completion bash)
+_mygit() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        return
+    fi
+    COMPREPLY=($(compgen -W "$(mygit completion refs 2>/dev/null)" -- "$cur"))
+    if [ ${#COMPREPLY[@]} -eq 0 ]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -F _mygit mygit
+`
+
+const zshCompletion = `#compdef mygit
+# zsh completion for mygit, load with: source <(mygit completion zsh)
+_mygit() {
+    local -a commands refs
+    commands=(
+%s    )
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        return
+    fi
+    refs=(${(f)"$(mygit completion refs 2>/dev/null)"})
+    _alternative 'refs:ref:compadd -a refs' 'files:file:_files'
+}
+compdef _mygit mygit
+`
+
+func WriteBashCompletion(w io.Writer) error {
+	_, err := fmt.Fprintf(w, bashCompletion, strings.Join(CommandNames(), " "))
+	return err
+}
+
+func WriteZshCompletion(w io.Writer) error {
+	commands := strings.Builder{}
+	for _, name := range CommandNames() {
+		summary := strings.NewReplacer(":", `\:`, "'", `'\''`).Replace(availableCommands[name].Summary)
+		fmt.Fprintf(&commands, "        '%s:%s'\n", name, summary)
+	}
+	_, err := fmt.Fprintf(w, zshCompletion, commands.String())
+	return err
+}
+
+// WriteRefCompletions lists HEAD and the short name of every ref
+func WriteRefCompletions(w io.Writer) error {
+	refs, err := ListRefs()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "HEAD")
+	for _, ref := range refs {
+		name := ref.Name
+		for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/"} {
+			if short, found := strings.CutPrefix(name, prefix); found {
+				name = short
+				break
+			}
+		}
+		if _, err := fmt.Fprintln(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
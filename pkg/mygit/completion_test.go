@@ -0,0 +1,61 @@
+package mygit
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestBashCompletion(t *testing.T) {
+	script := bytes.Buffer{}
+	if err := WriteBashCompletion(&script); err != nil {
+		t.Fatal(err)
+	}
+	words := regexp.MustCompile(`compgen -W "([^"$]*)"`).FindStringSubmatch(script.String())
+	if words == nil {
+		t.Fatalf("no command list in %q", script.String())
+	}
+	if got, want := strings.Fields(words[1]), CommandNames(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("completes %v, want %v", got, want)
+	}
+	if bash, err := exec.LookPath("bash"); err == nil {
+		check := exec.Command(bash, "-n")
+		check.Stdin = &script
+		if output, err := check.CombinedOutput(); err != nil {
+			t.Errorf("the script does not parse: %v\n%s", err, output)
+		}
+	}
+}
+
+func TestZshCompletion(t *testing.T) {
+	script := bytes.Buffer{}
+	if err := WriteZshCompletion(&script); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range CommandNames() {
+		if !strings.Contains(script.String(), "        '"+name+":") {
+			t.Errorf("%s is not completed", name)
+		}
+	}
+}
+
+func TestRefCompletions(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "content\n"})
+	sha := commitWorktree(t, "first")
+	for _, ref := range []string{"refs/tags/v1", "refs/remotes/origin/main", "refs/notes/commits"} {
+		if err := UpdateRef(ref, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := bytes.Buffer{}
+	if err := WriteRefCompletions(&output); err != nil {
+		t.Fatal(err)
+	}
+	if want := "HEAD\nmain\nrefs/notes/commits\norigin/main\nv1\n"; output.String() != want {
+		t.Errorf("completes %q, want %q", output.String(), want)
+	}
+}
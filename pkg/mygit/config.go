@@ -0,0 +1,435 @@
+package mygit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	InvalidConfig = errors.New("Config file cannot be parsed.")
+)
+
+// git stops following include chains after this many levels
+const maxIncludeDepth = 10
+
+type configEntry struct {
+	section    string // lowercased
+	subsection string // case sensitive
+	key        string // lowercased
+	value      string
+}
+
+type Config struct {
+	gitDir  string
+	entries []configEntry
+}
+
+// LoadConfig reads the user level config files followed by the repository
+// config, so that repository values override user values.
+func LoadConfig(gitDir string) (*Config, error) {
+	absGitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{gitDir: absGitDir}
+
+	files := []string{}
+	if home, err := os.UserHomeDir(); err == nil {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = path.Join(home, ".config")
+		}
+		files = append(files, path.Join(xdg, "git", "config"), path.Join(home, ".gitconfig"))
+	}
+	files = append(files, path.Join(absGitDir, "config"))
+
+	for _, file := range files {
+		if err := cfg.readFile(file, 0); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// Get returns the last value set for key, written as "section.key" or
+// "section.subsection.key".
+func (c *Config) Get(key string) (string, bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetBool reads key as a git boolean, returning def when it is not set
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return def, fmt.Errorf("%w: bad boolean value '%s' for %s", InvalidConfig, value, key)
+}
+
+func (c *Config) GetAll(key string) []string {
+	section, subsection, name, ok := splitConfigKey(key)
+	if !ok {
+		return nil
+	}
+	values := []string{}
+	for _, e := range c.entries {
+		if e.section == section && e.subsection == subsection && e.key == name {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+func splitConfigKey(key string) (section, subsection, name string, ok bool) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first == -1 || first == 0 || last == len(key)-1 {
+		return "", "", "", false
+	}
+	section = strings.ToLower(key[:first])
+	name = strings.ToLower(key[last+1:])
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	return section, subsection, name, true
+}
+
+// SetConfig sets key to value in the config file, replacing its last value
+// or adding it at the end of its section, which is created when missing.
+// The Config values already loaded are not updated.
+func SetConfig(file, key, value string) error {
+	section, subsection, name, ok := splitConfigKey(key)
+	if !ok {
+		return fmt.Errorf("%w: invalid key %q", InvalidConfig, key)
+	}
+	// read under the lock so that concurrent updates are not lost
+	lock, err := lockFile(file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		rollbackLock(lock)
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	// the line of the last value of key, or the last line of the section
+	setting := "\t" + key[strings.LastIndex(key, ".")+1:] + " = " + quoteConfigValue(value) + "\n"
+	keyLine, sectionEnd, inSection := -1, -1, false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			end := strings.LastIndex(trimmed, "]")
+			if end == -1 {
+				rollbackLock(lock)
+				return fmt.Errorf("%w: %s:%d", InvalidConfig, file, i+1)
+			}
+			s, sub, err := parseSectionHeader(trimmed[1:end])
+			if err != nil {
+				rollbackLock(lock)
+				return fmt.Errorf("%w: %s:%d", err, file, i+1)
+			}
+			inSection = s == section && sub == subsection
+			if inSection {
+				sectionEnd = i
+			}
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		sectionEnd = i
+		k, _, _ := strings.Cut(trimmed, "=")
+		if strings.ToLower(strings.TrimSpace(k)) == name {
+			keyLine = i
+		}
+	}
+
+	switch {
+	case keyLine != -1:
+		lines[keyLine] = setting
+	case sectionEnd != -1:
+		lines = append(lines[:sectionEnd+1], append([]string{setting}, lines[sectionEnd+1:]...)...)
+	default:
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			lines[len(lines)-1] += "\n"
+		}
+		header := "[" + section + "]\n"
+		if subsection != "" {
+			header = fmt.Sprintf("[%s \"%s\"]\n", section, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection))
+		}
+		lines = append(lines, header, setting)
+	}
+	if _, err := io.WriteString(lock, strings.Join(lines, "")); err != nil {
+		rollbackLock(lock)
+		return err
+	}
+	return commitLock(lock)
+}
+
+// quoteConfigValue escapes value so that parseConfigValue reads it back
+func quoteConfigValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\b", `\b`).Replace(value)
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+func (c *Config) readFile(file string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%w: include depth exceeded at %s", InvalidConfig, file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	section, subsection := "", ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		// values may continue on the next line with a trailing backslash
+		for strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") && scanner.Scan() {
+			line = line[:len(line)-1] + scanner.Text()
+			lineNo++
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.LastIndex(line, "]")
+			if end == -1 {
+				return fmt.Errorf("%w: %s:%d", InvalidConfig, file, lineNo)
+			}
+			section, subsection, err = parseSectionHeader(line[1:end])
+			if err != nil {
+				return fmt.Errorf("%w: %s:%d", err, file, lineNo)
+			}
+			// a key may follow the header on the same line
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			return fmt.Errorf("%w: %s:%d key outside of a section", InvalidConfig, file, lineNo)
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if found {
+			value, err = parseConfigValue(value)
+			if err != nil {
+				return fmt.Errorf("%w: %s:%d", err, file, lineNo)
+			}
+		} else {
+			value = "true" // a bare key is a boolean set to true
+		}
+		c.entries = append(c.entries, configEntry{section, subsection, key, value})
+
+		if key == "path" && c.shouldInclude(section, subsection, file) {
+			include := expandHome(value)
+			if !path.IsAbs(include) {
+				include = path.Join(path.Dir(file), include)
+			}
+			if err := c.readFile(include, depth+1); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func parseSectionHeader(header string) (string, string, error) {
+	name, rest, found := strings.Cut(header, " ")
+	if !found {
+		// legacy [section.subsection] syntax
+		if section, subsection, ok := strings.Cut(header, "."); ok {
+			return strings.ToLower(section), strings.ToLower(subsection), nil
+		}
+		return strings.ToLower(header), "", nil
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' {
+		return "", "", InvalidConfig
+	}
+	subsection := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(rest[1 : len(rest)-1])
+	return strings.ToLower(name), subsection, nil
+}
+
+func parseConfigValue(raw string) (string, error) {
+	value := strings.Builder{}
+	inQuotes := false
+	pendingSpace := ""
+	raw = strings.TrimSpace(raw)
+	for i := 0; i < len(raw); i++ {
+		ch := raw[i]
+		switch {
+		case ch == '"':
+			inQuotes = !inQuotes
+		case ch == '\\':
+			i++
+			if i == len(raw) {
+				return "", InvalidConfig
+			}
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			switch raw[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '"', '\\':
+				value.WriteByte(raw[i])
+			default:
+				return "", InvalidConfig
+			}
+		case !inQuotes && (ch == '#' || ch == ';'):
+			return value.String(), nil
+		case !inQuotes && (ch == ' ' || ch == '\t'):
+			// only keep inner whitespace, trailing whitespace is dropped
+			pendingSpace += string(ch)
+		default:
+			value.WriteString(pendingSpace)
+			pendingSpace = ""
+			value.WriteByte(ch)
+		}
+	}
+	if inQuotes {
+		return "", InvalidConfig
+	}
+	return value.String(), nil
+}
+
+// https://git-scm.com/docs/git-config#_includes
+func (c *Config) shouldInclude(section, subsection, file string) bool {
+	switch section {
+	case "include":
+		return subsection == ""
+	case "includeif":
+		pattern, caseless := "", false
+		if p, ok := strings.CutPrefix(subsection, "gitdir:"); ok {
+			pattern = p
+		} else if p, ok := strings.CutPrefix(subsection, "gitdir/i:"); ok {
+			pattern, caseless = p, true
+		} else {
+			// onbranch: and hasconfig: are not supported
+			return false
+		}
+		return matchGitDir(pattern, c.gitDir, path.Dir(file), caseless)
+	}
+	return false
+}
+
+func matchGitDir(pattern, gitDir, baseDir string, caseless bool) bool {
+	pattern = expandHome(pattern)
+	if strings.HasPrefix(pattern, "./") {
+		pattern = path.Join(baseDir, pattern[2:])
+	} else if !path.IsAbs(pattern) {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if caseless {
+		pattern, gitDir = strings.ToLower(pattern), strings.ToLower(gitDir)
+	}
+	// like git, a pattern which cannot be matched matches nothing
+	re, err := globToRegexp(pattern)
+	return err == nil && re.MatchString(gitDir)
+}
+
+// globToRegexp supports the wildmatch subset used by includeIf and sparse
+// checkout patterns: "*", "?", "**", bracket expressions and backslash
+// escapes. It fails on a bracket expression the regexp package rejects, like
+// the reversed range "[z-a]".
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					expr.WriteString("(.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			// "[!...]" negates like "[^...]", an unclosed bracket is literal
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == 0 && i+2 < len(pattern) {
+				end = strings.IndexByte(pattern[i+2:], ']') + 1 // "[]...]" starts with a literal ]
+			}
+			if end <= 0 {
+				expr.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			i += 1 + end
+			negate := class[0] == '!' || class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			expr.WriteByte('[')
+			if negate {
+				expr.WriteString("^/") // never matches a slash
+			}
+			expr.WriteString(strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(class))
+			expr.WriteByte(']')
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			// the bytes of a multibyte character are copied one by one
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return path.Join(home, p[1:])
+		}
+	}
+	return p
+}
@@ -0,0 +1,119 @@
+package mygit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigInclude(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		".git/config":          "[user]\n\tname = repo\n[include]\n\tpath = extra.inc\n[core]\n\tbare = false\n",
+		".git/extra.inc":       "[user]\n\temail = extra@example.com\n\tname = included\n[include]\n\tpath = nested/more.inc\n",
+		".git/nested/more.inc": "[core]\n\tbare = true\n\tautocrlf = input\n",
+	})
+
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an included file counts where the include is, later values win
+	for key, want := range map[string]string{
+		"user.name":     "included",
+		"user.email":    "extra@example.com",
+		"core.autocrlf": "input", // relative to the including file
+		"core.bare":     "false",
+	} {
+		if got, _ := cfg.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestConfigIncludeMissingAndLoop(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		".git/config": "[include]\n\tpath = missing.inc\n[user]\n\tname = repo\n",
+	})
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		t.Fatalf("missing include: %v", err)
+	}
+	if got, _ := cfg.Get("user.name"); got != "repo" {
+		t.Errorf("user.name = %q, want repo", got)
+	}
+
+	writeFiles(t, map[string]string{
+		".git/config": "[include]\n\tpath = config\n",
+	})
+	if _, err := LoadConfig(gitDir); err == nil {
+		t.Error("an include loop was read without error")
+	}
+}
+
+func TestConfigIncludeIf(t *testing.T) {
+	dir := testRepository(t)
+	absGitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := os.Getenv("HOME")
+
+	tests := []struct {
+		condition string
+		included  bool
+	}{
+		{"gitdir:" + absGitDir, true},
+		{"gitdir:" + dir + "/", true},
+		{"gitdir:" + filepath.Dir(dir) + "/", true},
+		{"gitdir:" + filepath.Dir(dir) + "/*/.git", true},
+		{"gitdir:" + dir + "/other/", false},
+		{"gitdir:" + filepath.Base(dir) + "/.git", true}, // matches at any depth
+		{"gitdir:./", true},                              // relative to the config file, .git itself
+		{"gitdir:~/", false},
+		{"gitdir/i:" + strings.ToUpper(absGitDir), true},
+		{"gitdir:" + strings.ToUpper(absGitDir), false},
+		{"onbranch:main", false},
+		{"gitdir:[z-a]/", false}, // an invalid range matches nothing
+	}
+	for _, test := range tests {
+		writeFiles(t, map[string]string{
+			".git/config":      "[user]\n\tname = repo\n[includeIf \"" + test.condition + "\"]\n\tpath = " + home + "/cond.inc\n",
+			home + "/cond.inc": "[user]\n\tname = conditional\n",
+		})
+		cfg, err := LoadConfig(gitDir)
+		if err != nil {
+			t.Fatalf("%s: %v", test.condition, err)
+		}
+		got, _ := cfg.Get("user.name")
+		if included := got == "conditional"; included != test.included {
+			t.Errorf("%s: included %v, want %v", test.condition, included, test.included)
+		}
+	}
+}
+
+func TestMatchGitDir(t *testing.T) {
+	tests := []struct {
+		pattern, gitDir string
+		caseless        bool
+		match           bool
+	}{
+		{"/home/é/x", "/home/é/x", false, true},
+		{"/home/é/", "/home/é/work/.git", false, true},
+		{"/home/?/x", "/home/a/x", false, true},
+		{"/srv/[a-c]*/", "/srv/beta/.git", false, true},
+		{"/srv/[!a-c]*/", "/srv/beta/.git", false, false},
+		{"/Work/", "/work/repo/.git", false, false},
+		{"/Work/", "/work/repo/.git", true, true},
+		{`/a\*b`, "/a*b", false, true},
+		{`/a\*b`, "/axb", false, false},
+		{"/srv/[z-a]/", "/srv/b/.git", false, false},
+	}
+	for _, test := range tests {
+		if got := matchGitDir(test.pattern, test.gitDir, "/", test.caseless); got != test.match {
+			t.Errorf("matchGitDir(%q, %q) = %v, want %v", test.pattern, test.gitDir, got, test.match)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	InvalidDate = errors.New("Date cannot be parsed.")
+)
+
+// "<seconds> <zone>", git's internal format, optionally with an @
+var rawDate = regexp.MustCompile(`^@?(\d+)(?: ([+-]\d{4}))?$`)
+
+// the formats git accepts besides the raw one, those without a zone are in
+// the local time zone
+var dateLayouts = []string{
+	time.RFC1123Z,                   // Thu, 07 Apr 2005 22:13:13 +0200
+	"2 Jan 2006 15:04:05 -0700",     // RFC 2822 without the weekday
+	"2006-01-02T15:04:05-0700",      // ISO 8601
+	"2006-01-02 15:04:05 -0700",     // git log --date=iso
+	time.RFC3339,                    // 2005-04-07T22:13:13+02:00
+	"2006-01-02T15:04:05",           // local time
+	"2006-01-02 15:04:05",           // local time
+	"Mon Jan 2 15:04:05 2006 -0700", // git log --date=default
+}
+
+// ParseGitDate reads a date like GIT_AUTHOR_DATE does. The zone it was
+// written in is kept, a raw date without one is in UTC.
+func ParseGitDate(date string) (time.Time, error) {
+	if match := rawDate.FindStringSubmatch(date); match != nil {
+		seconds, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %q", InvalidDate, date)
+		}
+		offset := 0
+		if match[2] != "" {
+			if offset, err = parseZone(match[2]); err != nil {
+				return time.Time{}, fmt.Errorf("%w: %q", InvalidDate, date)
+			}
+		}
+		return time.Unix(seconds, 0).In(time.FixedZone("", offset)), nil
+	}
+	for _, layout := range dateLayouts {
+		if when, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", InvalidDate, date)
+}
+
+// parseZone turns "+0530" into its offset in seconds east of UTC
+func parseZone(zone string) (int, error) {
+	hhmm, err := strconv.Atoi(zone)
+	if err != nil || len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+		return 0, InvalidDate
+	}
+	return (hhmm/100)*3600 + (hhmm%100)*60, nil
+}
+
+// formatZone writes the offset of when as git does, "+0530" or "-0100",
+// seconds are dropped
+func formatZone(when time.Time) string {
+	_, offset := when.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+}
@@ -0,0 +1,212 @@
+package mygit
+
+// https://git-scm.com/docs/pack-format#_deltified_representation
+
+import (
+	"cmp"
+	"encoding/binary"
+	"path"
+	"slices"
+)
+
+// deltaBlock is the length of the chunks of a base looked for in the
+// objects deltified against it, the same as git's
+const deltaBlock = 16
+
+// the most a single copy instruction copies, written as a size of zero
+const maxDeltaCopy = 0x10000
+
+// deltaIndex is a hash table of the chunks of a base starting at multiples
+// of deltaBlock, each slot holds the offset of the first chunk hashed to it
+// plus one. A chunk losing its slot to another is not found, like git which
+// caps its buckets too.
+type deltaIndex struct {
+	base  []byte
+	slots []int32
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	size := 1
+	for size < 2*len(base)/deltaBlock {
+		size <<= 1
+	}
+	idx := &deltaIndex{base: base, slots: make([]int32, size)}
+	for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+		lo, hi := binary.LittleEndian.Uint64(base[i:]), binary.LittleEndian.Uint64(base[i+8:])
+		if slot := &idx.slots[idx.slot(lo, hi)]; *slot == 0 {
+			*slot = int32(i + 1)
+		}
+	}
+	return idx
+}
+
+// slot hashes the chunk at the start of data, given as its two words
+func (idx *deltaIndex) slot(lo, hi uint64) int {
+	h := lo*0x9e3779b97f4a7c15 ^ hi*0xc2b2ae3d27d4eb4f
+	return int((h ^ h>>32) & uint64(len(idx.slots)-1))
+}
+
+// find returns where the chunk at the start of data is in the base
+func (idx *deltaIndex) find(data []byte) (int, bool) {
+	lo, hi := binary.LittleEndian.Uint64(data), binary.LittleEndian.Uint64(data[8:])
+	offset := int(idx.slots[idx.slot(lo, hi)]) - 1
+	if offset < 0 || binary.LittleEndian.Uint64(idx.base[offset:]) != lo || binary.LittleEndian.Uint64(idx.base[offset+8:]) != hi {
+		return 0, false
+	}
+	return offset, true
+}
+
+// computeDelta returns the instructions applyDelta turns the base into
+// target with, copies of the chunks found in the base grown as far as they
+// match and adds of the rest. It gives up with nil once they are longer than
+// maxSize.
+func (idx *deltaIndex) computeDelta(target []byte, maxSize int) []byte {
+	delta := binary.AppendUvarint(nil, uint64(len(idx.base)))
+	delta = binary.AppendUvarint(delta, uint64(len(target)))
+	added := 0 // target up to there is in the delta
+	add := func(end int) {
+		for added < end {
+			n := min(end-added, 0x7f)
+			delta = append(append(delta, byte(n)), target[added:added+n]...)
+			added += n
+		}
+	}
+
+	// bytes waiting to be added count already, dissimilar objects give up early
+	for i := 0; i+deltaBlock <= len(target) && len(delta)+i-added <= maxSize; {
+		offset, ok := idx.find(target[i:])
+		if !ok {
+			i++
+			continue
+		}
+		// the match may start before the chunk, back to what is not added yet
+		start := i
+		for start > added && offset > 0 && target[start-1] == idx.base[offset-1] {
+			start, offset = start-1, offset-1
+		}
+		end := i + deltaBlock
+		for end < len(target) && offset+end-start < len(idx.base) && target[end] == idx.base[offset+end-start] {
+			end++
+		}
+
+		add(start)
+		for start < end {
+			n := min(end-start, maxDeltaCopy)
+			delta = appendDeltaCopy(delta, offset, n)
+			start, offset = start+n, offset+n
+		}
+		added, i = end, end
+	}
+	add(len(target))
+	if len(delta) > maxSize {
+		return nil
+	}
+	return delta
+}
+
+// appendDeltaCopy writes a copy instruction, only the non zero bytes of the
+// offset and size follow it
+func appendDeltaCopy(delta []byte, offset, size int) []byte {
+	op, args := byte(0x80), []byte{}
+	for i := range 4 {
+		if b := byte(offset >> (i * 8)); b != 0 {
+			op |= 1 << i
+			args = append(args, b)
+		}
+	}
+	for i := range 3 {
+		if b := byte(size >> (i * 8)); b != 0 && size != maxDeltaCopy {
+			op |= 0x10 << i
+			args = append(args, b)
+		}
+	}
+	return append(append(delta, op), args...)
+}
+
+// PackOptions tell writePack whether to store objects as deltas of others.
+// Without a window every object is stored whole.
+type PackOptions struct {
+	Window int               // how many of the objects before one are tried as its base
+	Depth  int               // the longest chain of deltas an object is rebuilt through
+	Paths  map[string]string // where objects were found, to try versions of a file together
+}
+
+// packDelta is an object stored as the delta against base
+type packDelta struct {
+	base string
+	data []byte
+}
+
+// findDeltas picks the objects worth storing as deltas. Like git, objects
+// are sorted by type, file name and decreasing size so that versions of a
+// file sit next to each other, the biggest first, then each is compared with
+// the Window ones before it and the smallest delta is kept. A delta must be
+// less than half the object, and its base no more than Depth-1 deltas deep.
+func findDeltas(shas []string, opts PackOptions) (map[string]packDelta, error) {
+	deltas := map[string]packDelta{}
+	if opts.Window <= 0 || opts.Depth <= 0 {
+		return deltas, nil
+	}
+
+	type candidate struct {
+		sha  string
+		kind ObjectKind
+		size int64
+	}
+	candidates := []candidate{}
+	for _, sha := range shas {
+		kind, size, err := ObjectInfo(sha)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate{sha, kind, size})
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		pathA, pathB := opts.Paths[a.sha], opts.Paths[b.sha]
+		return cmp.Or(cmp.Compare(a.kind, b.kind), cmp.Compare(path.Base(pathA), path.Base(pathB)),
+			cmp.Compare(pathA, pathB), cmp.Compare(b.size, a.size), cmp.Compare(a.sha, b.sha))
+	})
+
+	type windowEntry struct {
+		sha     string
+		content []byte
+		index   *deltaIndex // built when first tried
+		depth   int
+	}
+	window := []*windowEntry{}
+	for i, c := range candidates {
+		if i > 0 && candidates[i-1].kind != c.kind {
+			window = window[:0]
+		}
+		_, content, err := readObject(c.sha)
+		if err != nil {
+			return nil, err
+		}
+
+		target := &windowEntry{sha: c.sha, content: content}
+		var best []byte
+		for j := len(window) - 1; j >= 0; j-- {
+			base := window[j]
+			maxSize := len(content)/2 - 20
+			if best != nil {
+				maxSize = len(best) - 1
+			}
+			if base.depth >= opts.Depth || max(len(content)-len(base.content), 0) >= maxSize {
+				continue
+			}
+			if base.index == nil {
+				base.index = newDeltaIndex(base.content)
+			}
+			if delta := base.index.computeDelta(content, maxSize); delta != nil {
+				best, target.depth = delta, base.depth+1
+				deltas[c.sha] = packDelta{base: base.sha, data: delta}
+			}
+		}
+
+		window = append(window, target)
+		if len(window) > opts.Window {
+			window = window[1:]
+		}
+	}
+	return deltas, nil
+}
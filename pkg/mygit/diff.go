@@ -0,0 +1,428 @@
+package mygit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type EditKind byte
+
+const (
+	EditEqual  EditKind = ' '
+	EditDelete EditKind = '-'
+	EditInsert EditKind = '+'
+)
+
+// Edit is one line of a diff, Old and New index the line in each side, -1
+// for the side it is missing from
+type Edit struct {
+	Kind EditKind
+	Old  int
+	New  int
+}
+
+// Whitespace tells which whitespace differences lines may have and still be
+// the same
+type Whitespace byte
+
+const (
+	WhitespaceExact   Whitespace = iota
+	IgnoreSpaceChange            // runs of whitespace are alike, trailing whitespace is ignored
+	IgnoreAllSpace               // whitespace is ignored altogether
+)
+
+// normalize rewrites the lines so that the ones to be the same are equal
+func (ws Whitespace) normalize(lines []string) []string {
+	if ws == WhitespaceExact {
+		return lines
+	}
+	normalized := make([]string, len(lines))
+	for i, line := range lines {
+		fields := strings.FieldsFunc(line, isSpace)
+		if ws == IgnoreAllSpace {
+			normalized[i] = strings.Join(fields, "")
+			continue
+		}
+		normalized[i] = strings.Join(fields, " ")
+		if len(line) > 0 && isSpace(rune(line[0])) && len(fields) > 0 {
+			normalized[i] = " " + normalized[i]
+		}
+	}
+	return normalized
+}
+
+// isSpace is C's isspace, what git counts as whitespace
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\v' || r == '\f' || r == '\r'
+}
+
+// splitLines cuts data after each newline, the last line may lack one
+func splitLines(data []byte) []string {
+	lines := []string{}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// isBinary uses git's heuristic, a NUL in the first 8000 bytes
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1
+}
+
+// DiffLines returns the shortest edit script turning a into b, computed with
+// Myers' algorithm after trimming the common prefix and suffix
+func DiffLines(a, b []string) []Edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := []Edit{}
+	for i := range prefix {
+		edits = append(edits, Edit{EditEqual, i, i})
+	}
+	for _, e := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		if e.Old != -1 {
+			e.Old += prefix
+		}
+		if e.New != -1 {
+			e.New += prefix
+		}
+		edits = append(edits, e)
+	}
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, Edit{EditEqual, len(a) - i, len(b) - i})
+	}
+	return compactEdits(a, b, edits)
+}
+
+// compactEdits moves ambiguous groups of changes where git's xdiff puts them,
+// as far down as they go unless that lines them up with changes on the other
+// side, so hunks come out the same as git's
+func compactEdits(a, b []string, edits []Edit) []Edit {
+	// changed lines of each side, with an unchanged sentinel at both ends
+	changedA, changedB := make([]bool, len(a)+2), make([]bool, len(b)+2)
+	for _, e := range edits {
+		switch e.Kind {
+		case EditDelete:
+			changedA[e.Old+1] = true
+		case EditInsert:
+			changedB[e.New+1] = true
+		}
+	}
+	compactChanges(a, changedA, changedB)
+	compactChanges(b, changedB, changedA)
+
+	// xdiff lists the deletions of a group before its insertions
+	compacted := make([]Edit, 0, len(edits))
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && changedA[i+1]:
+			compacted = append(compacted, Edit{EditDelete, i, -1})
+			i++
+		case j < len(b) && changedB[j+1]:
+			compacted = append(compacted, Edit{EditInsert, -1, j})
+			j++
+		default:
+			compacted = append(compacted, Edit{EditEqual, i, j})
+			i, j = i+1, j+1
+		}
+	}
+	return compacted
+}
+
+// changeGroup is a run of changed lines [start, end) of one side, it is empty
+// between two unchanged lines
+type changeGroup struct {
+	start, end int
+}
+
+// compactChanges slides the groups of changed lines of recs, other is the
+// other side whose groups match them one to one.
+// https://github.com/git/git/blob/master/xdiff/xdiffi.c xdl_change_compact
+func compactChanges(recs []string, changed, other []bool) {
+	// changed[i+1] is line i
+	isChanged := func(flags []bool, i int) bool { return flags[i+1] }
+	set := func(flags []bool, i int, v bool) { flags[i+1] = v }
+	n, m := len(recs), len(other)-2
+
+	first := func(flags []bool) changeGroup {
+		g := changeGroup{}
+		for isChanged(flags, g.end) {
+			g.end++
+		}
+		return g
+	}
+	next := func(flags []bool, g *changeGroup, size int) bool {
+		if g.end == size {
+			return false
+		}
+		g.start = g.end + 1
+		g.end = g.start
+		for isChanged(flags, g.end) {
+			g.end++
+		}
+		return true
+	}
+	previous := func(flags []bool, g *changeGroup) bool {
+		if g.start == 0 {
+			return false
+		}
+		g.end = g.start - 1
+		g.start = g.end
+		for isChanged(flags, g.start-1) {
+			g.start--
+		}
+		return true
+	}
+	slideUp := func(g *changeGroup) bool {
+		if g.start == 0 || recs[g.start-1] != recs[g.end-1] {
+			return false
+		}
+		g.start, g.end = g.start-1, g.end-1
+		set(changed, g.start, true)
+		set(changed, g.end, false)
+		for isChanged(changed, g.start-1) {
+			g.start--
+		}
+		return true
+	}
+	slideDown := func(g *changeGroup) bool {
+		if g.end == n || recs[g.start] != recs[g.end] {
+			return false
+		}
+		set(changed, g.start, false)
+		set(changed, g.end, true)
+		g.start, g.end = g.start+1, g.end+1
+		for isChanged(changed, g.end) {
+			g.end++
+		}
+		return true
+	}
+
+	g, og := first(changed), first(other)
+	for {
+		if g.end != g.start {
+			var earliestEnd, endMatchingOther int
+			for size := -1; size != g.end-g.start; {
+				size = g.end - g.start
+				endMatchingOther = -1
+
+				// sliding may merge the group with its neighbours, then
+				// it is tried again with its new size
+				for slideUp(&g) {
+					previous(other, &og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for slideDown(&g) {
+					next(other, &og, m)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+			}
+
+			if g.end != earliestEnd && endMatchingOther != -1 {
+				for og.end == og.start {
+					slideUp(&g)
+					previous(other, &og)
+				}
+			}
+		}
+		if !next(changed, &g, n) {
+			return
+		}
+		next(other, &og, m)
+	}
+}
+
+// http://www.xmailserver.org/diff2.pdf
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	limit := n + m
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+
+	// each round only keeps the diagonals it can reach, [-d-1, d+1]
+	trace := [][]int{}
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int{}, v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down, an insertion
+			} else {
+				x = v[offset+k-1] + 1 // right, a deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, x, y int) []Edit {
+	edits := []Edit{}
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			edits = append(edits, Edit{EditEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{EditInsert, -1, y - 1})
+			} else {
+				edits = append(edits, Edit{EditDelete, x - 1, -1})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// WriteUnifiedDiff writes the hunks turning a into b with context lines of
+// context around each change, changes closer than twice the context share a
+// hunk like in git. Lines differing only in the whitespace ws ignores are
+// unchanged, context lines are shown as they are in b.
+func WriteUnifiedDiff(w io.Writer, a, b []string, context int, ws Whitespace) {
+	edits := DiffLines(ws.normalize(a), ws.normalize(b))
+
+	// the hunks, as ranges of edits
+	type span struct{ start, end int }
+	hunks := []span{}
+	for i := 0; i < len(edits); {
+		if edits[i].Kind == EditEqual {
+			i++
+			continue
+		}
+		end := i
+		for end < len(edits) && edits[end].Kind != EditEqual {
+			end++
+		}
+		start := max(i-context, 0)
+		if n := len(hunks); n > 0 && start <= hunks[n-1].end {
+			hunks[n-1].end = min(end+context, len(edits))
+		} else {
+			hunks = append(hunks, span{start, min(end+context, len(edits))})
+		}
+		i = end
+	}
+
+	for _, hunk := range hunks {
+		oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+		for _, e := range edits[hunk.start:hunk.end] {
+			if e.Kind != EditInsert {
+				if oldCount == 0 {
+					oldStart = e.Old + 1
+				}
+				oldCount++
+			}
+			if e.Kind != EditDelete {
+				if newCount == 0 {
+					newStart = e.New + 1
+				}
+				newCount++
+			}
+		}
+		// an empty side starts at the line before the hunk
+		if oldCount == 0 {
+			oldStart = editsBefore(edits[:hunk.start], EditInsert)
+		}
+		if newCount == 0 {
+			newStart = editsBefore(edits[:hunk.start], EditDelete)
+		}
+
+		header := fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		if fn := functionContext(a, oldStart-1); fn != "" {
+			header += " " + fn
+		}
+		fmt.Fprintln(w, header)
+		for _, e := range edits[hunk.start:hunk.end] {
+			line := ""
+			if e.Kind == EditDelete {
+				line = a[e.Old]
+			} else {
+				line = b[e.New]
+			}
+			fmt.Fprintf(w, "%c%s", e.Kind, line)
+			if !strings.HasSuffix(line, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+	}
+}
+
+// editsBefore counts the lines of one side among edits, the side skip is
+// missing from
+func editsBefore(edits []Edit, skip EditKind) int {
+	count := 0
+	for _, e := range edits {
+		if e.Kind != skip {
+			count++
+		}
+	}
+	return count
+}
+
+// hunkRange formats "start,count", git leaves out a count of one
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// functionContext finds the line before line that looks like the start of a
+// function, git's default being any line starting with a letter, _ or $
+func functionContext(lines []string, line int) string {
+	for i := min(line, len(lines)) - 1; i >= 0; i-- {
+		text := lines[i]
+		if text == "" {
+			continue
+		}
+		if c := text[0]; c == '_' || c == '$' || (c|0x20 >= 'a' && c|0x20 <= 'z') {
+			text = strings.TrimRight(text, " \t\r\n")
+			if len(text) > 80 {
+				text = text[:80]
+			}
+			return strings.TrimRight(text, " \t")
+		}
+	}
+	return ""
+}
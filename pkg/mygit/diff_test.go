@@ -0,0 +1,90 @@
+package mygit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stagedChanges commits a few files with git then stages a change with
+// another on top of it and a new file, and leaves a deletion and a mode
+// change unstaged
+func stagedChanges(t *testing.T) {
+	t.Helper()
+	var numbers strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintln(&numbers, i)
+	}
+	writeFiles(t, map[string]string{"numbers": numbers.String(), "dir/x": "x\n", "gone": "gone\n"})
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-q", "-m", "numbers")
+
+	staged := strings.Replace(numbers.String(), "\n5\n", "\nfive\n", 1)
+	writeFiles(t, map[string]string{"numbers": staged})
+	runGit(t, "add", "numbers")
+	writeFiles(t, map[string]string{"numbers": strings.Replace(staged, "\n15\n", "\nfifteen\n", 1), "dir/new": "new\n"})
+	runGit(t, "add", "dir/new")
+	if err := os.Remove("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod("dir/x", 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffMatchesGit(t *testing.T) {
+	testRepository(t)
+	stagedChanges(t)
+	matchGit(t, "diff")
+	matchGit(t, "diff", "--", "dir")
+	// the change made on top of the staged one is left out
+	output := matchGit(t, "diff", "--cached")
+	if strings.Contains(output, "fifteen") || !strings.Contains(output, "+five\n") {
+		t.Errorf("diff --cached printed\n%s", output)
+	}
+}
+
+func TestWhitespaceMatchesGit(t *testing.T) {
+	testRepository(t)
+	stagedChanges(t)
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "staged")
+
+	// a change of indentation, trailing spaces and a real change
+	numbers, err := os.ReadFile("numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.NewReplacer("\n2\n", "\n  2  \n", "\n3\n", "\n3 \t\n", "\n7\n", "\nseven\n").Replace(string(numbers))
+	writeFiles(t, map[string]string{"numbers": edited, "dir/x": "x \n"})
+	for _, args := range [][]string{{"-w"}, {"-b"}, {"--ignore-all-space"}, {}} {
+		matchGit(t, append([]string{"diff"}, args...)...)
+	}
+	// files changed only by whitespace are left out
+	if output, _ := runCommand(t, "diff", "-w"); strings.Contains(output, "dir/x") {
+		t.Errorf("diff -w printed\n%s", output)
+	}
+
+	// apply fixing or refusing the added trailing spaces
+	patch := filepath.Join(t.TempDir(), "whitespace.patch")
+	if err := os.WriteFile(patch, gitOutput(t, nil, "diff"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "checkout", "-q", ".")
+	for _, action := range []string{"fix", "error"} {
+		_, status := gitStatus(t, nil, "apply", "--whitespace="+action, patch)
+		want := runGit(t, "diff")
+		runGit(t, "checkout", "-q", ".")
+
+		_, err := runCommand(t, "apply", "--whitespace="+action, patch)
+		if got := runGit(t, "diff"); got != want {
+			t.Errorf("apply --whitespace=%s left\n%s\ngit left\n%s", action, got, want)
+		}
+		if (err == nil) != (status == 0) {
+			t.Errorf("apply --whitespace=%s: %v, git exited with %d", action, err, status)
+		}
+		runGit(t, "checkout", "-q", ".")
+	}
+}
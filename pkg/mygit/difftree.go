@@ -0,0 +1,386 @@
+package mygit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TreeChange is a path that differs between two trees, the side it is
+// missing from has an empty entry
+type TreeChange struct {
+	Path   string
+	Old    entry
+	New    entry
+	Status byte // A, D, M or T for a change of type
+}
+
+// DiffTrees compares the trees at a and b, either may be empty for a tree
+// with no entries. Unless recursive, subtrees are compared as entries like
+// any other, changes come in the order git lists them.
+func DiffTrees(a, b string, recursive bool) ([]TreeChange, error) {
+	changes := []TreeChange{}
+	var walk func(a, b, prefix string) error
+	walk = func(a, b, prefix string) error {
+		oldEntries, err := treeEntries(a)
+		if err != nil {
+			return err
+		}
+		newEntries, err := treeEntries(b)
+		if err != nil {
+			return err
+		}
+
+		// both lists are in git order, a dir sorting as if it ended with a slash
+		key := func(e entry) string {
+			if e.kind == TreeKind {
+				return e.name + "/"
+			}
+			return e.name
+		}
+		for len(oldEntries) > 0 || len(newEntries) > 0 {
+			var old, new entry
+			switch {
+			case len(newEntries) == 0 || (len(oldEntries) > 0 && key(oldEntries[0]) < key(newEntries[0])):
+				old, oldEntries = oldEntries[0], oldEntries[1:]
+			case len(oldEntries) == 0 || key(newEntries[0]) < key(oldEntries[0]):
+				new, newEntries = newEntries[0], newEntries[1:]
+			default:
+				old, new = oldEntries[0], newEntries[0]
+				oldEntries, newEntries = oldEntries[1:], newEntries[1:]
+				if old.mode == new.mode && old.hash == new.hash {
+					continue
+				}
+			}
+
+			name := path.Join(prefix, old.name+new.name)
+			if old.name != "" && new.name != "" {
+				name = path.Join(prefix, old.name)
+			}
+			if recursive && (old.kind == TreeKind || new.kind == TreeKind) {
+				if err := walk(old.hash, new.hash, name); err != nil {
+					return err
+				}
+				continue
+			}
+
+			change := TreeChange{Path: name, Old: old, New: new, Status: 'M'}
+			switch {
+			case old.name == "":
+				change.Status = 'A'
+			case new.name == "":
+				change.Status = 'D'
+			case fileType(old.mode) != fileType(new.mode):
+				change.Status = 'T'
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	}
+	return changes, walk(a, b, "")
+}
+
+// DiffWorktree compares the files under dir with the tree-ish at sha, New
+// being the worktree side, without writing any object. Files the sparse
+// patterns leave out of the worktree are not expected there, a submodule is
+// only expected to be a directory. Changes are sorted by path.
+func DiffWorktree(ctx context.Context, dir, sha string, sparse *SparsePatterns) ([]TreeChange, error) {
+	expected, err := FlattenTree(sha)
+	if err != nil {
+		return nil, err
+	}
+	changes := []TreeChange{}
+	seen := map[string]bool{}
+	err = fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if d.IsDir() {
+			if e, ok := expected[name]; ok && e.kind == CommitKind {
+				seen[name] = true
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		worktree, err := NewIndexEntry(path.Join(dir, name), false)
+		if err != nil {
+			return err
+		}
+		actual := entry{mode: strconv.FormatUint(uint64(worktree.Mode), 8), kind: BlobKind, hash: worktree.Hash, name: name}
+		old, tracked := expected[name]
+		seen[name] = true
+		change := TreeChange{Path: name, Old: old, New: actual, Status: 'M'}
+		switch {
+		case !tracked:
+			change.Status = 'A'
+		case old.mode == actual.mode && old.hash == actual.hash:
+			return nil
+		case fileType(old.mode) != fileType(actual.mode):
+			change.Status = 'T'
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, e := range expected {
+		if !seen[name] && sparse.Includes(name) {
+			changes = append(changes, TreeChange{Path: name, Old: e, Status: 'D'})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// DiffIndex compares the tree-ish at sha, empty for no tree, with the staged
+// files like git diff --cached. Files added with add -N are not staged yet
+// and unmerged paths are left out. Changes are sorted by path.
+func DiffIndex(sha string, idx *Index) ([]TreeChange, error) {
+	expected := map[string]entry{}
+	if sha != "" {
+		var err error
+		if expected, err = FlattenTree(sha); err != nil {
+			return nil, err
+		}
+	}
+	changes := []TreeChange{}
+	for _, e := range idx.Entries {
+		// git only tells that an unmerged path is unmerged
+		if e.Stage() != 0 {
+			delete(expected, e.Path)
+			continue
+		}
+		if e.ExtendedFlags&IndexIntentToAdd != 0 {
+			continue
+		}
+		staged := e.entry()
+		old, tracked := expected[e.Path]
+		delete(expected, e.Path)
+		if change, ok := compareEntries(e.Path, old, staged, tracked); ok {
+			changes = append(changes, change)
+		}
+	}
+	for name, e := range expected {
+		changes = append(changes, TreeChange{Path: name, Old: e, Status: 'D'})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// DiffIndexWorktree compares the staged files with those under dir like git
+// diff, New being the worktree side named by its path, without writing any
+// object. Untracked files and those the sparse checkout leaves out are not
+// looked at, nor unmerged paths, a file added with add -N is new.
+func DiffIndexWorktree(ctx context.Context, dir string, idx *Index) ([]TreeChange, error) {
+	changes := []TreeChange{}
+	for _, e := range idx.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if e.ExtendedFlags&IndexSkipWorktree != 0 || e.Mode == 0o160000 || e.Stage() != 0 {
+			continue
+		}
+		staged, tracked := e.entry(), e.ExtendedFlags&IndexIntentToAdd == 0
+		if !tracked {
+			staged = entry{}
+		}
+		worktree, err := NewIndexEntry(path.Join(dir, e.Path), false)
+		if os.IsNotExist(err) {
+			if tracked {
+				changes = append(changes, TreeChange{Path: e.Path, Old: staged, Status: 'D'})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		worktree.Path = e.Path
+		if change, ok := compareEntries(e.Path, staged, worktree.entry(), tracked); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// compareEntries is the change from old to new at name, none when they are
+// the same. old is absent unless tracked.
+func compareEntries(name string, old, new entry, tracked bool) (TreeChange, bool) {
+	change := TreeChange{Path: name, Old: old, New: new, Status: 'M'}
+	switch {
+	case !tracked:
+		change.Status = 'A'
+	case old.mode == new.mode && old.hash == new.hash:
+		return change, false
+	case fileType(old.mode) != fileType(new.mode):
+		change.Status = 'T'
+	}
+	return change, true
+}
+
+func treeEntries(sha string) ([]entry, error) {
+	if sha == "" {
+		return nil, nil
+	}
+	tree, err := ReadTreeish(sha)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Entries()
+}
+
+// fileType is the type part of a mode, regular file, symlink or directory
+func fileType(mode string) int64 {
+	m, _ := strconv.ParseInt(mode, 8, 64)
+	return m &^ 0o7777
+}
+
+// rawMode pads a mode to 6 digits, an absent side is all zeros
+func rawMode(e entry) string {
+	m, _ := strconv.ParseInt(e.mode, 8, 64)
+	return fmt.Sprintf("%06o", m)
+}
+
+func rawHash(e entry) string {
+	if e.hash == "" {
+		return zeroHash
+	}
+	return e.hash
+}
+
+// WriteRawDiff prints the changes as diff-tree does by default:
+// ":<old mode> <new mode> <old sha> <new sha> <status>\t<path>"
+func WriteRawDiff(w io.Writer, changes []TreeChange, quoteHigh bool) {
+	for _, c := range changes {
+		fmt.Fprintf(w, ":%s %s %s %s %c\t%s\n", rawMode(c.Old), rawMode(c.New), rawHash(c.Old), rawHash(c.New),
+			c.Status, QuotePath(c.Path, quoteHigh))
+	}
+}
+
+// WritePatch prints the changes as git diff patches with 3 lines of context,
+// leaving out the files whose text only changed in the whitespace ws ignores
+func WritePatch(w io.Writer, changes []TreeChange, quoteHigh bool, ws Whitespace) error {
+	return writePatch(w, changes, quoteHigh, ws, readBlob)
+}
+
+// WriteWorktreePatch is WritePatch for changes from DiffIndexWorktree, the
+// content of a file not stored as an object is read from under dir
+func WriteWorktreePatch(w io.Writer, changes []TreeChange, quoteHigh bool, ws Whitespace, dir string) error {
+	return writePatch(w, changes, quoteHigh, ws, func(e entry) ([]byte, error) {
+		if HasObject(e.hash) {
+			return readBlob(e)
+		}
+		file := path.Join(dir, e.name)
+		if e.mode == "120000" {
+			target, err := os.Readlink(file)
+			return []byte(target), err
+		}
+		return os.ReadFile(file)
+	})
+}
+
+func readBlob(e entry) ([]byte, error) {
+	blob, err := ReadGitObject(e.hash)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Content(), nil
+}
+
+func writePatch(w io.Writer, changes []TreeChange, quoteHigh bool, ws Whitespace, read func(entry) ([]byte, error)) error {
+	for _, c := range changes {
+		// a change of type is shown as a deletion followed by an addition
+		if c.Status == 'T' {
+			if err := writeFilePatch(w, TreeChange{Path: c.Path, Old: c.Old, Status: 'D'}, quoteHigh, ws, read); err != nil {
+				return err
+			}
+			c = TreeChange{Path: c.Path, New: c.New, Status: 'A'}
+		}
+		if err := writeFilePatch(w, c, quoteHigh, ws, read); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFilePatch(w io.Writer, c TreeChange, quoteHigh bool, ws Whitespace, read func(entry) ([]byte, error)) error {
+	contents := [2][]byte{}
+	if c.Old.hash != c.New.hash {
+		for i, e := range []entry{c.Old, c.New} {
+			if e.hash == "" {
+				continue
+			}
+			content, err := read(e)
+			if err != nil {
+				return err
+			}
+			contents[i] = content
+		}
+	}
+	binary := isBinary(contents[0]) || isBinary(contents[1])
+	oldLines, newLines := splitLines(contents[0]), splitLines(contents[1])
+	// text the same but for whitespace is left out, only its headers are
+	// shown when its mode changed
+	sameText := !binary && c.Old.hash != c.New.hash && slices.Equal(ws.normalize(oldLines), ws.normalize(newLines))
+	if ws != WhitespaceExact && c.Status == 'M' && c.Old.mode == c.New.mode && sameText {
+		return nil
+	}
+
+	oldName, newName := QuotePath("a/"+c.Path, quoteHigh), QuotePath("b/"+c.Path, quoteHigh)
+	fmt.Fprintf(w, "diff --git %s %s\n", oldName, newName)
+	switch {
+	case c.Status == 'A':
+		fmt.Fprintf(w, "new file mode %s\n", c.New.mode)
+	case c.Status == 'D':
+		fmt.Fprintf(w, "deleted file mode %s\n", c.Old.mode)
+	case c.Old.mode != c.New.mode:
+		fmt.Fprintf(w, "old mode %s\nnew mode %s\n", c.Old.mode, c.New.mode)
+	}
+	if c.Old.hash == c.New.hash {
+		return nil // only the mode changed
+	}
+
+	index := fmt.Sprintf("index %s..%s", rawHash(c.Old)[:7], rawHash(c.New)[:7])
+	if c.Old.mode == c.New.mode {
+		index += " " + c.Old.mode
+	}
+	fmt.Fprintln(w, index)
+
+	if c.Status == 'A' {
+		oldName = "/dev/null"
+	}
+	if c.Status == 'D' {
+		newName = "/dev/null"
+	}
+	if binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return nil
+	}
+	if sameText {
+		return nil
+	}
+	// like git, a tab ends names with spaces so patch does not cut them
+	for _, name := range []*string{&oldName, &newName} {
+		if strings.Contains(*name, " ") {
+			*name += "\t"
+		}
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	WriteUnifiedDiff(w, oldLines, newLines, 3, ws)
+	return nil
+}
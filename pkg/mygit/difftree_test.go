@@ -0,0 +1,112 @@
+package mygit
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// diffTreeHistory commits a file tree then every kind of change to it
+func diffTreeHistory(t *testing.T) (string, string) {
+	t.Helper()
+	writeFiles(t, map[string]string{
+		"modified":        "one\ntwo\nthree\n",
+		"deleted":         "deleted\n",
+		"script":          "echo\n",
+		"dir/kept":        "kept\n",
+		"dir/sub/changed": "before\n",
+		"becomes-dir":     "file\n",
+	})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"modified": "one\n2\nthree\n", "dir/sub/changed": "after\n", "added": "added\n"})
+	for _, name := range []string{"deleted", "becomes-dir"} {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, map[string]string{"becomes-dir/file": "now a directory\n"})
+	if err := os.Chmod("script", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return first, commitWorktree(t, "second")
+}
+
+func TestDiffTrees(t *testing.T) {
+	testRepository(t)
+	first, second := diffTreeHistory(t)
+
+	changes, err := DiffTrees(treeOf(t, first), treeOf(t, second), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, string(c.Status)+" "+c.Path)
+	}
+	want := "A added, D becomes-dir, A becomes-dir/file, D deleted, M dir/sub/changed, M modified, M script"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("the changes are %s, want %s", strings.Join(got, ", "), want)
+	}
+
+	changes, err = DiffTrees(treeOf(t, first), treeOf(t, second), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, c := range changes {
+		got = append(got, string(c.Status)+" "+c.Path)
+	}
+	// a directory is one entry, one replacing a file is a deletion and an
+	// addition like in git
+	if want := "A added, D becomes-dir, A becomes-dir, D deleted, M dir, M modified, M script"; strings.Join(got, ", ") != want {
+		t.Errorf("the top level changes are %s, want %s", strings.Join(got, ", "), want)
+	}
+
+	if changes, err := DiffTrees(treeOf(t, first), treeOf(t, first), true); err != nil || len(changes) != 0 {
+		t.Errorf("a tree differs from itself by %v, %v", changes, err)
+	}
+}
+
+func TestDiffTreeMatchesGit(t *testing.T) {
+	testRepository(t)
+	first, second := diffTreeHistory(t)
+	for _, args := range [][]string{
+		{first, second},
+		{"-r", first, second},
+		{"-r", second, first},
+		{"-p", first, second},
+		{second},
+		{"-r", second},
+		{"--root", "-r", first},
+		{first},
+	} {
+		output, err := runCommand(t, append([]string{"diff-tree"}, args...)...)
+		if err != nil {
+			t.Fatalf("diff-tree %v: %v", args, err)
+		}
+		if want := runGit(t, append([]string{"diff-tree"}, args...)...); strings.TrimSpace(output) != want {
+			t.Errorf("diff-tree %v printed\n%s\ngit printed\n%s", args, output, want)
+		}
+	}
+}
+
+func TestVerifyWorktreeMatchesGit(t *testing.T) {
+	testRepository(t)
+	first, _ := diffTreeHistory(t)
+	if output, err := runCommand(t, "verify-worktree"); err != nil || output != "" {
+		t.Errorf("verify-worktree of the commit checked out printed %q, %v", output, err)
+	}
+
+	writeFiles(t, map[string]string{"extra": "local\n", "dir/kept": "edited\n"})
+	// git compares the worktree once it is all staged
+	for _, rev := range []string{"HEAD", first} {
+		output, err := runCommand(t, "verify-worktree", rev)
+		if err != ExitStatus(1) {
+			t.Errorf("verify-worktree %s: %v", rev, err)
+		}
+		runGit(t, "add", "-A")
+		if want := runGit(t, "diff-index", "--cached", "--name-status", rev); strings.TrimSpace(output) != want {
+			t.Errorf("verify-worktree %s printed\n%s\ngit printed\n%s", rev, output, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package mygit_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/codecrafters-io/git-starter-go/pkg/mygit"
+)
+
+func ExampleClone() {
+	result, err := mygit.Clone(context.Background(), mygit.CloneOptions{
+		URL: "https://github.com/codecrafters-io/git-sample-1",
+		Dir: "sample",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result.Branch, result.Head, result.Objects)
+}
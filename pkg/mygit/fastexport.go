@@ -0,0 +1,224 @@
+package mygit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// https://git-scm.com/docs/git-fast-import#_input_format
+type fastExporter struct {
+	w     *bufio.Writer
+	marks map[string]int // sha -> mark
+}
+
+func (e *fastExporter) mark(sha string) int {
+	mark := len(e.marks) + 1
+	e.marks[sha] = mark
+	return mark
+}
+
+// FastExport writes the history reachable from refs as a fast-import stream.
+// Commits come before their children, each one listing its changes against
+// the first parent, then every ref is reset to its tip. Annotated tags are
+// exported as tag commands, signatures are dropped like git does by default.
+func FastExport(w io.Writer, refs []Ref) error {
+	e := &fastExporter{w: bufio.NewWriter(w), marks: map[string]int{}}
+
+	type exportedTag struct {
+		sha string
+		tag *Tag
+	}
+	tags := []exportedTag{}
+	annotated := map[string]bool{}
+	tips := []Ref{}
+	for _, ref := range refs {
+		// a tag may point to another tag, the chain is exported innermost first
+		chain := []exportedTag{}
+		sha := ref.Hash
+		for {
+			gitObj, err := ReadGitObject(sha)
+			if err != nil {
+				return err
+			}
+			tag, ok := gitObj.(*Tag)
+			if !ok {
+				if gitObj.Kind() != CommitKind {
+					return fmt.Errorf("cannot export %s, it points to a %s", ref.Name, gitObj.Kind())
+				}
+				break
+			}
+			chain = append([]exportedTag{{sha, tag}}, chain...)
+			if sha, err = tag.Object(); err != nil {
+				return err
+			}
+		}
+		tags = append(tags, chain...)
+		annotated[ref.Name] = len(chain) > 0
+		tips = append(tips, Ref{Name: ref.Name, Hash: sha})
+	}
+
+	order, branchOf, err := topoOrder(tips, nil)
+	if err != nil {
+		return err
+	}
+	for _, sha := range order {
+		if err := e.exportCommit(sha, branchOf[sha]); err != nil {
+			return err
+		}
+	}
+
+	for _, tip := range tips {
+		if annotated[tip.Name] {
+			continue
+		}
+		fmt.Fprintf(e.w, "reset %s\nfrom :%d\n\n", tip.Name, e.marks[tip.Hash])
+	}
+	for _, t := range tags {
+		if _, ok := e.marks[t.sha]; ok {
+			continue
+		}
+		target, _ := t.tag.Object()
+		fmt.Fprintf(e.w, "tag %s\nmark :%d\nfrom :%d\n", t.tag.Name(), e.mark(t.sha), e.marks[target])
+		if tagger := t.tag.header("tagger"); tagger != "" {
+			fmt.Fprintf(e.w, "tagger %s\n", tagger)
+		}
+		writeFastData(e.w, stripSignature(t.tag.Message()))
+	}
+	return e.w.Flush()
+}
+
+// topoOrder lists the commits reachable from tips and not in exclude with
+// parents first, each commit is attributed to the first tip it was reached from
+func topoOrder(tips []Ref, exclude map[string]bool) ([]string, map[string]string, error) {
+	order := []string{}
+	branchOf := map[string]string{}
+	done := map[string]bool{}
+	for _, tip := range tips {
+		stack := []string{tip.Hash}
+		for len(stack) > 0 {
+			sha := stack[len(stack)-1]
+			if done[sha] || exclude[sha] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			if _, ok := branchOf[sha]; !ok {
+				branchOf[sha] = tip.Name
+			}
+			info, err := ReadCommitInfo(sha)
+			if err != nil {
+				return nil, nil, err
+			}
+			pushed := false
+			for i := len(info.Parents) - 1; i >= 0; i-- {
+				if parent := info.Parents[i]; !done[parent] && !exclude[parent] {
+					stack = append(stack, parent)
+					pushed = true
+				}
+			}
+			if !pushed {
+				done[sha] = true
+				order = append(order, sha)
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return order, branchOf, nil
+}
+
+func (e *fastExporter) exportCommit(sha, ref string) error {
+	commit, err := ReadCommit(sha)
+	if err != nil {
+		return err
+	}
+	files, err := FlattenTree(commit.tree)
+	if err != nil {
+		return err
+	}
+	parentFiles := map[string]entry{}
+	if len(commit.parents) > 0 {
+		if parentFiles, err = FlattenTree(commit.parents[0]); err != nil {
+			return err
+		}
+	}
+
+	deleted, modified := []string{}, []string{}
+	for name := range parentFiles {
+		if _, ok := files[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	for name, file := range files {
+		if file.kind != BlobKind {
+			continue // submodules are not exported
+		}
+		if old, ok := parentFiles[name]; !ok || old != file {
+			modified = append(modified, name)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(modified)
+
+	for _, name := range modified {
+		hash := files[name].hash
+		if _, ok := e.marks[hash]; ok {
+			continue
+		}
+		blob, err := ReadGitObject(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.w, "blob\nmark :%d\n", e.mark(hash))
+		writeFastData(e.w, string(blob.Content()))
+	}
+
+	if len(commit.parents) == 0 {
+		// without a from fast-import would use the current tip of the branch
+		fmt.Fprintf(e.w, "reset %s\n", ref)
+	}
+	fmt.Fprintf(e.w, "commit %s\nmark :%d\n", ref, e.mark(sha))
+	fmt.Fprintf(e.w, "author %s\ncommitter %s\n", commit.author, commit.committer)
+	if commit.encoding != "" {
+		fmt.Fprintf(e.w, "encoding %s\n", commit.encoding)
+	}
+	writeFastData(e.w, commit.message)
+	for i, parent := range commit.parents {
+		command := "merge"
+		if i == 0 {
+			command = "from"
+		}
+		fmt.Fprintf(e.w, "%s :%d\n", command, e.marks[parent])
+	}
+	for _, name := range deleted {
+		fmt.Fprintf(e.w, "D %s\n", quoteFastPath(name))
+	}
+	for _, name := range modified {
+		file := files[name]
+		fmt.Fprintf(e.w, "M %s :%d %s\n", file.mode, e.marks[file.hash], quoteFastPath(name))
+	}
+	fmt.Fprintln(e.w)
+	return nil
+}
+
+func writeFastData(w io.Writer, data string) {
+	fmt.Fprintf(w, "data %d\n%s\n", len(data), data)
+}
+
+// paths starting with a quote or holding a newline must be C-style quoted
+func quoteFastPath(name string) string {
+	if strings.HasPrefix(name, `"`) || strings.ContainsAny(name, "\n") {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// stripSignature drops the PGP signature appended to a tag message
+func stripSignature(message string) string {
+	if i := strings.Index(message, "-----BEGIN PGP SIGNATURE-----"); i != -1 {
+		return message[:i]
+	}
+	return message
+}
@@ -0,0 +1,69 @@
+package mygit
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestFastExportImportRoundTrip(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n", "dir/deeper/file": "deep\n"})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "two\n", "with space": "spaced\n"})
+	if err := os.RemoveAll("dir/deeper"); err != nil {
+		t.Fatal(err)
+	}
+	second := commitWorktree(t, "second\n\nwith a body")
+	if err := UpdateRef("refs/heads/side", first); err != nil {
+		t.Fatal(err)
+	}
+	tag := annotatedTag(t, "v1", first, "release one")
+	if err := UpdateRef("refs/tags/v1", tag); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("refs/tags/light", second); err != nil {
+		t.Fatal(err)
+	}
+	refs, err := ListRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := bytes.Buffer{}
+	if err := FastExport(&stream, refs); err != nil {
+		t.Fatal(err)
+	}
+	exported := stream.String()
+
+	testRepository(t)
+	stats, err := FastImport(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Commits != 2 || stats.Tags != 1 || stats.Refs != len(refs) {
+		t.Errorf("imported %+v from\n%s", stats, exported)
+	}
+	imported, err := ListRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != len(refs) {
+		t.Fatalf("imported refs %v, want %v", imported, refs)
+	}
+	// same objects, so same shas
+	for i := range refs {
+		if imported[i] != refs[i] {
+			t.Errorf("imported %v, want %v", imported[i], refs[i])
+		}
+	}
+
+	// exporting the import gives the stream back
+	again := bytes.Buffer{}
+	if err := FastExport(&again, imported); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != exported {
+		t.Errorf("exported again\n%s\nwant\n%s", again.String(), exported)
+	}
+}
@@ -0,0 +1,526 @@
+package mygit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	InvalidFastImport = errors.New("Fast-import stream cannot be parsed.")
+)
+
+type FastImportStats struct {
+	Blobs   int
+	Commits int
+	Tags    int
+	Refs    int
+}
+
+// https://git-scm.com/docs/git-fast-import#_input_format
+type fastImporter struct {
+	r        *bufio.Reader
+	lineNo   int
+	peeked   *string
+	marks    map[string]string // ":<n>" -> sha
+	branches map[string]string // ref -> commit
+	tags     map[string]string // ref -> tag object
+	stats    FastImportStats
+}
+
+// FastImport reads a fast-import stream, writing its objects and updating the
+// refs it touches once the whole stream is read. Only the commands written by
+// fast-export are supported, together with inline data and the C and R file
+// commands.
+func FastImport(r io.Reader) (FastImportStats, error) {
+	fi := &fastImporter{
+		r:        bufio.NewReader(r),
+		marks:    map[string]string{},
+		branches: map[string]string{},
+		tags:     map[string]string{},
+	}
+	if err := fi.run(); err != nil {
+		return fi.stats, fmt.Errorf("%w: line %d: %w", InvalidFastImport, fi.lineNo, err)
+	}
+
+	// tags go last so that a tag wins over a commit that was made on its ref
+	for _, refs := range []map[string]string{fi.branches, fi.tags} {
+		for ref, sha := range refs {
+			if err := UpdateRef(ref, sha); err != nil {
+				return fi.stats, err
+			}
+			fi.stats.Refs++
+		}
+	}
+	return fi.stats, nil
+}
+
+func (fi *fastImporter) run() error {
+	for {
+		line, err := fi.nextLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "blob":
+			err = fi.blob()
+		case "commit":
+			err = fi.commit(arg)
+		case "reset":
+			err = fi.reset(arg)
+		case "tag":
+			err = fi.tag(arg)
+		case "feature", "option", "progress", "checkpoint":
+			continue
+		case "done":
+			return nil
+		default:
+			return fmt.Errorf("unsupported command '%s'", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fi *fastImporter) nextLine() (string, error) {
+	if fi.peeked != nil {
+		line := *fi.peeked
+		fi.peeked = nil
+		return line, nil
+	}
+	line, err := fi.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	fi.lineNo++
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func (fi *fastImporter) unread(line string) {
+	fi.peeked = &line
+}
+
+// optional reads the "<command> <value>" line if it comes next
+func (fi *fastImporter) optional(command string) (string, bool, error) {
+	line, err := fi.nextLine()
+	if err == io.EOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if value, found := strings.CutPrefix(line, command+" "); found {
+		return value, true, nil
+	}
+	fi.unread(line)
+	return "", false, nil
+}
+
+// data reads "data <count>" followed by count bytes, or the delimited
+// "data <<<delim>" form
+func (fi *fastImporter) data() (string, error) {
+	line, err := fi.nextLine()
+	if err != nil {
+		return "", err
+	}
+	arg, found := strings.CutPrefix(line, "data ")
+	if !found {
+		return "", fmt.Errorf("expected data, got '%s'", line)
+	}
+
+	if delim, found := strings.CutPrefix(arg, "<<"); found {
+		data := strings.Builder{}
+		for {
+			line, err := fi.nextLine()
+			if err != nil {
+				return "", err
+			}
+			if line == delim {
+				return data.String(), nil
+			}
+			data.WriteString(line + "\n")
+		}
+	}
+
+	size, err := strconv.Atoi(arg)
+	if err != nil || size < 0 {
+		return "", fmt.Errorf("bad data size '%s'", arg)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(fi.r, buf); err != nil {
+		return "", err
+	}
+	fi.lineNo += strings.Count(string(buf), "\n")
+	// the data may be followed by a newline
+	if b, err := fi.r.ReadByte(); err == nil && b != '\n' {
+		fi.r.UnreadByte()
+	}
+	return string(buf), nil
+}
+
+// resolve turns a mark, a sha or a ref into a sha
+func (fi *fastImporter) resolve(ref string) (string, error) {
+	if strings.HasPrefix(ref, ":") {
+		sha, ok := fi.marks[ref]
+		if !ok {
+			return "", fmt.Errorf("unknown mark %s", ref)
+		}
+		return sha, nil
+	}
+	if sha, ok := fi.branches[ref]; ok {
+		return sha, nil
+	}
+	return ResolveRef(ref)
+}
+
+func (fi *fastImporter) setMark() (string, error) {
+	mark, _, err := fi.optional("mark")
+	if err != nil {
+		return "", err
+	}
+	// the original sha is informative only
+	if _, _, err := fi.optional("original-oid"); err != nil {
+		return "", err
+	}
+	return mark, nil
+}
+
+func (fi *fastImporter) blob() error {
+	mark, err := fi.setMark()
+	if err != nil {
+		return err
+	}
+	data, err := fi.data()
+	if err != nil {
+		return err
+	}
+	hash, err := WriteContent(&Blob{content: []byte(data)})
+	if err != nil {
+		return err
+	}
+	if mark != "" {
+		fi.marks[mark] = fmt.Sprintf("%x", hash)
+	}
+	fi.stats.Blobs++
+	return nil
+}
+
+func (fi *fastImporter) reset(ref string) error {
+	delete(fi.branches, ref)
+	from, found, err := fi.optional("from")
+	if err != nil || !found {
+		return err
+	}
+	sha, err := fi.resolve(from)
+	if err != nil {
+		return err
+	}
+	fi.branches[ref] = sha
+	return nil
+}
+
+func (fi *fastImporter) commit(ref string) error {
+	mark, err := fi.setMark()
+	if err != nil {
+		return err
+	}
+
+	commit := &Commit{}
+	author, hasAuthor, err := fi.optional("author")
+	if err != nil {
+		return err
+	}
+	committer, found, err := fi.optional("committer")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("commit %s has no committer", ref)
+	}
+	if commit.committer, err = parseSignature(committer); err != nil {
+		return err
+	}
+	commit.author = commit.committer
+	if hasAuthor {
+		if commit.author, err = parseSignature(author); err != nil {
+			return err
+		}
+	}
+	if commit.encoding, _, err = fi.optional("encoding"); err != nil {
+		return err
+	}
+	if commit.message, err = fi.data(); err != nil {
+		return err
+	}
+
+	// without a from the commit continues the branch
+	from, hasFrom, err := fi.optional("from")
+	if err != nil {
+		return err
+	}
+	if hasFrom {
+		if from, err = fi.resolve(from); err != nil {
+			return err
+		}
+	} else {
+		from = fi.branches[ref]
+	}
+	files := map[string]entry{}
+	if from != "" {
+		commit.parents = append(commit.parents, from)
+		if files, err = FlattenTree(from); err != nil {
+			return err
+		}
+	}
+	for {
+		merge, found, err := fi.optional("merge")
+		if err != nil {
+			return err
+		}
+		if !found {
+			break
+		}
+		if merge, err = fi.resolve(merge); err != nil {
+			return err
+		}
+		commit.parents = append(commit.parents, merge)
+	}
+
+	if err := fi.fileChanges(files); err != nil {
+		return err
+	}
+	tree, err := WriteTreeFromFiles(files, true)
+	if err != nil {
+		return err
+	}
+	commit.tree = fmt.Sprintf("%x", tree)
+
+	hash, err := WriteContent(commit)
+	if err != nil {
+		return err
+	}
+	sha := fmt.Sprintf("%x", hash)
+	if mark != "" {
+		fi.marks[mark] = sha
+	}
+	fi.branches[ref] = sha
+	fi.stats.Commits++
+	return nil
+}
+
+// fileChanges applies the M, D, C, R and deleteall commands up to the end of
+// the commit
+func (fi *fastImporter) fileChanges(files map[string]entry) error {
+	for {
+		line, err := fi.nextLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch command {
+		case "M":
+			err = fi.fileModify(files, arg)
+		case "D":
+			var name string
+			if name, _, err = parseFastPath(arg, false); err == nil {
+				removeFastPath(files, name)
+			}
+		case "C", "R":
+			err = copyFastPath(files, arg, command == "R")
+		case "deleteall":
+			clear(files)
+		default:
+			// the next command starts, the blank line is optional
+			fi.unread(line)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fi *fastImporter) fileModify(files map[string]entry, arg string) error {
+	fields := strings.SplitN(arg, " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("bad filemodify 'M %s'", arg)
+	}
+	mode, dataref := fields[0], fields[1]
+	name, _, err := parseFastPath(fields[2], false)
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case "644":
+		mode = "100644"
+	case "755":
+		mode = "100755"
+	case "100644", "100755", "120000", "040000":
+	default:
+		return fmt.Errorf("unsupported mode %s", mode)
+	}
+
+	var sha string
+	if dataref == "inline" {
+		data, err := fi.data()
+		if err != nil {
+			return err
+		}
+		hash, err := WriteContent(&Blob{content: []byte(data)})
+		if err != nil {
+			return err
+		}
+		fi.stats.Blobs++
+		sha = fmt.Sprintf("%x", hash)
+	} else if sha, err = fi.resolve(dataref); err != nil {
+		return err
+	}
+
+	removeFastPath(files, name)
+	if mode == "040000" {
+		subtree, err := FlattenTree(sha)
+		if err != nil {
+			return err
+		}
+		for subpath, e := range subtree {
+			e.name = name + "/" + subpath
+			files[e.name] = e
+		}
+		return nil
+	}
+	files[name] = entry{mode: mode, kind: BlobKind, hash: sha, name: name}
+	return nil
+}
+
+// parseFastPath reads a path that is either C-style quoted or runs up to the
+// end of the line, or up to the first space when more follows
+func parseFastPath(arg string, more bool) (string, string, error) {
+	if strings.HasPrefix(arg, `"`) {
+		quoted, err := strconv.QuotedPrefix(arg)
+		if err != nil {
+			return "", "", err
+		}
+		name, err := strconv.Unquote(quoted)
+		return name, strings.TrimPrefix(arg[len(quoted):], " "), err
+	}
+	if more {
+		name, rest, _ := strings.Cut(arg, " ")
+		return name, rest, nil
+	}
+	return arg, "", nil
+}
+
+// removeFastPath removes name, be it a file or a directory
+func removeFastPath(files map[string]entry, name string) {
+	delete(files, name)
+	for file := range files {
+		if strings.HasPrefix(file, name+"/") {
+			delete(files, file)
+		}
+	}
+}
+
+func copyFastPath(files map[string]entry, arg string, rename bool) error {
+	src, rest, err := parseFastPath(arg, true)
+	if err != nil {
+		return err
+	}
+	dst, _, err := parseFastPath(rest, false)
+	if err != nil {
+		return err
+	}
+
+	moved := map[string]entry{}
+	for file, e := range files {
+		if file == src {
+			moved[dst] = e
+		} else if sub, found := strings.CutPrefix(file, src+"/"); found {
+			moved[dst+"/"+sub] = e
+		}
+	}
+	if len(moved) == 0 {
+		return fmt.Errorf("path %s not in branch", src)
+	}
+	if rename {
+		removeFastPath(files, src)
+	}
+	removeFastPath(files, dst)
+	for file, e := range moved {
+		e.name = file
+		files[file] = e
+	}
+	return nil
+}
+
+func (fi *fastImporter) tag(name string) error {
+	mark, _, err := fi.optional("mark")
+	if err != nil {
+		return err
+	}
+	from, found, err := fi.optional("from")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("tag %s has no from", name)
+	}
+	target, err := fi.resolve(from)
+	if err != nil {
+		return err
+	}
+	if _, _, err := fi.optional("original-oid"); err != nil {
+		return err
+	}
+	tagger, hasTagger, err := fi.optional("tagger")
+	if err != nil {
+		return err
+	}
+	message, err := fi.data()
+	if err != nil {
+		return err
+	}
+
+	kind, err := ObjectType(target)
+	if err != nil {
+		return err
+	}
+	content := strings.Builder{}
+	fmt.Fprintf(&content, "object %s\ntype %s\ntag %s\n", target, kind, name)
+	if hasTagger {
+		fmt.Fprintf(&content, "tagger %s\n", tagger)
+	}
+	content.WriteString("\n" + message)
+
+	hash, err := WriteContent(&Tag{content: []byte(content.String())})
+	if err != nil {
+		return err
+	}
+	sha := fmt.Sprintf("%x", hash)
+	if mark != "" {
+		fi.marks[mark] = sha
+	}
+	fi.tags["refs/tags/"+name] = sha
+	delete(fi.branches, "refs/tags/"+name)
+	fi.stats.Tags++
+	return nil
+}
@@ -0,0 +1,350 @@
+package mygit
+
+// https://git-scm.com/docs/pack-protocol#_packfile_negotiation
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// haves sent in the first round, doubled every round up to the maximum
+	initialHaves = 16
+	maxHaveBatch = 1024
+	// git gives up once that many haves in a row found nothing new in common
+	maxInVain = 256
+)
+
+// haveWalker lists the local commits to offer as haves, newest first like
+// git does, leaving out the ancestors of commits known to be common since
+// the server has them too
+type haveWalker struct {
+	pending map[string]CommitInfo
+	parents map[string][]string // of the commits seen so far
+	common  map[string]bool
+	shallow map[string]bool // their parents are not in the repository
+}
+
+func newHaveWalker(tips []string) (*haveWalker, error) {
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+	w := &haveWalker{
+		pending: map[string]CommitInfo{},
+		parents: map[string][]string{},
+		common:  map[string]bool{},
+		shallow: shallow,
+	}
+	for _, tip := range tips {
+		w.push(tip)
+	}
+	return w, nil
+}
+
+// push queues sha, refs to something other than a commit are skipped
+func (w *haveWalker) push(sha string) {
+	if _, seen := w.parents[sha]; seen {
+		return
+	}
+	info, err := ReadCommitInfo(sha)
+	if err != nil {
+		return
+	}
+	if w.shallow[sha] {
+		info.Parents = nil
+	}
+	w.parents[sha] = info.Parents
+	w.pending[sha] = info
+}
+
+// next returns up to n haves, none once every commit was offered
+func (w *haveWalker) next(n int) []string {
+	haves := []string{}
+	for len(haves) < n && len(w.pending) > 0 {
+		newest := ""
+		for sha, info := range w.pending {
+			if newest == "" || info.Time > w.pending[newest].Time ||
+				(info.Time == w.pending[newest].Time && sha < newest) {
+				newest = sha
+			}
+		}
+		info := w.pending[newest]
+		delete(w.pending, newest)
+		for _, parent := range info.Parents {
+			w.push(parent)
+			if w.common[newest] {
+				w.markCommon(parent)
+			}
+		}
+		if !w.common[newest] {
+			haves = append(haves, newest)
+		}
+	}
+	return haves
+}
+
+// markCommon records that the server has sha, so it has its ancestors too
+func (w *haveWalker) markCommon(sha string) {
+	stack := []string{sha}
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.common[sha] {
+			continue
+		}
+		w.common[sha] = true
+		stack = append(stack, w.parents[sha]...)
+	}
+}
+
+// localTips returns the commits the refs and HEAD point to
+func localTips() ([]string, error) {
+	refs, err := ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	tips := []string{}
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash)
+	}
+	if head, err := readRef("HEAD"); err == nil {
+		tips = append(tips, head)
+	}
+	return tips, nil
+}
+
+// FetchPack asks the server for wants and returns the pack, after telling it
+// which commits we have so that it leaves out what they reach. With
+// multi_ack_detailed the haves go in rounds of growing size until the server
+// is ready to send the pack or we run out of them, every round repeating
+// the haves found in common since each HTTP request is answered on its own.
+// With no-done as well the round the server is ready in brings the pack.
+func FetchPack(ctx context.Context, adv *RefAdvertisement, wants, capabilities []string) (*UploadPackResponse, error) {
+	tips, err := localTips()
+	if err != nil {
+		return nil, err
+	}
+	walker, err := newHaveWalker(tips)
+	if err != nil {
+		return nil, err
+	}
+	request := UploadPackRequest{Wants: wants, Capabilities: capabilities}
+	if !slices.Contains(capabilities, "multi_ack_detailed") {
+		// a single round, the most recent commits are the likeliest in common
+		request.Haves = walker.next(maxInVain)
+		return UploadPack(ctx, adv.URL, request)
+	}
+
+	common, inVain := []string{}, 0
+	for batch, round := initialHaves, 1; ; batch, round = min(batch*2, maxHaveBatch), round+1 {
+		haves := walker.next(batch)
+		if len(haves) == 0 {
+			break
+		}
+		request.Haves = append(slices.Clone(common), haves...)
+		acks, resp, err := UploadPackRound(ctx, adv.URL, request)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			Verbosef("Negotiation round %d: the server is ready, the pack came without done\n", round)
+			return resp, nil
+		}
+
+		ready := false
+		inVain += len(haves)
+		for _, ack := range acks {
+			switch ack.Status {
+			case "common":
+				if !walker.common[ack.Hash] {
+					common = append(common, ack.Hash)
+					inVain = 0
+				}
+				walker.markCommon(ack.Hash)
+			case "ready":
+				ready = true
+			}
+		}
+		Verbosef("Negotiation round %d: %d haves, %d in common\n", round, len(haves), len(common))
+		if ready || (len(common) > 0 && inVain >= maxInVain) {
+			break
+		}
+	}
+	request.Haves = common
+	return UploadPack(ctx, adv.URL, request)
+}
+
+type FetchedRef struct {
+	Branch string // remote branch or tag name, without refs/heads/ or refs/tags/
+	Ref    string // remote-tracking ref it is stored as, the same name for a tag
+	Old    string // empty for a new branch
+	New    string
+	Forced bool // Old is not an ancestor of New
+}
+
+type FetchResult struct {
+	URL     string
+	Refs    []FetchedRef // only the ones that changed
+	Objects ObjectCounts
+}
+
+// Fetch downloads the branches of remote missing here and stores them as
+// refs/remotes/<remote>/<branch>, and the tags pointing to what it fetched
+// like git does. FETCH_HEAD lists every branch, those the current branch
+// merges from as branch.<name>.merge says for merge.
+func Fetch(ctx context.Context, remote string) (result FetchResult, _ error) {
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return result, err
+	}
+	url, ok := cfg.Get("remote." + remote + ".url")
+	if !ok {
+		return result, fmt.Errorf("%w: remote %q has no url", InvalidConfig, remote)
+	}
+	result.URL = url
+	adv, err := GetRefs(ctx, url)
+	if err != nil {
+		return result, err
+	}
+
+	merges := []string{}
+	if head, symbolic, err := ReadSymbolicRef("HEAD"); err == nil && symbolic {
+		current := strings.TrimPrefix(head, "refs/heads/")
+		if r, _ := cfg.Get("branch." + current + ".remote"); r == remote {
+			merges = cfg.GetAll("branch." + current + ".merge")
+		}
+	}
+
+	wants, t, fetchHead := []string{}, RefTransaction{}, []FetchHeadEntry{}
+	for _, ref := range adv.Refs {
+		branch, found := strings.CutPrefix(ref.Name, "refs/heads/")
+		if !found {
+			continue
+		}
+		fetchHead = append(fetchHead, FetchHeadEntry{
+			Hash:        ref.Hash,
+			NotForMerge: !slices.Contains(merges, ref.Name),
+			Description: fmt.Sprintf("branch '%s' of %s", branch, fetchHeadURL(url)),
+		})
+		fetched := FetchedRef{Branch: branch, Ref: "refs/remotes/" + remote + "/" + branch, New: ref.Hash}
+		old, err := readRef(fetched.Ref)
+		if err != nil && err != RefNotFound {
+			return result, err
+		}
+		if old == ref.Hash {
+			continue
+		}
+		fetched.Old = old
+		if !HasObject(ref.Hash) && !slices.Contains(wants, ref.Hash) {
+			wants = append(wants, ref.Hash)
+		}
+		if old == "" {
+			old = zeroHash
+		}
+		t.Update(fetched.Ref, ref.Hash, old)
+		result.Refs = append(result.Refs, fetched)
+	}
+
+	tags, err := tagWants(adv)
+	if err != nil {
+		return result, err
+	}
+	if wants = append(wants, tags...); len(wants) > 0 {
+		capabilities := append([]string{"multi_ack_detailed", "no-done"}, clientCapabilities...)
+		resp, err := FetchPack(ctx, adv, wants, adv.Negotiate(capabilities))
+		if err != nil {
+			return result, err
+		}
+		Verbosef("Received packfile of %d bytes\n", len(resp.Pack))
+		if result.Objects, err = unpackPack(ctx, resp.Pack); err != nil {
+			return result, err
+		}
+	}
+
+	for i, fetched := range result.Refs {
+		if fetched.Old == "" {
+			continue
+		}
+		ancestors, err := Ancestors(fetched.New)
+		if err != nil {
+			return result, err
+		}
+		result.Refs[i].Forced = !ancestors[fetched.Old]
+	}
+
+	followed, err := followedTags(adv)
+	if err != nil {
+		return result, err
+	}
+	for _, tag := range followed {
+		name := strings.TrimPrefix(tag.Name, "refs/tags/")
+		fetchHead = append(fetchHead, FetchHeadEntry{
+			Hash:        tag.Hash,
+			NotForMerge: true,
+			Description: fmt.Sprintf("tag '%s' of %s", name, fetchHeadURL(url)),
+		})
+		t.Create(tag.Name, tag.Hash)
+		result.Refs = append(result.Refs, FetchedRef{Branch: name, Ref: tag.Name, New: tag.Hash})
+	}
+	if err := WriteFetchHead(fetchHead); err != nil {
+		return result, err
+	}
+	return result, t.Commit()
+}
+
+// advertisedTags lists the tags of the advertisement missing here, with the
+// object each one peels to
+func advertisedTags(adv *RefAdvertisement) (tags []Ref, peeled []string, _ error) {
+	for _, ref := range adv.Refs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || strings.HasSuffix(ref.Name, "^{}") {
+			continue
+		}
+		if _, err := readRef(ref.Name); err != RefNotFound {
+			if err != nil {
+				return nil, nil, err
+			}
+			continue // an existing tag is never moved
+		}
+		target, ok := adv.Lookup(ref.Name + "^{}")
+		if !ok {
+			target = ref.Hash // not an annotated tag
+		}
+		tags, peeled = append(tags, ref), append(peeled, target)
+	}
+	return tags, peeled, nil
+}
+
+// tagWants lists the annotated tags to ask for because they point to
+// commits already here, include-tag only brings those pointing into the pack
+func tagWants(adv *RefAdvertisement) ([]string, error) {
+	tags, peeled, err := advertisedTags(adv)
+	if err != nil {
+		return nil, err
+	}
+	wants := []string{}
+	for i, tag := range tags {
+		if tag.Hash != peeled[i] && HasObject(peeled[i]) && !HasObject(tag.Hash) {
+			wants = append(wants, tag.Hash)
+		}
+	}
+	return wants, nil
+}
+
+// followedTags lists the tags to create after a fetch, like git's automatic
+// tag following: the missing ones whose object is now in the repository
+func followedTags(adv *RefAdvertisement) ([]Ref, error) {
+	tags, _, err := advertisedTags(adv)
+	if err != nil {
+		return nil, err
+	}
+	followed := []Ref{}
+	for _, tag := range tags {
+		if HasObject(tag.Hash) {
+			followed = append(followed, tag)
+		}
+	}
+	return followed, nil
+}
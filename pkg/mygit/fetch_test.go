@@ -0,0 +1,353 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// exchange is one upload-pack request the client must send, as pkt-lines,
+// and the answer the server gives to it
+type exchange struct {
+	request  []string
+	response []string // pkt-lines, "PACK" for the pack
+}
+
+// replayRemote answers the upload-pack requests with the exchanges in order,
+// reporting any request that differs from the expected one
+func replayRemote(t *testing.T, exchanges []exchange, pack []byte) (*fixtureRemote, *int) {
+	t.Helper()
+	remote, count := &fixtureRemote{}, new(int)
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		if *count >= len(exchanges) {
+			t.Errorf("unexpected request %d: %q", *count+1, decodePacketLines(t, body))
+			http.Error(w, "no more exchanges", http.StatusInternalServerError)
+			return
+		}
+		e := exchanges[*count]
+		*count++
+		if got := decodePacketLines(t, body); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", e.request) {
+			t.Errorf("request %d is\n%q\nwant\n%q", *count, got, e.request)
+		}
+		for _, line := range e.response {
+			if line == "PACK" {
+				w.Write(pack)
+			} else {
+				io.WriteString(w, serializePackeLine(line))
+			}
+		}
+	}
+	return remote, count
+}
+
+// haveLines lists "have" lines for commits[from] down to commits[to]
+func haveLines(commits []string, from, to int) []string {
+	lines := []string{}
+	for i := from; i >= to; i-- {
+		lines = append(lines, "have "+commits[i]+"\n")
+	}
+	return lines
+}
+
+func TestFetchPackNegotiation(t *testing.T) {
+	testRepository(t)
+	// 40 commits, the newest last, the server has the first ones
+	commits := []string{}
+	for i := range 40 {
+		writeFiles(t, map[string]string{"file": strconv.Itoa(i) + "\n"})
+		commits = append(commits, commitWorktree(t, "commit "+strconv.Itoa(i)))
+	}
+	pack, _ := rawPack(t, rawObject{kind: blob, data: []byte("fetched\n")})
+	want := blobSha("wanted\n")
+	wantLine := func(capabilities ...string) []string {
+		return []string{"want " + strings.Join(append([]string{want}, capabilities...), " ") + "\n", flushPacket}
+	}
+	concat := func(parts ...[]string) []string {
+		lines := []string{}
+		for _, part := range parts {
+			lines = append(lines, part...)
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name         string
+		capabilities []string
+		exchanges    []exchange
+	}{
+		{
+			// the first 16 haves find nothing, the next 32 (24 left) find
+			// commit 9 and the server is ready, sending the pack at once
+			"ready with no-done",
+			[]string{"multi_ack_detailed", "no-done"},
+			[]exchange{
+				{concat(wantLine("multi_ack_detailed", "no-done"), haveLines(commits, 39, 24), []string{flushPacket}), []string{"NAK\n"}},
+				{concat(wantLine("multi_ack_detailed", "no-done"), haveLines(commits, 23, 0), []string{flushPacket}),
+					[]string{"ACK " + commits[9] + " common\n", "ACK " + commits[9] + " ready\n", "NAK\n", "ACK " + commits[9] + "\n", "PACK"}},
+			},
+		},
+		{
+			// without no-done the pack is asked for with done, sending
+			// the commits in common again
+			"ready without no-done",
+			[]string{"multi_ack_detailed"},
+			[]exchange{
+				{concat(wantLine("multi_ack_detailed"), haveLines(commits, 39, 24), []string{flushPacket}), []string{"NAK\n"}},
+				{concat(wantLine("multi_ack_detailed"), haveLines(commits, 23, 0), []string{flushPacket}),
+					[]string{"ACK " + commits[9] + " common\n", "ACK " + commits[9] + " ready\n", "NAK\n"}},
+				{concat(wantLine("multi_ack_detailed"), []string{"have " + commits[9] + "\n", "done\n"}), []string{"ACK " + commits[9] + "\n", "PACK"}},
+			},
+		},
+		{
+			// commit 30 in common leaves nothing older to offer, the
+			// negotiation ends with done without the server being ready
+			"out of haves",
+			[]string{"multi_ack_detailed", "no-done"},
+			[]exchange{
+				{concat(wantLine("multi_ack_detailed", "no-done"), haveLines(commits, 39, 24), []string{flushPacket}),
+					[]string{"ACK " + commits[30] + " common\n", "NAK\n"}},
+				{concat(wantLine("multi_ack_detailed", "no-done"), []string{"have " + commits[30] + "\n", "done\n"}), []string{"ACK " + commits[30] + "\n", "PACK"}},
+			},
+		},
+		{
+			// without multi_ack_detailed there is a single round
+			"single round",
+			nil,
+			[]exchange{
+				{concat(wantLine(), haveLines(commits, 39, 0), []string{"done\n"}), []string{"NAK\n", "PACK"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote, count := replayRemote(t, test.exchanges, pack)
+			adv := &RefAdvertisement{URL: remote.serve(t)}
+			resp, err := FetchPack(context.Background(), adv, []string{want}, test.capabilities)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *count != len(test.exchanges) {
+				t.Errorf("%d requests, want %d", *count, len(test.exchanges))
+			}
+			if string(resp.Pack) != string(pack) {
+				t.Errorf("received a pack of %d bytes, want %d", len(resp.Pack), len(pack))
+			}
+		})
+	}
+}
+
+// servedRepository serves the repository at dir with UploadPackHandler while
+// the test works in another one, recording the haves of each fetch request
+// and whether it ends with done
+type servedRepository struct {
+	dir     string
+	handler UploadPackHandler
+	haves   []int
+	done    []bool
+}
+
+func (s *servedRepository) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		s.haves = append(s.haves, bytes.Count(body, []byte("have ")))
+		s.done = append(s.done, bytes.Contains(body, []byte("done\n")))
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// the client waits for the whole response, it is back in its own
+	// repository before reading it
+	saved := gitDir
+	gitDir = s.dir
+	dropObjectCaches()
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, r)
+	gitDir = saved
+	dropObjectCaches()
+	for name, values := range recorder.Header() {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(recorder.Code)
+	w.Write(recorder.Body.Bytes())
+}
+
+// importCommits writes with git fast-import a commit of the empty tree for
+// each date on ref, the first one on parent unless it is empty
+func importCommits(t *testing.T, dir, ref, parent string, dates ...int) {
+	t.Helper()
+	var stream strings.Builder
+	for i, date := range dates {
+		message := fmt.Sprintf("%s at %d\n", ref, date)
+		fmt.Fprintf(&stream, "commit %s\ncommitter Tester <tester@example.com> %d +0000\ndata %d\n%s", ref, date, len(message), message)
+		if i == 0 && parent != "" {
+			fmt.Fprintf(&stream, "from %s\n", parent)
+		}
+		stream.WriteString("\n")
+	}
+	gitOutput(t, []byte(stream.String()), "--git-dir="+dir, "fast-import", "--quiet")
+}
+
+func TestFetchPackFromUploadPackHandler(t *testing.T) {
+	testRepository(t)
+	// here: 600 commits the server does not have, older than the shared one
+	local := make([]int, 600)
+	for i := range local {
+		local[i] = 1_000_000 + i
+	}
+	importCommits(t, ".git", "refs/heads/local", "", local...)
+	importCommits(t, ".git", "refs/heads/main", "", 2_000_000)
+	shared := runGit(t, "rev-parse", "main")
+
+	// the server: the shared commit, a child of it and an unrelated one
+	server := &servedRepository{dir: filepath.Join(t.TempDir(), "server.git")}
+	runGit(t, "init", "-q", "--bare", server.dir)
+	importCommits(t, server.dir, "refs/heads/main", "", 2_000_000)
+	importCommits(t, server.dir, "refs/heads/main", "refs/heads/main^0", 3_000_000)
+	importCommits(t, server.dir, "refs/heads/unrelated", "", 3_000_000)
+	if got := runGit(t, "--git-dir="+server.dir, "rev-parse", "main^"); got != shared {
+		t.Fatalf("the server has %s as the shared commit, here it is %s", got, shared)
+	}
+	url := httptest.NewServer(server)
+	t.Cleanup(url.Close)
+
+	tests := []struct {
+		name  string
+		want  string
+		haves []int
+		done  []bool
+	}{
+		{
+			// the first round finds the shared commit, the main of the
+			// server descends from it and the pack comes without done
+			name:  "ready with no-done",
+			want:  "main",
+			haves: []int{initialHaves},
+			done:  []bool{false},
+		},
+		{
+			// nothing the unrelated commit reaches is here, the server is
+			// never ready: the rounds double, each repeating the commit in
+			// common, until maxInVain haves in a row found nothing new
+			name:  "maxInVain",
+			want:  "unrelated",
+			haves: []int{16, 1 + 32, 1 + 64, 1 + 128, 1 + 256, 1},
+			done:  []bool{false, false, false, false, false, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server.haves, server.done = nil, nil
+			adv, err := GetRefs(context.Background(), url.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, ok := adv.Lookup("refs/heads/" + test.want)
+			if !ok {
+				t.Fatalf("%s is not advertised in %v", test.want, adv.Refs)
+			}
+			capabilities := adv.Negotiate(append([]string{"multi_ack_detailed", "no-done"}, clientCapabilities...))
+			if !slices.Contains(capabilities, "no-done") {
+				t.Errorf("no-done is not advertised over HTTP: %v", adv.Capabilities)
+			}
+			resp, err := FetchPack(context.Background(), adv, []string{want}, capabilities)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(server.haves) != fmt.Sprint(test.haves) || fmt.Sprint(server.done) != fmt.Sprint(test.done) {
+				t.Errorf("requests with %v haves, done %v, want %v haves, done %v", server.haves, server.done, test.haves, test.done)
+			}
+			// only the wanted commit, its empty tree is the one the shared
+			// commit has
+			if len(resp.Pack) < 12 || binary.BigEndian.Uint32(resp.Pack[8:12]) != 1 {
+				t.Errorf("received a pack of %d bytes, want one with a single object", len(resp.Pack))
+			}
+		})
+	}
+}
+
+func TestParseAcks(t *testing.T) {
+	sha := strings.Repeat("1", 40)
+	for _, test := range []struct {
+		lines []string
+		acks  string
+	}{
+		{[]string{"NAK\n"}, "[]"},
+		{[]string{"ACK " + sha + " common\n", "ACK " + sha + " ready\n", "NAK\n"}, "[{" + sha + " common} {" + sha + " ready}]"},
+		{[]string{"ACK " + sha + "\n", "ignored\n"}, "[{" + sha + " }]"},
+	} {
+		raw := ""
+		for _, line := range test.lines {
+			raw += serializePackeLine(line)
+		}
+		acks, err := parseAcks(strings.NewReader(raw))
+		if err != nil || fmt.Sprint(acks) != test.acks {
+			t.Errorf("%q parses to %v, %v, want %s", test.lines, acks, err, test.acks)
+		}
+	}
+	for _, line := range []string{"ACK\n", "ACK nothex common\n", "ERR upload-pack: not our ref\n"} {
+		if acks, err := parseAcks(strings.NewReader(serializePackeLine(line))); err == nil {
+			t.Errorf("%q parses to %v", line, acks)
+		}
+	}
+}
+
+func TestFetchFollowsTagsMatchesGit(t *testing.T) {
+	bare := servedHistory(t)
+	// mygit and git fetch from the same server
+	url := gitHTTPBackend(t, bare)
+	branch := strings.TrimPrefix(runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD"), "refs/heads/")
+
+	root := t.TempDir()
+	mine, theirs := filepath.Join(root, "mygit"), filepath.Join(root, "git")
+	if _, err := runCommand(t, "-q", "clone", url, mine); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "clone", "-q", url, theirs)
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		return runGit(t, append([]string{"-C", dir, "--git-dir=.git"}, args...)...)
+	}
+	// the annotated tag include-tag sends with the commit it points to
+	if got, want := git(mine, "show-ref", "--tags"), git(theirs, "show-ref", "--tags"); got != want {
+		t.Errorf("the clone has the tags\n%s\ngit cloned\n%s", got, want)
+	}
+
+	// a new tag on a commit both have already is asked for by fetch
+	runGit(t, "--git-dir="+bare, "tag", "-a", "-m", "later", "later", branch+"~1")
+	git(mine, "config", "branch."+branch+".remote", "origin")
+	git(mine, "config", "branch."+branch+".merge", "refs/heads/"+branch)
+	git(theirs, "fetch", "-q")
+	if _, err := runCommand(t, "-C", mine, "-q", "fetch"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := git(mine, "show-ref", "--tags"), runGit(t, "--git-dir="+bare, "show-ref", "--tags"); got != want {
+		t.Errorf("fetch left the tags\n%s\nthe server has\n%s", got, want)
+	}
+	fetchHead := func(dir string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, ".git", "FETCH_HEAD"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got, want := fetchHead(mine), fetchHead(theirs); got != want {
+		t.Errorf("FETCH_HEAD is\n%s\ngit wrote\n%s", got, want)
+	}
+	if fsck := git(mine, "fsck", "--full"); fsck != "" {
+		t.Errorf("fsck: %s", fsck)
+	}
+}
@@ -0,0 +1,207 @@
+package mygit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// reachabilityRoots returns HEAD, every ref, every object recorded in the
+// reflogs and what the index holds, the objects that must be kept alive
+func reachabilityRoots() ([]string, error) {
+	named, err := namedRoots()
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{}
+	for _, root := range named {
+		roots = append(roots, root.Hash)
+	}
+	return roots, nil
+}
+
+// namedRoots is reachabilityRoots with what names each root: the ref,
+// "<ref>@{n}" for the reflog entry n of the ref, or ":<path>" for what the
+// index has at path, refs coming first and the index last
+func namedRoots() ([]Ref, error) {
+	roots := []Ref{}
+	refs, err := ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, refs...)
+	if hash, err := readRef("HEAD"); err == nil {
+		roots = append(roots, Ref{Name: "HEAD", Hash: hash})
+	}
+
+	logs := gitPath("logs")
+	err = filepath.WalkDir(logs, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(logs, p)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		// "<old> <new> <identity>\t<message>", the newest entry last
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		for i, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			n := len(lines) - 1 - i
+			for j, sha := range fields[:2] {
+				// expired entries may name objects that are gone
+				if sha != zeroHash && HasObject(sha) {
+					// the old value of entry n is entry n+1
+					name := fmt.Sprintf("%s@{%d}", filepath.ToSlash(rel), n+1-j)
+					roots = append(roots, Ref{Name: name, Hash: sha})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// the staged blobs and the trees write-tree cached, submodule commits
+	// are in another repository
+	idx, err := ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range idx.Entries {
+		if e.Mode != 0o160000 && HasObject(e.Hash) {
+			roots = append(roots, Ref{Name: ":" + e.Path, Hash: e.Hash})
+		}
+	}
+	var cachedTrees func(tree *CacheTree, dir string)
+	cachedTrees = func(tree *CacheTree, dir string) {
+		if tree == nil {
+			return
+		}
+		// a directory changed since may still have unchanged subdirectories
+		if tree.Entries >= 0 && HasObject(tree.Hash) {
+			roots = append(roots, Ref{Name: ":" + dir, Hash: tree.Hash})
+		}
+		for _, sub := range tree.Subtrees {
+			cachedTrees(sub, dir+sub.Name+"/")
+		}
+	}
+	cachedTrees(idx.Tree, "")
+	return roots, nil
+}
+
+// UnreachableObjects returns the stored objects no root reaches. Among them
+// the dangling ones are not referenced by any other unreachable object, they
+// are the tips of what was lost.
+func UnreachableObjects() (unreachable, dangling []string, _ error) {
+	roots, err := reachabilityRoots()
+	if err != nil {
+		return nil, nil, err
+	}
+	reachable, err := ReachableObjects(roots)
+	if err != nil {
+		return nil, nil, err
+	}
+	isReachable := map[string]bool{}
+	for _, sha := range reachable {
+		isReachable[sha] = true
+	}
+
+	all, err := AllObjects()
+	if err != nil {
+		return nil, nil, err
+	}
+	referenced := map[string]bool{}
+	for _, sha := range all {
+		if isReachable[sha] {
+			continue
+		}
+		unreachable = append(unreachable, sha)
+		refs, err := directReferences(sha)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ref := range refs {
+			referenced[ref] = true
+		}
+	}
+	for _, sha := range unreachable {
+		if !referenced[sha] {
+			dangling = append(dangling, sha)
+		}
+	}
+	return unreachable, dangling, nil
+}
+
+// LostCommits returns the dangling commits, newest first. They are the tips
+// of the history no ref nor reflog reaches anymore, like a branch that was
+// deleted or reset.
+func LostCommits() ([]string, error) {
+	_, dangling, err := UnreachableObjects()
+	if err != nil {
+		return nil, err
+	}
+	commits, times := []string{}, map[string]int64{}
+	for _, sha := range dangling {
+		kind, err := ObjectType(sha)
+		if err != nil {
+			return nil, err
+		}
+		if kind != CommitKind {
+			continue
+		}
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return nil, err
+		}
+		commits, times[sha] = append(commits, sha), info.Time
+	}
+	sort.SliceStable(commits, func(i, j int) bool { return times[commits[i]] > times[commits[j]] })
+	return commits, nil
+}
+
+// directReferences lists the objects sha points to without recursing
+func directReferences(sha string) ([]string, error) {
+	gitObj, err := ReadGitObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := gitObj.(type) {
+	case *CommitAsBytes:
+		tree, err := obj.TreeHash()
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{tree}, obj.Parents()...), nil
+	case *Tag:
+		target, err := obj.Object()
+		if err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	case *Tree:
+		entries, err := obj.Entries()
+		if err != nil {
+			return nil, err
+		}
+		refs := []string{}
+		for _, e := range entries {
+			refs = append(refs, e.hash)
+		}
+		return refs, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,194 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnreachableObjects(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n"})
+	first := commitWorktree(t, "first")
+	reachable, err := ReachableObjects([]string{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an orphan commit with a tree and a blob of its own
+	writeFiles(t, map[string]string{"file": "orphan\n"})
+	orphan := commitWorktree(t, "orphan")
+	if err := UpdateRef("refs/heads/main", first); err != nil {
+		t.Fatal(err)
+	}
+	// a reflog like git writes still reaches the orphan, nothing is lost yet
+	writeFiles(t, map[string]string{gitPath("logs", "refs", "heads", "main"): zeroHash + " " + orphan + " Tester <tester@example.com> 1700000000 +0000\tcommit: orphan\n"})
+	if _, dangling, err := UnreachableObjects(); err != nil || len(dangling) != 0 {
+		t.Fatalf("dangling with the reflog: %v, %v", dangling, err)
+	}
+	if err := os.RemoveAll(gitPath("logs")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a staged blob is kept by the index
+	writeFiles(t, map[string]string{"staged": "staged\n"})
+	if _, err := runCommand(t, "add", "staged"); err != nil {
+		t.Fatal(err)
+	}
+	// a blob nothing points to
+	loose, err := WriteContent(&Blob{content: []byte("loose\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unreachable, dangling, err := UnreachableObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphanTree := treeOf(t, orphan)
+	wantUnreachable := []string{orphan, orphanTree, blobSha("orphan\n"), fmt.Sprintf("%x", loose)}
+	slices.Sort(wantUnreachable)
+	slices.Sort(unreachable)
+	if !slices.Equal(unreachable, wantUnreachable) {
+		t.Errorf("unreachable %v, want %v", unreachable, wantUnreachable)
+	}
+	// the orphan tree and blob are reached from the orphan commit
+	wantDangling := []string{orphan, fmt.Sprintf("%x", loose)}
+	slices.Sort(wantDangling)
+	slices.Sort(dangling)
+	if !slices.Equal(dangling, wantDangling) {
+		t.Errorf("dangling %v, want %v", dangling, wantDangling)
+	}
+	for _, sha := range append(reachable, blobSha("staged\n")) {
+		if slices.Contains(unreachable, sha) {
+			t.Errorf("reachable %s is reported unreachable", sha)
+		}
+	}
+
+	output, err := runCommand(t, "fsck", "--dangling")
+	want := ""
+	for _, sha := range dangling {
+		kind, _ := ObjectType(sha)
+		want += fmt.Sprintf("dangling %s %s\n", kind, sha)
+	}
+	if err != nil || output != want {
+		t.Errorf("fsck --dangling printed\n%s%v\nwant\n%s", output, err, want)
+	}
+}
+
+// treeOf is the tree of the commit at sha
+func treeOf(t *testing.T, sha string) string {
+	t.Helper()
+	_, content, err := readObject(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _ := strings.Cut(string(content), "\n")
+	return strings.TrimPrefix(header, "tree ")
+}
+
+func TestReachabilityPathPrefersRefsToTheIndex(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"dir/file": "file\n"})
+	commit := commitWorktree(t, "first")
+	if _, err := runCommand(t, "add", "dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	root, steps, err := ReachabilityPath(blobSha("file\n"))
+	if err != nil || root != "refs/heads/main" || len(steps) != 4 || steps[0].Sha != commit {
+		t.Errorf("the committed blob is reached from %s through %v, %v", root, steps, err)
+	}
+
+	// only the index keeps a staged blob
+	writeFiles(t, map[string]string{"dir/file": "staged\n"})
+	if _, err := runCommand(t, "add", "dir/file"); err != nil {
+		t.Fatal(err)
+	}
+	root, steps, err = ReachabilityPath(blobSha("staged\n"))
+	if err != nil || root != ":dir/file" || len(steps) != 1 {
+		t.Errorf("the staged blob is reached from %s through %v, %v", root, steps, err)
+	}
+}
+
+func TestReachabilityPathMatchesGit(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"a/b/file": "deep\n"})
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "nested")
+	// only a branch other than HEAD's reaches the blob
+	runGit(t, "branch", "topic")
+	branch := runGit(t, "symbolic-ref", "HEAD")
+	runGit(t, "checkout", "-q", "--detach")
+	runGit(t, "branch", "-q", "-D", strings.TrimPrefix(branch, "refs/heads/"))
+
+	rev := func(name string) string { return runGit(t, "rev-parse", name) }
+	want := "refs/heads/topic -> commit " + rev("topic") + " -> tree " + rev("topic^{tree}") +
+		" -> tree " + rev("topic:a") + " (a) -> tree " + rev("topic:a/b") + " (a/b) -> blob " + rev("topic:a/b/file") + " (a/b/file)"
+	output, err := runCommand(t, "reachability-path", rev("topic:a/b/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(output) != want {
+		t.Errorf("reachability-path printed\n%s\nwant\n%s", output, want)
+	}
+
+	lost := strings.TrimSpace(string(gitOutput(t, []byte("lost\n"), "hash-object", "-w", "--stdin")))
+	output, err = runCommand(t, "reachability-path", lost)
+	var exit ExitStatus
+	if !errors.As(err, &exit) || exit != 1 || strings.TrimSpace(output) != "unreachable" {
+		t.Errorf("reachability-path of an unreachable blob printed %q, %v", output, err)
+	}
+}
+
+func TestLostFound(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n"})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "two\n"})
+	commitWorktree(t, "second")
+	writeFiles(t, map[string]string{"file": "three\n"})
+	third := commitWorktree(t, "third: reset away")
+	if err := UpdateRef("refs/heads/main", first); err != nil {
+		t.Fatal(err)
+	}
+	// a branch deleted after a commit of its own
+	writeFiles(t, map[string]string{"file": "topic\n"})
+	topic := commitWorktree(t, "topic")
+	if err := UpdateRef("refs/heads/main", first); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tips only, newest first, the second commit is reached from the third
+	lost, err := LostCommits()
+	if err != nil || strings.Join(lost, " ") != topic+" "+third {
+		t.Fatalf("lost %v, %v, want %s %s", lost, err, topic, third)
+	}
+	date := func(minutes int) string {
+		return testTime.Add(time.Duration(minutes) * time.Minute).Format("2006-01-02 15:04:05 -0700")
+	}
+	want := topic + " " + date(0) + " topic\n" + third + " " + date(-1) + " third: reset away\n"
+	output, err := runCommand(t, "lost-found")
+	if err != nil || output != want {
+		t.Errorf("lost-found printed\n%s\nwant\n%s", output, want)
+	}
+	if _, err := ResolveRef("refs/lost-found/" + topic); err == nil {
+		t.Error("lost-found wrote refs without --write")
+	}
+
+	if output, err := runCommand(t, "lost-found", "--write"); err != nil || output != want {
+		t.Errorf("lost-found --write printed %q, %v", output, err)
+	}
+	for _, sha := range []string{topic, third} {
+		if hash, err := ResolveRef("refs/lost-found/" + sha); err != nil || hash != sha {
+			t.Errorf("refs/lost-found/%s is %s, %v", sha, hash, err)
+		}
+	}
+	// the refs keep them, nothing is lost anymore
+	if output, err := runCommand(t, "lost-found"); err != nil || output != "" {
+		t.Errorf("lost-found printed %q, %v once recovered", output, err)
+	}
+}
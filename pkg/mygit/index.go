@@ -0,0 +1,763 @@
+package mygit
+
+// https://git-scm.com/docs/index-format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	InvalidIndex  = errors.New("File cannot be parsed into an index.")
+	IndexLocked   = errors.New("Index is locked by another process")
+	UnmergedIndex = errors.New("Index has unmerged paths.")
+	MissingStage  = errors.New("Unmerged path has no entry at that stage.")
+)
+
+const (
+	indexNameMask   = 0x0fff // name length in the flags, saturated
+	indexStageMask  = 0x3000 // merge stage in the flags
+	indexStageShift = 12
+	indexExtended   = 0x4000 // extended flags follow, version 3 and up
+
+	IndexSkipWorktree = 0x4000 // extended flags
+	IndexIntentToAdd  = 0x2000 // staged with add -N, not in write-tree
+)
+
+// indexEntrySize is the fixed fields, the sha and the flags of an entry,
+// the extended flags when there are some and the path follow
+func indexEntrySize() int {
+	return 40 + hashSize() + 2
+}
+
+// the stages of an unmerged path, a merged one only has stage 0
+const (
+	StageBase   = 1 // the common ancestor
+	StageOurs   = 2
+	StageTheirs = 3
+)
+
+// IndexEntry is a staged file with what stat said of it when it was added,
+// so that an unchanged file is not hashed again
+type IndexEntry struct {
+	CTime [2]uint32 // seconds and nanoseconds
+	MTime [2]uint32
+	Dev   uint32
+	Ino   uint32
+	Mode  uint32 // 0100644, 0100755, 0120000 or 0160000
+	UID   uint32
+	GID   uint32
+	Size  uint32 // truncated to 32 bits like git does
+	Hash  string
+	Flags uint16 // the stage, the name length is recomputed when writing
+	// IndexSkipWorktree and IndexIntentToAdd, they need version 3
+	ExtendedFlags uint16
+	Path          string // relative to the worktree, with forward slashes
+}
+
+// CacheTree is the TREE extension, the trees of the index directories as
+// write-tree last computed them. A directory whose entries changed since is
+// invalid, its Entries is -1, and so are its parents up to the root.
+type CacheTree struct {
+	Name     string // of the directory, empty for the root
+	Entries  int    // index entries under it, -1 when invalid
+	Hash     string // only meaningful while valid
+	Subtrees []*CacheTree
+}
+
+type Index struct {
+	Version uint32       // 2, 3 or 4, the one written back
+	Entries []IndexEntry // sorted by path then stage
+	Tree    *CacheTree   // nil without a TREE extension
+}
+
+// indexFile is the index of the repository, $GIT_INDEX_FILE like git
+func indexFile() string {
+	if file := os.Getenv("GIT_INDEX_FILE"); file != "" {
+		return file
+	}
+	return gitPath("index")
+}
+
+// ReadIndex reads the index of the repository, a missing one is empty
+func ReadIndex() (*Index, error) {
+	data, err := os.ReadFile(indexFile())
+	if os.IsNotExist(err) {
+		return &Index{Version: 2}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndex(data)
+}
+
+// HasIndex reports whether the repository has an index file
+func HasIndex() bool {
+	_, err := os.Stat(indexFile())
+	return err == nil
+}
+
+func ParseIndex(data []byte) (*Index, error) {
+	if len(data) < 12+hashSize() || !bytes.Equal(data[:4], []byte("DIRC")) {
+		return nil, InvalidIndex
+	}
+	body := data[:len(data)-hashSize()]
+	if sum := objectHasher.Sum(body); !bytes.Equal(sum, data[len(body):]) {
+		return nil, fmt.Errorf("%w: bad checksum", InvalidIndex)
+	}
+	idx := &Index{Version: binary.BigEndian.Uint32(data[4:8])}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, fmt.Errorf("%w: unsupported version %d", InvalidIndex, idx.Version)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	at, previous := 12, ""
+	for range count {
+		if at+indexEntrySize() > len(body) {
+			return nil, InvalidIndex
+		}
+		row, flags := body[at:], indexEntrySize()-2
+		word := func(i int) uint32 { return binary.BigEndian.Uint32(row[i*4:]) }
+		e := IndexEntry{
+			CTime: [2]uint32{word(0), word(1)},
+			MTime: [2]uint32{word(2), word(3)},
+			Dev:   word(4),
+			Ino:   word(5),
+			Mode:  word(6),
+			UID:   word(7),
+			GID:   word(8),
+			Size:  word(9),
+			Hash:  hex.EncodeToString(row[40:flags]),
+			Flags: binary.BigEndian.Uint16(row[flags:]),
+		}
+		size := indexEntrySize()
+		if e.Flags&indexExtended != 0 {
+			if idx.Version < 3 || size+2 > len(row) {
+				return nil, InvalidIndex
+			}
+			e.ExtendedFlags = binary.BigEndian.Uint16(row[size:])
+			size += 2
+		}
+
+		name := row[size:]
+		if idx.Version == 4 {
+			// the path drops that many bytes from the end of the previous
+			// one and appends the rest, without padding
+			r := bytes.NewReader(name)
+			strip, err := readOfsDeltaOffset(r)
+			if err != nil || strip > uint64(len(previous)) {
+				return nil, InvalidIndex
+			}
+			size += len(name) - r.Len()
+			name = name[len(name)-r.Len():]
+			end := bytes.IndexByte(name, 0)
+			if end < 0 {
+				return nil, InvalidIndex
+			}
+			e.Path = previous[:len(previous)-int(strip)] + string(name[:end])
+			at += size + end + 1
+		} else {
+			end := bytes.IndexByte(name, 0)
+			if end < 0 {
+				return nil, InvalidIndex
+			}
+			e.Path = string(name[:end])
+			// the path is followed by 1 to 8 NULs, to a multiple of 8 bytes
+			at += (size + end + 8) &^ 7
+		}
+		previous = e.Path
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	for at < len(body) {
+		if at+8 > len(body) {
+			return nil, InvalidIndex
+		}
+		signature := string(body[at : at+4])
+		size := int(binary.BigEndian.Uint32(body[at+4:]))
+		at += 8
+		if at+size > len(body) {
+			return nil, InvalidIndex
+		}
+		switch ext := body[at : at+size]; {
+		case signature == "TREE":
+			tree, rest, err := parseCacheTree(ext)
+			if err != nil {
+				return nil, err
+			}
+			if len(rest) != 0 {
+				return nil, fmt.Errorf("%w: trailing bytes in TREE", InvalidIndex)
+			}
+			idx.Tree = tree
+		case signature[0] < 'A' || signature[0] > 'Z':
+			// a lowercase signature means the index cannot be used without it
+			return nil, fmt.Errorf("%w: unsupported extension %q", InvalidIndex, signature)
+		default:
+			// optional, like the resolve-undo or untracked cache ones, and
+			// dropped on the next write as git does with what it ignores
+		}
+		at += size
+	}
+	return idx, nil
+}
+
+// each node: the NUL terminated name, "<entries> <subtrees>\n" in ASCII, the
+// sha when entries is not -1, then the subtrees
+func parseCacheTree(data []byte) (*CacheTree, []byte, error) {
+	name, rest, found := bytes.Cut(data, []byte{0})
+	if !found {
+		return nil, nil, InvalidIndex
+	}
+	counts, rest, found := bytes.Cut(rest, []byte{'\n'})
+	if !found {
+		return nil, nil, InvalidIndex
+	}
+	entries, subtrees, found := strings.Cut(string(counts), " ")
+	tree := &CacheTree{Name: string(name)}
+	var err error
+	if tree.Entries, err = strconv.Atoi(entries); !found || err != nil || tree.Entries < -1 {
+		return nil, nil, InvalidIndex
+	}
+	count, err := strconv.Atoi(subtrees)
+	if err != nil || count < 0 {
+		return nil, nil, InvalidIndex
+	}
+	if size := hashSize(); tree.Entries >= 0 {
+		if len(rest) < size {
+			return nil, nil, InvalidIndex
+		}
+		tree.Hash, rest = hex.EncodeToString(rest[:size]), rest[size:]
+	}
+	for range count {
+		var subtree *CacheTree
+		if subtree, rest, err = parseCacheTree(rest); err != nil {
+			return nil, nil, err
+		}
+		tree.Subtrees = append(tree.Subtrees, subtree)
+	}
+	return tree, rest, nil
+}
+
+func (t *CacheTree) encode(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s\x00%d %d\n", t.Name, t.Entries, len(t.Subtrees))
+	if t.Entries >= 0 {
+		sha, _ := hex.DecodeString(t.Hash)
+		buf.Write(sha)
+	}
+	for _, subtree := range t.Subtrees {
+		subtree.encode(buf)
+	}
+}
+
+// subtree returns the child named name, creating it when create is set
+func (t *CacheTree) subtree(name string, create bool) *CacheTree {
+	for _, subtree := range t.Subtrees {
+		if subtree.Name == name {
+			return subtree
+		}
+	}
+	if !create {
+		return nil
+	}
+	subtree := &CacheTree{Name: name, Entries: -1}
+	t.Subtrees = append(t.Subtrees, subtree)
+	// git keeps them ordered by name length first
+	sort.Slice(t.Subtrees, func(i, j int) bool {
+		a, b := t.Subtrees[i].Name, t.Subtrees[j].Name
+		return len(a) < len(b) || (len(a) == len(b) && a < b)
+	})
+	return subtree
+}
+
+// Invalidate marks the directories holding file as changed, their siblings
+// keep their cached trees
+func (t *CacheTree) Invalidate(file string) {
+	for tree := t; tree != nil; {
+		tree.Entries = -1
+		dir, rest, found := strings.Cut(file, "/")
+		if !found {
+			return
+		}
+		tree, file = tree.subtree(dir, false), rest
+	}
+}
+
+// Write replaces the index of the repository, through index.lock so that
+// readers never see it half written
+func (idx *Index) Write() error {
+	// like git, version 2 becomes 3 to hold extended flags
+	version := max(idx.Version, 2)
+	for _, e := range idx.Entries {
+		if version == 2 && e.ExtendedFlags != 0 {
+			version = 3
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, []uint32{version, uint32(len(idx.Entries))})
+	previous := ""
+	for _, e := range idx.Entries {
+		sha, err := hex.DecodeString(e.Hash)
+		if err != nil || len(sha) != hashSize() {
+			return fmt.Errorf("%w: bad sha for %s", InvalidIndex, e.Path)
+		}
+		start := buf.Len()
+		binary.Write(&buf, binary.BigEndian, []uint32{
+			e.CTime[0], e.CTime[1], e.MTime[0], e.MTime[1],
+			e.Dev, e.Ino, e.Mode, e.UID, e.GID, e.Size,
+		})
+		buf.Write(sha)
+		flags := e.Flags&^(indexNameMask|indexExtended) | uint16(min(len(e.Path), indexNameMask))
+		if e.ExtendedFlags != 0 {
+			flags |= indexExtended
+		}
+		binary.Write(&buf, binary.BigEndian, flags)
+		if e.ExtendedFlags != 0 {
+			binary.Write(&buf, binary.BigEndian, e.ExtendedFlags)
+		}
+
+		if version == 4 {
+			common := 0
+			for common < min(len(previous), len(e.Path)) && previous[common] == e.Path[common] {
+				common++
+			}
+			writeOfsDeltaOffset(&buf, uint64(len(previous)-common))
+			buf.WriteString(e.Path[common:] + "\x00")
+			previous = e.Path
+			continue
+		}
+		buf.WriteString(e.Path)
+		buf.Write(make([]byte, 8-(buf.Len()-start)%8))
+	}
+	if idx.Tree != nil {
+		tree := bytes.Buffer{}
+		idx.Tree.encode(&tree)
+		buf.WriteString("TREE")
+		binary.Write(&buf, binary.BigEndian, uint32(tree.Len()))
+		buf.Write(tree.Bytes())
+	}
+	sum := objectHasher.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	lock := indexFile() + ".lock"
+	file, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s exists, remove it if no other process is running", IndexLocked, lock)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lock, indexFile())
+	}
+	if err != nil {
+		os.Remove(lock)
+	}
+	return err
+}
+
+// find returns where path is or would be inserted, the first of its stages
+// when it is unmerged
+func (idx *Index) find(path string) (int, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool { return idx.Entries[i].Path >= path })
+	return i, i < len(idx.Entries) && idx.Entries[i].Path == path
+}
+
+// stages returns the entries at path, one per stage, as the bounds of
+// their range in the index
+func (idx *Index) stages(path string) (start, end int) {
+	start, _ = idx.find(path)
+	end = start
+	for end < len(idx.Entries) && idx.Entries[end].Path == path {
+		end++
+	}
+	return start, end
+}
+
+func (idx *Index) invalidate(path string) {
+	if idx.Tree != nil {
+		idx.Tree.Invalidate(path)
+	}
+}
+
+// Add stages e, replacing the entries at its path, all its stages when it
+// is unmerged so that adding it marks it resolved, and the ones a file and
+// a directory of the same name cannot coexist with
+func (idx *Index) Add(e IndexEntry) {
+	for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+		idx.Remove(dir)
+	}
+	idx.RemoveDir(e.Path)
+	idx.invalidate(e.Path)
+	start, end := idx.stages(e.Path)
+	idx.Entries = slices.Replace(idx.Entries, start, end, e)
+}
+
+// Remove unstages path, every stage of it, it reports whether it was staged
+func (idx *Index) Remove(path string) bool {
+	start, end := idx.stages(path)
+	if start == end {
+		return false
+	}
+	idx.invalidate(path)
+	idx.Entries = slices.Delete(idx.Entries, start, end)
+	return true
+}
+
+// RemoveDir unstages everything under dir
+func (idx *Index) RemoveDir(dir string) {
+	start, _ := idx.find(dir + "/")
+	end := start
+	for end < len(idx.Entries) && strings.HasPrefix(idx.Entries[end].Path, dir+"/") {
+		idx.invalidate(idx.Entries[end].Path)
+		end++
+	}
+	idx.Entries = append(idx.Entries[:start], idx.Entries[end:]...)
+}
+
+// Stage is the merge stage of the entry, 0 once merged
+func (e IndexEntry) Stage() int {
+	return int(e.Flags&indexStageMask) >> indexStageShift
+}
+
+// SetStage moves the entry to stage, 0 to 3
+func (e *IndexEntry) SetStage(stage int) {
+	e.Flags = e.Flags&^indexStageMask | uint16(stage)<<indexStageShift&indexStageMask
+}
+
+// Conflict is a path a merge left unmerged with its entry at each stage,
+// nil for the sides that do not have the file
+type Conflict struct {
+	Path   string
+	Base   *IndexEntry
+	Ours   *IndexEntry
+	Theirs *IndexEntry
+}
+
+// Description is how git status shows the conflict, like "both modified"
+func (c Conflict) Description() string {
+	switch {
+	case c.Ours != nil && c.Theirs != nil && c.Base != nil:
+		return "both modified"
+	case c.Ours != nil && c.Theirs != nil:
+		return "both added"
+	case c.Ours != nil && c.Base != nil:
+		return "deleted by them"
+	case c.Theirs != nil && c.Base != nil:
+		return "deleted by us"
+	case c.Ours != nil:
+		return "added by us"
+	case c.Theirs != nil:
+		return "added by them"
+	}
+	return "both deleted"
+}
+
+// Unmerged lists the paths with entries at stages 1 to 3, sorted by path.
+// The entries point into the index, until it changes.
+func (idx *Index) Unmerged() []Conflict {
+	conflicts := []Conflict{}
+	for i := range idx.Entries {
+		e := &idx.Entries[i]
+		if e.Stage() == 0 {
+			continue
+		}
+		if n := len(conflicts); n == 0 || conflicts[n-1].Path != e.Path {
+			conflicts = append(conflicts, Conflict{Path: e.Path})
+		}
+		c := &conflicts[len(conflicts)-1]
+		switch e.Stage() {
+		case StageBase:
+			c.Base = e
+		case StageOurs:
+			c.Ours = e
+		case StageTheirs:
+			c.Theirs = e
+		}
+	}
+	return conflicts
+}
+
+// AddConflict records c.Path as unmerged, its entries replaced by one per
+// side of c that has the file
+func (idx *Index) AddConflict(c Conflict) {
+	for dir := path.Dir(c.Path); dir != "."; dir = path.Dir(dir) {
+		idx.Remove(dir)
+	}
+	idx.Remove(c.Path)
+	idx.RemoveDir(c.Path)
+	idx.invalidate(c.Path)
+	entries := []IndexEntry{}
+	for stage, e := range []*IndexEntry{c.Base, c.Ours, c.Theirs} {
+		if e != nil {
+			staged := *e
+			staged.Path = c.Path
+			staged.SetStage(stage + 1)
+			entries = append(entries, staged)
+		}
+	}
+	start, _ := idx.find(c.Path)
+	idx.Entries = slices.Insert(idx.Entries, start, entries...)
+}
+
+// entry is the tree entry of the staged file, named by its path
+func (e IndexEntry) entry() entry {
+	kind := BlobKind
+	if e.Mode == 0o160000 {
+		kind = CommitKind
+	}
+	return entry{mode: strconv.FormatUint(uint64(e.Mode), 8), kind: kind, hash: e.Hash, name: e.Path}
+}
+
+// NewIndexEntry describes the file at name, hashing it or, for a symlink,
+// its target. Objects are only written when write is set.
+func NewIndexEntry(name string, write bool) (IndexEntry, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	e := IndexEntry{
+		MTime: [2]uint32{uint32(info.ModTime().Unix()), uint32(info.ModTime().Nanosecond())},
+		Size:  uint32(info.Size()),
+		Path:  name,
+	}
+	// git also records ctime, the device, the inode and the owner, left at
+	// zero here they only make git hash the file again to see it unchanged
+	e.CTime = e.MTime
+
+	var sha [20]byte
+	switch mode := info.Mode(); {
+	case mode&fs.ModeSymlink != 0:
+		e.Mode = 0o120000
+		target, err := os.Readlink(name)
+		if err != nil {
+			return e, err
+		}
+		sha, err = storeObject(&Blob{content: []byte(target)}, write)
+		if err != nil {
+			return e, err
+		}
+	case mode.IsRegular():
+		e.Mode = 0o100644
+		if mode.Perm()&0o111 != 0 {
+			e.Mode = 0o100755
+		}
+		if sha, err = HashFile(name, write); err != nil {
+			return e, err
+		}
+	default:
+		return e, fmt.Errorf("%w: %s is not a file", InvalidBlob, name)
+	}
+	e.Hash = hex.EncodeToString(sha[:])
+	return e, nil
+}
+
+// WriteTree builds the trees of the staged files and returns the root one.
+// Directories still valid in the TREE extension are not rebuilt, the others
+// are cached once computed. Objects are only written when write is set.
+func (idx *Index) WriteTree(write bool) ([20]byte, error) {
+	// like git, there is no tree to write until every conflict is resolved
+	if conflicts := idx.Unmerged(); len(conflicts) > 0 {
+		paths := []string{}
+		for _, c := range conflicts {
+			paths = append(paths, fmt.Sprintf("%s (%s)", c.Path, c.Description()))
+		}
+		return [20]byte{}, fmt.Errorf("%w: %s", UnmergedIndex, strings.Join(paths, ", "))
+	}
+	if idx.Tree == nil {
+		idx.Tree = &CacheTree{Entries: -1}
+	}
+	sha, _, err := writeIndexTree(idx.Entries, "", idx.Tree, write)
+	return sha, err
+}
+
+// writeIndexTree writes the tree of the directory prefix, whose entries come
+// first in entries, and returns how many of them it covers
+func writeIndexTree(entries []IndexEntry, prefix string, cache *CacheTree, write bool) (sha [20]byte, count int, _ error) {
+	if cache.Entries >= 0 && HasObject(cache.Hash) {
+		_, err := hex.Decode(sha[:], []byte(cache.Hash))
+		return sha, cache.Entries, err
+	}
+
+	content := bytes.Buffer{}
+	for count < len(entries) && strings.HasPrefix(entries[count].Path, prefix) {
+		e := entries[count]
+		name, _, isDir := strings.Cut(e.Path[len(prefix):], "/")
+		if isDir {
+			sub, n, err := writeIndexTree(entries[count:], prefix+name+"/", cache.subtree(name, true), write)
+			if err != nil {
+				return sha, 0, err
+			}
+			content.WriteString("40000 " + name + "\x00")
+			content.Write(sub[:])
+			count += n
+			continue
+		}
+		if e.ExtendedFlags&IndexIntentToAdd != 0 {
+			// nothing to commit yet, it still counts as covered
+			count++
+			continue
+		}
+		hash, err := hex.DecodeString(e.Hash)
+		if err != nil || len(hash) != 20 {
+			return sha, 0, fmt.Errorf("%w: bad sha for %s", InvalidIndex, e.Path)
+		}
+		fmt.Fprintf(&content, "%o %s\x00", e.Mode, name)
+		content.Write(hash)
+		count++
+	}
+
+	// directories gone from the index leave their cache behind
+	kept := cache.Subtrees[:0]
+	for _, subtree := range cache.Subtrees {
+		if subtree.Entries >= 0 {
+			kept = append(kept, subtree)
+		}
+	}
+	cache.Subtrees = kept
+
+	sha, err := storeObject(&Tree{content: content.Bytes()}, write)
+	if err != nil {
+		return sha, 0, err
+	}
+	cache.Entries, cache.Hash = count, hex.EncodeToString(sha[:])
+	return sha, count, nil
+}
+
+// stagePaths adds the files at paths, directories recursively, and unstages
+// the paths gone from the worktree
+func stagePaths(idx *Index, paths []string) error {
+	for _, name := range paths {
+		name = path.Clean(name)
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("%s: outside the worktree", name)
+		}
+		info, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			// the file was deleted, staging it stages the removal, unless
+			// the sparse checkout left it out of the worktree
+			removed, skipped := false, false
+			for _, e := range slices.Clone(idx.Entries) {
+				if name != "." && e.Path != name && !strings.HasPrefix(e.Path, name+"/") {
+					continue
+				}
+				if e.ExtendedFlags&IndexSkipWorktree != 0 {
+					skipped = true
+					continue
+				}
+				removed = idx.Remove(e.Path) || removed
+			}
+			if !removed && skipped {
+				return fmt.Errorf("%s is outside of the sparse-checkout definition, not updated in the index", name)
+			}
+			if !removed {
+				return fmt.Errorf("pathspec '%s' did not match any files", name)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			e, err := NewIndexEntry(name, true)
+			if err != nil {
+				return err
+			}
+			idx.Add(e)
+			continue
+		}
+
+		// files deleted under the directory are unstaged too
+		present := map[string]bool{}
+		err = fs.WalkDir(os.DirFS(name), ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			if d.IsDir() {
+				return nil
+			}
+			e, err := NewIndexEntry(path.Join(name, p), true)
+			if err != nil {
+				return err
+			}
+			present[e.Path] = true
+			idx.Add(e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range append([]IndexEntry{}, idx.Entries...) {
+			if (name == "." || strings.HasPrefix(e.Path, name+"/")) && !present[e.Path] && e.ExtendedFlags&IndexSkipWorktree == 0 {
+				idx.Remove(e.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveConflicts takes the side at stage, StageOurs or StageTheirs, of the
+// unmerged paths specs match: the worktree file is replaced by its blob and
+// the path is staged at stage 0, resolved. Nothing changes when one of them
+// lacks that side, like a file the other branch deleted. The resolved paths
+// are returned.
+func ResolveConflicts(idx *Index, specs []Pathspec, stage int) ([]string, error) {
+	matched, chosen := false, []IndexEntry{}
+	for _, e := range idx.Entries {
+		if !matchesPathspecs(e.Path, specs) {
+			continue
+		}
+		matched = true
+		if e.Stage() == 0 || len(chosen) > 0 && chosen[len(chosen)-1].Path == e.Path {
+			continue
+		}
+		start, end := idx.stages(e.Path)
+		i := slices.IndexFunc(idx.Entries[start:end], func(staged IndexEntry) bool { return staged.Stage() == stage })
+		if i < 0 {
+			side := map[int]string{StageOurs: "our", StageTheirs: "their"}[stage]
+			return nil, fmt.Errorf("%w: '%s' does not have %s version", MissingStage, e.Path, side)
+		}
+		chosen = append(chosen, idx.Entries[start+i])
+	}
+	if !matched {
+		originals := []string{}
+		for _, spec := range specs {
+			originals = append(originals, spec.Original)
+		}
+		return nil, fmt.Errorf("pathspec '%s' did not match any files", strings.Join(originals, " "))
+	}
+
+	resolved := []string{}
+	for _, e := range chosen {
+		staged := e.entry()
+		if err := writeWorktreeFile(e.Path, staged); err != nil {
+			return resolved, err
+		}
+		// the stat of the new file, so it is not seen as modified
+		e, err := NewIndexEntry(e.Path, false)
+		if err != nil {
+			return resolved, err
+		}
+		idx.Add(e)
+		resolved = append(resolved, e.Path)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,253 @@
+package mygit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readIndexFile returns the bytes of the index of the repository
+func readIndexFile(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(indexFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCacheTreeMatchesGit(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		"a/file":        "inside a\n",
+		"a/nested/file": "deeper\n",
+		"b/file":        "inside b\n",
+		"top":           "top\n",
+	})
+	runGit(t, "add", "-A")
+	runGit(t, "write-tree")
+
+	// add invalidates the cached trees of a/ and a/nested/ only
+	writeFiles(t, map[string]string{"a/nested/file": "changed\n"})
+	if _, err := runCommand(t, "add", "a/nested/file"); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := idx.Tree.subtree("a", false), idx.Tree.subtree("b", false)
+	if idx.Tree.Entries != -1 || a.Entries != -1 || a.subtree("nested", false).Entries != -1 || b.Entries < 0 {
+		t.Errorf("after add the cached trees are %+v", idx.Tree)
+	}
+
+	// write-tree must then leave the same index as git
+	added := readIndexFile(t)
+	output, err := runCommand(t, "write-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := readIndexFile(t)
+	if err := os.WriteFile(indexFile(), added, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if tree := runGit(t, "write-tree"); strings.TrimSpace(output) != tree {
+		t.Errorf("write-tree printed %s, git wrote %s", output, tree)
+	}
+	if want := readIndexFile(t); !bytes.Equal(written, want) {
+		t.Errorf("write-tree left the index\n%q\ngit left\n%q", written, want)
+	}
+}
+
+func TestIndexVersionsRoundTrip(t *testing.T) {
+	testRepository(t)
+	// a long path then a short one, version 4 strips more than the short
+	// one is long
+	writeFiles(t, map[string]string{
+		"a/very/long/nested/path/to/a/file": "deep\n",
+		"b":                                 "short\n",
+		"b.c/file":                          "dot\n",
+		"skipped":                           "skipped\n",
+	})
+	runGit(t, "add", "-A")
+	runGit(t, "update-index", "--skip-worktree", "skipped")
+
+	for _, version := range []string{"3", "4"} {
+		runGit(t, "update-index", "--index-version", version)
+		runGit(t, "write-tree")
+		want := readIndexFile(t)
+		idx, err := ParseIndex(want)
+		if err != nil {
+			t.Fatalf("version %s: %v", version, err)
+		}
+		paths := []string{}
+		for _, e := range idx.Entries {
+			paths = append(paths, e.Path)
+		}
+		if got, files := strings.Join(paths, "\n"), runGit(t, "ls-files"); got != files {
+			t.Errorf("version %s has the paths\n%s\ngit lists\n%s", version, got, files)
+		}
+
+		// written back as it is
+		if err := idx.Write(); err != nil {
+			t.Fatal(err)
+		}
+		if got := readIndexFile(t); !bytes.Equal(got, want) {
+			t.Errorf("version %s written back as\n%q\ngit wrote\n%q", version, got, want)
+		}
+		matchGit(t, "write-tree")
+	}
+}
+
+func TestIndexV4StripBeyondPreviousPath(t *testing.T) {
+	testRepository(t)
+	idx := &Index{Version: 4, Entries: []IndexEntry{
+		{Path: "ab", Mode: 0o100644, Hash: blobSha("ab")},
+		{Path: "cd", Mode: 0o100644, Hash: blobSha("cd")},
+	}}
+	if err := idx.Write(); err != nil {
+		t.Fatal(err)
+	}
+	data := readIndexFile(t)
+	if _, err := ParseIndex(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second path strips 3 bytes from "ab"
+	strip := 12 + indexEntrySize() + len("\x00ab\x00") + indexEntrySize()
+	if data[strip] != 2 {
+		t.Fatalf("the second entry strips %d bytes", data[strip])
+	}
+	data[strip] = 3
+	body := data[:len(data)-hashSize()]
+	data = append(body, objectHasher.Sum(body)...)
+	if _, err := ParseIndex(data); !errors.Is(err, InvalidIndex) {
+		t.Errorf("parsing a strip beyond the previous path: %v", err)
+	}
+}
+
+func TestIndexSHA256RoundTrip(t *testing.T) {
+	testRepository(t)
+	if err := os.RemoveAll(".git"); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "init", "-q", "--object-format=sha256")
+	if err := loadObjectFormat(); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"file": "top\n", "dir/nested": "nested\n"})
+	runGit(t, "add", "-A")
+	tree := runGit(t, "write-tree")
+
+	// the entries and the cached trees hold 32-byte ids
+	want := readIndexFile(t)
+	idx, err := ParseIndex(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range idx.Entries {
+		if sha := runGit(t, "rev-parse", ":"+e.Path); e.Hash != sha {
+			t.Errorf("%s is staged as %s, git has %s", e.Path, e.Hash, sha)
+		}
+	}
+	if idx.Tree == nil || idx.Tree.Hash != tree {
+		t.Errorf("the cached tree is %+v, git wrote %s", idx.Tree, tree)
+	}
+	if err := idx.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readIndexFile(t); !bytes.Equal(got, want) {
+		t.Errorf("written back as\n%q\ngit wrote\n%q", got, want)
+	}
+
+	runGit(t, "commit", "-q", "-m", "sha256")
+	commit := CommitAsBytes{content: gitOutput(t, nil, "cat-file", "commit", "HEAD")}
+	if hash, err := commit.TreeHash(); err != nil || hash != tree {
+		t.Errorf("TreeHash = %s, %v, want %s", hash, err, tree)
+	}
+}
+
+// conflictedMerge leaves with git a merge of other into main stopped on
+// conflicts: f changed on both sides, g changed on main and removed on
+// other
+func conflictedMerge(t *testing.T) {
+	t.Helper()
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	writeFiles(t, map[string]string{"f": "base\n", "g": "base\n"})
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "base")
+	runGit(t, "checkout", "-q", "-b", "other")
+	writeFiles(t, map[string]string{"f": "theirs\n"})
+	runGit(t, "rm", "-q", "g")
+	runGit(t, "commit", "-q", "-a", "-m", "theirs")
+	runGit(t, "checkout", "-q", "main")
+	writeFiles(t, map[string]string{"f": "ours\n", "g": "ours\n"})
+	runGit(t, "commit", "-q", "-a", "-m", "ours")
+	if _, status := gitStatus(t, nil, "merge", "-q", "other"); status == 0 {
+		t.Fatal("the merge has no conflicts")
+	}
+}
+
+func TestStagesMatchGit(t *testing.T) {
+	testRepository(t)
+	conflictedMerge(t)
+	matchGit(t, "ls-files")
+	matchGit(t, "ls-files", "-s")
+	matchGit(t, "ls-files", "-u")
+	if _, err := runCommand(t, "-q", "write-tree", "--dry-run"); err == nil {
+		t.Error("write-tree succeeded with conflicts")
+	}
+
+	// add leaves the path resolved like git add does
+	conflicted := readIndexFile(t)
+	runGit(t, "add", "f")
+	want := runGit(t, "ls-files", "-s")
+	if err := os.WriteFile(indexFile(), conflicted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "add", "f"); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, "ls-files", "-s"); got != want {
+		t.Errorf("add f left\n%s\ngit add left\n%s", got, want)
+	}
+}
+
+func TestCheckoutSideMatchesGit(t *testing.T) {
+	testRepository(t)
+	conflictedMerge(t)
+	conflicted := readIndexFile(t)
+
+	// that side in the worktree, staged resolved
+	for side, stage := range map[string]string{"--ours": "2", "--theirs": "3"} {
+		if err := os.WriteFile(indexFile(), conflicted, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sha := runGit(t, "rev-parse", ":"+stage+":f")
+		if _, err := runCommand(t, "-q", "checkout", side, "f"); err != nil {
+			t.Fatalf("checkout %s f: %v", side, err)
+		}
+		if got, want := runGit(t, "ls-files", "-s", "f"), "100644 "+sha+" 0\tf"; got != want {
+			t.Errorf("checkout %s f staged\n%s\nwant\n%s", side, got, want)
+		}
+		if got := runGit(t, "hash-object", "f"); got != sha {
+			t.Errorf("checkout %s f wrote %s, want %s", side, got, sha)
+		}
+	}
+
+	// g has no stage 3, git refuses too
+	if err := os.WriteFile(indexFile(), conflicted, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, status := gitStatus(t, nil, "checkout", "--theirs", "g"); status == 0 {
+		t.Fatal("git checkout --theirs g succeeded")
+	}
+	if _, err := runCommand(t, "-q", "checkout", "--theirs", "g"); err == nil {
+		t.Error("checkout --theirs g succeeded")
+	}
+	if !bytes.Equal(readIndexFile(t), conflicted) {
+		t.Error("the refused checkout changed the index")
+	}
+}
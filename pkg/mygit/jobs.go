@@ -0,0 +1,98 @@
+package mygit
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// jobsFlag is the worker count given with -j, 0 leaves it to the config
+var jobsFlag int
+
+// the worker count is looked up once per repository, dropObjectCaches
+// forgets it
+var (
+	jobs       int
+	jobsLoaded bool
+	jobsMu     sync.Mutex
+)
+
+// Jobs tells how many workers hash, write or check objects at once: the -j
+// flag, else mygit.jobs, else the number of CPUs. Like git's pack.threads a
+// configured 0 also means the number of CPUs.
+func Jobs() (int, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if jobsLoaded {
+		return jobs, nil
+	}
+	n := jobsFlag
+	if n == 0 {
+		cfg, err := LoadConfig(gitDir)
+		if err != nil {
+			return 0, err
+		}
+		if value, ok := cfg.Get("mygit.jobs"); ok {
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				return 0, fmt.Errorf("%w: mygit.jobs must be a number of workers, got %q", InvalidConfig, value)
+			}
+		}
+	}
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+	jobs, jobsLoaded = n, true
+	return n, nil
+}
+
+// forEachParallel calls fn with every index below count, on at most Jobs()
+// workers at once. Once fn fails or ctx is cancelled no other index starts,
+// the error of the lowest failed index is returned so the same one is
+// reported whatever the scheduling.
+func forEachParallel(ctx context.Context, count int, fn func(i int) error) error {
+	workers, err := Jobs()
+	if err != nil {
+		return err
+	}
+	workers = min(workers, count)
+
+	var (
+		mu       sync.Mutex
+		next     int
+		failed   = count // lowest failed index
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				stop := i >= count || firstErr != nil
+				mu.Unlock()
+				if stop {
+					return
+				}
+
+				err := ctx.Err()
+				if err == nil {
+					err = fn(i)
+				}
+				if err != nil {
+					mu.Lock()
+					if i < failed {
+						failed, firstErr = i, err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,95 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobsPerRepository(t *testing.T) {
+	saved := jobsFlag
+	t.Cleanup(func() { jobsFlag = saved })
+	jobsFlag = 0
+
+	for _, configured := range []string{"2", "5"} {
+		// each repository reads its own mygit.jobs
+		testRepository(t)
+		runGit(t, "config", "mygit.jobs", configured)
+		if n, err := Jobs(); err != nil || fmt.Sprint(n) != configured {
+			t.Errorf("Jobs() = %d, %v with mygit.jobs %s", n, err, configured)
+		}
+	}
+}
+
+func TestForEachParallelRespectsJobs(t *testing.T) {
+	testRepository(t)
+	saved := jobsFlag
+	t.Cleanup(func() { jobsFlag = saved })
+
+	for _, limit := range []int{1, 3} {
+		jobsFlag = limit
+		dropObjectCaches()
+		// a counting semaphore observing the workers, none may wait on it
+		running := make(chan struct{}, limit)
+		peak := make(chan int, 1)
+		peak <- 0
+		err := forEachParallel(context.Background(), 30, func(i int) error {
+			select {
+			case running <- struct{}{}:
+			default:
+				t.Errorf("index %d starts with %d workers already running", i, limit)
+				return nil
+			}
+			peak <- max(<-peak, len(running))
+			time.Sleep(time.Millisecond)
+			<-running
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := <-peak; got != limit {
+			t.Errorf("at most %d workers ran at once with -j %d", got, limit)
+		}
+	}
+}
+
+func TestWriteTreeJobsMatchesGit(t *testing.T) {
+	testRepository(t)
+	saved := jobsFlag
+	t.Cleanup(func() { jobsFlag = saved })
+	files := map[string]string{}
+	for i := 1; i <= 40; i++ {
+		files[fmt.Sprint("f", i)] = fmt.Sprintln(i)
+		files[fmt.Sprint("a/b/g", i)] = fmt.Sprintln(i)
+	}
+	writeFiles(t, files)
+
+	// without an index, the same tree whatever the number of workers
+	trees := map[string]string{}
+	for _, args := range [][]string{{"-j", "1"}, {"-j", "4"}, {}} {
+		if len(args) == 0 {
+			runGit(t, "config", "mygit.jobs", "3")
+		}
+		output, err := runCommand(t, append(args, "write-tree", "--dry-run")...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		trees[fmt.Sprint(args)] = strings.TrimSpace(output)
+	}
+	runGit(t, "config", "mygit.jobs", "many")
+	if _, err := runCommand(t, "-q", "write-tree", "--dry-run"); !errors.Is(err, InvalidConfig) {
+		t.Errorf("write-tree with mygit.jobs many: %v", err)
+	}
+
+	runGit(t, "add", "-A")
+	want := runGit(t, "write-tree")
+	for args, tree := range trees {
+		if tree != want {
+			t.Errorf("write-tree %s printed %s, git wrote %s", args, tree, want)
+		}
+	}
+}
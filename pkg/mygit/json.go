@@ -0,0 +1,87 @@
+package mygit
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// jsonOutput is set by --json, structured commands then print JSON instead of
+// git's text format. The field names below are what tools rely on, they only
+// ever get added to.
+var jsonOutput bool
+
+type SignatureJSON struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"` // RFC 3339, in the zone it was recorded in
+}
+
+type CommitJSON struct {
+	Hash      string        `json:"hash"`
+	Tree      string        `json:"tree"`
+	Parents   []string      `json:"parents"`
+	Author    SignatureJSON `json:"author"`
+	Committer SignatureJSON `json:"committer"`
+	Subject   string        `json:"subject"`
+	Message   string        `json:"message"`
+}
+
+type TreeEntryJSON struct {
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+}
+
+type RefJSON struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+type ObjectInfoJSON struct {
+	Hash    string `json:"hash"`
+	Type    string `json:"type,omitempty"`
+	Size    int64  `json:"size"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+type ReflogEntryJSON struct {
+	Selector  string        `json:"selector"` // like HEAD@{0}
+	Old       string        `json:"old"`
+	New       string        `json:"new"`
+	Committer SignatureJSON `json:"committer"`
+	Operation string        `json:"operation"` // commit, checkout, reset... or other
+	Message   string        `json:"message"`
+}
+
+func newSignatureJSON(s Signature) SignatureJSON {
+	return SignatureJSON{Name: s.Name, Email: s.Email, Date: s.When.Format(time.RFC3339)}
+}
+
+func newCommitJSON(sha string, c *Commit) CommitJSON {
+	parents := c.parents
+	if parents == nil {
+		parents = []string{} // a root commit has [], not null
+	}
+	return CommitJSON{
+		Hash:      sha,
+		Tree:      c.tree,
+		Parents:   parents,
+		Author:    newSignatureJSON(c.author),
+		Committer: newSignatureJSON(c.committer),
+		Subject:   c.Subject(),
+		Message:   c.Message(),
+	}
+}
+
+func newTreeEntryJSON(e entry) TreeEntryJSON {
+	return TreeEntryJSON{Mode: e.mode, Type: string(e.kind), Hash: e.hash, Name: e.name}
+}
+
+// writeJSON prints v indented, followed by a newline
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,54 @@
+package mygit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFixture returns testdata/name, before the test leaves the package
+// directory
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// jsonHistory commits a small history at fixed dates, the shas in the
+// fixtures depend on them
+func jsonHistory(t *testing.T) {
+	t.Helper()
+	saved := testTime
+	testTime = time.Unix(1700000000, 0).UTC()
+	t.Cleanup(func() { testTime = saved })
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n", "dir/nested": "nested\n"})
+	commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "two\n"})
+	if err := os.Chmod("file", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitWorktree(t, "second\n\nwith a body")
+}
+
+func TestLogJSON(t *testing.T) {
+	want := readFixture(t, "log.json")
+	jsonHistory(t)
+	output, err := runCommand(t, "--json", "log")
+	if err != nil || output != want {
+		t.Errorf("log --json printed\n%s\n%v, want testdata/log.json", output, err)
+	}
+}
+
+func TestListTreeJSON(t *testing.T) {
+	want := readFixture(t, "ls-tree.json")
+	jsonHistory(t)
+	output, err := runCommand(t, "--json", "ls-tree", "main")
+	if err != nil || output != want {
+		t.Errorf("ls-tree --json printed\n%s\n%v, want testdata/ls-tree.json", output, err)
+	}
+}
@@ -0,0 +1,258 @@
+package mygit
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ObjectLayout is how the objects of a repository are stored
+type ObjectLayout string
+
+const (
+	LooseLayout  ObjectLayout = "loose"  // a file per object
+	PackedLayout ObjectLayout = "packed" // all in a single pack
+)
+
+// ConvertLayout stores every object of the repository in layout, returning
+// how many were moved. The new copies are read back and checked against their
+// ids before the old ones are removed, a failure leaves the repository
+// readable with possibly both copies. Like git repack, packs with a .keep file
+// are left alone.
+func ConvertLayout(layout ObjectLayout) (int, error) {
+	packs, err := convertiblePacks()
+	if err != nil {
+		return 0, err
+	}
+	// drop the cached indexes, the packs change under them
+	defer dropObjectCaches()
+
+	switch layout {
+	case PackedLayout:
+		return packAllObjects(packs)
+	case LooseLayout:
+		return unpackAllObjects(packs)
+	}
+	return 0, fmt.Errorf("unknown object layout %q", layout)
+}
+
+// convertiblePacks lists the .pack files of the repository without a .keep
+func convertiblePacks() ([]string, error) {
+	files, err := filepath.Glob(gitPath("objects", "pack", "*.pack"))
+	if err != nil {
+		return nil, err
+	}
+	packs := []string{}
+	for _, pack := range files {
+		if _, err := os.Stat(strings.TrimSuffix(pack, ".pack") + ".keep"); err == nil {
+			Verbosef("Keeping %s\n", pack)
+			continue
+		}
+		packs = append(packs, pack)
+	}
+	return packs, nil
+}
+
+func isPromisorPack(pack string) bool {
+	_, err := os.Stat(strings.TrimSuffix(pack, ".pack") + ".promisor")
+	return err == nil
+}
+
+// packAllObjects writes the loose objects and those of packs to a new pack,
+// which is a promisor pack when one of them was
+func packAllObjects(packs []string) (int, error) {
+	loose, err := looseObjects()
+	if err != nil {
+		return 0, err
+	}
+	objects, promisor := slices.Clone(loose), false
+	for _, pack := range packs {
+		idx, err := ReadPackIndex(strings.TrimSuffix(pack, ".pack") + ".idx")
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", err, pack)
+		}
+		for _, sha := range idx.shas {
+			objects = append(objects, fmt.Sprintf("%x", sha))
+		}
+		promisor = promisor || isPromisorPack(pack)
+	}
+	slices.Sort(objects)
+	objects = slices.Compact(objects)
+	if len(objects) == 0 {
+		return 0, nil
+	}
+
+	name, err := writePackFiles(gitPath("objects", "pack", "pack"), objects, PackOptions{}, promisor)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, pack := range packs {
+		if pack != name+".pack" {
+			if err := removePack(pack); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(objects), removeLooseObjects(loose)
+}
+
+// writePackFiles writes the objects to <base>-<checksum>.pack with its index,
+// and a .promisor file when promisor is set, returning the path without the
+// extension. The pack is read back before it is given its name.
+func writePackFiles(base string, objects []string, opts PackOptions, promisor bool) (string, error) {
+	dir := path.Dir(base)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmpPack, err := os.CreateTemp(dir, "tmp_pack_*.pack")
+	if err != nil {
+		return "", err
+	}
+	tmpIdx := strings.TrimSuffix(tmpPack.Name(), ".pack") + ".idx"
+	defer os.Remove(tmpPack.Name())
+	defer os.Remove(tmpIdx)
+	entries, checksum, err := writePack(tmpPack, objects, opts)
+	if closeErr := tmpPack.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	idxFile, err := os.OpenFile(tmpIdx, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o444)
+	if err != nil {
+		return "", err
+	}
+	err = writePackIndex(idxFile, entries, checksum)
+	if closeErr := idxFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := verifyPack(tmpIdx); err != nil {
+		return "", err
+	}
+
+	// the pack goes first, an index is only ever found next to its pack
+	name := fmt.Sprintf("%s-%x", base, checksum)
+	if promisor {
+		if err := os.WriteFile(name+".promisor", nil, 0o644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmpPack.Name(), name+".pack"); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpIdx, name+".idx"); err != nil {
+		return "", err
+	}
+	Verbosef("Wrote %s.pack\n", name)
+	return name, nil
+}
+
+// verifyPack reads back every object of the pack of idxFile and checks its id
+func verifyPack(idxFile string) error {
+	idx, err := ReadPackIndex(idxFile)
+	if err != nil {
+		return err
+	}
+	for i, sha := range idx.shas {
+		kind, content, err := readPackObjectAt(idx.pack, idx.offsets[i])
+		if err != nil {
+			return fmt.Errorf("%x: %w", sha, err)
+		}
+		if err := checkObjectID(fmt.Sprintf("%x", sha), kind, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkObjectID fails unless content hashes to sha
+func checkObjectID(sha string, kind ObjectKind, content []byte) error {
+	gitObj, err := newGitObject(kind, content)
+	if err != nil {
+		return err
+	}
+	if hash, _ := HashObject(gitObj); fmt.Sprintf("%x", hash) != sha {
+		return fmt.Errorf("%w: %s hashes to %x", CorruptObject, sha, hash)
+	}
+	return nil
+}
+
+// unpackAllObjects writes every object of the packs as a loose object, the
+// objects a promisor pack stands for could not be told missing anymore
+func unpackAllObjects(packs []string) (int, error) {
+	count := 0
+	for _, pack := range packs {
+		if isPromisorPack(pack) {
+			return 0, fmt.Errorf("%s is a promisor pack of a partial clone, its objects cannot be made loose", pack)
+		}
+	}
+	for _, pack := range packs {
+		idx, err := ReadPackIndex(strings.TrimSuffix(pack, ".pack") + ".idx")
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", err, pack)
+		}
+		for i, key := range idx.shas {
+			sha := fmt.Sprintf("%x", key)
+			kind, content, err := readPackObjectAt(pack, idx.offsets[i])
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", sha, err)
+			}
+			gitObj, err := newGitObject(kind, content)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := WriteContent(gitObj); err != nil {
+				return 0, err
+			}
+			// an existing loose copy is kept by WriteContent, check it too
+			kind, content, err = readLooseObject(sha)
+			if err != nil {
+				return 0, err
+			}
+			if err := checkObjectID(sha, kind, content); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+	for _, pack := range packs {
+		if err := removePack(pack); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// removePack deletes a pack and the files next to it, the index first so
+// that readers never find an index without its pack. The multi-pack-index
+// would point to it, it goes too.
+func removePack(pack string) error {
+	base := strings.TrimSuffix(pack, ".pack")
+	for _, file := range []string{base + ".idx", pack, base + ".rev", base + ".bitmap", base + ".promisor", gitPath("objects", "pack", "multi-pack-index")} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	Verbosef("Removed %s\n", pack)
+	return nil
+}
+
+// removeLooseObjects deletes the loose objects and the fanout directories
+// they leave empty
+func removeLooseObjects(shas []string) error {
+	for _, sha := range shas {
+		file := looseObjectPath(sha)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		os.Remove(path.Dir(file)) // fails unless empty
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package mygit
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertLayoutRoundTrip(t *testing.T) {
+	testRepository(t)
+	packedHistory(t, 10)
+	runGit(t, "tag", "-a", "-m", "annotated", "v1", "HEAD~3")
+	before := runGit(t, "cat-file", "--batch-all-objects", "--batch-check")
+	objects := strings.Count(before, "\n") + 1
+	layout := func() (loose, packs int) {
+		t.Helper()
+		shas, err := looseObjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes, err := filepath.Glob(".git/objects/pack/*.idx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(shas), len(indexes)
+	}
+
+	// from the deltified pack of git to loose objects
+	if _, err := runCommand(t, "-q", "convert-layout", "--to=loose"); err != nil {
+		t.Fatal(err)
+	}
+	if loose, packs := layout(); loose != objects || packs != 0 {
+		t.Errorf("converted to %d loose objects and %d packs, want %d loose objects", loose, packs, objects)
+	}
+
+	// to a single pack git reads back
+	if _, err := runCommand(t, "-q", "convert-layout", "--to=packed"); err != nil {
+		t.Fatal(err)
+	}
+	if loose, packs := layout(); loose != 0 || packs != 1 {
+		t.Errorf("converted to %d loose objects and %d packs, want a pack", loose, packs)
+	}
+	if fsck := runGit(t, "fsck", "--strict"); fsck != "" {
+		t.Errorf("git fsck of the pack: %s", fsck)
+	}
+	if got := runGit(t, "cat-file", "--batch-all-objects", "--batch-check"); got != before {
+		t.Errorf("the pack holds\n%s\nwant\n%s", got, before)
+	}
+
+	// and loose again without losing any object
+	if _, err := runCommand(t, "-q", "convert-layout", "--to=loose"); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, "cat-file", "--batch-all-objects", "--batch-check"); got != before {
+		t.Errorf("the loose objects are\n%s\nwant\n%s", got, before)
+	}
+}
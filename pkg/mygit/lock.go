@@ -0,0 +1,101 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	FileLocked = errors.New("File is locked by another process")
+)
+
+// a lock this old was most likely left by a process that died, git never
+// holds one longer than a command runs
+const staleLockAge = 10 * time.Minute
+
+// lockFile creates <file>.lock exclusively, how git keeps the writers of file
+// apart. The new content is written to the lock, which commitLock renames
+// over file.
+func lockFile(file string) (*os.File, error) {
+	lock, err := os.OpenFile(file+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s.lock exists, remove it if no other process is running", FileLocked, file)
+	}
+	return lock, err
+}
+
+// commitLock replaces the locked file with the lock, which is removed when
+// that fails
+func commitLock(lock *os.File) error {
+	err := lock.Close()
+	if err == nil {
+		err = os.Rename(lock.Name(), strings.TrimSuffix(lock.Name(), ".lock"))
+	}
+	if err != nil {
+		os.Remove(lock.Name())
+	}
+	return err
+}
+
+// rollbackLock releases the lock leaving the locked file untouched
+func rollbackLock(lock *os.File) {
+	lock.Close()
+	os.Remove(lock.Name())
+}
+
+type LockInfo struct {
+	Path string
+	Age  time.Duration
+}
+
+func (l LockInfo) Stale(threshold time.Duration) bool {
+	return l.Age >= threshold
+}
+
+// FindLocks lists the lock files in the repository, index.lock, config.lock,
+// packed-refs.lock and those of refs among others, oldest first. The objects
+// are skipped as they are written without locks.
+func FindLocks() ([]LockInfo, error) {
+	now, locks := time.Now(), []LockInfo{}
+	add := func(p string, info fs.FileInfo) {
+		locks = append(locks, LockInfo{Path: p, Age: now.Sub(info.ModTime())})
+	}
+	err := filepath.WalkDir(gitDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == gitPath("objects") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil // released while walking
+		}
+		if err != nil {
+			return err
+		}
+		add(p, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// $GIT_INDEX_FILE may live outside of the repository
+	index := indexFile() + ".lock"
+	if rel, err := filepath.Rel(gitDir, index); err != nil || strings.HasPrefix(rel, "..") {
+		if info, err := os.Stat(index); err == nil {
+			add(index, info)
+		}
+	}
+	sort.SliceStable(locks, func(i, j int) bool { return locks[i].Age > locks[j].Age })
+	return locks, nil
+}
@@ -0,0 +1,46 @@
+package mygit
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "locked\n", ".git/index.lock": "", ".git/config.lock": ""})
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(".git/index.lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale one is reported, then removed with --force
+	want := "stale\t2h0m0s\t.git/index.lock\nheld\t0s\t.git/config.lock\n"
+	output, err := runCommand(t, "locks")
+	if output != want || err != ExitStatus(1) {
+		t.Errorf("locks printed\n%s\n%v", output, err)
+	}
+	output, err = runCommand(t, "-q", "locks", "--force")
+	if output != want || err != nil {
+		t.Errorf("locks --force printed\n%s\n%v", output, err)
+	}
+	if _, err := os.Stat(".git/index.lock"); !os.IsNotExist(err) {
+		t.Errorf("the stale lock is still there: %v", err)
+	}
+	if _, err := os.Stat(".git/config.lock"); err != nil {
+		t.Errorf("the held lock was removed: %v", err)
+	}
+
+	// a held lock stops the writers, like it stops git
+	if _, err := runCommand(t, "sparse-checkout", "set", "dir"); !errors.Is(err, FileLocked) {
+		t.Errorf("sparse-checkout set with config.lock held: %v", err)
+	}
+	writeFiles(t, map[string]string{".git/index.lock": ""})
+	if _, err := runCommand(t, "add", "file"); !errors.Is(err, IndexLocked) {
+		t.Errorf("add with index.lock held: %v", err)
+	}
+	if _, status := gitStatus(t, nil, "add", "file"); status == 0 {
+		t.Error("git added with index.lock held")
+	}
+}
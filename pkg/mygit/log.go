@@ -0,0 +1,405 @@
+package mygit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type LogOptions struct {
+	MaxCount int // 0 means no limit
+	Oneline  bool
+	Mailmap  *Mailmap // nil shows identities as recorded
+	Paths    []string // only commits changing these files or directories
+	Follow   bool     // the single path is traced back across renames
+}
+
+// newLogFilter tells which commits of the walk are shown, all of them
+// without paths
+func newLogFilter(tips []string, opts LogOptions) (func(sha string, commit *Commit) (bool, error), error) {
+	if opts.Follow {
+		f := &followFilter{path: opts.Paths[0]}
+		return f.show, nil
+	}
+	f, err := newPathFilter(tips, opts.Paths)
+	if err != nil || f == nil {
+		return func(string, *Commit) (bool, error) { return true, nil }, err
+	}
+	return func(sha string, _ *Commit) (bool, error) { return f.shown[sha], nil }, nil
+}
+
+// pathFilter keeps the commits of a walk that change one of paths. Like git
+// log, a merge with the same paths as one of its parents is left out and only
+// that parent's history is followed, commits reached only through the others
+// are skipped.
+type pathFilter struct {
+	paths []string
+	shown map[string]bool
+}
+
+// newPathFilter decides for every commit reachable from tips up front, the
+// children of a commit are not always walked before it when dates are equal.
+// It is nil, keeping every commit, without paths.
+func newPathFilter(tips []string, paths []string) (*pathFilter, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	refs := []Ref{}
+	for _, tip := range tips {
+		refs = append(refs, Ref{Hash: tip})
+	}
+	order, err := parentsFirst(refs)
+	if err != nil {
+		return nil, err
+	}
+	f, followed := &pathFilter{paths: paths, shown: map[string]bool{}}, map[string]bool{}
+	for _, tip := range tips {
+		followed[tip] = true
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		if sha := order[i]; followed[sha] {
+			if f.shown[sha], err = f.follow(sha, followed); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// follow marks the parents whose history is followed from the commit at sha
+// and tells whether it changes the paths
+func (f *pathFilter) follow(sha string, followed map[string]bool) (bool, error) {
+	info, err := ReadCommitInfo(sha)
+	if err != nil {
+		return false, err
+	}
+	parents := info.Parents
+	if len(parents) == 0 {
+		parents = []string{""} // a root commit adds all its paths
+	}
+	changed := false
+	for _, parent := range parents {
+		tree := ""
+		if parent != "" {
+			parentInfo, err := ReadCommitInfo(parent)
+			if err != nil {
+				return false, err
+			}
+			tree = parentInfo.Tree
+		}
+		same, err := f.sameTrees(tree, info.Tree)
+		if err != nil {
+			return false, err
+		}
+		if same && len(parents) > 1 {
+			followed[parent] = true
+			return false, nil
+		}
+		changed = changed || !same
+	}
+	for _, parent := range info.Parents {
+		followed[parent] = true
+	}
+	return changed, nil
+}
+
+// sameTrees tells whether the paths are the same in both trees
+func (f *pathFilter) sameTrees(a, b string) (bool, error) {
+	changes, err := DiffTrees(a, b, true)
+	if err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		if matchesPaths(change.Path, f.paths) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// followFilter keeps the commits changing path, which becomes the old name
+// of the file past the commit that renamed it. A rename is an added path
+// with the content of a deleted one. Commits must come in the order
+// WalkCommits visits them, a merge the same as one of its parents is left out.
+type followFilter struct {
+	path string
+}
+
+func (f *followFilter) show(sha string, commit *Commit) (bool, error) {
+	parents := commit.parents
+	if len(parents) == 0 {
+		parents = []string{""}
+	}
+	var renamed []TreeChange
+	for i, parent := range parents {
+		tree := ""
+		if parent != "" {
+			info, err := ReadCommitInfo(parent)
+			if err != nil {
+				return false, err
+			}
+			tree = info.Tree
+		}
+		changes, err := DiffTrees(tree, commit.tree, true)
+		if err != nil {
+			return false, err
+		}
+		touched := []TreeChange{}
+		for _, change := range changes {
+			if matchesPaths(change.Path, []string{f.path}) {
+				touched = append(touched, change)
+			}
+		}
+		if len(touched) == 0 {
+			return false, nil
+		}
+		if i == 0 {
+			renamed = changes
+		}
+	}
+
+	// the renames are looked for against the first parent only
+	for _, added := range renamed {
+		if added.Status != 'A' || added.Path != f.path {
+			continue
+		}
+		for _, deleted := range renamed {
+			if deleted.Status == 'D' && deleted.Old.hash == added.New.hash {
+				Verbosef("Following %s to %s in %s\n", f.path, deleted.Path, sha[:7])
+				f.path = deleted.Path
+				break
+			}
+		}
+	}
+	return true, nil
+}
+
+// matchesPaths tells whether name is one of paths or lies in one of them
+func matchesPaths(name string, paths []string) bool {
+	for _, p := range paths {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" || p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// WalkCommits visits every commit reachable from tips, newest committer date
+// first like git log, until fn returns false or an error. Commits waiting to
+// be visited are looked up in the commit-graph, only visited ones are parsed.
+func WalkCommits(tips []string, fn func(sha string, commit *Commit) (bool, error)) error {
+	seen := map[string]bool{}
+	pending := map[string]CommitInfo{}
+	push := func(sha string) error {
+		if seen[sha] {
+			return nil
+		}
+		seen[sha] = true
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return err
+		}
+		pending[sha] = info
+		return nil
+	}
+
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return err
+		}
+	}
+
+	for len(pending) > 0 {
+		newest := ""
+		for sha, info := range pending {
+			if newest == "" || info.Time > pending[newest].Time ||
+				(info.Time == pending[newest].Time && sha < newest) {
+				newest = sha
+			}
+		}
+		info := pending[newest]
+		delete(pending, newest)
+
+		commit, err := ReadCommit(newest)
+		if err != nil {
+			return err
+		}
+		if more, err := fn(newest, commit); err != nil || !more {
+			return err
+		}
+		for _, parent := range info.Parents {
+			if err := push(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func WriteLog(w io.Writer, tips []string, opts LogOptions) error {
+	show, err := newLogFilter(tips, opts)
+	if err != nil {
+		return err
+	}
+	count := 0
+	return WalkCommits(tips, func(sha string, commit *Commit) (bool, error) {
+		if opts.MaxCount > 0 && count == opts.MaxCount {
+			return false, nil
+		}
+		if shown, err := show(sha, commit); err != nil || !shown {
+			return err == nil, err
+		}
+		count++
+
+		if opts.Oneline {
+			_, err := fmt.Fprintf(w, "%s %s\n", sha[:7], commit.Subject())
+			return err == nil, err
+		}
+
+		if count > 1 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "commit %s\n", sha)
+		if len(commit.parents) > 1 {
+			abbrev := []string{}
+			for _, parent := range commit.parents {
+				abbrev = append(abbrev, parent[:7])
+			}
+			fmt.Fprintf(w, "Merge: %s\n", strings.Join(abbrev, " "))
+		}
+		name, email := commit.author.Name, commit.author.Email
+		if opts.Mailmap != nil {
+			name, email = opts.Mailmap.Resolve(name, email)
+		}
+		fmt.Fprintf(w, "Author: %s <%s>\n", name, email)
+		fmt.Fprintf(w, "Date:   %s\n\n", commit.author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+
+		message := strings.TrimRight(commit.Message(), "\n")
+		for _, line := range strings.Split(message, "\n") {
+			if _, err := fmt.Fprintf(w, "    %s\n", line); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// WriteLogJSON writes the commits WriteLog would show as a JSON array,
+// Oneline makes no difference
+func WriteLogJSON(w io.Writer, tips []string, opts LogOptions) error {
+	show, err := newLogFilter(tips, opts)
+	if err != nil {
+		return err
+	}
+	commits := []CommitJSON{}
+	err = WalkCommits(tips, func(sha string, commit *Commit) (bool, error) {
+		if opts.MaxCount > 0 && len(commits) == opts.MaxCount {
+			return false, nil
+		}
+		if shown, err := show(sha, commit); err != nil || !shown {
+			return err == nil, err
+		}
+		entry := newCommitJSON(sha, commit)
+		if opts.Mailmap != nil {
+			entry.Author.Name, entry.Author.Email = opts.Mailmap.Resolve(entry.Author.Name, entry.Author.Email)
+			entry.Committer.Name, entry.Committer.Email = opts.Mailmap.Resolve(entry.Committer.Name, entry.Committer.Email)
+		}
+		commits = append(commits, entry)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, commits)
+}
+
+// Ancestors returns the commits reachable from tips, tips included
+func Ancestors(tips ...string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	queue := append([]string{}, tips...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, info.Parents...)
+	}
+	return seen, nil
+}
+
+// MergeBase returns the best common ancestor of a and b, one no other common
+// ancestor descends from, the newest when there are several. It is empty when
+// the histories are unrelated.
+func MergeBase(a, b string) (string, error) {
+	fromA, err := Ancestors(a)
+	if err != nil {
+		return "", err
+	}
+	fromB, err := Ancestors(b)
+	if err != nil {
+		return "", err
+	}
+	common, parents := []string{}, []string{}
+	for sha := range fromA {
+		if !fromB[sha] {
+			continue
+		}
+		common = append(common, sha)
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return "", err
+		}
+		parents = append(parents, info.Parents...)
+	}
+	// a common ancestor reachable from another one is not the best
+	older, err := Ancestors(parents...)
+	if err != nil {
+		return "", err
+	}
+
+	best, bestTime := "", int64(0)
+	for _, sha := range common {
+		if older[sha] {
+			continue
+		}
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return "", err
+		}
+		if best == "" || info.Time > bestTime || (info.Time == bestTime && sha < best) {
+			best, bestTime = sha, info.Time
+		}
+	}
+	return best, nil
+}
+
+// AheadBehind counts the commits reachable from a but not b, and from b but
+// not a, the two sides of git rev-list --left-right --count a...b
+func AheadBehind(a, b string) (ahead, behind int, _ error) {
+	fromA, err := Ancestors(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	fromB, err := Ancestors(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	for sha := range fromA {
+		if !fromB[sha] {
+			ahead++
+		}
+	}
+	for sha := range fromB {
+		if !fromA[sha] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
@@ -0,0 +1,101 @@
+package mygit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAheadBehindMatchesGit(t *testing.T) {
+	testRepository(t)
+	divergedHistory(t)
+	// the merged side of the merge, by its sha
+	merged := runGit(t, "rev-parse", "main^2")
+	for _, revs := range [][2]string{{"main", "other"}, {"other", "main"}, {"main", "main"}, {merged, "other"}} {
+		output, err := runCommand(t, "ahead-behind", revs[0], revs[1])
+		if err != nil {
+			t.Fatalf("ahead-behind %s %s: %v", revs[0], revs[1], err)
+		}
+		if want := runGit(t, "rev-list", "--left-right", "--count", revs[0]+"..."+revs[1]); output != want+"\n" {
+			t.Errorf("ahead-behind %s %s printed %q, git counted %q", revs[0], revs[1], output, want)
+		}
+	}
+}
+
+// scopedHistory commits with git a history where only some commits touch
+// each path, with a merged side branch, a minute apart so that both walks
+// order them alike
+func scopedHistory(t *testing.T) (commit func(args ...string)) {
+	t.Helper()
+	tick := int64(1700000000)
+	commit = func(args ...string) {
+		t.Helper()
+		tick += 60
+		date := fmt.Sprintf("@%d +0000", tick)
+		t.Setenv("GIT_AUTHOR_DATE", date)
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		runGit(t, args...)
+	}
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	writeFiles(t, map[string]string{"a": "a\n", "dir/x": "x\n"})
+	runGit(t, "add", ".")
+	commit("commit", "-q", "-m", "add a and dir/x")
+	writeFiles(t, map[string]string{"b": "b\n"})
+	runGit(t, "add", ".")
+	commit("commit", "-q", "-m", "add b")
+	writeFiles(t, map[string]string{"dir/x": "x\ny\n"})
+	commit("commit", "-q", "-a", "-m", "edit dir/x")
+	runGit(t, "checkout", "-q", "-b", "side")
+	writeFiles(t, map[string]string{"a": "a\ns\n"})
+	commit("commit", "-q", "-a", "-m", "edit a on side")
+	writeFiles(t, map[string]string{"b": "t\n"})
+	commit("commit", "-q", "-a", "-m", "edit b on side")
+	runGit(t, "checkout", "-q", "main")
+	writeFiles(t, map[string]string{"dir/z": "z\n"})
+	runGit(t, "add", ".")
+	commit("commit", "-q", "-m", "add dir/z")
+	commit("merge", "-q", "--no-edit", "side")
+	writeFiles(t, map[string]string{"a": "a\ns\naa\n"})
+	commit("commit", "-q", "-a", "-m", "edit a")
+	return commit
+}
+
+func TestLogPathsMatchesGit(t *testing.T) {
+	testRepository(t)
+	scopedHistory(t)
+	for _, paths := range [][]string{{"a"}, {"dir"}, {"dir/"}, {"b", "dir/z"}, {"dir/x", "a"}, {"missing"}} {
+		output, err := runCommand(t, append([]string{"log", "--oneline", "--"}, paths...)...)
+		if err != nil {
+			t.Fatalf("log -- %v: %v", paths, err)
+		}
+		want := runGit(t, append([]string{"log", "--format=%h %s", "--"}, paths...)...)
+		if strings.TrimSpace(output) != want {
+			t.Errorf("log -- %v printed\n%s\ngit printed\n%s", paths, output, want)
+		}
+	}
+}
+
+func TestLogFollowMatchesGit(t *testing.T) {
+	testRepository(t)
+	commit := scopedHistory(t)
+	// renamed twice, once into a directory, the content unchanged by the
+	// renames
+	commit("mv", "a", "renamed")
+	commit("commit", "-q", "-m", "rename a")
+	writeFiles(t, map[string]string{"renamed": "a\ns\naa\nmore\n"})
+	commit("commit", "-q", "-a", "-m", "edit renamed")
+	commit("mv", "renamed", "dir/moved")
+	commit("commit", "-q", "-m", "move renamed into dir")
+
+	output, err := runCommand(t, "log", "--follow", "--oneline", "--", "dir/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := runGit(t, "log", "--follow", "--format=%h %s", "--", "dir/moved"); strings.TrimSpace(output) != want {
+		t.Errorf("log --follow printed\n%s\ngit printed\n%s", output, want)
+	}
+	// without --follow the history starts at the last rename
+	if output, err := runCommand(t, "log", "--oneline", "--", "dir/moved"); err != nil || strings.Count(output, "\n") != 1 {
+		t.Errorf("log -- dir/moved printed\n%s\n%v", output, err)
+	}
+}
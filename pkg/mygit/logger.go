@@ -0,0 +1,37 @@
+package mygit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type Verbosity int
+
+const (
+	QuietLevel Verbosity = iota
+	NormalLevel
+	VerboseLevel
+)
+
+// progress goes to stderr so stdout only carries the command output
+var (
+	verbosity           = NormalLevel
+	logOutput io.Writer = os.Stderr
+)
+
+func logAt(level Verbosity, format string, args ...any) {
+	if verbosity >= level {
+		fmt.Fprintf(logOutput, format, args...)
+	}
+}
+
+// Infof reports progress unless running with -q
+func Infof(format string, args ...any) {
+	logAt(NormalLevel, format, args...)
+}
+
+// Verbosef reports details only with -v
+func Verbosef(format string, args ...any) {
+	logAt(VerboseLevel, format, args...)
+}
@@ -0,0 +1,77 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLog collects the progress reported until the end of the test
+func captureLog(t *testing.T, level Verbosity) *bytes.Buffer {
+	t.Helper()
+	log := &bytes.Buffer{}
+	savedOutput, savedLevel := logOutput, verbosity
+	logOutput, verbosity = log, level
+	t.Cleanup(func() { logOutput, verbosity = savedOutput, savedLevel })
+	return log
+}
+
+func TestVerbosityLevels(t *testing.T) {
+	remote := newFixtureRemote(t)
+	url := remote.serve(t)
+	for _, test := range []struct {
+		flag    string
+		want    []string
+		notWant []string
+	}{
+		{"-q", nil, []string{"Cloning", "Received", "Fetching"}},
+		{"", []string{"Cloning into", "Received 8 objects", "Checked out refs/heads/master"}, []string{"Fetching"}},
+		{"-v", []string{"Cloning into", "Fetching refs/heads/master", "Received packfile", "Checked out"}, nil},
+	} {
+		t.Run(test.flag, func(t *testing.T) {
+			log := captureLog(t, NormalLevel)
+			args := []string{"clone", url, filepath.Join(t.TempDir(), "clone")}
+			if test.flag != "" {
+				args = append([]string{test.flag}, args...)
+			}
+			args, err := parseGlobalFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stdout := captureOutput(t, func() error {
+				return HandlerClone(context.Background(), args[0], args[1:])
+			})
+			if stdout != "" {
+				t.Errorf("clone printed %q on stdout", stdout)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(log.String(), want) {
+					t.Errorf("%q is missing from the log %q", want, log)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(log.String(), notWant) {
+					t.Errorf("%q is in the log %q", notWant, log)
+				}
+			}
+		})
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	for level, want := range map[Verbosity]string{
+		QuietLevel:   "",
+		NormalLevel:  "info\n",
+		VerboseLevel: "info\nverbose\n",
+	} {
+		log := captureLog(t, level)
+		Infof("info\n")
+		Verbosef("verbose\n")
+		if log.String() != want {
+			t.Errorf("level %d logged %q, want %q", level, log, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mygit
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// https://git-scm.com/docs/gitmailmap
+type mailmapEntry struct {
+	name  string
+	email string
+}
+
+type Mailmap struct {
+	// lowercased commit email -> lowercased commit name ("" matches any name)
+	entries map[string]map[string]mailmapEntry
+}
+
+// LoadMailmap reads .mailmap from the working tree and mailmap.file from the
+// config, a missing file gives an empty mailmap.
+func LoadMailmap(cfg *Config) (*Mailmap, error) {
+	m := &Mailmap{entries: map[string]map[string]mailmapEntry{}}
+	files := []string{".mailmap"}
+	if file, ok := cfg.Get("mailmap.file"); ok {
+		files = append(files, expandHome(file))
+	}
+	for _, file := range files {
+		if err := m.readFile(file); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ReadMailmapFile reads a single mailmap file, which must exist
+func ReadMailmapFile(file string) (*Mailmap, error) {
+	m := &Mailmap{entries: map[string]map[string]mailmapEntry{}}
+	return m, m.readFile(file)
+}
+
+func (m *Mailmap) readFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		m.parseLine(line)
+	}
+	return scanner.Err()
+}
+
+// each line is one of
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func (m *Mailmap) parseLine(line string) {
+	type ident struct{ name, email string }
+	idents := []ident{}
+	for {
+		open := strings.IndexByte(line, '<')
+		close := strings.IndexByte(line, '>')
+		if open == -1 || close < open {
+			break
+		}
+		idents = append(idents, ident{
+			name:  strings.TrimSpace(line[:open]),
+			email: strings.TrimSpace(line[open+1 : close]),
+		})
+		line = line[close+1:]
+	}
+
+	switch len(idents) {
+	case 1:
+		m.add(idents[0].name, "", "", idents[0].email)
+	case 2:
+		m.add(idents[0].name, idents[0].email, idents[1].name, idents[1].email)
+	}
+}
+
+func (m *Mailmap) add(properName, properEmail, commitName, commitEmail string) {
+	commitEmail = strings.ToLower(commitEmail)
+	byName, ok := m.entries[commitEmail]
+	if !ok {
+		byName = map[string]mailmapEntry{}
+		m.entries[commitEmail] = byName
+	}
+	key := strings.ToLower(commitName)
+	entry := byName[key]
+	if properName != "" {
+		entry.name = properName
+	}
+	if properEmail != "" {
+		entry.email = properEmail
+	}
+	byName[key] = entry
+}
+
+// Resolve maps an identity found in a commit to its canonical form, an entry
+// matching both name and email wins over one matching the email only.
+func (m *Mailmap) Resolve(name, email string) (string, string) {
+	byName, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+	entry, ok := byName[strings.ToLower(name)]
+	if !ok {
+		if entry, ok = byName[""]; !ok {
+			return name, email
+		}
+	}
+	if entry.name != "" {
+		name = entry.name
+	}
+	if entry.email != "" {
+		email = entry.email
+	}
+	return name, email
+}
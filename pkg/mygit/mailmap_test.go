@@ -0,0 +1,58 @@
+package mygit
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMailmapResolve(t *testing.T) {
+	file := t.TempDir() + "/mailmap"
+	mailmap := "# comment\n" +
+		"Proper Name <commit@example.com>\n" +
+		"<proper@example.com> <Old@Example.com>\n" +
+		"Joe <joe@example.com> <joe@laptop>  # trailing comment\n" +
+		"Jane Doe <jane@example.com> jane <shared@example.com>\n" +
+		"Someone Else <else@example.com> <shared@example.com>\n"
+	if err := os.WriteFile(file, []byte(mailmap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadMailmapFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ name, email, wantName, wantEmail string }{
+		{"whoever", "commit@example.com", "Proper Name", "commit@example.com"},
+		{"Old Name", "old@example.com", "Old Name", "proper@example.com"},
+		{"joe", "JOE@laptop", "Joe", "joe@example.com"},
+		{"Jane", "shared@example.com", "Jane Doe", "jane@example.com"}, // names match without case
+		{"bob", "shared@example.com", "Someone Else", "else@example.com"},
+		{"unmapped", "unmapped@example.com", "unmapped", "unmapped@example.com"},
+	}
+	for _, test := range tests {
+		name, email := m.Resolve(test.name, test.email)
+		if name != test.wantName || email != test.wantEmail {
+			t.Errorf("Resolve(%q, %q) = %q, %q, want %q, %q", test.name, test.email, name, email, test.wantName, test.wantEmail)
+		}
+	}
+}
+
+func TestLogUsesMailmap(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		"file":     "content\n",
+		".mailmap": "Canonical Tester <canonical@example.com> <TESTER@example.com>\n",
+	})
+	commitWorktree(t, "mapped")
+
+	output := captureOutput(t, func() error { return HandlerLog(context.Background(), LogCmd, nil) })
+	if !strings.Contains(output, "Author: Canonical Tester <canonical@example.com>\n") {
+		t.Errorf("log does not show the mapped author:\n%s", output)
+	}
+	output = captureOutput(t, func() error { return HandlerLog(context.Background(), LogCmd, []string{"--no-mailmap"}) })
+	if !strings.Contains(output, "Author: Tester <tester@example.com>\n") {
+		t.Errorf("log --no-mailmap does not show the commit author:\n%s", output)
+	}
+}
@@ -0,0 +1,149 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = "usage: mygit [-v | -q] [-C <path>] [--git-dir=<path>] [--timeout=<duration>] [-j <jobs>] [--json] [--help] <command> [<args>...]\n"
+
+// the command is cancelled once it runs for longer, 0 means no limit
+var commandTimeout time.Duration
+
+// ExitStatus makes mygit exit with the given code without printing anything,
+// for commands whose answer is the exit code itself
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func failOnErr(cmd string, err error) {
+	var status ExitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error for %s: %v\n", cmd, err)
+		os.Exit(1)
+	}
+}
+
+// parseGlobalFlags handles the flags before the command, setting up the
+// repository context, and returns the remaining arguments
+func parseGlobalFlags(args []string) ([]string, error) {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+
+		value, hasValue := "", false
+		if name, v, found := strings.Cut(flag, "="); found {
+			flag, value, hasValue = name, v, true
+		}
+		needValue := func() error {
+			if hasValue {
+				return nil
+			}
+			if len(args) == 0 {
+				return InvalidArgsError
+			}
+			value, args = args[0], args[1:]
+			return nil
+		}
+
+		switch flag {
+		case "-v", "--verbose":
+			verbosity = VerboseLevel
+		case "-q", "--quiet":
+			verbosity = QuietLevel
+		case "--json":
+			jsonOutput = true
+		case "-h", "--help":
+			return append([]string{"--help"}, args...), nil
+		case "-C":
+			// like git, each -C is relative to the previous one
+			if err := needValue(); err != nil {
+				return nil, err
+			}
+			if value == "" {
+				continue
+			}
+			if err := os.Chdir(value); err != nil {
+				return nil, err
+			}
+		case "--git-dir":
+			if err := needValue(); err != nil {
+				return nil, err
+			}
+			gitDir = value
+		case "--timeout":
+			if err := needValue(); err != nil {
+				return nil, err
+			}
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, InvalidArgsError
+			}
+			commandTimeout = timeout
+		case "-j", "--jobs":
+			if err := needValue(); err != nil {
+				return nil, err
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, InvalidArgsError
+			}
+			jobsFlag = n
+		default:
+			return nil, InvalidArgsError
+		}
+	}
+	return args, nil
+}
+
+// Main runs mygit with the command line arguments, without the program
+// name, and exits with its status
+func Main(args []string) {
+	args, err := parseGlobalFlags(args)
+	failOnErr("mygit", err)
+
+	if len(args) > 0 && args[0] == "--help" {
+		args = append([]string{HelpCmd}, args[1:]...)
+	}
+
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	command, args := args[0], args[1:]
+	handler, err := GetCommand(command)
+	if err == CommandNotFoundError {
+		fmt.Fprintf(os.Stderr, "mygit: '%s' is not a mygit command\n\n", command)
+		PrintCommandList(os.Stderr)
+		os.Exit(1)
+	}
+
+	failOnErr(command, err)
+
+	// an interrupt cancels the command, which stops between two steps
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, commandTimeout)
+		defer cancel()
+	}
+
+	failOnErr(command, loadObjectFormat())
+	err = handler(ctx, command, args)
+	stop()
+	failOnErr(command, err)
+}
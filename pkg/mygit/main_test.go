@@ -0,0 +1,108 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runCommand runs mygit with args like Main, and returns its stdout. The
+// directory and repository the flags switch to are left once it returns.
+func runCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the global flags only apply to this command
+	savedDir, savedLevel, savedJSON, savedTimeout, savedJobs := gitDir, verbosity, jsonOutput, commandTimeout, jobsFlag
+	savedFormat := objectHasher
+	defer func() {
+		os.Chdir(cwd)
+		gitDir, verbosity, jsonOutput, commandTimeout, jobsFlag = savedDir, savedLevel, savedJSON, savedTimeout, savedJobs
+		objectHasher = savedFormat
+		dropObjectCaches()
+	}()
+
+	output := captureOutput(t, func() error {
+		if args, err = parseGlobalFlags(args); err != nil {
+			return nil
+		}
+		if len(args) == 0 {
+			err = InvalidArgsError
+			return nil
+		}
+		handler, cmdErr := GetCommand(args[0])
+		if cmdErr != nil {
+			err = cmdErr
+			return nil
+		}
+		if err = loadObjectFormat(); err != nil {
+			return nil
+		}
+		err = handler(context.Background(), args[0], args[1:])
+		return nil
+	})
+	return output, err
+}
+
+func TestGlobalFlags(t *testing.T) {
+	root := t.TempDir()
+	testRepository(t)
+	chdir(t, root)
+	// a repository in a subdirectory, with one blob of its own
+	if err := os.MkdirAll(filepath.Join("sub", "deeper"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, "sub")
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	sha, err := WriteContent(&Blob{content: []byte("in sub\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := fmt.Sprintf("%x", sha)
+	chdir(t, root)
+
+	for _, args := range [][]string{
+		{"-C", "sub", "cat-file", "-p", blob},
+		{"-C", "sub/deeper", "-C", "..", "cat-file", "-p", blob},
+		{"-C", "", "-C=sub", "cat-file", "-p", blob},
+		{"--git-dir", "sub/.git", "cat-file", "-p", blob},
+		{"--git-dir=sub/.git", "cat-file", "-p", blob},
+	} {
+		output, err := runCommand(t, args...)
+		if err != nil || output != "in sub\n" {
+			t.Errorf("%v printed %q, %v", args, output, err)
+		}
+	}
+
+	if _, err := runCommand(t, "cat-file", "-p", blob); err == nil {
+		t.Error("found the object of sub outside of it")
+	}
+	for _, args := range [][]string{{"-C"}, {"-C", "missing", "cat-file"}, {"--unknown", "cat-file"}, {"-j", "zero"}} {
+		if _, err := runCommand(t, args...); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
+
+func TestGlobalFlagsHelp(t *testing.T) {
+	for _, args := range [][]string{{"--help"}, {"-h", "cat-file"}, {"-v", "--help", "log"}} {
+		rest, err := parseGlobalFlags(args)
+		if err != nil || rest[0] != "--help" {
+			t.Errorf("%v parsed to %v, %v", args, rest, err)
+		}
+	}
+	rest, err := parseGlobalFlags([]string{"cat-file", "-p", "-v"})
+	if err != nil || len(rest) != 3 {
+		t.Errorf("the flags of the command were parsed: %v, %v", rest, err)
+	}
+	if _, err := parseGlobalFlags([]string{"--timeout", "soon"}); !errors.Is(err, InvalidArgsError) {
+		t.Errorf("an invalid timeout: %v", err)
+	}
+}
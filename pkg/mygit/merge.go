@@ -0,0 +1,403 @@
+package mygit
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MergeLabels name the two sides in conflict markers
+type MergeLabels struct {
+	Ours   string
+	Theirs string
+}
+
+// MergeLines merges the changes from base to ours and from base to theirs the
+// way diff3 does. Regions changed differently on both sides are kept between
+// conflict markers, the number of such regions is returned.
+//
+// Like git, lines both sides agree on are moved out of a conflict and
+// conflicts at most three lines apart are joined into one.
+func MergeLines(base, ours, theirs []string, labels MergeLabels) ([]string, int) {
+	return mergeLines(base, ours, theirs, labels, false)
+}
+
+// mergeChunk is a run of merged lines, or a conflict between ours and theirs
+type mergeChunk struct {
+	lines        []string
+	ours, theirs []string
+	conflict     bool
+	resolved     bool // lines changed on one side, or the same way on both
+}
+
+// mergeLines is MergeLines, also joining conflicts separated only by lines
+// without letters or digits when joinNonAlnum is set, as merge-file does
+func mergeLines(base, ours, theirs []string, labels MergeLabels, joinNonAlnum bool) ([]string, int) {
+	// for each base line, the line it matches in ours and in theirs or -1
+	matches := func(other []string) []int {
+		match := make([]int, len(base))
+		for i := range match {
+			match[i] = -1
+		}
+		for _, e := range DiffLines(base, other) {
+			if e.Kind == EditEqual {
+				match[e.Old] = e.New
+			}
+		}
+		return match
+	}
+	inOurs, inTheirs := matches(ours), matches(theirs)
+
+	chunks := []mergeChunk{}
+	b, o, t := 0, 0, 0
+	for {
+		// lines unchanged on both sides
+		stable := 0
+		for b+stable < len(base) && inOurs[b+stable] == o+stable && inTheirs[b+stable] == t+stable {
+			stable++
+		}
+		if stable > 0 {
+			chunks = appendMerged(chunks, base[b:b+stable], false)
+			b, o, t = b+stable, o+stable, t+stable
+			continue
+		}
+
+		// the changed region ends at the next base line both sides kept
+		end := b
+		for end < len(base) && (inOurs[end] == -1 || inTheirs[end] == -1) {
+			end++
+		}
+		oursEnd, theirsEnd := len(ours), len(theirs)
+		if end < len(base) {
+			oursEnd, theirsEnd = inOurs[end], inTheirs[end]
+		}
+		if end == b && oursEnd == o && theirsEnd == t {
+			break
+		}
+
+		baseChunk, oursChunk, theirsChunk := base[b:end], ours[o:oursEnd], theirs[t:theirsEnd]
+		switch {
+		case slices.Equal(oursChunk, baseChunk), slices.Equal(oursChunk, theirsChunk):
+			chunks = appendMerged(chunks, theirsChunk, true)
+		case slices.Equal(theirsChunk, baseChunk):
+			chunks = appendMerged(chunks, oursChunk, true)
+		default:
+			chunks = refineConflict(chunks, oursChunk, theirsChunk)
+		}
+		b, o, t = end, oursEnd, theirsEnd
+	}
+	chunks = joinConflicts(chunks, joinNonAlnum)
+
+	merged, conflicts := []string{}, 0
+	for _, c := range chunks {
+		if !c.conflict {
+			merged = append(merged, c.lines...)
+			continue
+		}
+		conflicts++
+		merged = append(merged, "<<<<<<< "+labels.Ours+"\n")
+		merged = append(merged, withNewline(c.ours)...)
+		merged = append(merged, "=======\n")
+		merged = append(merged, withNewline(c.theirs)...)
+		merged = append(merged, ">>>>>>> "+labels.Theirs+"\n")
+	}
+	return merged, conflicts
+}
+
+// appendMerged adds lines that merged cleanly, to the previous chunk when it
+// is of the same kind. A resolved change is kept even without lines, a
+// deletion still separates the conflicts around it.
+func appendMerged(chunks []mergeChunk, lines []string, resolved bool) []mergeChunk {
+	if len(lines) == 0 && !resolved {
+		return chunks
+	}
+	if n := len(chunks); n > 0 && !chunks[n-1].conflict && chunks[n-1].resolved == resolved {
+		chunks[n-1].lines = append(chunks[n-1].lines, lines...)
+		return chunks
+	}
+	return append(chunks, mergeChunk{lines: slices.Clone(lines), resolved: resolved})
+}
+
+// refineConflict adds the conflict between ours and theirs, split where the
+// two sides have the same lines
+func refineConflict(chunks []mergeChunk, ours, theirs []string) []mergeChunk {
+	if len(ours) == 0 || len(theirs) == 0 {
+		return append(chunks, mergeChunk{ours: ours, theirs: theirs, conflict: true})
+	}
+	edits := DiffLines(ours, theirs)
+	for i := 0; i < len(edits); {
+		if edits[i].Kind == EditEqual {
+			chunks = appendMerged(chunks, ours[edits[i].Old:edits[i].Old+1], false)
+			i++
+			continue
+		}
+		c := mergeChunk{conflict: true}
+		for ; i < len(edits) && edits[i].Kind != EditEqual; i++ {
+			if edits[i].Kind == EditDelete {
+				c.ours = append(c.ours, ours[edits[i].Old])
+			} else {
+				c.theirs = append(c.theirs, theirs[edits[i].New])
+			}
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+// joinConflicts turns two conflicts and the few unchanged lines between them
+// into a single conflict, which is easier to resolve than many small ones
+func joinConflicts(chunks []mergeChunk, joinNonAlnum bool) []mergeChunk {
+	joined := []mergeChunk{}
+	for _, c := range chunks {
+		n := len(joined)
+		if c.conflict && n >= 2 && joined[n-2].conflict && !joined[n-1].conflict && !joined[n-1].resolved {
+			gap := joined[n-1].lines
+			if len(gap) <= 3 || (joinNonAlnum && !hasAlnum(gap)) {
+				prev := &joined[n-2]
+				prev.ours = slices.Concat(prev.ours, gap, c.ours)
+				prev.theirs = slices.Concat(prev.theirs, gap, c.theirs)
+				joined = joined[:n-1]
+				continue
+			}
+		}
+		joined = append(joined, c)
+	}
+	return joined
+}
+
+// hasAlnum tells if any of the lines has an ASCII letter or digit
+func hasAlnum(lines []string) bool {
+	for _, line := range lines {
+		for i := 0; i < len(line); i++ {
+			c := line[i] | 0x20
+			if ('0' <= line[i] && line[i] <= '9') || ('a' <= c && c <= 'z') {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withNewline terminates the last line so a conflict marker can follow it
+func withNewline(lines []string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines = append(lines[:n-1:n-1], lines[n-1]+"\n")
+	}
+	return lines
+}
+
+// MergeTrees merges, path by path, the changes from base to ours and from
+// base to theirs, all three being FlattenTree results. Paths that could not
+// be merged cleanly are returned sorted, conflicting text files are stored
+// with their markers.
+func MergeTrees(base, ours, theirs map[string]entry, labels MergeLabels) (map[string]entry, []string, error) {
+	paths := map[string]bool{}
+	for _, files := range []map[string]entry{base, ours, theirs} {
+		for name := range files {
+			paths[name] = true
+		}
+	}
+
+	merged, conflicts := map[string]entry{}, []string{}
+	keep := func(name string, e entry, ok bool) {
+		if ok {
+			merged[name] = e
+		}
+	}
+	for name := range paths {
+		b, inBase := base[name]
+		o, inOurs := ours[name]
+		t, inTheirs := theirs[name]
+		switch {
+		case inOurs == inTheirs && o == t:
+			keep(name, o, inOurs)
+		case inOurs == inBase && o == b:
+			keep(name, t, inTheirs)
+		case inTheirs == inBase && t == b:
+			keep(name, o, inOurs)
+		case !inOurs || !inTheirs:
+			// modified on one side, deleted on the other: keep the modified file
+			conflicts = append(conflicts, name)
+			keep(name, o, inOurs)
+			keep(name, t, inTheirs)
+		default:
+			e, clean, err := mergeFileEntries(b, inBase, o, t, labels)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !clean {
+				conflicts = append(conflicts, name)
+			}
+			merged[name] = e
+		}
+	}
+	sort.Strings(conflicts)
+	return merged, conflicts, nil
+}
+
+// ReplayCommit applies the change commit made to its first parent on top of
+// onto, undoing it instead when reverse is set, and returns the merged files
+// with the paths that conflicted
+func ReplayCommit(onto map[string]entry, commit *Commit, reverse bool, labels MergeLabels) (map[string]entry, []string, error) {
+	files, err := FlattenTree(commit.tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a root commit is a change from nothing
+	parent := map[string]entry{}
+	if len(commit.parents) > 0 {
+		if parent, err = FlattenTree(commit.parents[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if reverse {
+		return MergeTrees(files, onto, parent, labels)
+	}
+	return MergeTrees(parent, onto, files, labels)
+}
+
+// mergeFileEntries merges a file changed on both sides, one without a base
+// was added by both
+func mergeFileEntries(b entry, inBase bool, o, t entry, labels MergeLabels) (entry, bool, error) {
+	mode := o.mode
+	if inBase && o.mode == b.mode {
+		mode = t.mode
+	}
+	if o.kind != BlobKind || t.kind != BlobKind || mode == "120000" {
+		return o, false, nil
+	}
+
+	contents := [3][]byte{}
+	for i, e := range []entry{b, o, t} {
+		if i == 0 && !inBase {
+			continue
+		}
+		blob, err := ReadGitObject(e.hash)
+		if err != nil {
+			return o, false, err
+		}
+		contents[i] = blob.Content()
+	}
+	if isBinary(contents[0]) || isBinary(contents[1]) || isBinary(contents[2]) {
+		return o, false, nil
+	}
+
+	lines, conflicts := MergeLines(splitLines(contents[0]), splitLines(contents[1]), splitLines(contents[2]), labels)
+	hash, err := WriteContent(&Blob{content: []byte(strings.Join(lines, ""))})
+	if err != nil {
+		return o, false, err
+	}
+	return entry{mode: mode, kind: BlobKind, hash: fmt.Sprintf("%x", hash), name: o.name}, conflicts == 0, nil
+}
+
+// UpdateWorktree turns the files of from into the files of to in the current
+// directory. It refuses to start when a file it would touch differs from its
+// content in from, so local changes are never lost.
+func UpdateWorktree(from, to map[string]entry) error {
+	changed := []string{}
+	for name, e := range from {
+		if other, ok := to[name]; !ok || other != e {
+			changed = append(changed, name)
+		}
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	// files outside the sparse checkout are neither checked nor written
+	sparse, err := LoadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	changed = slices.DeleteFunc(changed, func(name string) bool { return !sparse.Includes(name) })
+
+	for _, name := range changed {
+		e, tracked := from[name]
+		info, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !tracked || info.IsDir() {
+			return fmt.Errorf("untracked %s would be overwritten", name)
+		}
+		sha, err := hashWorktreeFile(name, info)
+		if err != nil {
+			return err
+		}
+		if sha != e.hash {
+			return fmt.Errorf("your local changes to %s would be overwritten", name)
+		}
+	}
+
+	for _, name := range changed {
+		e, ok := to[name]
+		if !ok {
+			if err := removeWorktreeFile(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeWorktreeFile(name, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeWorktreeFile removes name and the directories it leaves empty, git
+// does not track them
+func removeWorktreeFile(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// hashWorktreeFile hashes a file of the worktree, a symlink is stored as the
+// path it points to
+func hashWorktreeFile(name string, info os.FileInfo) (string, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		sha, err := HashFile(name, false)
+		return fmt.Sprintf("%x", sha), err
+	}
+	target, err := os.Readlink(name)
+	if err != nil {
+		return "", err
+	}
+	sha, err := storeObject(&Blob{content: []byte(target)}, false)
+	return fmt.Sprintf("%x", sha), err
+}
+
+func writeWorktreeFile(name string, e entry) error {
+	blob, err := ReadGitObject(e.hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	switch e.mode {
+	case "120000":
+		return os.Symlink(string(blob.Content()), name)
+	case "100755":
+		return os.WriteFile(name, blob.Content(), 0o755)
+	}
+	return os.WriteFile(name, blob.Content(), 0o644)
+}
@@ -0,0 +1,53 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines is seq 1 n with the given lines replaced, an empty
+// replacement drops the line
+func numberedLines(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		line, found := replace[i]
+		if !found {
+			line = fmt.Sprint(i)
+		} else if line == "" {
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func TestMergeFileMatchesGit(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		"base":   numberedLines(20, nil),
+		"ours":   numberedLines(20, map[int]string{3: "three", 10: "ten-ours", 17: "seventeen-ours"}),
+		"theirs": numberedLines(20, map[int]string{6: "", 10: "ten-theirs", 17: "seventeen-theirs"}),
+		"clean":  numberedLines(20, map[int]string{12: "twelve"}),
+	})
+
+	for _, other := range []string{"clean", "theirs"} {
+		args := []string{"merge-file", "-p", "ours", "base", other}
+		want, wantStatus := gitStatus(t, nil, args...)
+		output, err := runCommand(t, args...)
+		status := 0
+		var exit ExitStatus
+		if errors.As(err, &exit) {
+			status = int(exit)
+		} else if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if output != string(want) {
+			t.Errorf("%v printed\n%s\ngit printed\n%s", args, output, want)
+		}
+		if status != wantStatus {
+			t.Errorf("%v exited with %d, git with %d", args, status, wantStatus)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package mygit
+
+// https://git-scm.com/docs/git-interpret-trailers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	EmptyMessage = errors.New("Aborting commit due to empty commit message.")
+)
+
+// prefixes of the lines git adds itself, a last paragraph holding one is a
+// trailer block even when most of its lines are not trailers
+var gitGeneratedTrailers = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// Trailer is a "<key>: <value>" line of the trailer block, continuation
+// lines are joined to the value
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// MessageParts is a commit message split the way git reads it: the first
+// paragraph is the subject, a last paragraph made of trailers is the
+// trailer block and everything in between is the body
+type MessageParts struct {
+	Subject  string
+	Body     string
+	Trailers []Trailer
+}
+
+// ParseMessage splits message into its subject, body and trailers
+func ParseMessage(message string) MessageParts {
+	lines := messageLines(message)
+	start, end := trailerBlock(lines)
+	parts := MessageParts{}
+	for _, line := range lines[start:end] {
+		if line.text == "" {
+			continue
+		}
+		if n := len(parts.Trailers); n > 0 && isSpace(rune(line.text[0])) {
+			parts.Trailers[n-1].Value += " " + strings.TrimSpace(line.text)
+			continue
+		}
+		key, value, _ := strings.Cut(line.text, ":")
+		parts.Trailers = append(parts.Trailers, Trailer{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+
+	head := message[:lines[start].offset]
+	subject, body, _ := strings.Cut(strings.TrimLeft(head, "\n"), "\n\n")
+	parts.Subject = strings.TrimSpace(subject)
+	parts.Body = strings.Trim(body, "\n")
+	return parts
+}
+
+type messageLine struct {
+	text   string
+	offset int // where the line starts in the message
+}
+
+// messageLines splits message into lines, the last one always empty and
+// starting at the end of the message so that slices of lines have an end
+func messageLines(message string) []messageLine {
+	lines, offset := []messageLine{}, 0
+	for _, text := range strings.SplitAfter(message, "\n") {
+		if text == "" {
+			continue
+		}
+		lines = append(lines, messageLine{strings.TrimSuffix(text, "\n"), offset})
+		offset += len(text)
+	}
+	return append(lines, messageLine{"", len(message)})
+}
+
+// trailerBlock finds the lines of the trailer block like git's
+// find_trailer_block_start: the last paragraph, after the subject, when
+// all of its lines are trailers, or a quarter of them with one added by
+// git. Both are the index of the last line when there is none.
+func trailerBlock(lines []messageLine) (start, end int) {
+	none := len(lines) - 1
+	title := 0
+	for title < none && strings.TrimSpace(lines[title].text) != "" {
+		title++
+	}
+	end = none
+	for end > title && strings.TrimSpace(lines[end-1].text) == "" {
+		end--
+	}
+
+	trailers, others, continuations, recognized := 0, 0, 0, false
+	for i := end - 1; i >= title; i-- {
+		text := lines[i].text
+		if strings.TrimSpace(text) == "" {
+			others += continuations
+			if trailers > 0 && (others == 0 || recognized && trailers*3 >= others) {
+				return i + 1, end
+			}
+			return none, none
+		}
+		switch {
+		case hasAnyPrefix(text, gitGeneratedTrailers):
+			trailers, continuations, recognized = trailers+1, 0, true
+		case trailerSeparator(text) >= 1 && !isSpace(rune(text[0])):
+			trailers, continuations = trailers+1, 0
+		case isSpace(rune(text[0])):
+			continuations++
+		default:
+			others, continuations = others+1+continuations, 0
+		}
+	}
+	return none, none
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trailerSeparator returns where the ":" after the key of a trailer line
+// is, the key is made of letters, digits and dashes possibly followed by
+// spaces. -1 when line is not a trailer.
+func trailerSeparator(line string) int {
+	spaces := false
+	for i, c := range []byte(line) {
+		switch {
+		case c == ':':
+			return i
+		case !spaces && (c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'):
+		case i > 0 && (c == ' ' || c == '\t'):
+			spaces = true
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// AppendSignoff adds a "Signed-off-by: <identity>" trailer like git commit
+// -s: to the trailer block if there is one, else after a blank line, and
+// not at all when it is the last trailer already
+func AppendSignoff(message, identity string) string {
+	signoff := "Signed-off-by: " + identity + "\n"
+	lines := messageLines(message)
+	start, end := trailerBlock(lines)
+
+	if start == end {
+		switch {
+		case message == "":
+			message = "\n\n" // room for the subject and the body
+		case message == "\n":
+			message += "\n"
+		case !strings.HasSuffix(message, "\n\n"):
+			if !strings.HasSuffix(message, "\n") {
+				message += "\n"
+			}
+			message += "\n"
+		}
+		return message + signoff
+	}
+	if last := lines[end-1]; message[last.offset:lines[end].offset] == signoff {
+		return message
+	}
+	return message + signoff
+}
+
+// CleanupMessage strips a message like git's default cleanup: comment lines
+// and trailing spaces go, runs of blank lines become one and there are none
+// at the start and end
+func CleanupMessage(message string) string {
+	out, blank := strings.Builder{}, false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRightFunc(line, isSpace)
+		if line == "" {
+			blank = out.Len() > 0
+			continue
+		}
+		if blank {
+			out.WriteByte('\n')
+			blank = false
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+// commitTemplate reads the file commit.template names, empty without one
+func commitTemplate() (string, error) {
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return "", err
+	}
+	file, ok := cfg.Get("commit.template")
+	if !ok {
+		return "", nil
+	}
+	content, err := os.ReadFile(expandHome(file))
+	if err != nil {
+		return "", fmt.Errorf("could not read commit.template: %w", err)
+	}
+	return string(content), nil
+}
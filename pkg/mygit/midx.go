@@ -0,0 +1,126 @@
+package mygit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	InvalidMultiPackIndex = errors.New("File cannot be parsed into a multi-pack-index.")
+)
+
+// https://git-scm.com/docs/gitformat-pack#_multi_pack_index_midx_files_have_the_following_format
+type MultiPackIndex struct {
+	packs   []string // path of each .pack file, by pack-int-id
+	shas    [][20]byte
+	packIDs []uint32
+	offsets []uint64
+}
+
+func ReadMultiPackIndex(file string) (*MultiPackIndex, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// signature, version 1, sha1, chunk count, base count, pack count
+	if len(data) < 12+20 || !bytes.Equal(data[:4], []byte("MIDX")) || data[4] != 1 || data[5] != 1 {
+		return nil, InvalidMultiPackIndex
+	}
+	chunkCount := int(data[6])
+	packCount := int(binary.BigEndian.Uint32(data[8:12]))
+
+	// the lookup table has a terminating row giving the end of the last chunk
+	if len(data) < 12+(chunkCount+1)*12 {
+		return nil, InvalidMultiPackIndex
+	}
+	chunks := map[string][]byte{}
+	for i := range chunkCount {
+		row := data[12+i*12:]
+		start := binary.BigEndian.Uint64(row[4:12])
+		end := binary.BigEndian.Uint64(row[16:24])
+		if start > end || end > uint64(len(data)) {
+			return nil, InvalidMultiPackIndex
+		}
+		chunks[string(row[:4])] = data[start:end]
+	}
+
+	names, fanout, shas, offsets := chunks["PNAM"], chunks["OIDF"], chunks["OIDL"], chunks["OOFF"]
+	if names == nil || len(fanout) != 256*4 {
+		return nil, InvalidMultiPackIndex
+	}
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+	if len(shas) != count*20 || len(offsets) != count*8 {
+		return nil, InvalidMultiPackIndex
+	}
+
+	dir := filepath.Dir(file)
+	midx := &MultiPackIndex{
+		shas:    make([][20]byte, count),
+		packIDs: make([]uint32, count),
+		offsets: make([]uint64, count),
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(names), "\x00"), "\x00") {
+		midx.packs = append(midx.packs, filepath.Join(dir, strings.TrimSuffix(name, ".idx")+".pack"))
+	}
+	if len(midx.packs) != packCount {
+		return nil, InvalidMultiPackIndex
+	}
+
+	large := chunks["LOFF"]
+	for i := range count {
+		copy(midx.shas[i][:], shas[i*20:])
+		midx.packIDs[i] = binary.BigEndian.Uint32(offsets[i*8:])
+		if int(midx.packIDs[i]) >= packCount {
+			return nil, InvalidMultiPackIndex
+		}
+
+		offset := binary.BigEndian.Uint32(offsets[i*8+4:])
+		if offset&0x80000000 == 0 {
+			midx.offsets[i] = uint64(offset)
+			continue
+		}
+		// like in pack indexes, the offset indexes the 64 bits table
+		at := int(offset&0x7fffffff) * 8
+		if at+8 > len(large) {
+			return nil, InvalidMultiPackIndex
+		}
+		midx.offsets[i] = binary.BigEndian.Uint64(large[at:])
+	}
+	return midx, nil
+}
+
+// Lookup returns the pack holding sha and the offset of the object in it
+func (midx *MultiPackIndex) Lookup(sha [20]byte) (string, uint64, bool) {
+	i := sort.Search(len(midx.shas), func(i int) bool {
+		return bytes.Compare(midx.shas[i][:], sha[:]) >= 0
+	})
+	if i < len(midx.shas) && midx.shas[i] == sha {
+		return midx.packs[midx.packIDs[i]], midx.offsets[i], true
+	}
+	return "", 0, false
+}
+
+// the multi-pack-index is read once per process, nil when there is none
+var multiPackIndex *MultiPackIndex
+var multiPackIndexLoaded bool
+var multiPackIndexMu sync.Mutex
+
+func loadMultiPackIndex() (*MultiPackIndex, error) {
+	multiPackIndexMu.Lock()
+	defer multiPackIndexMu.Unlock()
+	if multiPackIndexLoaded {
+		return multiPackIndex, nil
+	}
+	midx, err := ReadMultiPackIndex(gitPath("objects", "pack", "multi-pack-index"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	multiPackIndex, multiPackIndexLoaded = midx, true
+	return midx, nil
+}
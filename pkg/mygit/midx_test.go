@@ -0,0 +1,127 @@
+package mygit
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitPacks commits count versions of a file with git and packs each batch
+// of commits on its own, returning all the packs
+func gitPacks(t *testing.T, batches, count int) []string {
+	t.Helper()
+	for range batches {
+		for range count {
+			testTime = testTime.Add(time.Minute)
+			writeFiles(t, map[string]string{"file": strings.Repeat(testTime.String()+"\n", 50)})
+			runGit(t, "add", "file")
+			runGit(t, "commit", "-qm", "at "+testTime.String())
+		}
+		// only the loose objects, so the packs do not overlap
+		runGit(t, "repack", "-dq")
+	}
+	packs, err := filepath.Glob(gitPath("objects", "pack", "*.pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packs
+}
+
+func TestMultiPackIndexWrittenByGit(t *testing.T) {
+	testRepository(t)
+	if packs := gitPacks(t, 2, 5); len(packs) != 2 {
+		t.Fatalf("git wrote the packs %v", packs)
+	}
+	runGit(t, "multi-pack-index", "write")
+	shas := strings.Fields(runGit(t, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)"))
+
+	midx, err := ReadMultiPackIndex(gitPath("objects", "pack", "multi-pack-index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(midx.packs) != 2 || len(midx.shas) != len(shas) {
+		t.Fatalf("the multi-pack-index has %d packs and %d objects, want 2 and %d", len(midx.packs), len(midx.shas), len(shas))
+	}
+	// the offsets are those of the pack indexes
+	for _, sha := range shas {
+		var key [20]byte
+		hex.Decode(key[:], []byte(sha))
+		pack, offset, ok := midx.Lookup(key)
+		if !ok {
+			t.Errorf("%s is not in the multi-pack-index", sha)
+			continue
+		}
+		idx, err := ReadPackIndex(strings.TrimSuffix(pack, ".pack") + ".idx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, ok := idx.Lookup(key); !ok || want != offset {
+			t.Errorf("%s is at %d in %s, the pack index says %d", sha, offset, pack, want)
+		}
+	}
+	if _, _, ok := midx.Lookup([20]byte{}); ok {
+		t.Error("found a missing object")
+	}
+}
+
+func TestReadObjectsThroughMultiPackIndex(t *testing.T) {
+	testRepository(t)
+	gitPacks(t, 2, 3)
+	runGit(t, "multi-pack-index", "write")
+	// a pack written since is not covered
+	gitPacks(t, 1, 2)
+	shas := strings.Fields(runGit(t, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)"))
+	contents := map[string]string{}
+	for _, sha := range shas {
+		contents[sha] = runGit(t, "cat-file", "-p", sha)
+	}
+
+	// the covered packs are found through the multi-pack-index alone
+	covered, err := ReadMultiPackIndex(gitPath("objects", "pack", "multi-pack-index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pack := range covered.packs {
+		if err := os.Remove(strings.TrimSuffix(pack, ".pack") + ".idx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dropObjectCaches()
+	for sha, want := range contents {
+		gitObj, err := ReadGitObject(sha)
+		if err != nil {
+			t.Errorf("%s: %v", sha, err)
+			continue
+		}
+		if got := strings.TrimSpace(gitObj.String()); got != want {
+			t.Errorf("%s is\n%s\nwant\n%s", sha, got, want)
+		}
+	}
+}
+
+func TestReadMultiPackIndexRejectsCorruptFiles(t *testing.T) {
+	testRepository(t)
+	gitPacks(t, 2, 2)
+	runGit(t, "multi-pack-index", "write")
+	file := gitPath("objects", "pack", "multi-pack-index")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string][]byte{
+		"truncated": data[:40],
+		"signature": append([]byte("XDIM"), data[4:]...),
+		"version":   append(append([]byte{}, data[:4]...), append([]byte{2}, data[5:]...)...),
+	} {
+		os.Remove(file)
+		if err := os.WriteFile(file, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadMultiPackIndex(file); err != InvalidMultiPackIndex {
+			t.Errorf("read a multi-pack-index with a bad %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package mygit
+
+// https://git-scm.com/docs/hash-function-transition
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+var (
+	UnsupportedObjectFormat = errors.New("Object format is not supported.")
+)
+
+// Hasher computes the object ids, and the checksums ending packs, indexes
+// and commit-graphs which use the same function. ObjectFormat implements it.
+type Hasher interface {
+	New() hash.Hash
+	Sum(data []byte) []byte
+	HexLen() int // of an id
+}
+
+// objectHasher is the Hasher of the repository, its object format once the
+// repository is set up
+var objectHasher Hasher = SHA1
+
+// hashSize is the length in bytes of the ids and checksums of objectHasher
+func hashSize() int {
+	return objectHasher.HexLen() / 2
+}
+
+// ObjectFormat is the hash function naming the objects of a repository
+type ObjectFormat string
+
+const (
+	SHA1   ObjectFormat = "sha1"
+	SHA256 ObjectFormat = "sha256"
+)
+
+func ParseObjectFormat(name string) (ObjectFormat, error) {
+	switch format := ObjectFormat(name); format {
+	case SHA1, SHA256:
+		return format, nil
+	}
+	return "", fmt.Errorf("%w: %q", UnsupportedObjectFormat, name)
+}
+
+// HexLen is the length of an object id written in hex
+func (f ObjectFormat) HexLen() int {
+	return f.New().Size() * 2
+}
+
+func (f ObjectFormat) New() hash.Hash {
+	if f == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+func (f ObjectFormat) Sum(data []byte) []byte {
+	h := f.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// RepositoryObjectFormat reads the object format from the config. Like git,
+// extensions.objectformat only counts in a version 1 repository, a version 0
+// one predates extensions and is sha1.
+func RepositoryObjectFormat() (ObjectFormat, error) {
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return "", err
+	}
+	switch version, _ := cfg.Get("core.repositoryformatversion"); version {
+	case "", "0":
+		return SHA1, nil
+	case "1":
+		name, ok := cfg.Get("extensions.objectformat")
+		if !ok {
+			return SHA1, nil
+		}
+		return ParseObjectFormat(name)
+	default:
+		return "", fmt.Errorf("%w: repository format version %s", UnsupportedObjectFormat, version)
+	}
+}
+
+// loadObjectFormat hashes with the object format of the repository mygit
+// works in, sha1 when it has no config yet
+func loadObjectFormat() error {
+	format, err := RepositoryObjectFormat()
+	if err != nil {
+		return err
+	}
+	objectHasher = format
+	return nil
+}
+
+// checkWritableFormat refuses writing objects in a repository whose object
+// format is not implemented: trees, the index and packs store ids in 20
+// bytes so far
+func checkWritableFormat() error {
+	if hashSize() != 20 {
+		return fmt.Errorf("%w: %v objects cannot be written", UnsupportedObjectFormat, objectHasher)
+	}
+	return nil
+}
+
+// storedID returns id in the 20 bytes ids are stored in, refusing the longer
+// ids of the formats which cannot be stored
+func storedID(id []byte) (sha [20]byte, _ error) {
+	if err := checkWritableFormat(); err != nil {
+		return sha, err
+	}
+	copy(sha[:], id)
+	return sha, nil
+}
+
+// InitObjectFormat records format in the config of a new repository
+func InitObjectFormat(format ObjectFormat) error {
+	if format == SHA1 {
+		return nil // the default, git leaves the config alone too
+	}
+	if err := SetConfig(gitPath("config"), "core.repositoryformatversion", "1"); err != nil {
+		return err
+	}
+	if err := SetConfig(gitPath("config"), "extensions.objectformat", string(format)); err != nil {
+		return err
+	}
+	objectHasher = format
+	return nil
+}
@@ -0,0 +1,360 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+type ObjectKind string
+
+const (
+	BlobKind   ObjectKind = "blob"
+	TreeKind   ObjectKind = "tree"
+	CommitKind ObjectKind = "commit"
+	TagKind    ObjectKind = "tag"
+)
+
+var (
+	InvalidObject  = errors.New("Invalid Object")
+	ObjectNotFound = errors.New("Object not found")
+	CorruptObject  = errors.New("Object is corrupt")
+)
+
+type GitObject interface {
+	Kind() ObjectKind
+	Content() []byte
+	String() string
+}
+
+// read file at sha and /parses/ into a gitobject
+func ReadGitObject(sha string) (GitObject, error) {
+	kind, content, err := readObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	return newGitObject(kind, content)
+}
+
+func newGitObject(kind ObjectKind, content []byte) (GitObject, error) {
+	switch kind {
+	case BlobKind:
+		return &Blob{content: content}, nil
+	case TreeKind:
+		return &Tree{content: content}, nil
+	case CommitKind:
+		return &CommitAsBytes{content: content}, nil
+	case TagKind:
+		return &Tag{content: content}, nil
+	}
+	return nil, InvalidObject
+}
+
+// readObject looks the object up in the loose storage first, then in the
+// packs, then asks the promisor remote of a partial clone
+func readObject(sha string) (ObjectKind, []byte, error) {
+	if !isHash(sha) {
+		return "", nil, InvalidObject
+	}
+	kind, content, err := readLooseObject(sha)
+	if os.IsNotExist(err) {
+		kind, content, err = readPackedObject(sha)
+	}
+	if !errors.Is(err, ObjectNotFound) {
+		return kind, content, err
+	}
+
+	// a partial clone fetches the objects its filter left out on first use
+	promised, fetchErr := fetchPromisedObject(sha)
+	if fetchErr != nil {
+		return "", nil, fmt.Errorf("%w: %s: %w", ObjectNotFound, sha, fetchErr)
+	}
+	if !promised {
+		return "", nil, err
+	}
+	kind, content, err = readLooseObject(sha)
+	if os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("%w: %s: the promisor remote did not send it", ObjectNotFound, sha)
+	}
+	return kind, content, err
+}
+
+func readLooseObject(sha string) (ObjectKind, []byte, error) {
+	file, err := os.Open(looseObjectPath(sha))
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	// a byte reader keeps flate from reading past the end of the stream
+	r := bufio.NewReader(file)
+	zReader, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s: %w", CorruptObject, sha, err)
+	}
+	defer zReader.Close()
+
+	// reading to EOF is what checks the adler32 trailer
+	content, err := io.ReadAll(zReader)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s: %w", CorruptObject, sha, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return "", nil, fmt.Errorf("%w: %s: garbage after the compressed data", CorruptObject, sha)
+	}
+
+	header, body, found := bytes.Cut(content, []byte{byte(0)})
+	if !found {
+		return "", nil, InvalidObject
+	}
+	kind, size, found := bytes.Cut(header, []byte{' '})
+	if !found || string(size) != strconv.Itoa(len(body)) {
+		return "", nil, InvalidObject
+	}
+
+	switch kind := ObjectKind(kind); kind {
+	case BlobKind, TreeKind, CommitKind, TagKind:
+		return kind, body, nil
+	}
+	return "", nil, InvalidObject
+}
+
+// ObjectType returns the kind of the object at sha, see ObjectInfo
+func ObjectType(sha string) (ObjectKind, error) {
+	kind, _, err := ObjectInfo(sha)
+	return kind, err
+}
+
+// ObjectInfo returns the kind and size of the object at sha. Only the header
+// of a loose object is decompressed, a packed one is not decompressed at all
+// unless it is a delta, whose size is in the first bytes of its data.
+func ObjectInfo(sha string) (ObjectKind, int64, error) {
+	if !isHash(sha) {
+		return "", 0, InvalidObject
+	}
+	file, err := os.Open(looseObjectPath(sha))
+	if os.IsNotExist(err) {
+		return packedObjectInfo(sha)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	zReader, err := zlib.NewReader(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer zReader.Close()
+
+	// "<kind> <size>\x00", the size of a huge commit still fits
+	header := make([]byte, 32)
+	n, err := io.ReadFull(zReader, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", 0, err
+	}
+	header, _, found := bytes.Cut(header[:n], []byte{0})
+	if !found {
+		return "", 0, InvalidObject
+	}
+	kind, rawSize, found := bytes.Cut(header, []byte{' '})
+	size, err := strconv.ParseInt(string(rawSize), 10, 64)
+	if !found || err != nil || size < 0 {
+		return "", 0, InvalidObject
+	}
+	switch kind := ObjectKind(kind); kind {
+	case BlobKind, TreeKind, CommitKind, TagKind:
+		return kind, size, nil
+	}
+	return "", 0, InvalidObject
+}
+
+// looseObjectPath is where the loose object at sha is stored, whether it
+// exists or not
+func looseObjectPath(sha string) string {
+	return gitPath("objects", sha[:2], sha[2:])
+}
+
+// ObjectLocation is where an object is stored, its loose file or the pack
+// holding it and its offset there
+type ObjectLocation struct {
+	Path   string
+	Packed bool
+	Offset uint64
+}
+
+// LocateObject finds the object at sha without reading it, looking at the
+// loose objects first like readObject
+func LocateObject(sha string) (ObjectLocation, error) {
+	if !isHash(sha) {
+		return ObjectLocation{}, InvalidObject
+	}
+	loose := looseObjectPath(sha)
+	if _, err := os.Stat(loose); err == nil || !os.IsNotExist(err) {
+		return ObjectLocation{Path: loose}, err
+	}
+	var key [20]byte
+	hex.Decode(key[:], []byte(sha))
+	pack, offset, ok, err := findPackedObject(key)
+	if err != nil {
+		return ObjectLocation{}, err
+	}
+	if !ok {
+		return ObjectLocation{}, fmt.Errorf("%w: %s", ObjectNotFound, sha)
+	}
+	return ObjectLocation{Path: pack, Packed: true, Offset: offset}, nil
+}
+
+// HasObject tells whether sha is stored, loose or packed, without reading it
+func HasObject(sha string) bool {
+	if !isHash(sha) {
+		return false
+	}
+	if _, err := os.Stat(looseObjectPath(sha)); err == nil {
+		return true
+	}
+	var key [20]byte
+	hex.Decode(key[:], []byte(sha))
+	_, _, ok, err := findPackedObject(key)
+	return ok && err == nil
+}
+
+// AllObjects lists the sha of every loose and packed object, sorted
+func AllObjects() ([]string, error) {
+	seen := map[string]bool{}
+
+	loose, err := looseObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range loose {
+		seen[sha] = true
+	}
+
+	packed, err := packedObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range packed {
+		seen[fmt.Sprintf("%x", sha)] = true
+	}
+
+	objects := make([]string, 0, len(seen))
+	for sha := range seen {
+		objects = append(objects, sha)
+	}
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// looseObjects lists the sha of every loose object
+func looseObjects() ([]string, error) {
+	dirs, err := os.ReadDir(gitPath("objects"))
+	if err != nil {
+		return nil, err
+	}
+	objects := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(gitPath("objects", dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if sha := dir.Name() + file.Name(); isHash(sha) {
+				objects = append(objects, sha)
+			}
+		}
+	}
+	return objects, nil
+}
+
+// HashObject returns the id of gitObj, as long as the hash of the repository
+// makes it, and the bytes stored for it
+func HashObject(gitObj GitObject) ([]byte, []byte) {
+	obj := []byte(fmt.Sprintf("%s %d\x00", gitObj.Kind(), len(gitObj.Content())))
+	obj = append(obj, gitObj.Content()...)
+	return objectHasher.Sum(obj), obj
+}
+
+// wellKnownObjects builds the objects scripts refer to by name, like the
+// empty tree to diff a root commit against. They are hashed as any other
+// object so their shas always agree with git.
+var wellKnownObjects = map[string]func(write bool) ([20]byte, error){
+	"empty-blob": func(write bool) ([20]byte, error) { return storeObject(&Blob{}, write) },
+	"empty-tree": func(write bool) ([20]byte, error) { return WriteTreeFromFiles(nil, write) },
+}
+
+// storeObject returns the sha of gitObj, writing it only when write is set
+func storeObject(gitObj GitObject, write bool) ([20]byte, error) {
+	if !write {
+		hash, _ := HashObject(gitObj)
+		return storedID(hash)
+	}
+	return WriteContent(gitObj)
+}
+
+// WriteContent stores gitObj as a loose object. Objects never change once
+// written so an existing one is left alone, and the file appears through a
+// rename only when complete: concurrent writers of the same object cannot
+// corrupt it and readers never see it half written.
+func WriteContent(gitObj GitObject) (nilSha [20]byte, _ error) {
+	id, content := HashObject(gitObj)
+	hash, err := storedID(id)
+	if err != nil {
+		return nilSha, err
+	}
+	objPath := looseObjectPath(fmt.Sprintf("%x", hash))
+	if _, err := os.Stat(objPath); err == nil {
+		return hash, nil
+	}
+
+	// another writer may create the directory at the same time, MkdirAll
+	// does not fail when it already exists
+	if err := os.MkdirAll(path.Dir(objPath), 0o755); err != nil {
+		return nilSha, err
+	}
+	err = writeAtomically(objPath, 0o644, func(w io.Writer) error {
+		compressed := zlib.NewWriter(w)
+		if _, err := compressed.Write(content); err != nil {
+			return err
+		}
+		return compressed.Close()
+	})
+	if err != nil {
+		return nilSha, err
+	}
+	return hash, nil
+}
+
+// writeAtomically fills a temporary file next to dest with write and renames
+// it to dest once closed, on failure dest is left untouched
+func writeAtomically(dest string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(path.Dir(dest), "tmp_"+path.Base(dest)+"_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
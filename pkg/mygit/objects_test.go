@@ -0,0 +1,412 @@
+package mygit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// packObjects moves the objects at shas to a pack, leaving the loose copies
+// of those in keepLoose
+func packObjects(t *testing.T, shas []string, keepLoose ...string) {
+	t.Helper()
+	if _, err := writePackFiles(gitPath("objects", "pack", "pack"), shas, PackOptions{}, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, sha := range shas {
+		if !strings.Contains(strings.Join(keepLoose, " "), sha) {
+			if err := os.Remove(looseObjectPath(sha)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dropObjectCaches()
+}
+
+func TestBatchAllObjects(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n", "dir/file": "nested\n"})
+	commitWorktree(t, "first")
+	packed, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"file": "two\n"})
+	commitWorktree(t, "second")
+
+	// the first commit is packed, one of its objects is also loose
+	packObjects(t, packed, packed[0])
+	loose, err := looseObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loose) != 4 { // second commit, its root tree and blob, packed[0]
+		t.Fatalf("loose objects %v", loose)
+	}
+
+	all, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := map[string]bool{}
+	for _, sha := range append(append([]string{}, packed...), loose...) {
+		kind, content, err := readObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[fmt.Sprintf("%s %s %d", sha, kind, len(content))] = true
+	}
+	want := []string{}
+	for line := range lines {
+		want = append(want, line)
+	}
+	sort.Strings(want)
+	if len(all) != len(want) {
+		t.Errorf("enumerated %d objects, want %d", len(all), len(want))
+	}
+
+	output := captureOutput(t, func() error {
+		return catFileBatchCheck([]string{"--batch-check", "--batch-all-objects"})
+	})
+	if output != strings.Join(want, "\n")+"\n" {
+		t.Errorf("cat-file --batch-check --batch-all-objects:\n%s\nwant\n%s", output, strings.Join(want, "\n"))
+	}
+}
+
+func TestCatFileExists(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"packed": "packed\n", "loose": "loose\n"})
+	commitWorktree(t, "first")
+	packed, loose := blobSha("packed\n"), blobSha("loose\n")
+	packObjects(t, []string{packed})
+	corrupt, err := WriteContent(&Blob{content: []byte("corrupt\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// objects are read-only
+	os.Remove(looseObjectPath(fmt.Sprintf("%x", corrupt)))
+	if err := os.WriteFile(looseObjectPath(fmt.Sprintf("%x", corrupt)), []byte("not zlib"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for object, want := range map[string]error{
+		packed:                     nil,
+		loose:                      nil,
+		"main":                     nil,
+		fmt.Sprintf("%x", corrupt): ExitStatus(1),
+		blobSha("missing\n"):       ExitStatus(1),
+		"refs/heads/missing":       ExitStatus(1),
+	} {
+		output, err := runCommand(t, "cat-file", "-e", object)
+		if err != want || output != "" {
+			t.Errorf("cat-file -e %s printed %q, %v, want %v", object, output, err, want)
+		}
+	}
+}
+
+func TestObjectType(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "content\n", "dir/packed": "packed\n"})
+	commit := commitWorktree(t, "first")
+	tag := annotatedTag(t, "v1", commit, "release")
+	tree := treeOf(t, commit)
+	packObjects(t, []string{blobSha("packed\n")})
+
+	for sha, want := range map[string]ObjectKind{
+		blobSha("content\n"): BlobKind,
+		blobSha("packed\n"):  BlobKind,
+		tree:                 TreeKind,
+		commit:               CommitKind,
+		tag:                  TagKind,
+	} {
+		if kind, err := ObjectType(sha); err != nil || kind != want {
+			t.Errorf("%s is a %s, %v, want %s", sha, kind, err, want)
+		}
+		output, err := runCommand(t, "cat-file", "-t", sha)
+		if err != nil || output != string(want)+"\n" {
+			t.Errorf("cat-file -t %s printed %q, %v", sha, output, err)
+		}
+	}
+	if _, err := ObjectType(blobSha("missing\n")); err == nil {
+		t.Error("found the type of a missing object")
+	}
+}
+
+// writeLoose stores data as the loose object sha as is
+func writeLoose(t *testing.T, sha string, data []byte) {
+	t.Helper()
+	// objects are read-only
+	os.Remove(looseObjectPath(sha))
+	if err := os.MkdirAll(filepath.Dir(looseObjectPath(sha)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(looseObjectPath(sha), data, 0o444); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLooseObjectCompression(t *testing.T) {
+	testRepository(t)
+	content := strings.Repeat("compressed the way other tools do\n", 100)
+	sha := blobSha(content)
+	raw := []byte(fmt.Sprintf("blob %d\x00%s", len(content), content))
+	compress := func(level int) []byte {
+		var buf bytes.Buffer
+		w, err := zlib.NewWriterLevel(&buf, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(raw)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	// stored blocks reference nothing, any window size is valid for them
+	smallWindow := compress(zlib.NoCompression)
+	smallWindow[0] = 0x18 // deflate with a 512 bytes window
+	smallWindow[1] = byte(31 - int(smallWindow[0])<<8%31)
+	for name, data := range map[string][]byte{
+		"default":      compress(zlib.DefaultCompression),
+		"fastest":      compress(zlib.BestSpeed),
+		"best":         compress(zlib.BestCompression),
+		"stored":       compress(zlib.NoCompression),
+		"small window": smallWindow,
+	} {
+		writeLoose(t, sha, data)
+		kind, body, err := readLooseObject(sha)
+		if err != nil || kind != BlobKind || string(body) != content {
+			t.Errorf("%s: read a %s of %d bytes, %v", name, kind, len(body), err)
+		}
+	}
+
+	good := compress(zlib.DefaultCompression)
+	badTrailer := bytes.Clone(good)
+	badTrailer[len(badTrailer)-1] ^= 0xff
+	for name, data := range map[string][]byte{
+		"bad header":       append([]byte{0x78, 0x00}, good[2:]...),
+		"bad trailer":      badTrailer,
+		"truncated":        good[:len(good)-10],
+		"trailing garbage": append(bytes.Clone(good), "garbage"...),
+	} {
+		writeLoose(t, sha, data)
+		if _, _, err := readLooseObject(sha); !errors.Is(err, CorruptObject) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestConcurrentWriteContent(t *testing.T) {
+	testRepository(t)
+	const writers, objects = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < objects; i++ {
+				// every writer writes the shared objects and its own
+				for _, content := range []string{fmt.Sprintf("shared %d\n", i), fmt.Sprintf("writer %d object %d\n", w, i)} {
+					if _, err := WriteContent(&Blob{content: []byte(content)}); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{}
+	for i := 0; i < objects; i++ {
+		want[blobSha(fmt.Sprintf("shared %d\n", i))] = fmt.Sprintf("shared %d\n", i)
+		for w := 0; w < writers; w++ {
+			content := fmt.Sprintf("writer %d object %d\n", w, i)
+			want[blobSha(content)] = content
+		}
+	}
+	for sha, content := range want {
+		kind, body, err := readLooseObject(sha)
+		if err != nil || kind != BlobKind || string(body) != content {
+			t.Errorf("%s is a %s %q, %v, want %q", sha, kind, body, err, content)
+		}
+	}
+	// no temporary file is left behind
+	if files := objectFiles(t); files != len(want) {
+		t.Errorf("%d object files, want %d", files, len(want))
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"packed": "packed\n", "both": "both\n", "loose": "loose\n"})
+	commit := commitWorktree(t, "first")
+	packed, both, loose := blobSha("packed\n"), blobSha("both\n"), blobSha("loose\n")
+	packObjects(t, []string{packed, both}, both)
+
+	// loose objects come first, like they are read
+	for _, sha := range []string{loose, both, commit} {
+		location, err := LocateObject(sha)
+		if err != nil || location.Packed || location.Path != looseObjectPath(sha) {
+			t.Errorf("%s is at %+v, %v", sha, location, err)
+		}
+	}
+	output, err := runCommand(t, "object-path", "main")
+	if err != nil || output != "loose "+looseObjectPath(commit)+"\n" {
+		t.Errorf("object-path main printed %q, %v", output, err)
+	}
+
+	location, err := LocateObject(packed)
+	if err != nil || !location.Packed || !strings.HasSuffix(location.Path, ".pack") {
+		t.Fatalf("%s is at %+v, %v", packed, location, err)
+	}
+	// the offset is where the object header is
+	pack, err := os.ReadFile(location.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, size, err := parseObjectHeader(bytes.NewReader(pack[location.Offset:]))
+	if err != nil || kind != blob || size != int64(len("packed\n")) {
+		t.Errorf("the object at %d is a %s of %d bytes, %v", location.Offset, kind, size, err)
+	}
+	output, err = runCommand(t, "object-path", packed)
+	if err != nil || output != fmt.Sprintf("packed %s %d\n", location.Path, location.Offset) {
+		t.Errorf("object-path of a packed object printed %q, %v", output, err)
+	}
+
+	if _, err := LocateObject(blobSha("missing\n")); !errors.Is(err, ObjectNotFound) {
+		t.Errorf("a missing object: %v", err)
+	}
+	if _, err := runCommand(t, "object-path", "refs/heads/missing"); err == nil {
+		t.Error("object-path of a missing ref succeeded")
+	}
+}
+
+func TestWritesRefusedForUnimplementedFormat(t *testing.T) {
+	testRepository(t)
+	if err := InitObjectFormat(SHA256); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, map[string]string{"file": "content\n"})
+
+	if _, err := WriteContent(&Blob{content: []byte("content\n")}); !errors.Is(err, UnsupportedObjectFormat) {
+		t.Errorf("WriteContent: %v", err)
+	}
+	if _, err := HashFile("file", true); !errors.Is(err, UnsupportedObjectFormat) {
+		t.Errorf("HashFile: %v", err)
+	}
+	// the commands read the object format when they set up the repository
+	objectHasher = SHA1
+	if _, err := runCommand(t, "hash-object", "-w", "file"); !errors.Is(err, UnsupportedObjectFormat) {
+		t.Errorf("hash-object -w: %v", err)
+	}
+	if _, err := runCommand(t, "write-tree"); !errors.Is(err, UnsupportedObjectFormat) {
+		t.Errorf("write-tree: %v", err)
+	}
+	if n := objectFiles(t); n != 0 {
+		t.Errorf("%d objects written", n)
+	}
+	// hashing alone is fine
+	output, err := runCommand(t, "hash-object", "file")
+	if want := "7c490ebf9db90b84753749c721ef2bedfeb85c1da94160f2619df1249c64bdda\n"; err != nil || output != want {
+		t.Errorf("hash-object printed %q, %v", output, err)
+	}
+}
+
+// stubHasher is sha1 with a prefix, its ids are as long as sha1 ones but
+// never agree with them
+type stubHasher struct{}
+
+func (stubHasher) New() hash.Hash {
+	h := sha1.New()
+	h.Write([]byte("stub"))
+	return h
+}
+
+func (s stubHasher) Sum(data []byte) []byte {
+	h := s.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func (stubHasher) HexLen() int { return 40 }
+
+func TestWriteContentUsesTheHasher(t *testing.T) {
+	testRepository(t)
+	stub := stubHasher{}
+	objectHasher = stub
+	large := bytes.Repeat([]byte("a line of a file too large to be read at once\n"), streamThreshold/40)
+	writeFiles(t, map[string]string{"small": "content\n", "large": string(large)})
+
+	for name, content := range map[string][]byte{"small": []byte("content\n"), "large": large} {
+		stored := append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...)
+		want := stub.Sum(stored)
+		sha, err := HashFile(name, true)
+		if err != nil || !bytes.Equal(sha[:], want) {
+			t.Errorf("%s hashed to %x, %v, want %x", name, sha, err, want)
+		}
+		if _, err := os.Stat(looseObjectPath(fmt.Sprintf("%x", want))); err != nil {
+			t.Errorf("%s not written where the hasher names it: %v", name, err)
+		}
+		if HasObject(fmt.Sprintf("%x", SHA1.Sum(stored))) {
+			t.Errorf("%s written under its sha1", name)
+		}
+	}
+
+	tree, err := WriteTreeFromFiles(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := stub.Sum([]byte("tree 0\x00")); !bytes.Equal(tree[:], want) {
+		t.Errorf("empty tree %x, want %x", tree, want)
+	}
+}
+
+func TestWellKnownMatchesGit(t *testing.T) {
+	testRepository(t)
+	kinds := map[string]string{"empty-blob": "blob", "empty-tree": "tree"}
+	for known, kind := range kinds {
+		want := runGit(t, "hash-object", "-t", kind, "/dev/null")
+		output, err := runCommand(t, "well-known", "-w", known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sha := strings.TrimSpace(output); sha != want {
+			t.Errorf("well-known %s = %s, git hashed %s", known, sha, want)
+		}
+		// the written object is one git reads
+		if got := runGit(t, "cat-file", "-t", want); got != kind {
+			t.Errorf("git reads %s as a %s, want a %s", known, got, kind)
+		}
+	}
+
+	output, err := runCommand(t, "well-known")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%s empty-blob\n%s empty-tree\n",
+		runGit(t, "hash-object", "-t", "blob", "/dev/null"), runGit(t, "hash-object", "-t", "tree", "/dev/null"))
+	if output != want {
+		t.Errorf("well-known printed\n%s\nwant\n%s", output, want)
+	}
+}
+
+func TestBatchCheckPackedMatchesGit(t *testing.T) {
+	testRepository(t)
+	packedHistory(t, 30)
+	// the types and sizes of the deltas come from their headers
+	matchGit(t, "cat-file", "--batch-all-objects", "--batch-check")
+}
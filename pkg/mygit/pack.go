@@ -0,0 +1,784 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var kindToPackKind = map[ObjectKind]packFileKind{
+	CommitKind: commit,
+	TreeKind:   tree,
+	BlobKind:   blob,
+	TagKind:    tag,
+}
+
+var packKindToKind = map[packFileKind]ObjectKind{
+	commit: CommitKind,
+	tree:   TreeKind,
+	blob:   BlobKind,
+	tag:    TagKind,
+}
+
+var (
+	InvalidPackIndex = errors.New("File cannot be parsed into a pack index.")
+)
+
+// https://git-scm.com/docs/pack-format#_version_2_pack_idx_files_support_packs_larger_than_4_gib_and
+type PackIndex struct {
+	pack    string // path of the matching .pack file
+	shas    [][20]byte
+	offsets []uint64
+}
+
+func ReadPackIndex(file string) (*PackIndex, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, InvalidPackIndex
+	}
+
+	fanout := data[8 : 8+256*4]
+	count := int(binary.BigEndian.Uint32(fanout[255*4:]))
+	shasStart := 8 + 256*4
+	offsetsStart := shasStart + count*20 + count*4 // skip the crc table
+	largeStart := offsetsStart + count*4
+	if len(data) < largeStart+40 {
+		return nil, InvalidPackIndex
+	}
+
+	idx := &PackIndex{
+		pack:    strings.TrimSuffix(file, ".idx") + ".pack",
+		shas:    make([][20]byte, count),
+		offsets: make([]uint64, count),
+	}
+	for i := range count {
+		copy(idx.shas[i][:], data[shasStart+i*20:])
+
+		offset := binary.BigEndian.Uint32(data[offsetsStart+i*4:])
+		if offset&0x80000000 == 0 {
+			idx.offsets[i] = uint64(offset)
+			continue
+		}
+		// the offset does not fit 31 bits, it indexes the 64 bits table
+		large := largeStart + int(offset&0x7fffffff)*8
+		if large+8 > len(data)-40 {
+			return nil, InvalidPackIndex
+		}
+		idx.offsets[i] = binary.BigEndian.Uint64(data[large:])
+	}
+
+	return idx, nil
+}
+
+func (idx *PackIndex) Lookup(sha [20]byte) (uint64, bool) {
+	i := sort.Search(len(idx.shas), func(i int) bool {
+		return bytes.Compare(idx.shas[i][:], sha[:]) >= 0
+	})
+	if i < len(idx.shas) && idx.shas[i] == sha {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+// pack indexes are read once per process, packs covered by the
+// multi-pack-index are left out. Workers may look objects up at once.
+var (
+	packIndexes []*PackIndex
+	packsMu     sync.Mutex
+)
+
+func loadPacks() ([]*PackIndex, error) {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	if packIndexes != nil {
+		return packIndexes, nil
+	}
+	midx, err := loadMultiPackIndex()
+	if err != nil {
+		return nil, err
+	}
+	covered := map[string]bool{}
+	if midx != nil {
+		for _, pack := range midx.packs {
+			covered[pack] = true
+		}
+	}
+
+	files, err := filepath.Glob(gitPath("objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	packs := []*PackIndex{}
+	for _, file := range files {
+		if covered[strings.TrimSuffix(file, ".idx")+".pack"] {
+			continue
+		}
+		idx, err := ReadPackIndex(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, file)
+		}
+		packs = append(packs, idx)
+	}
+	packIndexes = packs
+	return packs, nil
+}
+
+// dropObjectCaches forgets the pack indexes, the multi-pack-index and the
+// commit-graph read so far, for when they change on disk or another
+// repository is used, and the worker count its config gave
+func dropObjectCaches() {
+	packsMu.Lock()
+	packIndexes = nil
+	packsMu.Unlock()
+	multiPackIndexMu.Lock()
+	multiPackIndex, multiPackIndexLoaded = nil, false
+	multiPackIndexMu.Unlock()
+	commitGraph, commitGraphLoaded = nil, false
+	jobsMu.Lock()
+	jobsLoaded = false
+	jobsMu.Unlock()
+}
+
+// findPackedObject returns the pack holding sha and its offset there
+func findPackedObject(sha [20]byte) (string, uint64, bool, error) {
+	midx, err := loadMultiPackIndex()
+	if err != nil {
+		return "", 0, false, err
+	}
+	if midx != nil {
+		if pack, offset, ok := midx.Lookup(sha); ok {
+			return pack, offset, true, nil
+		}
+	}
+	packs, err := loadPacks()
+	if err != nil {
+		return "", 0, false, err
+	}
+	for _, idx := range packs {
+		if offset, ok := idx.Lookup(sha); ok {
+			return idx.pack, offset, true, nil
+		}
+	}
+	return "", 0, false, nil
+}
+
+// packedObjects lists the sha of every packed object, possibly repeated
+func packedObjects() ([][20]byte, error) {
+	shas := [][20]byte{}
+	midx, err := loadMultiPackIndex()
+	if err != nil {
+		return nil, err
+	}
+	if midx != nil {
+		shas = append(shas, midx.shas...)
+	}
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range packs {
+		shas = append(shas, idx.shas...)
+	}
+	return shas, nil
+}
+
+func readPackedObject(sha string) (ObjectKind, []byte, error) {
+	var key [20]byte
+	if _, err := hex.Decode(key[:], []byte(sha)); err != nil {
+		return "", nil, InvalidObject
+	}
+	pack, offset, ok, err := findPackedObject(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ObjectNotFound, sha)
+	}
+	return readPackObjectAt(pack, offset)
+}
+
+// readPackObjectAt reads the object at offset, resolving deltas
+func readPackObjectAt(packFile string, offset uint64) (ObjectKind, []byte, error) {
+	file, err := os.Open(packFile)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(file, int64(offset), 1<<62))
+	kind, size, err := parseObjectHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var baseKind ObjectKind
+	var base []byte
+	switch kind {
+	case ofsDelta:
+		distance, err := readOfsDeltaOffset(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if distance > offset {
+			return "", nil, InvalidPackError
+		}
+		if baseKind, base, err = readPackObjectAt(packFile, offset-distance); err != nil {
+			return "", nil, err
+		}
+	case refDelta:
+		var baseSha [20]byte
+		if _, err := io.ReadFull(r, baseSha[:]); err != nil {
+			return "", nil, err
+		}
+		if baseKind, base, err = readObject(fmt.Sprintf("%x", baseSha)); err != nil {
+			return "", nil, err
+		}
+	default:
+		objKind, ok := packKindToKind[kind]
+		if !ok {
+			return "", nil, InvalidPackError
+		}
+		data, err := decompress(r, size)
+		if err != nil {
+			return "", nil, err
+		}
+		return objKind, data.Bytes(), nil
+	}
+
+	delta, err := decompress(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := applyDelta(base, delta)
+	if err != nil {
+		return "", nil, err
+	}
+	return baseKind, content, nil
+}
+
+func packedObjectInfo(sha string) (ObjectKind, int64, error) {
+	var key [20]byte
+	if _, err := hex.Decode(key[:], []byte(sha)); err != nil {
+		return "", 0, InvalidObject
+	}
+	pack, offset, ok, err := findPackedObject(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("%w: %s", ObjectNotFound, sha)
+	}
+	return packObjectInfoAt(pack, offset)
+}
+
+// packObjectInfoAt reads the kind and size of the object at offset from its
+// header. A delta has the kind of its base and only the start of its data is
+// inflated, where the size of the object it builds is.
+func packObjectInfoAt(packFile string, offset uint64) (ObjectKind, int64, error) {
+	file, err := os.Open(packFile)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(file, int64(offset), 1<<62))
+	kind, size, err := parseObjectHeader(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var baseKind ObjectKind
+	switch kind {
+	case ofsDelta:
+		distance, err := readOfsDeltaOffset(r)
+		if err != nil {
+			return "", 0, err
+		}
+		if distance > offset {
+			return "", 0, InvalidPackError
+		}
+		if baseKind, _, err = packObjectInfoAt(packFile, offset-distance); err != nil {
+			return "", 0, err
+		}
+	case refDelta:
+		var baseSha [20]byte
+		if _, err := io.ReadFull(r, baseSha[:]); err != nil {
+			return "", 0, err
+		}
+		if baseKind, err = ObjectType(fmt.Sprintf("%x", baseSha)); err != nil {
+			return "", 0, err
+		}
+	default:
+		objKind, ok := packKindToKind[kind]
+		if !ok {
+			return "", 0, InvalidPackError
+		}
+		return objKind, size, nil
+	}
+
+	// the delta starts with the size of its base then of the result, a
+	// small buffer keeps the rest from being inflated
+	zReader, err := zlib.NewReader(r)
+	if err != nil {
+		return "", 0, err
+	}
+	defer zReader.Close()
+	delta := bufio.NewReaderSize(zReader, 16)
+	if _, err := binary.ReadUvarint(delta); err != nil {
+		return "", 0, err
+	}
+	resultSize, err := binary.ReadUvarint(delta)
+	if err != nil {
+		return "", 0, err
+	}
+	return baseKind, int64(resultSize), nil
+}
+
+// the base of an ofs-delta is encoded as a distance backwards from the object
+func readOfsDeltaOffset(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	distance := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		distance = ((distance + 1) << 7) | uint64(b&0x7f)
+	}
+	return distance, nil
+}
+
+// writeOfsDeltaOffset is the inverse of readOfsDeltaOffset, index v4 uses
+// the same encoding for its path prefixes
+func writeOfsDeltaOffset(w io.ByteWriter, n uint64) {
+	encoded := []byte{byte(n & 0x7f)}
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		encoded = append([]byte{0x80 | byte(n&0x7f)}, encoded...)
+	}
+	for _, b := range encoded {
+		w.WriteByte(b)
+	}
+}
+
+func (k packFileKind) String() string {
+	switch k {
+	case commit:
+		return "commit"
+	case tree:
+		return "tree"
+	case blob:
+		return "blob"
+	case tag:
+		return "tag"
+	case ofsDelta:
+		return "ofs-delta"
+	case refDelta:
+		return "ref-delta"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(k))
+}
+
+// DumpPack prints the offset, type and declared size of every object in the
+// pack, with the base of deltas, without writing anything to the repository
+func DumpPack(w io.Writer, raw []byte) error {
+	if len(raw) < 32 || !bytes.Equal(raw[:4], []byte{'P', 'A', 'C', 'K'}) {
+		return InvalidPackError
+	}
+	size := hashSize()
+	checksum := objectHasher.Sum(raw[:len(raw)-size])
+	if !bytes.Equal(checksum, raw[len(raw)-size:]) {
+		return MismatchedChecksumError
+	}
+	data := raw[:len(raw)-size]
+
+	count := binary.BigEndian.Uint32(data[8:12])
+	fmt.Fprintf(w, "version %d, %d objects\n", binary.BigEndian.Uint32(data[4:8]), count)
+
+	r := bytes.NewReader(data[12:])
+	for range count {
+		offset := int64(len(data)) - int64(r.Len())
+		kind, size, err := parseObjectHeader(r)
+		if err != nil {
+			return fmt.Errorf("object at offset %d: %w", offset, err)
+		}
+
+		base := ""
+		switch kind {
+		case ofsDelta:
+			distance, err := readOfsDeltaOffset(r)
+			if err != nil {
+				return err
+			}
+			base = fmt.Sprintf(" base-offset %d", offset-int64(distance))
+		case refDelta:
+			var sha [20]byte
+			if _, err := io.ReadFull(r, sha[:]); err != nil {
+				return err
+			}
+			base = fmt.Sprintf(" base %x", sha)
+		}
+
+		// inflate only to find where the next object starts
+		if _, err := decompress(r, size); err != nil {
+			return fmt.Errorf("object at offset %d: %w", offset, err)
+		}
+		if _, err := fmt.Fprintf(w, "%d %s %d%s\n", offset, kind, size, base); err != nil {
+			return err
+		}
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", InvalidPackError, r.Len())
+	}
+	return nil
+}
+
+// ReachableObjects walks the history from each tip and returns every commit,
+// tree, blob and annotated tag it references, each sha listed once. The walk
+// stops at the shallow boundary, whose parents are not stored.
+func ReachableObjects(tips []string) ([]string, error) {
+	seen := map[string]bool{}
+	objects := []string{}
+	shallow, err := readShallow()
+	if err != nil {
+		return nil, err
+	}
+
+	var walkTree func(sha string) error
+	walkTree = func(sha string) error {
+		if seen[sha] {
+			return nil
+		}
+		seen[sha] = true
+		objects = append(objects, sha)
+
+		tree, err := ReadTreeish(sha)
+		if err != nil {
+			return err
+		}
+		entries, err := tree.Entries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.kind == TreeKind {
+				if err := walkTree(e.hash); err != nil {
+					return err
+				}
+			} else if e.kind == CommitKind {
+				continue // a submodule commit, stored in its own repository
+			} else if !seen[e.hash] {
+				seen[e.hash] = true
+				objects = append(objects, e.hash)
+			}
+		}
+		return nil
+	}
+
+	graph, err := loadCommitGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	queue := append([]string{}, tips...)
+	for len(queue) > 0 {
+		sha := queue[0]
+		queue = queue[1:]
+		if seen[sha] {
+			continue
+		}
+
+		// commits in the graph do not need to be read
+		if graph != nil {
+			info, ok, err := graph.Lookup(sha)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				seen[sha] = true
+				objects = append(objects, sha)
+				if err := walkTree(info.Tree); err != nil {
+					return nil, err
+				}
+				if !shallow[sha] {
+					queue = append(queue, info.Parents...)
+				}
+				continue
+			}
+		}
+
+		gitObj, err := ReadGitObject(sha)
+		if err != nil {
+			return nil, err
+		}
+		switch obj := gitObj.(type) {
+		case *CommitAsBytes:
+			seen[sha] = true
+			objects = append(objects, sha)
+			treeSha, err := obj.TreeHash()
+			if err != nil {
+				return nil, err
+			}
+			if err := walkTree(treeSha); err != nil {
+				return nil, err
+			}
+			if !shallow[sha] {
+				queue = append(queue, obj.Parents()...)
+			}
+		case *Tag:
+			seen[sha] = true
+			objects = append(objects, sha)
+			target, err := obj.Object()
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, target)
+		case *Tree:
+			if err := walkTree(sha); err != nil {
+				return nil, err
+			}
+		default:
+			seen[sha] = true
+			objects = append(objects, sha)
+		}
+	}
+
+	return objects, nil
+}
+
+// ObjectPath is an object found in a tree at path, empty for the tree itself
+type ObjectPath struct {
+	Sha  string
+	Path string
+}
+
+// IntroducedObjects lists the trees and blobs of the commit at sha that none
+// of its parents has, whatever their path there, in the order rev-list
+// --objects <commit> --not <parents> lists them after the commit: each tree
+// before its entries, in tree order. Trees the parents have are not entered.
+func IntroducedObjects(sha string) ([]ObjectPath, error) {
+	info, err := ReadCommitInfo(sha)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	var walk func(tree, prefix string, visit func(ObjectPath)) error
+	walk = func(tree, prefix string, visit func(ObjectPath)) error {
+		if known[tree] {
+			return nil
+		}
+		known[tree] = true
+		visit(ObjectPath{Sha: tree, Path: prefix})
+		entries, err := treeEntries(tree)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := path.Join(prefix, e.name)
+			switch {
+			case e.kind == TreeKind:
+				if err := walk(e.hash, name, visit); err != nil {
+					return err
+				}
+			case e.kind == BlobKind && !known[e.hash]:
+				known[e.hash] = true
+				visit(ObjectPath{Sha: e.hash, Path: name})
+			}
+		}
+		return nil
+	}
+
+	for _, parent := range info.Parents {
+		parentInfo, err := ReadCommitInfo(parent)
+		if err != nil {
+			return nil, err
+		}
+		if err := walk(parentInfo.Tree, "", func(ObjectPath) {}); err != nil {
+			return nil, err
+		}
+	}
+	objects := []ObjectPath{}
+	err = walk(info.Tree, "", func(o ObjectPath) { objects = append(objects, o) })
+	return objects, err
+}
+
+// readShallow returns the commits listed in .git/shallow
+func readShallow() (map[string]bool, error) {
+	shallow := map[string]bool{}
+	content, err := os.ReadFile(gitPath("shallow"))
+	if os.IsNotExist(err) {
+		return shallow, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, sha := range strings.Fields(string(content)) {
+		shallow[sha] = true
+	}
+	return shallow, nil
+}
+
+// https://git-scm.com/docs/pack-format
+// WritePack writes an undeltified version 2 packfile holding the given objects
+func WritePack(w io.Writer, shas []string) error {
+	_, _, err := writePack(w, shas, PackOptions{})
+	return err
+}
+
+// packIndexEntry is an object of a pack as the index records it
+type packIndexEntry struct {
+	sha    [20]byte
+	offset uint64
+	crc    uint32 // of the header and compressed data of the object
+}
+
+// writePack is WritePack storing the objects findDeltas picks as ofs-deltas,
+// each written after its base. It returns where each object starts and the
+// checksum ending the pack.
+func writePack(w io.Writer, shas []string, opts PackOptions) ([]packIndexEntry, []byte, error) {
+	// an object is only written once, the header counts it once too
+	seen := map[string]bool{}
+	shas = slices.DeleteFunc(slices.Clone(shas), func(sha string) bool {
+		defer func() { seen[sha] = true }()
+		return seen[sha]
+	})
+	deltas, err := findDeltas(shas, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := objectHasher.New()
+	out := io.MultiWriter(w, hash)
+
+	header := []byte{'P', 'A', 'C', 'K', 0, 0, 0, 2, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[8:], uint32(len(shas)))
+	if _, err := out.Write(header); err != nil {
+		return nil, nil, err
+	}
+
+	entries, offset := make([]packIndexEntry, 0, len(shas)), uint64(len(header))
+	offsets := map[string]uint64{}
+	var writeObject func(sha string) error
+	writeObject = func(sha string) error {
+		if _, ok := offsets[sha]; ok {
+			return nil
+		}
+		var data *bytes.Buffer
+		var content []byte
+		if delta, ok := deltas[sha]; ok {
+			if err := writeObject(delta.base); err != nil {
+				return err
+			}
+			data = bytes.NewBuffer(encodeObjectHeader(ofsDelta, int64(len(delta.data))))
+			writeOfsDeltaOffset(data, offset-offsets[delta.base])
+			content = delta.data
+		} else {
+			gitObj, err := ReadGitObject(sha)
+			if err != nil {
+				return err
+			}
+			kind, ok := kindToPackKind[gitObj.Kind()]
+			if !ok {
+				return InvalidObject
+			}
+			data = bytes.NewBuffer(encodeObjectHeader(kind, int64(len(gitObj.Content()))))
+			content = gitObj.Content()
+		}
+
+		zWriter := zlib.NewWriter(data)
+		if _, err := zWriter.Write(content); err != nil {
+			return err
+		}
+		if err := zWriter.Close(); err != nil {
+			return err
+		}
+		entry := packIndexEntry{offset: offset, crc: crc32.ChecksumIEEE(data.Bytes())}
+		if _, err := hex.Decode(entry.sha[:], []byte(sha)); err != nil {
+			return InvalidObject
+		}
+		entries = append(entries, entry)
+		offsets[sha] = offset
+		offset += uint64(data.Len())
+		_, err := out.Write(data.Bytes())
+		return err
+	}
+	for _, sha := range shas {
+		if err := writeObject(sha); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sum := hash.Sum(nil)
+	_, err = w.Write(sum)
+	return entries, sum, err
+}
+
+// writePackIndex writes the version 2 index of a pack, what ReadPackIndex
+// reads: the fanout table, the sorted shas, their crc and offset, offsets
+// past 31 bits in a table of their own, then the two checksums
+func writePackIndex(w io.Writer, entries []packIndexEntry, packChecksum []byte) error {
+	sorted := slices.Clone(entries)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].sha[:], sorted[j].sha[:]) < 0 })
+
+	buf := bytes.NewBuffer([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	fanout := [256]uint32{}
+	for _, entry := range sorted {
+		fanout[entry.sha[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(buf, binary.BigEndian, fanout)
+	for _, entry := range sorted {
+		buf.Write(entry.sha[:])
+	}
+	for _, entry := range sorted {
+		binary.Write(buf, binary.BigEndian, entry.crc)
+	}
+	large := []uint64{}
+	for _, entry := range sorted {
+		offset := uint32(entry.offset)
+		if entry.offset >= 0x80000000 {
+			offset = 0x80000000 | uint32(len(large))
+			large = append(large, entry.offset)
+		}
+		binary.Write(buf, binary.BigEndian, offset)
+	}
+	binary.Write(buf, binary.BigEndian, large)
+	buf.Write(packChecksum)
+	buf.Write(objectHasher.Sum(buf.Bytes()))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// inverse of parseObjectHeader
+func encodeObjectHeader(kind packFileKind, size int64) []byte {
+	b := byte(kind)<<4 | byte(size&0x0f)
+	size >>= 4
+	header := []byte{}
+	for size != 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(header, b)
+}
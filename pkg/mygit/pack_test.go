@@ -0,0 +1,283 @@
+package mygit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// rawObject is an object as stored in a pack, a delta names its base by the
+// index of an earlier object for an ofs-delta or by sha for a ref-delta
+type rawObject struct {
+	kind    packFileKind
+	data    []byte
+	base    int
+	baseSha string
+}
+
+// rawPack lays out objects in a pack, checksum included, and returns it with
+// the offset of each object
+func rawPack(t *testing.T, objects ...rawObject) ([]byte, []int64) {
+	t.Helper()
+	pack := bytes.NewBuffer([]byte("PACK"))
+	binary.Write(pack, binary.BigEndian, uint32(2))
+	binary.Write(pack, binary.BigEndian, uint32(len(objects)))
+	offsets := []int64{}
+	for _, object := range objects {
+		offset := int64(pack.Len())
+		offsets = append(offsets, offset)
+		pack.Write(encodeObjectHeader(object.kind, int64(len(object.data))))
+		switch object.kind {
+		case ofsDelta:
+			writeOfsDeltaOffset(pack, uint64(offset-offsets[object.base]))
+		case refDelta:
+			sha, err := hex.DecodeString(object.baseSha)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pack.Write(sha)
+		}
+		z := zlib.NewWriter(pack)
+		z.Write(object.data)
+		z.Close()
+	}
+	pack.Write(objectHasher.Sum(pack.Bytes()))
+	return pack.Bytes(), offsets
+}
+
+// deltaOf is the delta rebuilding target from base
+func deltaOf(base, target string) []byte {
+	return newDeltaIndex([]byte(base)).computeDelta([]byte(target), len(target)*2+32)
+}
+
+func blobSha(content string) string {
+	sha, _ := HashObject(&Blob{content: []byte(content)})
+	return fmt.Sprintf("%x", sha)
+}
+
+func TestDumpPack(t *testing.T) {
+	base := "a file long enough for a delta to be worth it\n"
+	pack, offsets := rawPack(t,
+		rawObject{kind: blob, data: []byte(base)},
+		rawObject{kind: ofsDelta, data: deltaOf(base, base+"more\n"), base: 0},
+		rawObject{kind: refDelta, data: deltaOf(base, base+"other\n"), baseSha: blobSha(base)},
+		rawObject{kind: tree, data: []byte{}},
+	)
+	testRepository(t)
+	before, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := bytes.Buffer{}
+	if err := DumpPack(&output, pack); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("version 2, 4 objects\n%d blob %d\n%d ofs-delta %d base-offset %d\n%d ref-delta %d base %s\n%d tree 0\n",
+		offsets[0], len(base),
+		offsets[1], len(deltaOf(base, base+"more\n")), offsets[0],
+		offsets[2], len(deltaOf(base, base+"other\n")), blobSha(base),
+		offsets[3])
+	if output.String() != want {
+		t.Errorf("dumped\n%s\nwant\n%s", output.String(), want)
+	}
+
+	after, err := AllObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("dumping wrote %d objects", len(after)-len(before))
+	}
+}
+
+func TestDumpPackRejectsCorruptPacks(t *testing.T) {
+	pack, _ := rawPack(t, rawObject{kind: blob, data: []byte("content\n")})
+	corrupt := bytes.Clone(pack)
+	corrupt[len(corrupt)-21] ^= 0xff
+	for name, raw := range map[string][]byte{
+		"truncated": pack[:20],
+		"checksum":  corrupt,
+		"magic":     append([]byte("KCAP"), pack[4:]...),
+	} {
+		if err := DumpPack(&bytes.Buffer{}, raw); err == nil {
+			t.Errorf("dumped a pack with a bad %s", name)
+		}
+	}
+}
+
+// similarHistory commits with git twenty versions of a file, each a line
+// away from the previous one, and returns the objects as rev-list lists them
+func similarHistory(t *testing.T) []byte {
+	t.Helper()
+	lines := make([]string, 2000)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i + 1)
+	}
+	for i := 1; i <= 20; i++ {
+		lines[i*50-1] = fmt.Sprint("edit ", i)
+		writeFiles(t, map[string]string{"numbers": strings.Join(lines, "\n") + "\n"})
+		runGit(t, "add", "numbers")
+		runGit(t, "commit", "-q", "-m", fmt.Sprint("edit ", i))
+	}
+	return gitOutput(t, nil, "rev-list", "--objects", "--all")
+}
+
+// runPackObjects packs objects with pack-objects into a temporary directory
+// and returns the path of the pack
+func runPackObjects(t *testing.T, objects []byte, args ...string) string {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "pack")
+	var output string
+	var err error
+	withStdin(t, objects, func() {
+		output, err = runCommand(t, append(append([]string{"-q", "pack-objects"}, args...), base)...)
+	})
+	if err != nil {
+		t.Fatalf("pack-objects %v: %v", args, err)
+	}
+	return base + "-" + strings.TrimSpace(output) + ".pack"
+}
+
+func TestPackObjectsDeltasReadByGit(t *testing.T) {
+	testRepository(t)
+	objects := similarHistory(t)
+	count := bytes.Count(objects, []byte("\n"))
+	whole := runPackObjects(t, objects, "--window=0")
+	deltas := runPackObjects(t, objects, "--depth=5")
+
+	// far smaller as deltas of each other
+	wholeInfo, err := os.Stat(whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltasInfo, err := os.Stat(deltas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltasInfo.Size()*4 >= wholeInfo.Size() {
+		t.Errorf("the pack with deltas has %d bytes, without %d", deltasInfo.Size(), wholeInfo.Size())
+	}
+
+	// git indexes it, the chains no longer than the depth
+	verify := runGit(t, "verify-pack", "-v", strings.TrimSuffix(deltas, ".pack")+".idx")
+	listed, longest := 0, ""
+	for _, line := range strings.Split(verify, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && isHash(fields[0]) {
+			listed++
+		}
+		if chain, found := strings.CutPrefix(line, "chain length = "); found {
+			longest, _, _ = strings.Cut(chain, ":")
+		}
+	}
+	if listed != count || longest != "5" {
+		t.Errorf("git verified %d objects of %d, the longest chain is %s\n%s", listed, count, longest, verify)
+	}
+
+	// every object reads back the same
+	want := runGit(t, "cat-file", "--batch-all-objects", "--batch-check")
+	testRepository(t)
+	if _, err := runCommand(t, "-q", "unpack-objects", deltas); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, "cat-file", "--batch-all-objects", "--batch-check"); got != want {
+		t.Errorf("unpacked\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIntroducedObjectsMatchesGit(t *testing.T) {
+	testRepository(t)
+	runGit(t, "commit", "-q", "--allow-empty", "-m", "empty")
+	writeFiles(t, map[string]string{"other/file": "kept\n", "dir/sub/old": "old\n"})
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-q", "-m", "directories")
+	writeFiles(t, map[string]string{"dir/sub/new": "new\n"})
+	runGit(t, "add", ".")
+	runGit(t, "commit", "-q", "-m", "add one file")
+
+	// git lists the commit first, then the objects it introduces with the
+	// paths, which are the blob and the trees up to the root only
+	introduced := func(args ...string) string {
+		_, objects, _ := strings.Cut(runGit(t, append([]string{"rev-list", "--objects"}, args...)...), "\n")
+		return objects
+	}
+	for _, c := range []struct{ rev, want string }{
+		{"HEAD", introduced("HEAD", "--not", "HEAD^")},
+		{runGit(t, "rev-parse", "HEAD^"), introduced("HEAD^", "--not", "HEAD~2")},
+		{runGit(t, "rev-parse", "HEAD~2"), introduced("HEAD~2")},
+	} {
+		output, err := runCommand(t, "introduced-objects", c.rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(output) != c.want {
+			t.Errorf("introduced-objects %s printed\n%s\ngit listed\n%s", c.rev, output, c.want)
+		}
+	}
+	if output, _ := runCommand(t, "introduced-objects", "HEAD"); strings.Count(output, "\n") != 4 {
+		t.Errorf("adding one file introduced\n%s", output)
+	}
+}
+
+func TestComparePacksMatchesGit(t *testing.T) {
+	testRepository(t)
+	objects := similarHistory(t)
+	deltas := runPackObjects(t, objects, "--depth=5")
+	whole := runPackObjects(t, objects, "--window=0")
+	gitPack := func(objects []byte) string {
+		t.Helper()
+		base := filepath.Join(t.TempDir(), "git")
+		hash := strings.TrimSpace(string(gitOutput(t, objects, "pack-objects", "-q", base)))
+		return base + "-" + hash + ".pack"
+	}
+	// git's ref-deltas, and a pack missing all but the first 3 objects
+	repacked := gitPack(objects)
+	lines := strings.SplitAfter(string(objects), "\n")
+	partial := gitPack([]byte(strings.Join(lines[:3], "")))
+	missing := []string{}
+	for _, line := range lines[3:] {
+		if sha, _, _ := strings.Cut(line, " "); strings.TrimSpace(sha) != "" {
+			missing = append(missing, strings.TrimSpace(sha))
+		}
+	}
+	slices.Sort(missing)
+	prefixed := func(prefix string) string {
+		var b strings.Builder
+		for _, sha := range missing {
+			b.WriteString(prefix + sha + "\n")
+		}
+		return b.String()
+	}
+
+	for _, c := range []struct {
+		a, b   string
+		output string
+		status int
+	}{
+		{repacked, deltas, "", 0},
+		{whole, repacked, "", 0},
+		{deltas, partial, prefixed("-"), 1},
+		{partial, whole, prefixed("+"), 1},
+	} {
+		output, err := runCommand(t, "-q", "compare-packs", c.a, c.b)
+		status := 0
+		var exit ExitStatus
+		if errors.As(err, &exit) {
+			status = int(exit)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if output != c.output || status != c.status {
+			t.Errorf("compare-packs %s %s printed\n%s\nexited with %d, want\n%s\nexit %d",
+				filepath.Base(c.a), filepath.Base(c.b), output, status, c.output, c.status)
+		}
+	}
+}
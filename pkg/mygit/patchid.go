@@ -0,0 +1,196 @@
+package mygit
+
+// https://git-scm.com/docs/git-patch-id
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/hex"
+	"hash"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PatchID is the id of the changes of a patch, the same for the same change
+// whatever the lines it applies at or the whitespace, with the commit the
+// patch came after in the input, zeroHash when none
+type PatchID struct {
+	ID     string
+	Commit string
+}
+
+// PatchIDOptions are the modes of git patch-id, by default the files are
+// hashed together so their order matters
+type PatchIDOptions struct {
+	Stable   bool // each file hashed on its own and the hashes summed
+	Verbatim bool // whitespace is kept, implies Stable
+}
+
+// patchIDHasher hashes a patch like git's get_one_patchid
+type patchIDHasher struct {
+	opts   PatchIDOptions
+	hasher hash.Hash
+	result [20]byte
+}
+
+// flush adds the hash of what was hashed since the last flush to the
+// result, a sum with carry so the order of the files does not matter
+func (h *patchIDHasher) flush() {
+	sum := h.hasher.Sum(nil)
+	h.hasher.Reset()
+	carry := 0
+	for i := range h.result {
+		carry += int(h.result[i]) + int(sum[i])
+		h.result[i] = byte(carry)
+		carry >>= 8
+	}
+}
+
+// PatchIDs reads patches like git log -p or git diff prints them and
+// returns the patch-id of each, those without any diff are left out. A
+// "commit <sha>", "From <sha>" or "diff-tree <sha>" line, or one that is
+// only a sha, names the commit of the patch following it.
+func PatchIDs(r io.Reader, opts PatchIDOptions) ([]PatchID, error) {
+	opts.Stable = opts.Stable || opts.Verbatim
+	in := bufio.NewReader(r)
+	ids, commit := []PatchID{}, zeroHash
+	for {
+		h := &patchIDHasher{opts: opts, hasher: objectHasher.New()}
+		next, length, err := h.read(in)
+		if err != nil {
+			return nil, err
+		}
+		if length > 0 {
+			ids = append(ids, PatchID{ID: hex.EncodeToString(h.result[:]), Commit: commit})
+		}
+		if next == "" {
+			if _, err := in.Peek(1); err == io.EOF {
+				return ids, nil
+			}
+		}
+		commit = cmp.Or(next, zeroHash)
+	}
+}
+
+// read hashes one patch and returns the commit the next one comes after,
+// empty when the input ended or the patch did without naming one, and how
+// many bytes were hashed
+func (h *patchIDHasher) read(in *bufio.Reader) (string, int, error) {
+	length, before, after := 0, -1, -1
+	binary, oldSha, newSha := false, "", ""
+	defer h.flush()
+	for {
+		line, err := in.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return "", length, nil
+		}
+		if err != nil && err != io.EOF {
+			return "", length, err
+		}
+
+		rest, named := line, false
+		for _, prefix := range []string{"diff-tree ", "commit ", "From "} {
+			if strings.HasPrefix(line, prefix) {
+				rest, named = line[len(prefix):], true
+				break
+			}
+		}
+		// "\ No newline at end of file"
+		if !named && strings.HasPrefix(line, "\\ ") && len(line) > 12 {
+			if h.opts.Verbatim {
+				h.hasher.Write([]byte(line))
+			}
+			continue
+		}
+		if len(rest) >= 40 && isHash(rest[:40]) {
+			return rest[:40], length, nil
+		}
+		// the commit message before the first diff
+		if length == 0 && !strings.HasPrefix(line, "diff ") {
+			continue
+		}
+
+		if before == -1 {
+			switch {
+			case strings.HasPrefix(line, "GIT binary patch") || strings.HasPrefix(line, "Binary files"):
+				// binary changes are told apart by the blobs alone
+				binary, before = true, 0
+				h.hasher.Write([]byte(oldSha + newSha))
+				if h.opts.Stable {
+					h.flush()
+				}
+				continue
+			case strings.HasPrefix(line, "index "):
+				shas, _, _ := strings.Cut(strings.TrimSpace(line[len("index "):]), " ")
+				if from, to, found := strings.Cut(shas, ".."); found {
+					oldSha, newSha = from, to
+				}
+				continue
+			case strings.HasPrefix(line, "--- "):
+				before, after = 1, 1
+			case !isAlpha(line[0]):
+				return "", length, nil
+			}
+		}
+
+		if binary {
+			if strings.HasPrefix(line, "diff ") {
+				binary, before = false, -1
+			}
+			continue
+		}
+
+		if before == 0 && after == 0 {
+			if strings.HasPrefix(line, "@@ -") {
+				// the counts tell where the hunk ends, the line numbers are left out
+				before, after = hunkCounts(line)
+				continue
+			}
+			if !strings.HasPrefix(line, "diff ") {
+				return "", length, nil
+			}
+			if h.opts.Stable {
+				h.flush()
+			}
+			before, after = -1, -1
+		}
+
+		if line[0] == '-' || line[0] == ' ' {
+			before--
+		}
+		if line[0] == '+' || line[0] == ' ' {
+			after--
+		}
+		hashed := []byte(line)
+		if !h.opts.Verbatim {
+			// byte by byte, the content may not be UTF-8
+			hashed = slices.DeleteFunc(hashed, func(c byte) bool { return isSpace(rune(c)) })
+		}
+		length += len(hashed)
+		h.hasher.Write(hashed)
+	}
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// hunkCounts reads the line counts of "@@ -<start>[,<count>] +<start>[,<count>] @@",
+// a missing count is 1
+func hunkCounts(header string) (before, after int) {
+	fields := strings.Fields(header)
+	count := func(i int) int {
+		if i >= len(fields) {
+			return 0
+		}
+		_, n, found := strings.Cut(fields[i], ",")
+		if !found {
+			return 1
+		}
+		value, _ := strconv.Atoi(n)
+		return value
+	}
+	return count(1), count(2)
+}
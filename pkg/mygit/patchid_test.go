@@ -0,0 +1,48 @@
+package mygit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPatchIDMatchesGit(t *testing.T) {
+	testRepository(t)
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	writeFiles(t, map[string]string{"f": numberedLines(9, nil), "g": "other\n"})
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "base")
+	runGit(t, "checkout", "-q", "-b", "topic")
+	writeFiles(t, map[string]string{"f": numberedLines(9, map[int]string{8: "eight"}), "g": "0\n"})
+	runGit(t, "commit", "-q", "-a", "-m", "change")
+	// the same change picked onto another base, its hunks moved by a line
+	runGit(t, "checkout", "-q", "main")
+	writeFiles(t, map[string]string{"f": "new first line\n" + numberedLines(9, nil)})
+	runGit(t, "commit", "-q", "-a", "-m", "another base")
+	runGit(t, "cherry-pick", "topic")
+
+	patchID := func(args ...string) string {
+		t.Helper()
+		output, err := runCommand(t, append([]string{"patch-id"}, args...)...)
+		if err != nil {
+			t.Fatalf("patch-id %v: %v", args, err)
+		}
+		return strings.TrimSpace(output)
+	}
+	picked, topic := patchID("main"), patchID("topic")
+	if id, _, _ := strings.Cut(picked, " "); !strings.HasPrefix(topic, id+" ") {
+		t.Errorf("the picked change has the id %s, the original %s", picked, topic)
+	}
+	if want := strings.TrimSpace(string(gitOutput(t, gitOutput(t, nil, "show", "main"), "patch-id"))); picked != want {
+		t.Errorf("patch-id main printed %s, git %s", picked, want)
+	}
+
+	// the patches of log -p read from stdin
+	patches := gitOutput(t, nil, "log", "-p", "--all")
+	for _, opts := range [][]string{nil, {"--stable"}, {"--verbatim"}} {
+		var output string
+		withStdin(t, patches, func() { output = patchID(opts...) })
+		if want := strings.TrimSpace(string(gitOutput(t, patches, append([]string{"patch-id"}, opts...)...))); output != want {
+			t.Errorf("patch-id %v printed\n%s\ngit printed\n%s", opts, output, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package mygit
+
+// https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Pathspec is a path or a pattern given on the command line, resolved from
+// where mygit was started to the worktree root
+type Pathspec struct {
+	Original string // as given, for messages
+	Pattern  string // relative to the worktree root, empty for all of it
+	Glob     bool   // :(glob), "*" stops at slashes and "**" crosses them
+	Literal  bool   // :(literal), wildcards are plain characters
+}
+
+// ParsePathspec resolves arg against prefix, the directory mygit was
+// started from as enterWorktree returns it. Like git a pathspec starting
+// with ":/" or ":(top)" is from the root whatever the prefix, and ":(glob)"
+// and ":(literal)" change how wildcards match. Other magic is refused.
+func ParsePathspec(arg, prefix string) (Pathspec, error) {
+	spec, pattern, top := Pathspec{Original: arg}, arg, false
+	switch {
+	case strings.HasPrefix(arg, ":("):
+		magic, rest, found := strings.Cut(arg[2:], ")")
+		if !found {
+			return spec, fmt.Errorf("%w: missing ')' at the end of the pathspec magic in '%s'", InvalidArgsError, arg)
+		}
+		for _, word := range strings.Split(magic, ",") {
+			switch word {
+			case "top":
+				top = true
+			case "glob":
+				spec.Glob = true
+			case "literal":
+				spec.Literal = true
+			default:
+				return spec, fmt.Errorf("%w: unsupported pathspec magic '%s' in '%s'", InvalidArgsError, word, arg)
+			}
+		}
+		if spec.Glob && spec.Literal {
+			return spec, fmt.Errorf("%w: 'glob' and 'literal' pathspec magic are incompatible", InvalidArgsError)
+		}
+		pattern = rest
+	case strings.HasPrefix(arg, ":/"):
+		pattern, top = strings.TrimPrefix(arg[2:], ":"), true
+	case strings.HasPrefix(arg, ":") && len(arg) > 1:
+		return spec, fmt.Errorf("%w: unsupported pathspec magic in '%s'", InvalidArgsError, arg)
+	}
+
+	if !top {
+		pattern = prefix + pattern
+	}
+	dir := strings.HasSuffix(pattern, "/")
+	switch pattern = path.Clean(pattern); {
+	case pattern == ".." || strings.HasPrefix(pattern, "../") || path.IsAbs(pattern):
+		return spec, fmt.Errorf("%w: '%s' is outside the repository", InvalidArgsError, arg)
+	case pattern == ".":
+		pattern = ""
+	case dir:
+		pattern += "/"
+	}
+	spec.Pattern = pattern
+	return spec, nil
+}
+
+// ParsePathspecs resolves every arg with ParsePathspec
+func ParsePathspecs(args []string, prefix string) ([]Pathspec, error) {
+	specs := []Pathspec{}
+	for _, arg := range args {
+		spec, err := ParsePathspec(arg, prefix)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// hasWildcards tells whether the pattern is matched as a pattern, git
+// matches one without wildcards as a path
+func (p Pathspec) hasWildcards() bool {
+	return !p.Literal && strings.ContainsAny(p.Pattern, "*?[")
+}
+
+// Matches tells whether the file at name, relative to the worktree root,
+// is one the pathspec names: the path itself or one under it, or one the
+// pattern matches. Without :(glob) wildcards match slashes too, like git.
+func (p Pathspec) Matches(name string) bool {
+	if !p.hasWildcards() {
+		return matchesPaths(name, []string{p.Pattern})
+	}
+	return wildmatch(p.Pattern, name, p.Glob)
+}
+
+// matchesPathspecs tells whether name matches one of specs, any name when
+// there are none
+func matchesPathspecs(name string, specs []Pathspec) bool {
+	return len(specs) == 0 || slices.ContainsFunc(specs, func(p Pathspec) bool { return p.Matches(name) })
+}
+
+// wildmatch is fnmatch with "*", "?", "[...]" and "\" escapes. With
+// pathname set wildcards do not match "/" but "**" matches across
+// directories, "**/" possibly none.
+func wildmatch(pattern, name string, pathname bool) bool {
+	for len(pattern) > 0 {
+		switch c := pattern[0]; c {
+		case '*':
+			double := strings.HasPrefix(pattern, "**")
+			rest := strings.TrimLeft(pattern, "*")
+			crosses := !pathname || double
+			if pathname && double && strings.HasPrefix(rest, "/") && wildmatch(rest[1:], name, pathname) {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if wildmatch(rest, name[i:], pathname) {
+					return true
+				}
+				if i < len(name) && name[i] == '/' && !crosses {
+					return false
+				}
+			}
+			return false
+		case '?':
+			if name == "" || pathname && name[0] == '/' {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		case '[':
+			end := -1
+			if len(pattern) > 2 {
+				end = strings.IndexByte(pattern[2:], ']')
+			}
+			if end < 0 || name == "" || pathname && name[0] == '/' {
+				return false
+			}
+			class := pattern[1 : end+2]
+			negate := strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					matched = matched || class[i] <= name[0] && name[0] <= class[i+2]
+					i += 2
+				} else {
+					matched = matched || class[i] == name[0]
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern, name = pattern[end+3:], name[1:]
+		default:
+			if c == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+				c = pattern[0]
+			}
+			if name == "" || name[0] != c {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+// expandPathspecs turns specs into paths stagePaths takes: the pattern of a
+// path, the files a pattern matches in the worktree or the index otherwise.
+// A pattern matching nothing is an error, like in git.
+func expandPathspecs(idx *Index, specs []Pathspec) ([]string, error) {
+	paths := []string{}
+	for _, spec := range specs {
+		if !spec.hasWildcards() {
+			paths = append(paths, cmp.Or(spec.Pattern, "."))
+			continue
+		}
+		found := map[string]bool{}
+		err := fs.WalkDir(os.DirFS("."), ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			if !d.IsDir() && spec.Matches(p) {
+				found[p] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// files deleted from the worktree are staged as removed, not those
+		// the sparse checkout left out
+		for _, e := range idx.Entries {
+			if spec.Matches(e.Path) && e.ExtendedFlags&IndexSkipWorktree == 0 {
+				found[e.Path] = true
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("pathspec '%s' did not match any files", spec.Original)
+		}
+		matched := make([]string, 0, len(found))
+		for p := range found {
+			matched = append(matched, p)
+		}
+		slices.Sort(matched)
+		paths = append(paths, matched...)
+	}
+	return paths, nil
+}
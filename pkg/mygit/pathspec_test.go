@@ -0,0 +1,57 @@
+package mygit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathspecsMatchGit(t *testing.T) {
+	root := testRepository(t)
+	writeFiles(t, map[string]string{
+		"src/a.c":     "a\n",
+		"src/lib/b.c": "b\n",
+		"src/lib/c.h": "c\n",
+		"docs/d.md":   "d\n",
+		"top.c":       "t\n",
+	})
+	// git run from src like mygit
+	gitInSrc := func(args ...string) string {
+		t.Helper()
+		return runGit(t, append([]string{"-C", "src", "--git-dir=" + filepath.Join(root, ".git"), "--work-tree=" + root}, args...)...)
+	}
+
+	// relative to src, ":/" and ":(top)" from the root, wildcards like git's
+	for _, specs := range [][]string{{"lib/b.c", ":/docs"}, {"*.h"}, {"../top.c"}, {":(top,glob)src/*.c"}} {
+		if err := os.Remove(indexFile()); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		gitInSrc(append([]string{"add"}, specs...)...)
+		want := runGit(t, "ls-files", "-s")
+		if err := os.Remove(indexFile()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runCommand(t, append([]string{"-C", "src", "add"}, specs...)...); err != nil {
+			t.Fatalf("add %v: %v", specs, err)
+		}
+		if got := runGit(t, "ls-files", "-s"); got != want {
+			t.Errorf("add %v from src staged\n%s\ngit staged\n%s", specs, got, want)
+		}
+	}
+
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "pathspecs")
+	writeFiles(t, map[string]string{"top.c": "t\nmore\n", "src/a.c": "a\nmore\n"})
+	output, err := runCommand(t, "-C", "src", "diff", "--", ":/top.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gitInSrc("diff", "--", ":/top.c"); strings.TrimSpace(output) != want {
+		t.Errorf("diff -- :/top.c from src printed\n%s\ngit printed\n%s", output, want)
+	}
+
+	if _, err := runCommand(t, "-C", "src", "-q", "add", "../../elsewhere"); err == nil {
+		t.Error("add outside the repository succeeded")
+	}
+}
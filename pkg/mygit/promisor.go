@@ -0,0 +1,147 @@
+package mygit
+
+// https://git-scm.com/docs/partial-clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	InvalidFilter = errors.New("Invalid object filter.")
+)
+
+// the remote a partial clone promises its missing objects from
+const promisorRemote = "origin"
+
+// ValidateFilter accepts the filters a partial clone supports: blob:none and
+// blob:limit=<n> with an optional k, m or g unit
+func ValidateFilter(spec string) error {
+	if spec == "blob:none" {
+		return nil
+	}
+	limit, found := strings.CutPrefix(spec, "blob:limit=")
+	if !found {
+		return fmt.Errorf("%w: %q", InvalidFilter, spec)
+	}
+	limit = strings.TrimRight(strings.ToLower(limit), "kmg")
+	if _, err := strconv.ParseUint(limit, 10, 64); err != nil {
+		return fmt.Errorf("%w: %q", InvalidFilter, spec)
+	}
+	return nil
+}
+
+// recordPromisor marks the repository as a partial clone of url, git needs
+// format version 1 to honour the extension
+func recordPromisor(url, filter string) error {
+	settings := [][2]string{
+		{"core.repositoryformatversion", "1"},
+		{"extensions.partialclone", promisorRemote},
+		{"remote." + promisorRemote + ".url", url},
+		{"remote." + promisorRemote + ".promisor", "true"},
+		{"remote." + promisorRemote + ".partialclonefilter", filter},
+	}
+	for _, setting := range settings {
+		if err := SetConfig(gitPath("config"), setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PromisorURL returns the url of the remote promising the objects missing
+// from a partial clone, ok is false when the repository is complete
+func PromisorURL() (url string, ok bool, _ error) {
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return "", false, err
+	}
+	remote, ok := cfg.Get("extensions.partialclone")
+	if !ok {
+		return "", false, nil
+	}
+	url, ok = cfg.Get("remote." + remote + ".url")
+	if !ok {
+		return "", false, fmt.Errorf("%w: promisor remote %q has no url", InvalidConfig, remote)
+	}
+	return url, true, nil
+}
+
+// FetchPromisedObjects asks the promisor remote for the objects at shas and
+// writes them to the repository
+func FetchPromisedObjects(ctx context.Context, shas []string) (ObjectCounts, error) {
+	url, ok, err := PromisorURL()
+	if err != nil {
+		return ObjectCounts{}, err
+	}
+	if !ok {
+		return ObjectCounts{}, fmt.Errorf("%w: not a partial clone, %d objects are missing", ObjectNotFound, len(shas))
+	}
+	adv, err := GetRefs(ctx, url)
+	if err != nil {
+		return ObjectCounts{}, err
+	}
+	Verbosef("Fetching %d promised objects from %s\n", len(shas), adv.URL)
+	resp, err := UploadPack(ctx, adv.URL, UploadPackRequest{
+		Wants:        shas,
+		Capabilities: adv.Negotiate(clientCapabilities),
+	})
+	if err != nil {
+		return ObjectCounts{}, err
+	}
+	return unpackPack(ctx, resp.Pack)
+}
+
+// the promisor remote url is only looked up once, empty for a complete
+// repository. Workers missing objects fetch them one at a time.
+var (
+	promisorURL       string
+	promisorURLLoaded bool
+	promisorMu        sync.Mutex
+)
+
+// fetchPromisedObject fetches the missing object at sha, promised is false
+// when the repository is not a partial clone and nothing was fetched.
+// Object reads take no context so the fetch cannot be cancelled.
+func fetchPromisedObject(sha string) (promised bool, _ error) {
+	promisorMu.Lock()
+	defer promisorMu.Unlock()
+	if !promisorURLLoaded {
+		url, ok, err := PromisorURL()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			promisorURL = url
+		}
+		promisorURLLoaded = true
+	}
+	if promisorURL == "" {
+		return false, nil
+	}
+	Verbosef("Fetching missing object %s\n", sha)
+	_, err := FetchPromisedObjects(context.Background(), []string{sha})
+	return true, err
+}
+
+// missingBlobs lists the blobs of the tree-ish at sha that are not in the
+// repository, each once
+func missingBlobs(sha string) ([]string, error) {
+	files, err := FlattenTree(sha)
+	if err != nil {
+		return nil, err
+	}
+	missing, seen := []string{}, map[string]bool{}
+	for _, file := range files {
+		if seen[file.hash] || HasObject(file.hash) {
+			continue
+		}
+		seen[file.hash] = true
+		missing = append(missing, file.hash)
+	}
+	return missing, nil
+}
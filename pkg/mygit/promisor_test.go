@@ -0,0 +1,183 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateFilter(t *testing.T) {
+	for _, spec := range []string{"blob:none", "blob:limit=0", "blob:limit=100", "blob:limit=1k", "blob:limit=2M", "blob:limit=3g"} {
+		if err := ValidateFilter(spec); err != nil {
+			t.Errorf("%s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "blob", "blob:limit=", "blob:limit=k", "blob:limit=-1", "blob:limit=1t", "tree:0", "sparse:oid=main"} {
+		if err := ValidateFilter(spec); !errors.Is(err, InvalidFilter) {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+}
+
+// newPartialRemote is the fixture remote honouring blob:none: the filtered
+// pack has no blobs, and wants of blobs get those blobs alone. It returns
+// the remote and its blobs.
+func newPartialRemote(t *testing.T) (*fixtureRemote, map[string]string) {
+	t.Helper()
+	remote := newFixtureRemote(t)
+	remote.capabilities = append(remote.capabilities, "filter")
+	objects, err := ReachableObjects([]string{remote.refs[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, others := map[string]string{}, []string{}
+	for _, sha := range objects {
+		kind, content, err := readObject(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind == BlobKind {
+			blobs[sha] = string(content)
+		} else {
+			others = append(others, sha)
+		}
+	}
+	filtered := bytes.Buffer{}
+	if err := WritePack(&filtered, others); err != nil {
+		t.Fatal(err)
+	}
+
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		wants, filter := []string{}, ""
+		r := bytes.NewReader(body)
+		for {
+			line, err := parsePacketLine(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fields := strings.Fields(string(line))
+			switch {
+			case len(fields) >= 2 && fields[0] == "want":
+				wants = append(wants, fields[1])
+			case len(fields) == 2 && fields[0] == "filter":
+				filter = fields[1]
+			}
+		}
+		io.WriteString(w, serializePackeLine("NAK\n"))
+		if filter != "" {
+			w.Write(filtered.Bytes())
+			return
+		}
+		objects := []rawObject{}
+		for _, sha := range wants {
+			content, ok := blobs[sha]
+			if !ok {
+				t.Errorf("%s is not a blob of the remote", sha)
+				return
+			}
+			objects = append(objects, rawObject{kind: blob, data: []byte(content)})
+		}
+		pack, _ := rawPack(t, objects...)
+		w.Write(pack)
+	}
+	return remote, blobs
+}
+
+func TestPartialClone(t *testing.T) {
+	remote, blobs := newPartialRemote(t)
+	url := remote.serve(t)
+	result := cloneFixture(t, CloneOptions{URL: url, Filter: "blob:none"})
+
+	// the filtered pack, then the blobs of the checkout at once
+	if len(remote.requests) != 2 || !bytes.Contains(remote.requests[0], []byte("filter blob:none\n")) {
+		t.Fatalf("upload-pack requests %q", remote.requests)
+	}
+	if result.Objects.Blobs != 2 {
+		t.Errorf("received %s, want the 2 blobs of the checkout", result.Objects)
+	}
+	files := readWorktree(t, "file", "dir/nested")
+	if files["file"] != "two\n" || files["dir/nested"] != "nested\n" {
+		t.Errorf("the worktree is %q", files)
+	}
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"core.repositoryformatversion":     "1",
+		"extensions.partialclone":          "origin",
+		"remote.origin.url":                url,
+		"remote.origin.promisor":           "true",
+		"remote.origin.partialclonefilter": "blob:none",
+	} {
+		if value, _ := cfg.Get(key); value != want {
+			t.Errorf("%s is %q, want %q", key, value, want)
+		}
+	}
+	// only the blob of the first commit was left out
+	for sha, content := range blobs {
+		if HasObject(sha) != (content != "one\n") {
+			t.Errorf("the clone has %q: %v", content, HasObject(sha))
+		}
+	}
+}
+
+func TestFilterIgnoredWithoutServerSupport(t *testing.T) {
+	remote := newFixtureRemote(t)
+	cloneFixture(t, CloneOptions{URL: remote.serve(t), Filter: "blob:none"})
+	if bytes.Contains(remote.requests[0], []byte("filter")) {
+		t.Errorf("a filter was sent to a server without the capability: %q", remote.requests[0])
+	}
+	if !HasObject(blobSha("one\n")) {
+		t.Error("the fallback clone is not complete")
+	}
+	if _, ok, err := PromisorURL(); ok || err != nil {
+		t.Errorf("the full clone has a promisor remote, %v", err)
+	}
+	if _, err := FetchPromisedObjects(context.Background(), []string{blobSha("one\n")}); !errors.Is(err, ObjectNotFound) {
+		t.Errorf("fetching promised objects of a full clone: %v", err)
+	}
+}
+
+func TestPartialCloneFetchesOnDemand(t *testing.T) {
+	remote, _ := newPartialRemote(t)
+	first := remote.refs[1].Hash
+	cloneFixture(t, CloneOptions{URL: remote.serve(t), Filter: "blob:none"})
+	missing := blobSha("one\n")
+	if HasObject(missing) {
+		t.Fatal("the blob of the first commit was cloned")
+	}
+
+	// reading the old version fetches its blob, once
+	output, err := runCommand(t, "cat-file", "-p", first+":file")
+	if err != nil || output != "one\n" {
+		t.Fatalf("cat-file printed %q, %v", output, err)
+	}
+	if len(remote.requests) != 3 || !bytes.Contains(remote.requests[2], []byte("want "+missing)) {
+		t.Errorf("upload-pack requests %q", remote.requests)
+	}
+	if !HasObject(missing) {
+		t.Error("the fetched blob was not stored")
+	}
+	if _, err := runCommand(t, "cat-file", "-p", first+":file"); err != nil || len(remote.requests) != 3 {
+		t.Errorf("a second read made %d requests, %v", len(remote.requests), err)
+	}
+
+	// an object the remote does not have either is still missing
+	remote.uploadPack = func(w http.ResponseWriter, body []byte) {
+		io.WriteString(w, serializePackeLine("NAK\n"))
+		pack, _ := rawPack(t)
+		w.Write(pack)
+	}
+	if _, _, err := readObject(blobSha("never existed\n")); !errors.Is(err, ObjectNotFound) {
+		t.Errorf("reading an object nobody has: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package mygit
+
+// https://git-scm.com/docs/protocol-v2
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// separates the capabilities of a command from its arguments
+const delimPacket = "0001"
+
+// the capabilities of a version 2 server, every command is one of them,
+// with object-format=<format> of the repository
+var serverCapabilitiesV2 = []string{"agent=" + agent, "ls-refs", "fetch"}
+
+// wantsProtocolV2 tells whether $GIT_PROTOCOL, or the Git-Protocol header
+// over HTTP, asks for version 2
+func wantsProtocolV2(value string) bool {
+	return slices.Contains(strings.Split(value, ":"), "version=2")
+}
+
+// readPacketV2 reads a pkt-line without its newline, special is set instead
+// for a flush or a delim packet
+func readPacketV2(r io.Reader) (line, special string, _ error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", "", err
+	}
+	switch string(length) {
+	case flushPacket, delimPacket:
+		return "", string(length), nil
+	}
+	size, err := strconv.ParseUint(string(length), 16, 16)
+	if err != nil || size < 4 {
+		return "", "", fmt.Errorf("packet line has invalid length %q", length)
+	}
+	data := make([]byte, size-4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", "", fmt.Errorf("packet line doesnt' match declared length: %w", err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), "", nil
+}
+
+// commandV2 is a request: "command=<name>", capabilities, a delim packet,
+// the arguments and a flush
+type commandV2 struct {
+	name         string
+	capabilities []string
+	args         []string
+}
+
+// readCommandV2 reads the next request, io.EOF once the client is done
+func readCommandV2(r io.Reader) (*commandV2, error) {
+	cmd, inArgs := &commandV2{}, false
+	for {
+		line, special, err := readPacketV2(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case special == flushPacket && cmd.name == "" && !inArgs:
+			return nil, io.EOF // a flush alone ends the session
+		case special == flushPacket:
+			return cmd, nil
+		case special == delimPacket:
+			inArgs = true
+		case inArgs:
+			cmd.args = append(cmd.args, line)
+		case strings.HasPrefix(line, "command=") && cmd.name == "":
+			cmd.name = strings.TrimPrefix(line, "command=")
+		default:
+			cmd.capabilities = append(cmd.capabilities, line)
+		}
+	}
+}
+
+// serveProtocolV2 advertises the capabilities then answers commands until
+// the client is done, or only the first one when stateless
+func serveProtocolV2(ctx context.Context, r io.Reader, w *bufio.Writer, opts UploadPackOptions) error {
+	if !opts.StatelessRPC || opts.AdvertiseRefs {
+		format, err := RepositoryObjectFormat()
+		if err != nil {
+			return err
+		}
+		w.WriteString(serializePackeLine("version 2\n"))
+		for _, capability := range append(serverCapabilitiesV2, "object-format="+string(format)) {
+			w.WriteString(serializePackeLine(capability + "\n"))
+		}
+		w.WriteString(flushPacket)
+		if err := w.Flush(); err != nil || opts.AdvertiseRefs {
+			return err
+		}
+	}
+
+	for {
+		cmd, err := readCommandV2(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch cmd.name {
+		case "ls-refs":
+			err = lsRefs(w, cmd.args)
+		case "fetch":
+			err = fetchV2(ctx, w, cmd.args)
+		default:
+			err = fmt.Errorf("upload-pack: unknown command %q", cmd.name)
+		}
+		if err != nil {
+			w.WriteString(serializePackeLine("ERR " + err.Error() + "\n"))
+			return err
+		}
+		if err := w.Flush(); err != nil || opts.StatelessRPC {
+			return err
+		}
+	}
+}
+
+// lsRefs lists HEAD and the refs starting with one of the ref-prefix
+// arguments, all of them without any. symrefs adds the target of symbolic
+// refs and peel the object an annotated tag points to.
+func lsRefs(w io.Writer, args []string) error {
+	symrefs, peel, prefixes := false, false, []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peel = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(arg, "ref-prefix "))
+		default:
+			return fmt.Errorf("upload-pack: unexpected ls-refs argument %q", arg)
+		}
+	}
+
+	refs, err := ListRefs()
+	if err != nil {
+		return err
+	}
+	if head, err := readRef("HEAD"); err == nil {
+		refs = append([]Ref{{Name: "HEAD", Hash: head}}, refs...)
+	}
+	for _, ref := range refs {
+		matches := len(prefixes) == 0
+		for _, prefix := range prefixes {
+			matches = matches || strings.HasPrefix(ref.Name, prefix)
+		}
+		if !matches {
+			continue
+		}
+
+		line := ref.Hash + " " + ref.Name
+		if symrefs {
+			// a packed ref is never symbolic
+			if target, ok, err := ReadSymbolicRef(ref.Name); err != nil && !os.IsNotExist(err) {
+				return err
+			} else if ok {
+				line += " symref-target:" + target
+			}
+		}
+		if kind, err := ObjectType(ref.Hash); peel && err == nil && kind == TagKind {
+			peeled, _, err := PeelObject(ref.Hash)
+			if err != nil {
+				return fmt.Errorf("%s: %w", ref.Name, err)
+			}
+			line += " peeled:" + peeled
+		}
+		if _, err := io.WriteString(w, serializePackeLine(line+"\n")); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, flushPacket)
+	return err
+}
+
+// fetchV2 acknowledges the haves it has too until the client is done, then
+// sends the pack in the packfile section, always over side-band-64k
+func fetchV2(ctx context.Context, w io.Writer, args []string) error {
+	wants, haves, done, includeTags := []string{}, []string{}, false, false
+	for _, arg := range args {
+		keyword, value, _ := strings.Cut(arg, " ")
+		switch {
+		case keyword == "want" && isHash(value):
+			wants = append(wants, value)
+		case keyword == "have" && isHash(value):
+			haves = append(haves, value)
+		case arg == "done":
+			done = true
+		case arg == "include-tag":
+			includeTags = true
+		case arg == "thin-pack" || arg == "no-progress" || arg == "ofs-delta":
+			// nothing to do, the pack has no deltas and no progress is sent
+		default:
+			return fmt.Errorf("upload-pack: unexpected fetch argument %q", arg)
+		}
+	}
+	refs, err := AdvertisedRefs()
+	if err != nil {
+		return err
+	}
+	if err := checkWants(wants, refs); err != nil {
+		return err
+	}
+	common := []string{}
+	for _, sha := range haves {
+		if HasObject(sha) && !slices.Contains(common, sha) {
+			common = append(common, sha)
+		}
+	}
+
+	if !done {
+		io.WriteString(w, serializePackeLine("acknowledgments\n"))
+		if len(common) == 0 {
+			io.WriteString(w, serializePackeLine("NAK\n"))
+		}
+		for _, sha := range common {
+			io.WriteString(w, serializePackeLine("ACK "+sha+"\n"))
+		}
+		_, err := io.WriteString(w, flushPacket)
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	objects, err := objectsToSend(wants, common, includeTags)
+	if err != nil {
+		return err
+	}
+	Verbosef("Sending %d objects\n", len(objects))
+	io.WriteString(w, serializePackeLine("packfile\n"))
+	if err := WritePack(&sidebandWriter{w: w, band: 1, max: 65520}, objects); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, flushPacket)
+	return err
+}
@@ -0,0 +1,169 @@
+package mygit
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// PathStep is an object on the way from a root to another object, with
+// where it is in the tree of the commit for trees and blobs
+type PathStep struct {
+	Kind ObjectKind
+	Sha  string
+	Path string // empty for the root tree and objects outside trees
+}
+
+func (s PathStep) String() string {
+	if s.Path == "" {
+		return fmt.Sprintf("%s %s", s.Kind, s.Sha)
+	}
+	return fmt.Sprintf("%s %s (%s)", s.Kind, s.Sha, s.Path)
+}
+
+// ReachabilityPath finds why target is kept: the root naming it, as fsck
+// and prune see roots, and the objects leading from that root to target,
+// the root object first and target last. The walk is breadth first so the
+// chain is one of the shortest from the refs, or from the reflogs when no
+// ref reaches target, or from the index when neither does. No steps means
+// target is unreachable.
+func ReachabilityPath(target string) (string, []PathStep, error) {
+	roots, err := namedRoots()
+	if err != nil {
+		return "", nil, err
+	}
+	shallow, err := readShallow()
+	if err != nil {
+		return "", nil, err
+	}
+
+	type visit struct {
+		step PathStep
+		prev string // sha of the object referencing this one, empty for roots
+		root string
+	}
+	visited := map[string]visit{}
+	queue := []string{}
+	seed := func(roots []Ref) error {
+		for _, root := range roots {
+			if _, ok := visited[root.Hash]; ok {
+				continue
+			}
+			kind, err := ObjectType(root.Hash)
+			if err != nil {
+				return fmt.Errorf("%s: %w", root.Name, err)
+			}
+			visited[root.Hash] = visit{step: PathStep{Kind: kind, Sha: root.Hash}, root: root.Name}
+			queue = append(queue, root.Hash)
+		}
+		return nil
+	}
+	walk := func() error {
+		for len(queue) > 0 {
+			if _, ok := visited[target]; ok {
+				return nil
+			}
+			sha := queue[0]
+			queue = queue[1:]
+			current := visited[sha]
+			next, err := referencedSteps(current.step, shallow)
+			if err != nil {
+				return err
+			}
+			for _, step := range next {
+				if _, ok := visited[step.Sha]; ok {
+					continue
+				}
+				visited[step.Sha] = visit{step: step, prev: sha, root: current.root}
+				// blobs and submodule commits reference nothing stored here
+				if step.Path == "" || step.Kind == TreeKind {
+					queue = append(queue, step.Sha)
+				}
+			}
+		}
+		return nil
+	}
+
+	// the reflogs are only looked at when no ref reaches target, the index
+	// when nothing else does
+	refs, reflogs, index := []Ref{}, []Ref{}, []Ref{}
+	for _, root := range roots {
+		if strings.HasPrefix(root.Name, ":") {
+			index = append(index, root)
+		} else if strings.Contains(root.Name, "@{") {
+			reflogs = append(reflogs, root)
+		} else {
+			refs = append(refs, root)
+		}
+	}
+	for _, roots := range [][]Ref{refs, reflogs, index} {
+		if err := seed(roots); err != nil {
+			return "", nil, err
+		}
+		if err := walk(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	found, ok := visited[target]
+	if !ok {
+		return "", nil, nil
+	}
+	steps := []PathStep{found.step}
+	for found.prev != "" {
+		found = visited[found.prev]
+		steps = append(steps, found.step)
+	}
+	slices.Reverse(steps)
+	return found.root, steps, nil
+}
+
+// referencedSteps lists the objects step references: the tree and parents
+// of a commit, the target of a tag and the entries of a tree
+func referencedSteps(step PathStep, shallow map[string]bool) ([]PathStep, error) {
+	switch step.Kind {
+	case CommitKind:
+		info, err := ReadCommitInfo(step.Sha)
+		if err != nil {
+			return nil, err
+		}
+		steps := []PathStep{{Kind: TreeKind, Sha: info.Tree}}
+		// the parents of the shallow boundary are not stored
+		if !shallow[step.Sha] {
+			for _, parent := range info.Parents {
+				steps = append(steps, PathStep{Kind: CommitKind, Sha: parent})
+			}
+		}
+		return steps, nil
+	case TagKind:
+		gitObj, err := ReadGitObject(step.Sha)
+		if err != nil {
+			return nil, err
+		}
+		tag, ok := gitObj.(*Tag)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a tag", InvalidTag, step.Sha)
+		}
+		target, err := tag.Object()
+		if err != nil {
+			return nil, err
+		}
+		return []PathStep{{Kind: tag.TargetKind(), Sha: target}}, nil
+	case TreeKind:
+		tree, err := ReadTreeish(step.Sha)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := tree.Entries()
+		if err != nil {
+			return nil, err
+		}
+		steps := []PathStep{}
+		for _, e := range entries {
+			steps = append(steps, PathStep{Kind: e.kind, Sha: e.hash, Path: path.Join(step.Path, e.name)})
+		}
+		return steps, nil
+	}
+	return nil, nil
+}
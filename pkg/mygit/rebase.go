@@ -0,0 +1,396 @@
+package mygit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	RebaseInProgress = errors.New("A rebase is in progress, finish it with --continue.")
+	NoRebase         = errors.New("No rebase in progress.")
+)
+
+// RebaseState is what a stopped rebase keeps in .git/rebase-merge, in the
+// files git uses for them
+type RebaseState struct {
+	HeadName  string   // branch being rebased
+	OrigHead  string   // its tip before the rebase
+	Onto      string   // commit the branch is replayed on
+	Stopped   string   // commit whose pick conflicted
+	Todo      []string // commits left to pick, oldest first
+	Conflicts []string // paths the user has to resolve
+}
+
+// RebaseResult tells how a rebase ended, Stopped is set when it waits for
+// conflicts to be resolved
+type RebaseResult struct {
+	Branch    string
+	Head      string
+	UpToDate  bool
+	Picked    int
+	Skipped   int
+	Stopped   string
+	Conflicts []string
+}
+
+// Rebase replays the commits of the current branch missing from onto on top
+// of it, oldest first, then moves the branch to the last one. Merge commits
+// are dropped and commits whose change is already in onto are skipped, like
+// git does. HEAD is detached while the commits are picked.
+func Rebase(ctx context.Context, onto string) (RebaseResult, error) {
+	if _, err := os.Stat(rebaseDir()); err == nil {
+		return RebaseResult{}, RebaseInProgress
+	}
+	branch, symbolic, err := ReadSymbolicRef("HEAD")
+	if err != nil {
+		return RebaseResult{}, err
+	}
+	if !symbolic {
+		return RebaseResult{}, fmt.Errorf("cannot rebase a detached HEAD")
+	}
+	head, err := readRef(branch)
+	if err != nil {
+		return RebaseResult{}, err
+	}
+	result := RebaseResult{Branch: branch, Head: head}
+
+	base, err := MergeBase(head, onto)
+	if err != nil {
+		return result, err
+	}
+	if base == onto {
+		result.UpToDate = true
+		return result, nil
+	}
+
+	upstream, err := Ancestors(onto)
+	if err != nil {
+		return result, err
+	}
+	todo, err := commitsToPick(head, upstream)
+	if err != nil {
+		return result, err
+	}
+
+	from, err := FlattenTree(head)
+	if err != nil {
+		return result, err
+	}
+	to, err := FlattenTree(onto)
+	if err != nil {
+		return result, err
+	}
+	if err := UpdateWorktree(from, to); err != nil {
+		return result, err
+	}
+	if err := UpdateRef("HEAD", onto); err != nil {
+		return result, err
+	}
+
+	state := &RebaseState{HeadName: branch, OrigHead: head, Onto: onto, Todo: todo}
+	return runRebase(ctx, state, result)
+}
+
+// ContinueRebase picks up a stopped rebase once the conflicts are resolved in
+// the worktree, the resolved files are committed in place of the pick that
+// stopped
+func ContinueRebase(ctx context.Context) (RebaseResult, error) {
+	state, err := readRebaseState()
+	if err != nil {
+		return RebaseResult{}, err
+	}
+	result := RebaseResult{Branch: state.HeadName}
+	if state.Stopped == "" {
+		return runRebase(ctx, state, result)
+	}
+
+	head, err := readRef("HEAD")
+	if err != nil {
+		return result, err
+	}
+	ours, err := FlattenTree(head)
+	if err != nil {
+		return result, err
+	}
+	commit, err := ReadCommit(state.Stopped)
+	if err != nil {
+		return result, err
+	}
+	merged, _, err := ReplayCommit(ours, commit, false, pickLabels(state.Stopped, commit))
+	if err != nil {
+		return result, err
+	}
+	for _, name := range state.Conflicts {
+		info, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			delete(merged, name)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		resolved, err := resolvedEntry(name, info)
+		if err != nil {
+			return result, err
+		}
+		merged[name] = resolved
+	}
+
+	head, picked, err := commitPick(head, ours, merged, commit)
+	if err != nil {
+		return result, err
+	}
+	if err := UpdateRef("HEAD", head); err != nil {
+		return result, err
+	}
+	if picked {
+		result.Picked++
+	} else {
+		result.Skipped++
+	}
+	state.Stopped, state.Conflicts = "", nil
+	return runRebase(ctx, state, result)
+}
+
+// runRebase picks the commits left in state on top of the detached HEAD,
+// saving state to disk when one conflicts
+func runRebase(ctx context.Context, state *RebaseState, result RebaseResult) (RebaseResult, error) {
+	head, err := readRef("HEAD")
+	if err != nil {
+		return result, err
+	}
+	for len(state.Todo) > 0 {
+		if err := ctx.Err(); err != nil {
+			// nothing to resolve, --continue starts with the next pick
+			return result, errors.Join(err, writeRebaseState(state))
+		}
+		sha := state.Todo[0]
+		state.Todo = state.Todo[1:]
+
+		commit, err := ReadCommit(sha)
+		if err != nil {
+			return result, err
+		}
+		ours, err := FlattenTree(head)
+		if err != nil {
+			return result, err
+		}
+		merged, conflicts, err := ReplayCommit(ours, commit, false, pickLabels(sha, commit))
+		if err != nil {
+			return result, err
+		}
+		if err := UpdateWorktree(ours, merged); err != nil {
+			return result, err
+		}
+		if len(conflicts) > 0 {
+			state.Stopped, state.Conflicts = sha, conflicts
+			result.Stopped, result.Conflicts = sha, conflicts
+			return result, writeRebaseState(state)
+		}
+
+		next, picked, err := commitPick(head, ours, merged, commit)
+		if err != nil {
+			return result, err
+		}
+		if err := UpdateRef("HEAD", next); err != nil {
+			return result, err
+		}
+		if picked {
+			result.Picked++
+		} else {
+			result.Skipped++
+		}
+		head = next
+	}
+
+	// the branch must not have moved since the rebase started
+	t := RefTransaction{}
+	t.Update(state.HeadName, head, state.OrigHead)
+	t.UpdateSymbolic("HEAD", state.HeadName)
+	if err := t.Commit(); err != nil {
+		return result, err
+	}
+	if err := os.WriteFile(gitPath("ORIG_HEAD"), []byte(state.OrigHead+"\n"), 0o644); err != nil {
+		return result, err
+	}
+	result.Head = head
+	return result, os.RemoveAll(rebaseDir())
+}
+
+// commitsToPick lists the commits reachable from head and not from upstream,
+// parents first, leaving out merges
+func commitsToPick(head string, upstream map[string]bool) ([]string, error) {
+	order, _, err := topoOrder([]Ref{{Name: "HEAD", Hash: head}}, upstream)
+	if err != nil {
+		return nil, err
+	}
+	todo := []string{}
+	for _, sha := range order {
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(info.Parents) <= 1 {
+			todo = append(todo, sha)
+		}
+	}
+	return todo, nil
+}
+
+// commitPick records merged as a copy of commit on top of head, keeping its
+// author and message. A pick that changes nothing is dropped.
+func commitPick(head string, ours, merged map[string]entry, commit *Commit) (string, bool, error) {
+	tree, err := WriteTreeFromFiles(merged, true)
+	if err != nil {
+		return "", false, err
+	}
+	current, err := WriteTreeFromFiles(ours, false)
+	if err != nil {
+		return "", false, err
+	}
+	if tree == current {
+		return head, false, nil
+	}
+
+	committer, err := resolveSignature("COMMITTER")
+	if err != nil {
+		return "", false, err
+	}
+	hash, err := storeObject(&Commit{
+		tree:      fmt.Sprintf("%x", tree),
+		parents:   []string{head},
+		author:    commit.author,
+		committer: committer,
+		encoding:  commit.encoding,
+		message:   commit.message,
+	}, true)
+	if err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("%x", hash), true, nil
+}
+
+func pickLabels(sha string, commit *Commit) MergeLabels {
+	return MergeLabels{
+		Ours:   "HEAD",
+		Theirs: fmt.Sprintf("%s (%s)", sha[:7], commit.Subject()),
+	}
+}
+
+// resolvedEntry stores the worktree file the user resolved a conflict in,
+// refusing one that still holds conflict markers
+func resolvedEntry(name string, info os.FileInfo) (entry, error) {
+	resolved := entry{mode: "100644", kind: BlobKind, name: name}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		resolved.mode = "120000"
+		target, err := os.Readlink(name)
+		if err != nil {
+			return resolved, err
+		}
+		sha, err := WriteContent(&Blob{content: []byte(target)})
+		resolved.hash = fmt.Sprintf("%x", sha)
+		return resolved, err
+	case info.Mode().Perm()&0o111 != 0:
+		resolved.mode = "100755"
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return resolved, err
+	}
+	for _, line := range splitLines(content) {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return resolved, fmt.Errorf("%s still has conflict markers", name)
+		}
+	}
+	sha, err := WriteContent(&Blob{content: content})
+	resolved.hash = fmt.Sprintf("%x", sha)
+	return resolved, err
+}
+
+func rebaseDir() string {
+	return gitPath("rebase-merge")
+}
+
+func writeRebaseState(state *RebaseState) error {
+	dir := rebaseDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	todo := strings.Builder{}
+	for _, sha := range state.Todo {
+		fmt.Fprintf(&todo, "pick %s\n", sha)
+	}
+	files := map[string]string{
+		"head-name":       state.HeadName + "\n",
+		"orig-head":       state.OrigHead + "\n",
+		"onto":            state.Onto + "\n",
+		"stopped-sha":     state.Stopped + "\n",
+		"git-rebase-todo": todo.String(),
+		"conflicts":       strings.Join(append(slices.Clone(state.Conflicts), ""), "\n"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRebaseState() (*RebaseState, error) {
+	dir := rebaseDir()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, NoRebase
+	}
+	read := func(name string) ([]string, error) {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		lines := []string{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines, scanner.Err()
+	}
+
+	state := &RebaseState{}
+	for name, field := range map[string]*string{
+		"head-name":   &state.HeadName,
+		"orig-head":   &state.OrigHead,
+		"onto":        &state.Onto,
+		"stopped-sha": &state.Stopped,
+	} {
+		lines, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) > 0 {
+			*field = lines[0]
+		}
+	}
+	todo, err := read("git-rebase-todo")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range todo {
+		if sha, ok := strings.CutPrefix(line, "pick "); ok {
+			state.Todo = append(state.Todo, sha)
+		}
+	}
+	if state.Conflicts, err = read("conflicts"); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
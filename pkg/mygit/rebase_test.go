@@ -0,0 +1,158 @@
+package mygit
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// forkTopic commits the topic changes on a branch topic forked from base,
+// then the upstream change on main, and checks out topic. It returns the
+// tips of topic and main.
+func forkTopic(t *testing.T, base map[string]string, topic []map[string]string, upstream map[string]string) (string, string) {
+	t.Helper()
+	writeFiles(t, base)
+	fork := commitWorktree(t, "base")
+	for i, change := range topic {
+		writeFiles(t, change)
+		commitWorktree(t, "topic "+strconv.Itoa(i+1))
+	}
+	topicTip, err := ResolveRef("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topicFiles, err := FlattenTree(topicTip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateRef("refs/heads/main", fork); err != nil {
+		t.Fatal(err)
+	}
+	for name := range topicFiles {
+		if err := os.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, base)
+	writeFiles(t, upstream)
+	mainTip := commitWorktree(t, "upstream")
+
+	if err := UpdateRef("refs/heads/topic", topicTip); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSymbolicRef("HEAD", "refs/heads/topic"); err != nil {
+		t.Fatal(err)
+	}
+	from, err := FlattenTree(mainTip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateWorktree(from, topicFiles); err != nil {
+		t.Fatal(err)
+	}
+	return topicTip, mainTip
+}
+
+// firstParents lists the commits from sha down to stop, following the
+// first parents, with their messages
+func firstParents(t *testing.T, sha, stop string) []string {
+	t.Helper()
+	messages := []string{}
+	for sha != stop {
+		commit, err := ReadCommit(sha)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(commit.parents) == 0 {
+			t.Fatalf("%s is not above %s", sha, stop)
+		}
+		messages = append(messages, strings.TrimSpace(commit.message))
+		sha = commit.parents[0]
+	}
+	return messages
+}
+
+func TestRebaseOntoAdvancedBase(t *testing.T) {
+	testRepository(t)
+	topicTip, mainTip := forkTopic(t,
+		map[string]string{"file": "a\nb\nc\n", "other": "x\n"},
+		[]map[string]string{{"new": "topic\n"}, {"file": "A\nb\nc\n"}},
+		map[string]string{"other": "y\n"})
+
+	output, err := runCommand(t, "rebase", "main")
+	if err != nil || output != "Successfully rebased and updated refs/heads/topic.\n" {
+		t.Fatalf("rebase printed %q, %v", output, err)
+	}
+	if target, symbolic, err := ReadSymbolicRef("HEAD"); err != nil || !symbolic || target != "refs/heads/topic" {
+		t.Errorf("HEAD points to %s, %v", target, err)
+	}
+	head, err := ResolveRef("refs/heads/topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(firstParents(t, head, mainTip), ", "); got != "topic 2, topic 1" {
+		t.Errorf("the rebased commits are %s", got)
+	}
+	files := readWorktree(t, "file", "other", "new")
+	if files["file"] != "A\nb\nc\n" || files["other"] != "y\n" || files["new"] != "topic\n" {
+		t.Errorf("the worktree is %q", files)
+	}
+	if origHead, err := os.ReadFile(gitPath("ORIG_HEAD")); err != nil || string(origHead) != topicTip+"\n" {
+		t.Errorf("ORIG_HEAD is %q, %v", origHead, err)
+	}
+
+	output, err = runCommand(t, "rebase", "main")
+	if err != nil || output != "Current branch topic is up to date.\n" {
+		t.Errorf("a second rebase printed %q, %v", output, err)
+	}
+}
+
+func TestRebaseConflictContinue(t *testing.T) {
+	testRepository(t)
+	_, mainTip := forkTopic(t,
+		map[string]string{"file": "a\nb\nc\n"},
+		[]map[string]string{{"file": "topic\nb\nc\n"}, {"new": "topic\n"}},
+		map[string]string{"file": "upstream\nb\nc\n"})
+
+	output, err := runCommand(t, "rebase", "main")
+	if err != ExitStatus(1) || !strings.Contains(output, "CONFLICT: merge conflict in file\n") {
+		t.Fatalf("rebase printed %q, %v", output, err)
+	}
+	if files := readWorktree(t, "file"); !strings.Contains(files["file"], "<<<<<<< ") {
+		t.Errorf("the conflicted file is %q", files["file"])
+	}
+	if _, symbolic, _ := ReadSymbolicRef("HEAD"); symbolic {
+		t.Error("HEAD is not detached while the rebase is stopped")
+	}
+	if _, err := runCommand(t, "rebase", "main"); err != RebaseInProgress {
+		t.Errorf("a second rebase returned %v", err)
+	}
+
+	writeFiles(t, map[string]string{"file": "resolved\nb\nc\n"})
+	output, err = runCommand(t, "rebase", "--continue")
+	if err != nil || output != "Successfully rebased and updated refs/heads/topic.\n" {
+		t.Fatalf("rebase --continue printed %q, %v", output, err)
+	}
+	head, err := ResolveRef("refs/heads/topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(firstParents(t, head, mainTip), ", "); got != "topic 2, topic 1" {
+		t.Errorf("the rebased commits are %s", got)
+	}
+	files, err := FlattenTree(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files["file"].hash != blobSha("resolved\nb\nc\n") || files["new"].hash != blobSha("topic\n") {
+		t.Errorf("the rebased tree is %v", files)
+	}
+	if _, err := os.Stat(rebaseDir()); !os.IsNotExist(err) {
+		t.Error("the rebase state is left behind")
+	}
+	if _, err := runCommand(t, "rebase", "--continue"); err != NoRebase {
+		t.Errorf("--continue without a rebase returned %v", err)
+	}
+}
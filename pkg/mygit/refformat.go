@@ -0,0 +1,160 @@
+package mygit
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// https://git-scm.com/docs/git-for-each-ref#_field_names
+const defaultRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+// FormatRef expands the %(field) placeholders of format for ref, a field
+// starting with "*" reads the object the tag points to (empty for non tags).
+// "%%" is a literal percent and "%xx" the byte with hex value xx.
+func FormatRef(format string, ref Ref) (string, error) {
+	out := strings.Builder{}
+	for len(format) > 0 {
+		i := strings.IndexByte(format, '%')
+		if i == -1 {
+			out.WriteString(format)
+			break
+		}
+		out.WriteString(format[:i])
+		format = format[i:]
+
+		switch {
+		case strings.HasPrefix(format, "%%"):
+			out.WriteByte('%')
+			format = format[2:]
+		case strings.HasPrefix(format, "%("):
+			end := strings.IndexByte(format, ')')
+			if end == -1 {
+				return "", fmt.Errorf("malformed format string %s", format)
+			}
+			value, err := refField(format[2:end], ref)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			format = format[end+1:]
+		default:
+			if len(format) >= 3 {
+				if b, err := strconv.ParseUint(format[1:3], 16, 8); err == nil {
+					out.WriteByte(byte(b))
+					format = format[3:]
+					continue
+				}
+			}
+			out.WriteByte('%')
+			format = format[1:]
+		}
+	}
+	return out.String(), nil
+}
+
+func refField(field string, ref Ref) (string, error) {
+	name, modifier, _ := strings.Cut(field, ":")
+	if name == "refname" {
+		switch modifier {
+		case "":
+			return ref.Name, nil
+		case "short":
+			return shortRefName(ref.Name), nil
+		}
+		return "", fmt.Errorf("unknown %%(refname) modifier %s", modifier)
+	}
+
+	sha := ref.Hash
+	if deref, found := strings.CutPrefix(name, "*"); found {
+		gitObj, err := ReadGitObject(sha)
+		if err != nil {
+			return "", err
+		}
+		tag, ok := gitObj.(*Tag)
+		if !ok {
+			return "", nil
+		}
+		if sha, err = tag.Object(); err != nil {
+			return "", err
+		}
+		name = deref
+	}
+
+	switch name {
+	case "objectname":
+		switch modifier {
+		case "":
+			return sha, nil
+		case "short":
+			return sha[:7], nil
+		}
+		return "", fmt.Errorf("unknown %%(objectname) modifier %s", modifier)
+	case "objecttype", "objectsize", "subject":
+		if modifier != "" {
+			return "", fmt.Errorf("unknown %%(%s) modifier %s", name, modifier)
+		}
+	default:
+		return "", fmt.Errorf("unknown field name: %s", field)
+	}
+
+	if name == "objecttype" {
+		kind, err := ObjectType(sha)
+		return string(kind), err
+	}
+	gitObj, err := ReadGitObject(sha)
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "objectsize":
+		return strconv.Itoa(len(gitObj.Content())), nil
+	}
+	return objectSubject(gitObj)
+}
+
+// only commits and tags have a subject
+func objectSubject(gitObj GitObject) (string, error) {
+	switch gitObj.Kind() {
+	case CommitKind:
+		commit, err := ParseCommit(gitObj.Content())
+		if err != nil {
+			return "", err
+		}
+		return commit.Subject(), nil
+	case TagKind:
+		return (&Tag{content: gitObj.Content()}).Subject(), nil
+	}
+	return "", nil
+}
+
+// shortRefName drops the well known ref prefixes, like %(refname:short)
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if short, found := strings.CutPrefix(name, prefix); found {
+			return short
+		}
+	}
+	return name
+}
+
+// a for-each-ref pattern matches a ref either as a path prefix or as a glob
+func matchRefPrefix(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			continue
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+		if name == pattern || strings.HasPrefix(name, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package mygit
+
+import (
+	"fmt"
+	"testing"
+)
+
+// annotatedTag writes a tag object named name pointing to the commit at sha
+func annotatedTag(t *testing.T, name, sha, message string) string {
+	t.Helper()
+	content := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger Tester <tester@example.com> 1700000000 +0000\n\n%s\n", sha, name, message)
+	tag, err := WriteContent(&Tag{content: []byte(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", tag)
+}
+
+func TestForEachRef(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\n"})
+	commit := commitWorktree(t, "first commit\n\nwith a body")
+	tag := annotatedTag(t, "v1", commit, "release one")
+	for ref, sha := range map[string]string{"refs/tags/v1": tag, "refs/tags/light": commit} {
+		if err := UpdateRef(ref, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		args   []string
+		output string
+	}{
+		{nil, commit + " commit\trefs/heads/main\n" + commit + " commit\trefs/tags/light\n" + tag + " tag\trefs/tags/v1\n"},
+		{
+			[]string{"--format=%(refname:short) %(objecttype) %(objectname:short) %(subject)", "refs/tags"},
+			"light commit " + commit[:7] + " first commit\nv1 tag " + tag[:7] + " release one\n",
+		},
+		// peeled through the tag, nothing for the others
+		{[]string{"--format=%(refname)%09%(*objecttype) %(*objectname)", "refs/tags/v*"}, "refs/tags/v1\tcommit " + commit + "\n"},
+		{[]string{"--format=%(refname)%09%(*objectname)", "--count=1"}, "refs/heads/main\t\n"},
+		{[]string{"--format=%%(refname) %(objectsize)", "refs/heads/"}, fmt.Sprintf("%%(refname) %d\n", commitSize(t, commit))},
+	} {
+		output, err := runCommand(t, append([]string{"for-each-ref"}, test.args...)...)
+		if err != nil || output != test.output {
+			t.Errorf("for-each-ref %v printed\n%s%v\nwant\n%s", test.args, output, err, test.output)
+		}
+	}
+
+	for _, format := range []string{"%(unknown)", "%(refname", "%(refname:long)", "%(subject:short)"} {
+		if _, err := FormatRef(format, Ref{Name: "refs/heads/main", Hash: commit}); err == nil {
+			t.Errorf("formatted %q", format)
+		}
+	}
+}
+
+func commitSize(t *testing.T, sha string) int {
+	t.Helper()
+	_, content, err := readObject(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(content)
+}
+
+func TestShortRefName(t *testing.T) {
+	for name, want := range map[string]string{
+		"refs/heads/main":          "main",
+		"refs/tags/v1":             "v1",
+		"refs/remotes/origin/main": "origin/main",
+		"refs/notes/commits":       "notes/commits",
+		"HEAD":                     "HEAD",
+	} {
+		if got := shortRefName(name); got != want {
+			t.Errorf("%s is short for %s, want %s", got, name, want)
+		}
+	}
+}
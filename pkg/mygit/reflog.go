@@ -0,0 +1,94 @@
+package mygit
+
+// https://git-scm.com/docs/git-reflog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	InvalidReflog = errors.New("Reflog entry cannot be parsed.")
+)
+
+// the operations git names at the start of reflog messages, like
+// "commit (amend): fix" or "checkout: moving from main to topic"
+var reflogOperations = []string{
+	"am", "branch", "checkout", "cherry-pick", "clone", "commit", "fetch",
+	"merge", "pull", "push", "rebase", "reset", "revert", "stash", "switch",
+}
+
+// ReflogEntry is a line of a reflog, the ref moving from Old to New
+type ReflogEntry struct {
+	Old       string // zeroHash when the ref was created
+	New       string
+	Committer Signature // who moved the ref and when
+	Message   string
+}
+
+// Operation is the git command that moved the ref, read from the start of
+// the message: "commit" for "commit (amend): ...", "merge" for "merge
+// topic: Fast-forward", "push" for "update by push". "other" when the
+// message names none, like the ones update-ref -m was given.
+func (e ReflogEntry) Operation() string {
+	if strings.HasPrefix(e.Message, "update by push") {
+		return "push"
+	}
+	prefix, _, found := strings.Cut(e.Message, ":")
+	if !found {
+		return "other"
+	}
+	// the command may be followed by what it worked on or a variant
+	word, _, _ := strings.Cut(prefix, " ")
+	for _, operation := range reflogOperations {
+		if word == operation {
+			return operation
+		}
+	}
+	return "other"
+}
+
+// ParseReflogEntry parses "<old> <new> <committer>\t<message>", the message
+// and its tab may be missing
+func ParseReflogEntry(line string) (ReflogEntry, error) {
+	head, message, _ := strings.Cut(line, "\t")
+	from, rest, _ := strings.Cut(head, " ")
+	to, identity, _ := strings.Cut(rest, " ")
+	if !isHash(from) || !isHash(to) {
+		return ReflogEntry{}, fmt.Errorf("%w: %q", InvalidReflog, line)
+	}
+	committer, err := parseSignature(identity)
+	if err != nil {
+		return ReflogEntry{}, fmt.Errorf("%w: bad committer in %q", InvalidReflog, line)
+	}
+	return ReflogEntry{Old: from, New: to, Committer: committer, Message: message}, nil
+}
+
+// ReadReflog returns the reflog of the ref named refName, like HEAD or
+// refs/heads/main, newest first so that entry n is <ref>@{n}. A ref without
+// a reflog has no entries.
+func ReadReflog(refName string) ([]ReflogEntry, error) {
+	content, err := os.ReadFile(gitPath("logs", refName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []ReflogEntry{}
+	for i, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		e, err := ParseReflogEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("logs/%s line %d: %w", refName, i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
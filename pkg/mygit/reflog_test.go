@@ -0,0 +1,57 @@
+package mygit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReflogMatchesGit(t *testing.T) {
+	testRepository(t)
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	writeFiles(t, map[string]string{"f": "one\n"})
+	runGit(t, "add", "f")
+	runGit(t, "commit", "-q", "-m", "one")
+	writeFiles(t, map[string]string{"f": "two\n"})
+	runGit(t, "commit", "-q", "-a", "-m", "two")
+	runGit(t, "checkout", "-q", "-b", "topic")
+	runGit(t, "commit", "-q", "--amend", "-m", "two amended")
+	runGit(t, "reset", "-q", "--hard", "HEAD~1")
+	writeFiles(t, map[string]string{"g": "three\n"})
+	runGit(t, "add", "g")
+	runGit(t, "commit", "-q", "-m", "three")
+	runGit(t, "checkout", "-q", "main")
+	runGit(t, "merge", "-q", "topic")
+	runGit(t, "update-ref", "-m", "custom move", "HEAD", "HEAD~1")
+
+	// newest first, the full ids like --no-abbrev
+	for _, args := range [][]string{{"reflog"}, {"reflog", "show", "topic"}} {
+		output, err := runCommand(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := runGit(t, append(append([]string{}, args...), "--no-abbrev")...); strings.TrimSpace(output) != want {
+			t.Errorf("%v printed\n%s\ngit printed\n%s", args, output, want)
+		}
+	}
+
+	output, err := runCommand(t, "--json", "reflog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ReflogEntryJSON
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		t.Fatalf("%v in\n%s", err, output)
+	}
+	got, operations := []string{}, []string{}
+	for _, e := range entries {
+		got = append(got, strings.Join([]string{e.Selector, e.New, e.Committer.Name + " <" + e.Committer.Email + ">", e.Message}, "\t"))
+		operations = append(operations, e.Operation)
+	}
+	if want := runGit(t, "reflog", "--format=%gd%x09%H%x09%gn <%ge>%x09%gs"); strings.Join(got, "\n") != want {
+		t.Errorf("reflog --json has\n%s\ngit has\n%s", strings.Join(got, "\n"), want)
+	}
+	if want := "other merge checkout commit reset commit checkout commit commit"; strings.Join(operations, " ") != want {
+		t.Errorf("reflog --json operations are %v, want %s", operations, want)
+	}
+}
@@ -0,0 +1,77 @@
+package mygit
+
+// https://git-scm.com/docs/git-check-ref-format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RefNameOptions relax or adjust CheckRefFormat like the flags of git
+// check-ref-format
+type RefNameOptions struct {
+	AllowOneLevel  bool // a name without any slash, like HEAD
+	RefspecPattern bool // one "*" anywhere in the name
+	Normalize      bool // leading slashes are dropped and repeated ones collapsed first
+}
+
+// CheckRefFormat tells whether git accepts name for a ref and returns it,
+// normalized when asked. Like git, a component cannot be empty, start with
+// "." or end with ".lock", and the name cannot hold "..", "@{", control
+// characters, spaces or any of ~^:?*[\, end with "." or be "@".
+func CheckRefFormat(name string, opts RefNameOptions) (string, error) {
+	if opts.Normalize {
+		components := strings.Split(name, "/")
+		kept := components[:0]
+		for i, component := range components {
+			// the last one stays, a trailing slash is still invalid
+			if component != "" || i == len(components)-1 && len(kept) > 0 {
+				kept = append(kept, component)
+			}
+		}
+		name = strings.Join(kept, "/")
+	}
+	invalid := func(reason string) (string, error) {
+		return "", fmt.Errorf("%w: %q %s", InvalidRef, name, reason)
+	}
+
+	switch {
+	case name == "@":
+		return invalid("is a shorthand for HEAD")
+	case strings.HasSuffix(name, "."):
+		return invalid("ends with a dot")
+	case strings.Contains(name, ".."):
+		return invalid("contains ..")
+	case strings.Contains(name, "@{"):
+		return invalid("contains @{")
+	}
+	stars := 0
+	for _, c := range []byte(name) {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			return invalid("contains a control character")
+		case c == '*':
+			if stars++; !opts.RefspecPattern || stars > 1 {
+				return invalid("contains *")
+			}
+		case strings.IndexByte(" ~^:?[\\", c) >= 0:
+			return invalid(fmt.Sprintf("contains %q", c))
+		}
+	}
+
+	components := strings.Split(name, "/")
+	for _, component := range components {
+		switch {
+		case component == "":
+			return invalid("has an empty component")
+		case strings.HasPrefix(component, "."):
+			return invalid("has a component starting with a dot")
+		case strings.HasSuffix(component, ".lock"):
+			return invalid("has a component ending with .lock")
+		}
+	}
+	if len(components) < 2 && !opts.AllowOneLevel {
+		return invalid("has a single level")
+	}
+	return name, nil
+}
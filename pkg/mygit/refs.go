@@ -0,0 +1,415 @@
+package mygit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	RefNotFound  = errors.New("Reference not found")
+	InvalidRef   = errors.New("Reference cannot be parsed.")
+	PathNotFound = errors.New("Path not found in the tree")
+)
+
+type Ref struct {
+	Name string
+	Hash string
+}
+
+func isHash(s string) bool {
+	if len(s) != objectHasher.HexLen() {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ReadSymbolicRef returns the target of a symbolic ref like HEAD, ok is false
+// if the ref holds a sha instead
+func ReadSymbolicRef(name string) (target string, ok bool, _ error) {
+	content, err := os.ReadFile(gitPath(name))
+	if err != nil {
+		return "", false, err
+	}
+	line := strings.TrimSpace(string(content))
+	if target, found := strings.CutPrefix(line, "ref: "); found {
+		return target, true, nil
+	}
+	return "", false, nil
+}
+
+// https://git-scm.com/docs/git-pack-refs
+// readPackedRefs parses .git/packed-refs, a missing file has no refs. The
+// "^<sha>" lines holding the peeled value of the previous tag are skipped.
+func readPackedRefs() ([]Ref, error) {
+	f, err := os.Open(gitPath("packed-refs"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	refs := []Ref{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, name, found := strings.Cut(line, " ")
+		if !found || !isHash(hash) {
+			return nil, fmt.Errorf("%w: packed-refs line '%s'", InvalidRef, line)
+		}
+		refs = append(refs, Ref{Name: name, Hash: hash})
+	}
+	return refs, scanner.Err()
+}
+
+func readPackedRef(name string) (string, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name == name {
+			return ref.Hash, nil
+		}
+	}
+	return "", RefNotFound
+}
+
+// readRef follows symbolic refs until it finds a sha, loose refs win over
+// packed ones
+func readRef(name string) (string, error) {
+	for range 5 { // git gives up on deeper symref chains too
+		content, err := os.ReadFile(gitPath(name))
+		if os.IsNotExist(err) {
+			if strings.HasPrefix(name, "refs/") {
+				return readPackedRef(name)
+			}
+			return "", RefNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSpace(string(content))
+		target, found := strings.CutPrefix(line, "ref: ")
+		if !found {
+			// FETCH_HEAD and MERGE_HEAD list more, the first sha is the ref
+			line, _, _ = strings.Cut(line, "\n")
+			line, _, _ = strings.Cut(line, "\t")
+			if !isHash(line) {
+				return "", InvalidRef
+			}
+			return line, nil
+		}
+		name = target
+	}
+	return "", InvalidRef
+}
+
+// ExpandRef finds the full name of a (possibly abbreviated) ref using git's
+// lookup order, returning the name with the sha it points to
+func ExpandRef(name string) (string, string, error) {
+	for _, candidate := range []string{
+		name,
+		path.Join("refs", name),
+		path.Join("refs/tags", name),
+		path.Join("refs/heads", name),
+		path.Join("refs/remotes", name),
+		path.Join("refs/remotes", name, "HEAD"),
+	} {
+		hash, err := readRef(candidate)
+		if err == RefNotFound {
+			continue
+		}
+		return candidate, hash, err
+	}
+	return "", "", RefNotFound
+}
+
+// ResolveRef turns a sha, HEAD or a (possibly abbreviated) ref name into a
+// sha, "<rev>:<path>" names the entry at path in the tree of rev
+func ResolveRef(name string) (string, error) {
+	if rev, p, found := strings.Cut(name, ":"); found {
+		return resolveTreePath(rev, p)
+	}
+	if isHash(name) {
+		return name, nil
+	}
+	_, hash, err := ExpandRef(name)
+	return hash, err
+}
+
+// resolveTreePath walks the trees of rev down to p, an empty path names the
+// root tree and one ending with a slash must name a tree
+func resolveTreePath(rev, p string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("%w: :%s names the index, which is not supported", InvalidRef, p)
+	}
+	sha, err := ResolveRef(rev)
+	if err != nil {
+		return "", err
+	}
+	sha, gitObj, err := PeelObject(sha)
+	if err != nil {
+		return "", err
+	}
+	switch obj := gitObj.(type) {
+	case *CommitAsBytes:
+		if sha, err = obj.TreeHash(); err != nil {
+			return "", err
+		}
+	case *Tree:
+	default:
+		return "", fmt.Errorf("%w: %s is a %s, not a tree-ish", InvalidRef, rev, gitObj.Kind())
+	}
+
+	kind, walked := TreeKind, ""
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+		if kind != TreeKind {
+			return "", fmt.Errorf("%w: '%s' is not a tree in '%s'", PathNotFound, walked, rev)
+		}
+		tree, err := ReadTreeish(sha)
+		if err != nil {
+			return "", err
+		}
+		entries, err := tree.Entries()
+		if err != nil {
+			return "", err
+		}
+		walked = path.Join(walked, name)
+		found := false
+		for _, e := range entries {
+			if e.name == name {
+				sha, kind, found = e.hash, e.kind, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%w: '%s' does not exist in '%s'", PathNotFound, walked, rev)
+		}
+	}
+	if strings.HasSuffix(p, "/") && kind != TreeKind {
+		return "", fmt.Errorf("%w: '%s' is not a tree in '%s'", PathNotFound, walked, rev)
+	}
+	return sha, nil
+}
+
+// ListRefs returns every ref under .git/refs and in .git/packed-refs sorted
+// by name
+func ListRefs() ([]Ref, error) {
+	packed, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]Ref{}
+	for _, ref := range packed {
+		byName[ref.Name] = ref
+	}
+
+	root := gitPath("refs")
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(gitDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		hash, err := readRef(name)
+		if err != nil {
+			return err
+		}
+		byName[name] = Ref{Name: name, Hash: hash}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	refs := make([]Ref, 0, len(byName))
+	for _, ref := range byName {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// UpdateRef sets name to hash whatever it held, a transaction of its own
+func UpdateRef(name, hash string) error {
+	t := RefTransaction{}
+	t.Update(name, hash, "")
+	return t.Commit()
+}
+
+func UpdateSymbolicRef(name, target string) error {
+	t := RefTransaction{}
+	t.UpdateSymbolic(name, target)
+	return t.Commit()
+}
+
+// DerefSymbolic follows the symbolic refs from name to the ref that holds a
+// sha, or will once created
+func DerefSymbolic(name string) (string, error) {
+	for range 5 {
+		target, symbolic, err := ReadSymbolicRef(name)
+		if os.IsNotExist(err) || (err == nil && !symbolic) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name = target
+	}
+	return "", fmt.Errorf("%w: symbolic refs nested too deep at %s", InvalidRef, name)
+}
+
+// AdvanceHead moves the branch HEAD points to, or HEAD itself when detached,
+// from old to hash. It fails when another process moved it in the meantime.
+func AdvanceHead(hash, old string) error {
+	target, err := DerefSymbolic("HEAD")
+	if err != nil {
+		return err
+	}
+	t := RefTransaction{}
+	t.Update(target, hash, old)
+	return t.Commit()
+}
+
+type HeadState struct {
+	Target   string // branch HEAD points to, empty when detached
+	Hash     string // empty when HEAD does not resolve
+	Missing  bool   // no HEAD file, or one that cannot be parsed
+	Dangling bool   // points to a branch that does not exist while others do
+}
+
+func (h HeadState) String() string {
+	switch {
+	case h.Missing:
+		return "HEAD is missing or invalid"
+	case h.Dangling:
+		return fmt.Sprintf("HEAD -> %s, which does not exist", h.Target)
+	case h.Target == "":
+		return fmt.Sprintf("HEAD detached at %s", h.Hash)
+	case h.Hash == "":
+		return fmt.Sprintf("HEAD -> %s, no commits yet", h.Target)
+	}
+	return fmt.Sprintf("HEAD -> %s at %s", h.Target, h.Hash)
+}
+
+// Broken tells whether HEAD needs a repair
+func (h HeadState) Broken() bool {
+	return h.Missing || h.Dangling
+}
+
+// InspectHead reports where HEAD points. A branch that does not exist yet is
+// only dangling when the repository has other branches, a new repository
+// legitimately points to its unborn first branch.
+func InspectHead() (state HeadState, _ error) {
+	content, err := os.ReadFile(gitPath("HEAD"))
+	if os.IsNotExist(err) {
+		state.Missing = true
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	line := strings.TrimSpace(string(content))
+	target, symbolic := strings.CutPrefix(line, "ref: ")
+	if !symbolic {
+		if !isHash(line) {
+			state.Missing = true
+			return state, nil
+		}
+		state.Hash = line
+		return state, nil
+	}
+
+	state.Target = target
+	state.Hash, err = readRef(target)
+	if err == InvalidRef {
+		state.Dangling = true
+		return state, nil
+	}
+	if err != RefNotFound {
+		return state, err
+	}
+	branches, err := listBranches()
+	if err != nil {
+		return state, err
+	}
+	state.Dangling = len(branches) > 0
+	return state, nil
+}
+
+func listBranches() ([]Ref, error) {
+	refs, err := ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	branches := []Ref{}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, "refs/heads/") {
+			branches = append(branches, ref)
+		}
+	}
+	return branches, nil
+}
+
+// RepairHead points HEAD to branch, or when empty to main, master or the
+// first existing branch, returning the full name of the branch chosen
+func RepairHead(branch string) (string, error) {
+	branches, err := listBranches()
+	if err != nil {
+		return "", err
+	}
+	exists := func(name string) bool {
+		for _, ref := range branches {
+			if ref.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	target := ""
+	if branch != "" {
+		target = "refs/heads/" + strings.TrimPrefix(branch, "refs/heads/")
+		if !exists(target) {
+			return "", fmt.Errorf("%w: %s", RefNotFound, target)
+		}
+	} else {
+		for _, candidate := range []string{"refs/heads/main", "refs/heads/master"} {
+			if exists(candidate) {
+				target = candidate
+				break
+			}
+		}
+		if target == "" && len(branches) > 0 {
+			target = branches[0].Name
+		}
+		if target == "" {
+			return "", fmt.Errorf("no branch to point HEAD to")
+		}
+	}
+	return target, UpdateSymbolicRef("HEAD", target)
+}
@@ -0,0 +1,207 @@
+package mygit
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestShowRef(t *testing.T) {
+	testRepository(t)
+	shaC := strings.Repeat("3", 40)
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		shaA + " refs/heads/packed\n" +
+		shaB + " refs/tags/v1\n" +
+		"^" + shaC + "\n" +
+		shaB + " refs/heads/main\n"
+	if err := os.WriteFile(gitPath("packed-refs"), []byte(packed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the loose ref wins over its packed copy
+	for ref, sha := range map[string]string{"refs/heads/main": shaA, "refs/heads/loose": shaB, "refs/remotes/origin/main": shaC} {
+		if err := UpdateRef(ref, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		args   []string
+		output string
+	}{
+		{nil, shaB + " refs/heads/loose\n" + shaA + " refs/heads/main\n" + shaA + " refs/heads/packed\n" + shaC + " refs/remotes/origin/main\n" + shaB + " refs/tags/v1\n"},
+		{[]string{"--heads"}, shaB + " refs/heads/loose\n" + shaA + " refs/heads/main\n" + shaA + " refs/heads/packed\n"},
+		{[]string{"--tags"}, shaB + " refs/tags/v1\n"},
+		{[]string{"--head", "--tags"}, shaA + " HEAD\n" + shaB + " refs/tags/v1\n"},
+		{[]string{"main"}, shaA + " refs/heads/main\n" + shaC + " refs/remotes/origin/main\n"},
+		{[]string{"-s", "v1"}, shaB + "\n"},
+		{[]string{"--verify", "refs/heads/packed", "refs/heads/main"}, shaA + " refs/heads/packed\n" + shaA + " refs/heads/main\n"},
+	} {
+		output, err := runCommand(t, append([]string{"show-ref"}, test.args...)...)
+		if err != nil || output != test.output {
+			t.Errorf("show-ref %v printed\n%s%v\nwant\n%s", test.args, output, err, test.output)
+		}
+	}
+
+	for _, args := range [][]string{{"--verify", "main"}, {"--verify", "refs/heads/missing"}, {"--verify"}, {"missing"}} {
+		if output, err := runCommand(t, append([]string{"show-ref"}, args...)...); err == nil || output != "" {
+			t.Errorf("show-ref %v printed %q, %v", args, output, err)
+		}
+	}
+}
+
+func TestInspectHead(t *testing.T) {
+	testRepository(t)
+	writeHead := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(gitPath("HEAD"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new repository points to its unborn branch
+	if state, err := InspectHead(); err != nil || state != (HeadState{Target: "refs/heads/main"}) || state.Broken() {
+		t.Errorf("new repository: %+v, %v", state, err)
+	}
+	if err := UpdateRef("refs/heads/master", shaA); err != nil {
+		t.Fatal(err)
+	}
+	for content, want := range map[string]HeadState{
+		"ref: refs/heads/main\n":   {Target: "refs/heads/main", Dangling: true},
+		"ref: refs/heads/master\n": {Target: "refs/heads/master", Hash: shaA},
+		shaB + "\n":                {Hash: shaB},
+		"garbage\n":                {Missing: true},
+	} {
+		writeHead(content)
+		if state, err := InspectHead(); err != nil || state != want {
+			t.Errorf("HEAD %q: %+v, %v, want %+v", content, state, err, want)
+		}
+	}
+	os.Remove(gitPath("HEAD"))
+	if state, err := InspectHead(); err != nil || !state.Missing {
+		t.Errorf("without HEAD: %+v, %v", state, err)
+	}
+}
+
+func TestRepairHead(t *testing.T) {
+	testRepository(t)
+	for ref, sha := range map[string]string{"refs/heads/master": shaA, "refs/heads/feature": shaB} {
+		if err := UpdateRef(ref, sha); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// HEAD points to main, which was never created
+	output, err := runCommand(t, "head")
+	if err != ExitStatus(1) || output != "HEAD -> refs/heads/main, which does not exist\n" {
+		t.Errorf("head printed %q, %v", output, err)
+	}
+
+	output, err = runCommand(t, "head", "--repair")
+	if err != nil || output != "HEAD -> refs/heads/main, which does not exist\nHEAD now points to refs/heads/master\n" {
+		t.Errorf("head --repair printed %q, %v", output, err)
+	}
+	if state, err := InspectHead(); err != nil || state != (HeadState{Target: "refs/heads/master", Hash: shaA}) {
+		t.Errorf("repaired HEAD: %+v, %v", state, err)
+	}
+
+	// a healthy HEAD only moves to an explicit branch
+	if output, err := runCommand(t, "head", "--repair"); err != nil || output != "HEAD -> refs/heads/master at "+shaA+"\n" {
+		t.Errorf("head --repair printed %q, %v", output, err)
+	}
+	if target, err := RepairHead("feature"); err != nil || target != "refs/heads/feature" {
+		t.Errorf("repaired to %s, %v", target, err)
+	}
+	if _, err := RepairHead("missing"); !errors.Is(err, RefNotFound) {
+		t.Errorf("repairing to a missing branch: %v", err)
+	}
+
+	// without any branch there is nothing to point to
+	testRepository(t)
+	os.Remove(gitPath("HEAD"))
+	if _, err := RepairHead(""); err == nil {
+		t.Error("repaired HEAD without branches")
+	}
+}
+
+func TestResolveTreePath(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"top": "top\n", "dir/sub/file": "nested\n"})
+	commit := commitWorktree(t, "first")
+	root := treeOf(t, commit)
+	sub, err := ResolveRef(commit + ":dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"main:":               root,
+		"HEAD:/":              root,
+		"main:top":            blobSha("top\n"),
+		"main:dir/sub/file":   blobSha("nested\n"),
+		"main:dir//sub/":      sub,
+		root + ":dir/sub":     sub,
+		"refs/heads/main:top": blobSha("top\n"),
+	} {
+		if sha, err := ResolveRef(name); err != nil || sha != want {
+			t.Errorf("%s resolves to %s, %v, want %s", name, sha, err, want)
+		}
+	}
+	for _, name := range []string{"main:missing", "main:top/", "main:top/file", "main:dir/sub/file/"} {
+		if sha, err := ResolveRef(name); !errors.Is(err, PathNotFound) {
+			t.Errorf("%s resolves to %s, %v", name, sha, err)
+		}
+	}
+	if _, err := ResolveRef(blobSha("top\n") + ":top"); !errors.Is(err, InvalidRef) {
+		t.Errorf("a path in a blob: %v", err)
+	}
+
+	output, err := runCommand(t, "cat-file", "-p", "main:dir/sub/file")
+	if err != nil || output != "nested\n" {
+		t.Errorf("cat-file -p main:dir/sub/file printed %q, %v", output, err)
+	}
+	if _, err := runCommand(t, "cat-file", "-e", "main:missing"); err != ExitStatus(1) {
+		t.Errorf("cat-file -e main:missing returned %v", err)
+	}
+	output, err = runCommand(t, "ls-tree", "--name-only", "main:dir")
+	if err != nil || output != "sub\n" {
+		t.Errorf("ls-tree main:dir printed %q, %v", output, err)
+	}
+}
+
+func TestCheckRefFormatMatchesGit(t *testing.T) {
+	testRepository(t)
+	names := []string{
+		"refs/heads/main", "main", "@", "refs/heads/a..b", "refs/heads/.hidden", "refs/heads/x.lock",
+		"refs/heads/a b", "refs/heads/a@{1}", "refs/heads/end.", "refs/heads/", "refs/*/x", "refs/*/*",
+		"refs/heads/a^", "refs//heads", "//refs//heads//main", "refs/heads/a\\b", "refs/heads/a\x7fb",
+	}
+	for _, name := range names {
+		for _, opts := range [][]string{nil, {"--allow-onelevel"}, {"--refspec-pattern"}, {"--normalize"}} {
+			args := append(append([]string{"check-ref-format"}, opts...), name)
+			want, wantStatus := gitStatus(t, nil, args...)
+			output, err := runCommand(t, args...)
+			status := 0
+			var exit ExitStatus
+			if errors.As(err, &exit) {
+				status = int(exit)
+			} else if err != nil {
+				t.Fatalf("%q: %v", args, err)
+			}
+			if status != wantStatus || output != string(want) {
+				t.Errorf("%q printed %q and exited with %d, git printed %q and exited with %d", args, output, status, want, wantStatus)
+			}
+		}
+	}
+
+	// names git refuses are refused where refs are made too
+	commitWorktree(t, "first")
+	if _, err := runCommand(t, "branch", "bad..name"); err == nil {
+		t.Error("branch bad..name succeeded")
+	}
+	if _, err := runCommand(t, "update-ref", "refs/heads/a@{1}", "HEAD"); err == nil {
+		t.Error("update-ref refs/heads/a@{1} succeeded")
+	}
+	if refs := runGit(t, "for-each-ref", "--format=%(refname)"); refs != "refs/heads/main" {
+		t.Errorf("the refs are\n%s", refs)
+	}
+}
@@ -0,0 +1,110 @@
+package mygit
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	NotARepository = errors.New("Not a git repository (or any of the parent directories).")
+)
+
+// gitDir is the repository directory, set by --git-dir or $GIT_DIR
+var gitDir = ".git"
+
+func init() {
+	if dir := os.Getenv("GIT_DIR"); dir != "" {
+		gitDir = dir
+	}
+}
+
+// gitPath joins elem to the repository directory
+func gitPath(elem ...string) string {
+	return path.Join(append([]string{gitDir}, elem...)...)
+}
+
+// useRepository makes dir the repository, the .git directory of a worktree
+// or a bare repository
+func useRepository(dir string) error {
+	gitDir = dir
+	if info, err := os.Stat(path.Join(dir, ".git")); err == nil && info.IsDir() {
+		gitDir = path.Join(dir, ".git")
+	}
+	return loadObjectFormat()
+}
+
+// repositoryMu serializes the library calls working in a repository of their
+// own, like Clone, as the repository and its caches are process-wide
+var repositoryMu sync.Mutex
+
+// inRepository runs fn with dir as the repository, without moving to it.
+// The repository of the caller is back once it returns, its caches are
+// reloaded when needed.
+func inRepository(dir string, fn func() error) error {
+	repositoryMu.Lock()
+	defer repositoryMu.Unlock()
+	saved, savedFormat := gitDir, objectHasher
+	defer func() {
+		gitDir, objectHasher = saved, savedFormat
+		dropRepositoryCaches()
+	}()
+	gitDir = dir
+	dropRepositoryCaches()
+	if err := loadObjectFormat(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// dropRepositoryCaches forgets what was loaded from the repository
+func dropRepositoryCaches() {
+	dropObjectCaches()
+	promisorMu.Lock()
+	promisorURL, promisorURLLoaded = "", false
+	promisorMu.Unlock()
+}
+
+// enterWorktree moves to the root of the worktree when mygit runs from one
+// of its subdirectories, finding it like git as the closest parent holding
+// a .git, and returns where mygit was started relative to the root, like
+// "src/", empty at the root. Commands taking pathspecs call it, the others
+// see the current directory as the worktree. A repository given with
+// --git-dir or $GIT_DIR has the current directory as its worktree.
+func enterWorktree() (string, error) {
+	if gitDir != ".git" {
+		return "", nil
+	}
+	if _, err := os.Stat(gitDir); err == nil {
+		return "", nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	root := cwd
+	for {
+		if _, err := os.Stat(filepath.Join(root, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", NotARepository
+		}
+		root = parent
+	}
+	prefix, err := filepath.Rel(root, cwd)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chdir(root); err != nil {
+		return "", err
+	}
+	// the repository was not found from where mygit started
+	if err := loadObjectFormat(); err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(prefix) + "/", nil
+}
@@ -0,0 +1,244 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRepository initializes a repository in a temporary directory and runs
+// the rest of the test from its worktree, with a home of its own so that
+// the user's config stays out
+func testRepository(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_INDEX_FILE", "")
+	t.Setenv("GIT_AUTHOR_NAME", "Tester")
+	t.Setenv("GIT_AUTHOR_EMAIL", "tester@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Tester")
+	t.Setenv("GIT_COMMITTER_EMAIL", "tester@example.com")
+
+	chdir(t, dir)
+	saved, savedFormat := gitDir, objectHasher
+	gitDir, objectHasher = ".git", SHA1
+	dropObjectCaches()
+	t.Cleanup(func() {
+		gitDir, objectHasher = saved, savedFormat
+		dropObjectCaches()
+	})
+	if err := InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// chdir moves to dir until the end of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// writeFiles writes each file, relative to the current directory, creating
+// its parents
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testTime is when the next test commit is made, a minute after the last
+var testTime = time.Unix(1700000000, 0).UTC()
+
+// commitWorktree commits the files of the worktree on top of main and moves
+// main to the commit, whose sha it returns
+func commitWorktree(t *testing.T, message string) string {
+	t.Helper()
+	_, tree, err := BuildTreeFromDir(context.Background(), ".", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents := []string{}
+	if parent, err := ResolveRef("refs/heads/main"); err == nil {
+		parents = append(parents, parent)
+	}
+	testTime = testTime.Add(time.Minute)
+	sig := Signature{Name: "Tester", Email: "tester@example.com", When: testTime}
+	sha, err := WriteContent(&Commit{
+		tree:      fmt.Sprintf("%x", tree),
+		parents:   parents,
+		author:    sig,
+		committer: sig,
+		message:   message + "\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("refs/heads/main", fmt.Sprintf("%x", sha)); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", sha)
+}
+
+// captureOutput returns what run prints to stdout
+func captureOutput(t *testing.T, run func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+	runErr := run()
+	os.Stdout = stdout
+	w.Close()
+	data := <-output
+	r.Close()
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	return string(data)
+}
+
+// runGit runs git in the current directory and returns its trimmed output,
+// the test is skipped without git
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	return strings.TrimSpace(string(gitOutput(t, nil, args...)))
+}
+
+// packedHistory commits a file growing over commits commits with git and
+// repacks them, most of the objects end up deltas in a single pack
+func packedHistory(t *testing.T, commits int) {
+	t.Helper()
+	for i := 1; i <= commits; i++ {
+		var numbers strings.Builder
+		for n := 1; n <= i*50; n++ {
+			fmt.Fprintln(&numbers, n)
+		}
+		writeFiles(t, map[string]string{"numbers": numbers.String()})
+		runGit(t, "add", "numbers")
+		runGit(t, "commit", "-q", "-m", fmt.Sprint("commit ", i))
+	}
+	runGit(t, "repack", "-adfq")
+}
+
+// divergedHistory commits with git two branches diverging from a base,
+// main having merged other once before other moved on
+func divergedHistory(t *testing.T) {
+	t.Helper()
+	commit := func(message string) { runGit(t, "commit", "-q", "--allow-empty", "-m", message) }
+	runGit(t, "symbolic-ref", "HEAD", "refs/heads/main")
+	commit("base")
+	runGit(t, "branch", "other")
+	for i := 1; i <= 3; i++ {
+		commit(fmt.Sprint("ours ", i))
+	}
+	runGit(t, "checkout", "-q", "other")
+	for i := 1; i <= 2; i++ {
+		commit(fmt.Sprint("theirs ", i))
+	}
+	runGit(t, "checkout", "-q", "main")
+	runGit(t, "merge", "-q", "--no-edit", "other")
+	runGit(t, "checkout", "-q", "other")
+	commit("theirs 3")
+	runGit(t, "checkout", "-q", "main")
+}
+
+// gitOutput runs git in the current directory with stdin as its input and
+// returns its output as it is, the test is skipped without git
+func gitOutput(t *testing.T, stdin []byte, args ...string) []byte {
+	t.Helper()
+	output, status := gitStatus(t, stdin, args...)
+	if status != 0 {
+		t.Fatalf("git %v: exit status %d", args, status)
+	}
+	return output
+}
+
+// gitStatus runs git like gitOutput but returns its exit status instead of
+// failing the test on one
+func gitStatus(t *testing.T, stdin []byte, args ...string) ([]byte, int) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	cmd := exec.Command("git", args...)
+	// an empty GIT_INDEX_FILE is an index file without a name to git
+	cmd.Env = append(slices.DeleteFunc(os.Environ(), func(v string) bool {
+		return v == "GIT_INDEX_FILE="
+	}), "GIT_CONFIG_NOSYSTEM=1", "GIT_DIR="+gitDir)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		t.Logf("git %v: %v\n%s", args, err, stderr.Bytes())
+		return output, exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return output, 0
+}
+
+// withStdin runs run with data as the standard input
+func withStdin(t *testing.T, data []byte, run func()) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+	run()
+}
+
+// matchGit runs the same command with git then with mygit, in the current
+// directory, and fails the test unless both print the same. It returns the
+// output of mygit. The test is skipped without git.
+func matchGit(t *testing.T, args ...string) string {
+	t.Helper()
+	want := runGit(t, args...)
+	output, err := runCommand(t, args...)
+	if err != nil {
+		t.Errorf("%v: %v", args, err)
+	} else if strings.TrimSpace(output) != want {
+		t.Errorf("%v printed\n%s\ngit printed\n%s", args, output, want)
+	}
+	return output
+}
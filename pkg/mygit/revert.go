@@ -0,0 +1,92 @@
+package mygit
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// RevertResult is the commit a revert created, or the paths left with
+// conflict markers when it could not create one
+type RevertResult struct {
+	Commit    string
+	Conflicts []string
+}
+
+// Revert undoes the changes the commit at sha made on top of HEAD: the
+// commit is merged into HEAD with its parent standing for the other side.
+// When paths conflict their markers are left in the worktree and no commit
+// is made, REVERT_HEAD and MERGE_MSG are written like git does instead.
+func Revert(ctx context.Context, sha string) (RevertResult, error) {
+	head, err := ResolveRef("HEAD")
+	if err != nil {
+		return RevertResult{}, fmt.Errorf("cannot revert without a HEAD commit: %w", err)
+	}
+	commit, err := ReadCommit(sha)
+	if err != nil {
+		return RevertResult{}, err
+	}
+	if len(commit.parents) > 1 {
+		return RevertResult{}, fmt.Errorf("commit %s is a merge, reverting it is not supported", sha)
+	}
+
+	ours, err := FlattenTree(head)
+	if err != nil {
+		return RevertResult{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return RevertResult{}, err
+	}
+
+	labels := MergeLabels{
+		Ours:   "HEAD",
+		Theirs: fmt.Sprintf("parent of %s (%s)", sha[:7], commit.Subject()),
+	}
+	merged, conflicts, err := ReplayCommit(ours, commit, true, labels)
+	if err != nil {
+		return RevertResult{}, err
+	}
+	if err := UpdateWorktree(ours, merged); err != nil {
+		return RevertResult{}, err
+	}
+
+	message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.\n", commit.Subject(), sha)
+	if len(conflicts) > 0 {
+		message += "\n# Conflicts:\n"
+		for _, name := range conflicts {
+			message += "#\t" + name + "\n"
+		}
+		if err := os.WriteFile(gitPath("MERGE_MSG"), []byte(message), 0o644); err != nil {
+			return RevertResult{}, err
+		}
+		if err := os.WriteFile(gitPath("REVERT_HEAD"), []byte(sha+"\n"), 0o644); err != nil {
+			return RevertResult{}, err
+		}
+		return RevertResult{Conflicts: conflicts}, nil
+	}
+
+	tree, err := WriteTreeFromFiles(merged, true)
+	if err != nil {
+		return RevertResult{}, err
+	}
+	author, err := resolveSignature("AUTHOR")
+	if err != nil {
+		return RevertResult{}, err
+	}
+	committer, err := resolveSignature("COMMITTER")
+	if err != nil {
+		return RevertResult{}, err
+	}
+	hash, err := storeObject(&Commit{
+		tree:      fmt.Sprintf("%x", tree),
+		parents:   []string{head},
+		author:    author,
+		committer: committer,
+		message:   message,
+	}, true)
+	if err != nil {
+		return RevertResult{}, err
+	}
+	revert := fmt.Sprintf("%x", hash)
+	return RevertResult{Commit: revert}, AdvanceHead(revert, head)
+}
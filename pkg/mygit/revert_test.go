@@ -0,0 +1,98 @@
+package mygit
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readWorktree returns the content of the files, missing ones are empty
+func readWorktree(t *testing.T, names ...string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		files[name] = string(content)
+	}
+	return files
+}
+
+func TestRevertCleanCommit(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\ntwo\nthree\n", "other": "other\n"})
+	first := commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "one\n2\nthree\n", "added": "added\n"})
+	second := commitWorktree(t, "change two")
+	writeFiles(t, map[string]string{"other": "changed since\n"})
+	third := commitWorktree(t, "change other")
+
+	result, err := Revert(context.Background(), second)
+	if err != nil || len(result.Conflicts) != 0 {
+		t.Fatalf("revert: %+v, %v", result, err)
+	}
+	files := readWorktree(t, "file", "other", "added")
+	if files["file"] != "one\ntwo\nthree\n" || files["other"] != "changed since\n" || files["added"] != "" {
+		t.Errorf("the worktree is %q", files)
+	}
+	if _, err := os.Stat("added"); !os.IsNotExist(err) {
+		t.Error("the file the commit added is still there")
+	}
+
+	if head, err := ResolveRef("HEAD"); err != nil || head != result.Commit {
+		t.Errorf("HEAD is %s, %v, want %s", head, err, result.Commit)
+	}
+	commit, err := ReadCommit(result.Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(commit.parents, " ") != third {
+		t.Errorf("the revert has the parents %v, want %s", commit.parents, third)
+	}
+	if want := "Revert \"change two\"\n\nThis reverts commit " + second + ".\n"; commit.message != want {
+		t.Errorf("the revert message is %q, want %q", commit.message, want)
+	}
+	// the tree is the one of the first commit with the later change
+	firstTree, err := FlattenTree(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := FlattenTree(result.Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted["file"] != firstTree["file"] || len(reverted) != 2 {
+		t.Errorf("the revert tree is %v", reverted)
+	}
+}
+
+func TestRevertConflict(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"file": "one\ntwo\nthree\n"})
+	commitWorktree(t, "first")
+	writeFiles(t, map[string]string{"file": "one\n2\nthree\n"})
+	second := commitWorktree(t, "change two")
+	writeFiles(t, map[string]string{"file": "one\nTWO\nthree\n"})
+	third := commitWorktree(t, "change two again")
+
+	output, err := runCommand(t, "revert", second)
+	if err != ExitStatus(1) || !strings.Contains(output, "CONFLICT: merge conflict in file\n") {
+		t.Fatalf("revert printed %q, %v", output, err)
+	}
+	want := "one\n<<<<<<< HEAD\nTWO\n=======\ntwo\n>>>>>>> parent of " + second[:7] + " (change two)\nthree\n"
+	if files := readWorktree(t, "file"); files["file"] != want {
+		t.Errorf("the conflicted file is\n%s\nwant\n%s", files["file"], want)
+	}
+	if head, _ := ResolveRef("HEAD"); head != third {
+		t.Errorf("HEAD moved to %s", head)
+	}
+	if revertHead, err := os.ReadFile(gitPath("REVERT_HEAD")); err != nil || string(revertHead) != second+"\n" {
+		t.Errorf("REVERT_HEAD is %q, %v", revertHead, err)
+	}
+	if message, err := os.ReadFile(gitPath("MERGE_MSG")); err != nil || !strings.HasSuffix(string(message), "# Conflicts:\n#\tfile\n") {
+		t.Errorf("MERGE_MSG is %q, %v", message, err)
+	}
+}
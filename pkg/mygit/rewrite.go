@@ -0,0 +1,134 @@
+package mygit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RewriteResult maps each commit given a new sha to it, in the order they
+// were rewritten, and lists the refs moved
+type RewriteResult struct {
+	Commits []Ref // Name is the old sha
+	Refs    []Ref
+}
+
+// RewriteIdentities rewrites the authors and committers of every commit
+// reachable from the branches, the lightweight tags and a detached HEAD
+// through m, then moves those refs to the new commits at once. Parents are
+// rewritten before their children, a commit whose identities and parents are
+// unchanged keeps its sha, and trees are reused as they are. Signatures no
+// longer match and are dropped, annotated tags are left on the old commits.
+// Nothing is written with dryRun.
+func RewriteIdentities(m *Mailmap, dryRun bool) (result RewriteResult, _ error) {
+	refs, err := ListRefs()
+	if err != nil {
+		return result, err
+	}
+	tips := []Ref{}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Name, "refs/heads/") && !strings.HasPrefix(ref.Name, "refs/tags/") {
+			continue
+		}
+		if kind, err := ObjectType(ref.Hash); err != nil {
+			return result, err
+		} else if kind == CommitKind {
+			tips = append(tips, ref)
+		}
+	}
+	if _, symbolic, err := ReadSymbolicRef("HEAD"); err != nil {
+		return result, err
+	} else if !symbolic {
+		if head, err := readRef("HEAD"); err == nil {
+			tips = append(tips, Ref{Name: "HEAD", Hash: head})
+		}
+	}
+
+	order, err := parentsFirst(tips)
+	if err != nil {
+		return result, err
+	}
+	rewritten := map[string]string{}
+	for _, sha := range order {
+		commit, err := ReadCommit(sha)
+		if err != nil {
+			return result, err
+		}
+		changed := false
+		for i, parent := range commit.parents {
+			if rewritten[parent] != parent {
+				commit.parents[i], changed = rewritten[parent], true
+			}
+		}
+		for _, sig := range []*Signature{&commit.author, &commit.committer} {
+			name, email := m.Resolve(sig.Name, sig.Email)
+			if name != sig.Name || email != sig.Email {
+				sig.Name, sig.Email, changed = name, email, true
+			}
+		}
+		if !changed {
+			rewritten[sha] = sha
+			continue
+		}
+
+		headers := []commitHeader{}
+		for _, header := range commit.headers {
+			if header.key != "gpgsig" && header.key != "gpgsig-sha256" {
+				headers = append(headers, header)
+			}
+		}
+		commit.headers = headers
+		hash, err := storeObject(commit, !dryRun)
+		if err != nil {
+			return result, err
+		}
+		rewritten[sha] = fmt.Sprintf("%x", hash)
+		result.Commits = append(result.Commits, Ref{Name: sha, Hash: rewritten[sha]})
+	}
+
+	tx := &RefTransaction{}
+	for _, ref := range tips {
+		if rewritten[ref.Hash] != ref.Hash {
+			tx.Update(ref.Name, rewritten[ref.Hash], ref.Hash)
+			result.Refs = append(result.Refs, Ref{Name: ref.Name, Hash: rewritten[ref.Hash]})
+		}
+	}
+	if dryRun || len(result.Refs) == 0 {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// parentsFirst orders the commits reachable from tips so that every commit
+// comes after its parents, walking with a stack as histories can be deep
+func parentsFirst(tips []Ref) ([]string, error) {
+	order, visited, stack := []string{}, map[string]bool{}, []string{}
+	for _, ref := range tips {
+		stack = append(stack, ref.Hash)
+	}
+	done := map[string]bool{}
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		if done[sha] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if visited[sha] {
+			// its parents were all done while it waited on the stack
+			stack = stack[:len(stack)-1]
+			done[sha] = true
+			order = append(order, sha)
+			continue
+		}
+		visited[sha] = true
+		info, err := ReadCommitInfo(sha)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range info.Parents {
+			if !visited[parent] {
+				stack = append(stack, parent)
+			}
+		}
+	}
+	return order, nil
+}
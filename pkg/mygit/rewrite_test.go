@@ -0,0 +1,53 @@
+package mygit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteIdentitiesMatchesFilterBranch(t *testing.T) {
+	testRepository(t)
+	t.Setenv("FILTER_BRANCH_SQUELCH_WARNING", "1")
+	divergedHistory(t)
+	// only the author of this one is left alone
+	runGit(t, "commit", "-q", "--allow-empty", "--author=Other <other@example.com>", "-m", "other author")
+	before := runGit(t, "rev-parse", "main", "other")
+	commits := strings.Count(runGit(t, "rev-list", "--all"), "\n") + 1
+
+	// git filter-branch with the same mapping, then the refs are put back
+	runGit(t, "filter-branch", "-f", "--env-filter", `
+		if [ "$GIT_AUTHOR_EMAIL" = tester@example.com ]; then GIT_AUTHOR_NAME=Renamed GIT_AUTHOR_EMAIL=renamed@example.com; fi
+		if [ "$GIT_COMMITTER_EMAIL" = tester@example.com ]; then GIT_COMMITTER_NAME=Renamed GIT_COMMITTER_EMAIL=renamed@example.com; fi
+	`, "--", "main", "other")
+	want := runGit(t, "rev-parse", "main", "other")
+	if want == before {
+		t.Fatal("filter-branch rewrote nothing")
+	}
+	for i, branch := range []string{"main", "other"} {
+		runGit(t, "update-ref", "refs/heads/"+branch, strings.Fields(before)[i])
+		runGit(t, "update-ref", "-d", "refs/original/refs/heads/"+branch)
+	}
+	runGit(t, "reset", "-q", "--hard")
+
+	mailmap := filepath.Join(t.TempDir(), "mailmap")
+	if err := os.WriteFile(mailmap, []byte("Renamed <renamed@example.com> <tester@example.com>\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	trees := runGit(t, "log", "--format=%T", "main")
+	output, err := runCommand(t, "-q", "rewrite-identities", mailmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, "rev-parse", "main", "other"); got != want {
+		t.Errorf("rewrote the branches to\n%s\nfilter-branch rewrote them to\n%s", got, want)
+	}
+	if got := runGit(t, "log", "--format=%T", "main"); got != trees {
+		t.Errorf("the trees changed to\n%s\nfrom\n%s", got, trees)
+	}
+	// one line per commit, old sha then new one
+	if lines := strings.Count(output, "\n"); lines != commits {
+		t.Errorf("the map has %d lines for %d commits:\n%s", lines, commits, output)
+	}
+}
@@ -0,0 +1,457 @@
+package mygit
+
+// https://git-scm.com/docs/pack-protocol#_reference_discovery
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// AdvertisedRefs returns the refs upload-pack advertises, in its order: HEAD
+// when it resolves, then every ref by name, an annotated tag being followed
+// by the object it peels to as <tag>^{}
+func AdvertisedRefs() ([]Ref, error) {
+	refs, err := ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	advertised := []Ref{}
+	if head, err := readRef("HEAD"); err == nil {
+		advertised = append(advertised, Ref{Name: "HEAD", Hash: head})
+	}
+	for _, ref := range refs {
+		advertised = append(advertised, ref)
+		if kind, err := ObjectType(ref.Hash); err != nil || kind != TagKind {
+			continue
+		}
+		peeled, _, err := PeelObject(ref.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref.Name, err)
+		}
+		advertised = append(advertised, Ref{Name: ref.Name + "^{}", Hash: peeled})
+	}
+	return advertised, nil
+}
+
+// WriteAdvertisedRefs prints the advertised refs like git ls-remote --symref,
+// the branch HEAD points to coming first
+func WriteAdvertisedRefs(w io.Writer) error {
+	refs, err := AdvertisedRefs()
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 && refs[0].Name == "HEAD" {
+		if target, ok, err := ReadSymbolicRef("HEAD"); err != nil {
+			return err
+		} else if ok {
+			fmt.Fprintf(w, "ref: %s\tHEAD\n", target)
+		}
+	}
+	for _, ref := range refs {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", ref.Hash, ref.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the capabilities upload-pack advertises, with symref=HEAD:<branch> when
+// HEAD is a branch and no-done over HTTP
+var serverCapabilities = []string{"multi_ack", "multi_ack_detailed", "side-band", "side-band-64k", "include-tag", "no-progress", "agent=" + agent}
+
+type UploadPackOptions struct {
+	// every request is answered on its own as over HTTP, a negotiation
+	// round ends the response instead of waiting for the next one
+	StatelessRPC  bool
+	AdvertiseRefs bool // only advertise the refs, the first HTTP request
+	ProtocolV2    bool // the client asked for version 2
+}
+
+// ServeUploadPack is the server side of a fetch: it advertises the refs,
+// reads the commits the client wants and the ones it has, and sends a pack
+// of what the client is missing
+func ServeUploadPack(ctx context.Context, r io.Reader, w io.Writer, opts UploadPackOptions) error {
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	if opts.ProtocolV2 {
+		return serveProtocolV2(ctx, r, out, opts)
+	}
+
+	refs, err := AdvertisedRefs()
+	if err != nil {
+		return err
+	}
+	if !opts.StatelessRPC || opts.AdvertiseRefs {
+		if err := writeRefAdvertisement(out, refs, opts.StatelessRPC); err != nil {
+			return err
+		}
+		if opts.AdvertiseRefs {
+			return nil
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+
+	wants, capabilities, err := readWants(r, refs)
+	if errors.Is(err, io.EOF) || (err == nil && len(wants) == 0) {
+		return nil // the client only wanted the refs, like ls-remote
+	}
+	if err != nil {
+		fmt.Fprint(out, serializePackeLine("ERR "+err.Error()+"\n"))
+		return err
+	}
+	Verbosef("Client capabilities: %s\n", strings.Join(capabilities, " "))
+	has := func(name string) bool { return slices.Contains(capabilities, name) }
+
+	common, done, err := negotiate(r, out, wants, has, opts.StatelessRPC)
+	if err != nil || !done {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	objects, err := objectsToSend(wants, common, has("include-tag"))
+	if err != nil {
+		return err
+	}
+	Verbosef("Sending %d objects\n", len(objects))
+
+	var pack io.Writer = out
+	switch {
+	case has("side-band-64k"):
+		pack = &sidebandWriter{w: out, band: 1, max: 65520}
+	case has("side-band"):
+		pack = &sidebandWriter{w: out, band: 1, max: 1000}
+	}
+	if err := WritePack(pack, objects); err != nil {
+		return err
+	}
+	if pack != io.Writer(out) {
+		_, err = out.WriteString(flushPacket)
+	}
+	return err
+}
+
+func writeRefAdvertisement(w io.Writer, refs []Ref, stateless bool) error {
+	capabilities := slices.Clone(serverCapabilities)
+	if stateless {
+		// only a stateless client waits for the pack in the round the
+		// server is ready in
+		capabilities = append(capabilities, "no-done")
+	}
+	format, err := RepositoryObjectFormat()
+	if err != nil {
+		return err
+	}
+	capabilities = append(capabilities, "object-format="+string(format))
+	if target, ok, err := ReadSymbolicRef("HEAD"); err != nil {
+		return err
+	} else if ok && len(refs) > 0 && refs[0].Name == "HEAD" {
+		capabilities = append(capabilities, "symref=HEAD:"+target)
+	}
+	if len(refs) == 0 {
+		// an empty repository still has to send its capabilities
+		refs = []Ref{{Name: "capabilities^{}", Hash: zeroHash}}
+	}
+	for i, ref := range refs {
+		line := ref.Hash + " " + ref.Name
+		if i == 0 {
+			line += "\x00" + strings.Join(capabilities, " ")
+		}
+		if _, err := io.WriteString(w, serializePackeLine(line+"\n")); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, flushPacket)
+	return err
+}
+
+// checkWants fails unless every want was advertised, like git does by
+// default
+func checkWants(wants []string, refs []Ref) error {
+	advertised := map[string]bool{}
+	for _, ref := range refs {
+		advertised[ref.Hash] = true
+	}
+	for _, sha := range wants {
+		if !advertised[sha] {
+			return fmt.Errorf("upload-pack: not our ref %s", sha)
+		}
+	}
+	return nil
+}
+
+// readWants reads the want lines up to the flush, capabilities come on the
+// first one
+func readWants(r io.Reader, refs []Ref) (wants, capabilities []string, _ error) {
+	for {
+		line, err := parsePacketLine(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == nil {
+			return wants, capabilities, checkWants(wants, refs)
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 || fields[0] != "want" || !isHash(fields[1]) {
+			return nil, nil, fmt.Errorf("upload-pack: protocol error, expected a want, got %q", string(line))
+		}
+		if len(wants) == 0 {
+			capabilities = fields[2:]
+		}
+		wants = append(wants, fields[1])
+	}
+}
+
+// negotiate acknowledges the haves the repository has too, answering each
+// flush with a NAK, until the client is done. A stateless request ends at
+// its first flush, without done. With multi_ack_detailed the flush is
+// preceded by a ready once every want reaches a commit in common, and with
+// no-done as well the negotiation ends there and the pack follows.
+func negotiate(r io.Reader, w *bufio.Writer, wants []string, has func(string) bool, stateless bool) (common []string, done bool, _ error) {
+	multiAck, detailed := has("multi_ack"), has("multi_ack_detailed")
+	last := ""
+	for {
+		line, err := parsePacketLine(r)
+		if err != nil {
+			return nil, false, err
+		}
+		if line == nil {
+			ready := false
+			if detailed && len(common) > 0 {
+				if ready, err = commonReachedByWants(wants, common); err != nil {
+					return nil, false, err
+				}
+				if ready {
+					w.WriteString(serializePackeLine("ACK " + last + " ready\n"))
+				}
+			}
+			if len(common) == 0 || multiAck || detailed {
+				w.WriteString(serializePackeLine("NAK\n"))
+			}
+			if ready && has("no-done") {
+				w.WriteString(serializePackeLine("ACK " + last + "\n"))
+				return common, true, nil
+			}
+			if err := w.Flush(); err != nil || stateless {
+				return nil, false, err
+			}
+			continue
+		}
+
+		text := strings.TrimSuffix(string(line), "\n")
+		if text == "done" {
+			if len(common) == 0 {
+				w.WriteString(serializePackeLine("NAK\n"))
+			} else if multiAck || detailed {
+				w.WriteString(serializePackeLine("ACK " + last + "\n"))
+			}
+			return common, true, nil
+		}
+		sha, found := strings.CutPrefix(text, "have ")
+		if !found || !isHash(sha) {
+			return nil, false, fmt.Errorf("upload-pack: protocol error, expected a have, got %q", text)
+		}
+		if !HasObject(sha) {
+			continue
+		}
+		if !slices.Contains(common, sha) {
+			common = append(common, sha)
+		}
+		last = sha
+		switch {
+		case detailed:
+			w.WriteString(serializePackeLine("ACK " + sha + " common\n"))
+		case multiAck:
+			w.WriteString(serializePackeLine("ACK " + sha + " continue\n"))
+		case len(common) == 1:
+			w.WriteString(serializePackeLine("ACK " + sha + "\n"))
+		}
+	}
+}
+
+// commonReachedByWants tells whether every want reaches a commit in common,
+// the client has then told enough for the pack to leave out what it has
+func commonReachedByWants(wants, common []string) (bool, error) {
+	for _, want := range wants {
+		if kind, err := ObjectType(want); err != nil || kind != CommitKind {
+			return false, err
+		}
+		ancestors, err := Ancestors(want)
+		if err != nil {
+			return false, err
+		}
+		if !slices.ContainsFunc(common, func(sha string) bool { return ancestors[sha] }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// objectsToSend lists what wants reach and common does not, with the
+// annotated tags pointing to what is sent when includeTags is set
+func objectsToSend(wants, common []string, includeTags bool) ([]string, error) {
+	known := map[string]bool{}
+	if len(common) > 0 {
+		reachable, err := ReachableObjects(common)
+		if err != nil {
+			return nil, err
+		}
+		for _, sha := range reachable {
+			known[sha] = true
+		}
+	}
+	reachable, err := ReachableObjects(wants)
+	if err != nil {
+		return nil, err
+	}
+	objects, sent := []string{}, map[string]bool{}
+	for _, sha := range reachable {
+		if !known[sha] {
+			objects = append(objects, sha)
+			sent[sha] = true
+		}
+	}
+	if !includeTags {
+		return objects, nil
+	}
+
+	refs, err := ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Name, "refs/tags/") || sent[ref.Hash] || known[ref.Hash] {
+			continue
+		}
+		if kind, err := ObjectType(ref.Hash); err != nil || kind != TagKind {
+			continue
+		}
+		tag, err := ReadGitObject(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+		target, err := tag.(*Tag).Object()
+		if err != nil {
+			return nil, err
+		}
+		if sent[target] {
+			objects = append(objects, ref.Hash)
+			sent[ref.Hash] = true
+		}
+	}
+	return objects, nil
+}
+
+// sidebandWriter sends what is written on a side-band channel, in packets
+// of at most max bytes
+type sidebandWriter struct {
+	w    io.Writer
+	band byte
+	max  int
+}
+
+func (s *sidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), s.max-5)]
+		if _, err := fmt.Fprintf(s.w, "%04x%c", len(chunk)+5, s.band); err != nil {
+			return written, err
+		}
+		if _, err := s.w.Write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// https://git-scm.com/docs/http-protocol#_smart_clients
+// UploadPackHandler serves the repository over smart HTTP, under any path
+// prefix: the ref advertisement at info/refs and the fetches at
+// git-upload-pack. Requests are served one at a time, the repository state
+// like the loaded packs is shared.
+type UploadPackHandler struct {
+	mu sync.Mutex
+}
+
+func (h *UploadPackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		if r.URL.Query().Get("service") != "git-upload-pack" {
+			// the dumb protocol needs the files of the repository
+			http.Error(w, "only the smart protocol is supported", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, serializePackeLine("# service=git-upload-pack\n")+flushPacket)
+		opts := UploadPackOptions{StatelessRPC: true, AdvertiseRefs: true, ProtocolV2: wantsProtocolV2(r.Header.Get("Git-Protocol"))}
+		err := ServeUploadPack(r.Context(), r.Body, w, opts)
+		h.logError(r, err)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+		body := r.Body
+		// git compresses the larger requests
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = gz
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		w.Header().Set("Cache-Control", "no-cache")
+		opts := UploadPackOptions{StatelessRPC: true, ProtocolV2: wantsProtocolV2(r.Header.Get("Git-Protocol"))}
+		err := ServeUploadPack(r.Context(), body, w, opts)
+		h.logError(r, err)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// once the response started the status cannot change, errors are logged
+func (h *UploadPackHandler) logError(r *http.Request, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", r.Method, r.URL.Path, err)
+	}
+}
+
+// ServeHTTP serves the repository on addr until ctx is cancelled
+func ServeHTTP(ctx context.Context, addr string, ready func(net.Addr)) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if ready != nil {
+		ready(listener.Addr())
+	}
+	server := &http.Server{Handler: &UploadPackHandler{}}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		// an interrupt is how the server is meant to stop
+		return nil
+	}
+	return err
+}
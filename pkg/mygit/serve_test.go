@@ -0,0 +1,293 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// servedHistory returns a bare clone of a packed history with an annotated
+// tag and a second branch, made by git
+func servedHistory(t *testing.T) string {
+	t.Helper()
+	testRepository(t)
+	packedHistory(t, 10)
+	runGit(t, "tag", "-a", "-m", "annotated", "v1", "HEAD~3")
+	runGit(t, "branch", "side", "HEAD~1")
+	bare := filepath.Join(t.TempDir(), "history.git")
+	runGit(t, "clone", "-q", "--bare", ".", bare)
+	return bare
+}
+
+// gitHTTPBackend serves the bare repository over smart HTTP with git
+// http-backend, in a process of its own, and returns its url
+func gitHTTPBackend(t *testing.T, bare string) string {
+	t.Helper()
+	backend, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	server := httptest.NewServer(&cgi.Handler{
+		Path: backend,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(bare), "GIT_HTTP_EXPORT_ALL=1", "GIT_CONFIG_NOSYSTEM=1"},
+	})
+	t.Cleanup(server.Close)
+	return server.URL + "/" + filepath.Base(bare)
+}
+
+func TestServeHeadMatchesGit(t *testing.T) {
+	bare := servedHistory(t)
+	head := runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD")
+
+	// the packed refs and the annotated tag peeled, like git advertises them
+	want := runGit(t, "ls-remote", "--symref", bare)
+	output, err := runCommand(t, "--git-dir="+bare, "serve-head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(output) != want {
+		t.Errorf("serve-head printed\n%s\ngit advertises\n%s", output, want)
+	}
+
+	runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD", "refs/heads/side")
+	want = runGit(t, "ls-remote", "--symref", bare)
+	runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD", head)
+	output, err = runCommand(t, "--git-dir="+bare, "serve-head", "side")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(output) != want {
+		t.Errorf("serve-head side printed\n%s\ngit advertises\n%s", output, want)
+	}
+	if got := runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD"); got != "refs/heads/side" {
+		t.Errorf("HEAD is %s after serve-head side", got)
+	}
+}
+
+// uploadPackResponse serves request and returns the negotiation lines
+// answering it, after the ref advertisement unless stateless, and the number
+// of objects in the pack following them, -1 without a pack
+func uploadPackResponse(t *testing.T, request string, stateless bool) ([]string, int) {
+	t.Helper()
+	out := bytes.Buffer{}
+	err := ServeUploadPack(context.Background(), strings.NewReader(request), &out, UploadPackOptions{StatelessRPC: stateless})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(out.Bytes())
+	if !stateless {
+		for {
+			line, err := parsePacketLine(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == nil {
+				break
+			}
+		}
+	}
+	lines := []string{}
+	for r.Len() > 0 {
+		if rest := out.Bytes()[out.Len()-r.Len():]; bytes.HasPrefix(rest, []byte("PACK")) {
+			return lines, int(binary.BigEndian.Uint32(rest[8:12]))
+		}
+		line, err := parsePacketLine(r)
+		if err != nil {
+			t.Fatalf("%v after %q", err, lines)
+		}
+		lines = append(lines, strings.TrimSuffix(string(line), "\n"))
+	}
+	return lines, -1
+}
+
+func TestUploadPackNegotiation(t *testing.T) {
+	servedHistory(t)
+	tip, second, fourth := runGit(t, "rev-parse", "HEAD"), runGit(t, "rev-parse", "HEAD~2"), runGit(t, "rev-parse", "HEAD~4")
+	missing := strings.Count(runGit(t, "rev-list", "--objects", tip, "--not", second, fourth), "\n") + 1
+	pkt := serializePackeLine
+
+	// as git fetch sends them, the second have is unknown to the repository
+	cases := []struct {
+		name      string
+		request   string
+		stateless bool
+		want      []string
+		objects   int
+	}{
+		{
+			name: "multi_ack_detailed",
+			request: pkt("want "+tip+" multi_ack_detailed\n") + flushPacket +
+				pkt("have "+second+"\n") + pkt("have "+shaA+"\n") + flushPacket +
+				pkt("have "+fourth+"\n") + pkt("done\n"),
+			want:    []string{"ACK " + second + " common", "ACK " + second + " ready", "NAK", "ACK " + fourth + " common", "ACK " + fourth},
+			objects: missing,
+		},
+		{
+			name: "multi_ack",
+			request: pkt("want "+tip+" multi_ack\n") + flushPacket +
+				pkt("have "+second+"\n") + flushPacket + pkt("have "+fourth+"\n") + pkt("done\n"),
+			want:    []string{"ACK " + second + " continue", "NAK", "ACK " + fourth + " continue", "ACK " + fourth},
+			objects: missing,
+		},
+		{
+			name: "single ack",
+			request: pkt("want "+tip+"\n") + flushPacket +
+				pkt("have "+shaA+"\n") + flushPacket + pkt("have "+second+"\n") + pkt("have "+fourth+"\n") +
+				flushPacket + pkt("done\n"),
+			want:    []string{"NAK", "ACK " + second},
+			objects: missing,
+		},
+		{
+			// the round ends the response, the client sends the next one
+			name: "stateless round",
+			request: pkt("want "+tip+" multi_ack_detailed\n") + flushPacket +
+				pkt("have "+second+"\n") + pkt("have "+shaA+"\n") + flushPacket,
+			stateless: true,
+			want:      []string{"ACK " + second + " common", "ACK " + second + " ready", "NAK"},
+			objects:   -1,
+		},
+		{
+			// the haves of the previous rounds are sent again
+			name: "stateless done",
+			request: pkt("want "+tip+" multi_ack_detailed\n") + flushPacket +
+				pkt("have "+second+"\n") + pkt("have "+fourth+"\n") + pkt("done\n"),
+			stateless: true,
+			want:      []string{"ACK " + second + " common", "ACK " + fourth + " common", "ACK " + fourth},
+			objects:   missing,
+		},
+		{
+			// ready with no-done, the pack follows the round
+			name: "stateless no-done",
+			request: pkt("want "+tip+" multi_ack_detailed no-done\n") + flushPacket +
+				pkt("have "+second+"\n") + pkt("have "+shaA+"\n") + flushPacket,
+			stateless: true,
+			want:      []string{"ACK " + second + " common", "ACK " + second + " ready", "NAK", "ACK " + second},
+			objects:   strings.Count(runGit(t, "rev-list", "--objects", tip, "--not", second), "\n") + 1,
+		},
+		{
+			name:      "stateless nothing in common",
+			request:   pkt("want "+tip+" multi_ack_detailed\n") + flushPacket + pkt("have "+shaA+"\n") + pkt("done\n"),
+			stateless: true,
+			want:      []string{"NAK"},
+			objects:   strings.Count(runGit(t, "rev-list", "--objects", tip), "\n") + 1,
+		},
+	}
+	for _, c := range cases {
+		lines, objects := uploadPackResponse(t, c.request, c.stateless)
+		if strings.Join(lines, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%s: answered\n%s\nwant\n%s", c.name, strings.Join(lines, "\n"), strings.Join(c.want, "\n"))
+		}
+		if objects != c.objects {
+			t.Errorf("%s: sent %d objects, want %d", c.name, objects, c.objects)
+		}
+	}
+}
+
+func TestServeHTTPToGit(t *testing.T) {
+	servedHistory(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs, served := make(chan net.Addr, 1), make(chan error, 1)
+	go func() {
+		served <- ServeHTTP(ctx, "127.0.0.1:0", func(addr net.Addr) { addrs <- addr })
+	}()
+	var url string
+	select {
+	case addr := <-addrs:
+		url = "http://" + addr.String() + "/history.git"
+	case err := <-served:
+		t.Fatal(err)
+	}
+
+	want := runGit(t, "rev-parse", "HEAD", "side", "v1")
+	for _, version := range []string{"0", "2"} {
+		clone := filepath.Join(t.TempDir(), "clone")
+		runGit(t, "-c", "protocol.version="+version, "clone", "-q", url, clone)
+		got := runGit(t, "-C", clone, "--git-dir=.git", "rev-parse", "HEAD", "origin/side", "v1")
+		if got != want {
+			t.Errorf("protocol %s cloned\n%s\nwant\n%s", version, got, want)
+		}
+		if fsck := runGit(t, "-C", clone, "--git-dir=.git", "fsck", "--full"); fsck != "" {
+			t.Errorf("protocol %s clone: %s", version, fsck)
+		}
+	}
+	// the dumb protocol is refused
+	resp, err := http.Get(url + "/info/refs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("info/refs without a service: %s", resp.Status)
+	}
+
+	// cancelling the context stops the server
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("ServeHTTP returned %v once cancelled", err)
+	}
+}
+
+func TestLsRefsMatchesGit(t *testing.T) {
+	servedHistory(t)
+	t.Setenv("GIT_PROTOCOL", "version=2")
+	pkt, delim := serializePackeLine, "0001"
+	request := pkt("command=ls-refs\n") + delim + pkt("peel\n") + pkt("symrefs\n") +
+		pkt("ref-prefix refs/tags/\n") + pkt("ref-prefix refs/heads/s\n") + flushPacket
+	// only the refs, the capabilities before them are git's own
+	refs := func(output []byte) []string {
+		lines := []string{}
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.Contains(line, " refs/") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	// the refs loose, then packed like in a clone
+	for _, packed := range []bool{false, true} {
+		if packed {
+			runGit(t, "pack-refs", "--all")
+		}
+		want := refs(gitOutput(t, []byte(request), "upload-pack", "."))
+		out := bytes.Buffer{}
+		err := ServeUploadPack(context.Background(), strings.NewReader(request), &out, UploadPackOptions{ProtocolV2: true})
+		if err != nil {
+			t.Fatalf("packed %v: %v", packed, err)
+		}
+		if got := refs(out.Bytes()); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("packed %v: ls-refs answered\n%s\ngit answered\n%s", packed, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+		if len(want) != 2 {
+			t.Errorf("packed %v: git answered %q, want the tag and side", packed, want)
+		}
+	}
+}
+
+func TestRemoteHeadMatchesGit(t *testing.T) {
+	bare := servedHistory(t)
+	url := gitHTTPBackend(t, bare)
+
+	head := strings.TrimPrefix(runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD"), "refs/heads/")
+	for _, branch := range []string{"side", head} {
+		runGit(t, "--git-dir="+bare, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+		symref, _, _ := strings.Cut(runGit(t, "ls-remote", "--symref", url, "HEAD"), "\t")
+		want := strings.TrimPrefix(symref, "ref: refs/heads/")
+		output, err := runCommand(t, "remote-head", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(output); got != want || got != branch {
+			t.Errorf("remote-head printed %s with HEAD on %s, git reads %s", got, branch, want)
+		}
+	}
+}
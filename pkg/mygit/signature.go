@@ -0,0 +1,163 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	NotSigned = errors.New("Object has no signature.")
+)
+
+const pgpSignatureStart = "-----BEGIN PGP SIGNATURE-----"
+
+type SignatureStatus int
+
+const (
+	SignatureGood SignatureStatus = iota
+	SignatureBad
+	SignatureUnknownKey // the key is not in the keyring
+)
+
+// SignatureCheck is what gpg said about a signature
+type SignatureCheck struct {
+	Status SignatureStatus
+	KeyID  string
+	Signer string // user id of the key, only known for a good or bad signature
+}
+
+func (s SignatureCheck) String() string {
+	switch s.Status {
+	case SignatureGood:
+		return fmt.Sprintf("Good signature from %s (key %s)", s.Signer, s.KeyID)
+	case SignatureBad:
+		return fmt.Sprintf("BAD signature from %s (key %s)", s.Signer, s.KeyID)
+	}
+	return fmt.Sprintf("Can't check signature: no public key %s", s.KeyID)
+}
+
+// SplitSignature separates the signature of a commit or tag from the payload
+// it signs. A commit carries it in its gpgsig header, the payload being the
+// commit without that header; a tag appends it to its message.
+func SplitSignature(kind ObjectKind, content []byte) (payload, signature []byte, _ error) {
+	switch kind {
+	case TagKind:
+		i := bytes.Index(content, []byte(pgpSignatureStart))
+		if i == -1 {
+			return nil, nil, NotSigned
+		}
+		return content[:i], content[i:], nil
+	case CommitKind:
+		return splitCommitSignature(content)
+	}
+	return nil, nil, fmt.Errorf("%w: a %s cannot be signed", NotSigned, kind)
+}
+
+// the header value continues on the following lines starting with a space
+func splitCommitSignature(content []byte) ([]byte, []byte, error) {
+	payload, signature := bytes.Buffer{}, bytes.Buffer{}
+	inHeaders, inSignature := true, false
+	for _, line := range splitLines(content) {
+		switch {
+		case !inHeaders:
+		case line == "\n":
+			inHeaders, inSignature = false, false
+		case inSignature && strings.HasPrefix(line, " "):
+			signature.WriteString(line[1:])
+			continue
+		case strings.HasPrefix(line, "gpgsig "):
+			inSignature = true
+			signature.WriteString(strings.TrimPrefix(line, "gpgsig "))
+			continue
+		default:
+			inSignature = false
+		}
+		payload.WriteString(line)
+	}
+	if signature.Len() == 0 {
+		return nil, nil, NotSigned
+	}
+	return payload.Bytes(), signature.Bytes(), nil
+}
+
+// VerifySignature checks a detached signature of payload with gpg, like git
+// does with gpg.program. An empty keyring uses gpg's default keyrings.
+func VerifySignature(ctx context.Context, payload, signature []byte, keyring string) (SignatureCheck, error) {
+	program := "gpg"
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return SignatureCheck{}, err
+	}
+	if configured, ok := cfg.Get("gpg.program"); ok {
+		program = configured
+	}
+
+	// gpg reads the signature from a file and the payload from stdin
+	sigFile, err := os.CreateTemp("", "mygit-signature-")
+	if err != nil {
+		return SignatureCheck{}, err
+	}
+	defer os.Remove(sigFile.Name())
+	if _, err := sigFile.Write(signature); err != nil {
+		sigFile.Close()
+		return SignatureCheck{}, err
+	}
+	if err := sigFile.Close(); err != nil {
+		return SignatureCheck{}, err
+	}
+
+	args := []string{"--status-fd=1", "--batch"}
+	if keyring != "" {
+		args = append(args, "--no-default-keyring", "--keyring", keyring)
+	}
+	args = append(args, "--verify", sigFile.Name(), "-")
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	status, err := cmd.Output()
+	// gpg exits with an error for bad and unverifiable signatures, the status
+	// lines tell which
+	check, found := parseGPGStatus(status)
+	if !found {
+		if err == nil {
+			err = fmt.Errorf("no signature status from %s", program)
+		}
+		return SignatureCheck{}, fmt.Errorf("%s: %w: %s", program, err, strings.TrimSpace(stderr.String()))
+	}
+	return check, nil
+}
+
+// https://github.com/gpg/gnupg/blob/master/doc/DETAILS#format-of-the-status-fd-output
+func parseGPGStatus(status []byte) (SignatureCheck, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimPrefix(scanner.Text(), "[GNUPG:] "), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		check := SignatureCheck{KeyID: fields[1]}
+		if len(fields) == 3 {
+			check.Signer = fields[2]
+		}
+		switch fields[0] {
+		case "GOODSIG":
+			check.Status = SignatureGood
+		case "BADSIG", "EXPKEYSIG", "REVKEYSIG":
+			check.Status = SignatureBad
+		case "ERRSIG":
+			// ERRSIG <keyid> <algo> ..., the key is missing or unusable
+			check.Status, check.Signer = SignatureUnknownKey, ""
+		default:
+			continue
+		}
+		return check, true
+	}
+	return SignatureCheck{}, false
+}
@@ -0,0 +1,163 @@
+package mygit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSignature = pgpSignatureStart + "\n\nsigned by %s\n-----END PGP SIGNATURE-----\n"
+
+// signedCommit writes a commit whose gpgsig header is the test signature
+// naming how the stub gpg judges it
+func signedCommit(t *testing.T, verdict string) (string, string) {
+	t.Helper()
+	payload := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\nauthor Tester <tester@example.com> 1700000000 +0000\ncommitter Tester <tester@example.com> 1700000000 +0000\n\nsigned\n"
+	header, body, _ := strings.Cut(payload, "\n\n")
+	signature := fmt.Sprintf(testSignature, verdict)
+	gpgsig := "gpgsig " + strings.ReplaceAll(strings.TrimSuffix(signature, "\n"), "\n", "\n ") + "\n"
+	sha, err := WriteContent(&CommitAsBytes{content: []byte(header + "\n" + gpgsig + "\n" + body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", sha), payload
+}
+
+// stubGPG configures a gpg.program answering like gpg from what the
+// signature says, it records its arguments and the payload it was given
+func stubGPG(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "$@" > "` + dir + `/args"
+cat > "` + dir + `/payload"
+for arg; do sig="$prev"; prev="$arg"; done
+case "$(cat "$sig")" in
+*"signed by good"*) echo "[GNUPG:] GOODSIG 0123456789ABCDEF Tester <tester@example.com>"; exit 0 ;;
+*"signed by bad"*) echo "[GNUPG:] BADSIG 0123456789ABCDEF Tester <tester@example.com>" ;;
+*"signed by unknown"*) echo "[GNUPG:] ERRSIG 0123456789ABCDEF 1 8 00 1700000000 9" ;;
+esac
+echo "gpg: stub" >&2
+exit 1
+`
+	program := filepath.Join(dir, "gpg")
+	if err := os.WriteFile(program, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(gitPath("config"), []byte("[gpg]\n\tprogram = "+program+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSplitSignature(t *testing.T) {
+	testRepository(t)
+	commit, payload := signedCommit(t, "good")
+	_, content, err := readObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotPayload, signature, err := SplitSignature(CommitKind, content)
+	if err != nil || string(gotPayload) != payload || string(signature) != fmt.Sprintf(testSignature, "good") {
+		t.Errorf("the commit splits into %q and %q, %v", gotPayload, signature, err)
+	}
+
+	tag := "object " + commit + "\ntype commit\ntag v1\ntagger Tester <tester@example.com> 1700000000 +0000\n\nrelease\n"
+	gotPayload, signature, err = SplitSignature(TagKind, []byte(tag+fmt.Sprintf(testSignature, "good")))
+	if err != nil || string(gotPayload) != tag || string(signature) != fmt.Sprintf(testSignature, "good") {
+		t.Errorf("the tag splits into %q and %q, %v", gotPayload, signature, err)
+	}
+
+	for kind, content := range map[ObjectKind]string{CommitKind: payload, TagKind: tag, BlobKind: "blob"} {
+		if _, _, err := SplitSignature(kind, []byte(content)); !errors.Is(err, NotSigned) {
+			t.Errorf("an unsigned %s: %v", kind, err)
+		}
+	}
+}
+
+func TestParseGPGStatus(t *testing.T) {
+	for status, want := range map[string]SignatureCheck{
+		"[GNUPG:] NEWSIG\n[GNUPG:] GOODSIG ABCD Tester <t@example.com>\n[GNUPG:] VALIDSIG ABCD\n": {SignatureGood, "ABCD", "Tester <t@example.com>"},
+		"[GNUPG:] BADSIG ABCD Tester <t@example.com>\n":                                           {SignatureBad, "ABCD", "Tester <t@example.com>"},
+		"[GNUPG:] EXPKEYSIG ABCD Tester\n":                                                        {SignatureBad, "ABCD", "Tester"},
+		"[GNUPG:] ERRSIG ABCD 1 8 00 1700000000 9\n":                                              {SignatureUnknownKey, "ABCD", ""},
+	} {
+		if check, found := parseGPGStatus([]byte(status)); !found || check != want {
+			t.Errorf("%q parses to %+v, %v, want %+v", status, check, found, want)
+		}
+	}
+	if check, found := parseGPGStatus([]byte("[GNUPG:] NEWSIG\n")); found {
+		t.Errorf("no verdict parses to %+v", check)
+	}
+}
+
+func TestVerifyCommit(t *testing.T) {
+	testRepository(t)
+	stub := stubGPG(t)
+	good, payload := signedCommit(t, "good")
+	bad, _ := signedCommit(t, "bad")
+	unknown, _ := signedCommit(t, "unknown")
+	writeFiles(t, map[string]string{"file": "unsigned\n"})
+	unsigned := commitWorktree(t, "unsigned")
+
+	output, err := runCommand(t, "verify-commit", "--keyring", "keys.gpg", good)
+	if err != nil || output != good+": Good signature from Tester <tester@example.com> (key 0123456789ABCDEF)\n" {
+		t.Errorf("verify-commit of a good signature printed %q, %v", output, err)
+	}
+	if sent, err := os.ReadFile(filepath.Join(stub, "payload")); err != nil || string(sent) != payload {
+		t.Errorf("gpg was given the payload %q, %v", sent, err)
+	}
+	if args, err := os.ReadFile(filepath.Join(stub, "args")); err != nil || !strings.Contains(string(args), "--no-default-keyring --keyring keys.gpg --verify ") {
+		t.Errorf("gpg was run with %q, %v", args, err)
+	}
+
+	for sha, want := range map[string]string{
+		bad:      "BAD signature from Tester <tester@example.com> (key 0123456789ABCDEF)",
+		unknown:  "Can't check signature: no public key 0123456789ABCDEF",
+		unsigned: "no signature found",
+	} {
+		output, err := runCommand(t, "verify-commit", sha)
+		if err != ExitStatus(1) || output != sha+": "+want+"\n" {
+			t.Errorf("verify-commit printed %q, %v, want %q", output, err, want)
+		}
+	}
+	if _, err := runCommand(t, "verify-tag", good); err == nil {
+		t.Error("verify-tag accepted a commit")
+	}
+}
+
+func TestVerifyTag(t *testing.T) {
+	testRepository(t)
+	stubGPG(t)
+	writeFiles(t, map[string]string{"file": "content\n"})
+	commit := commitWorktree(t, "first")
+	payload := "object " + commit + "\ntype commit\ntag v1\ntagger Tester <tester@example.com> 1700000000 +0000\n\nrelease\n"
+	tag, err := WriteContent(&Tag{content: []byte(payload + fmt.Sprintf(testSignature, "good"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRef("refs/tags/v1", fmt.Sprintf("%x", tag)); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runCommand(t, "verify-tag", "-v", "v1")
+	if err != nil || !strings.HasPrefix(output, payload) || !strings.HasSuffix(output, "v1: Good signature from Tester <tester@example.com> (key 0123456789ABCDEF)\n") {
+		t.Errorf("verify-tag -v printed %q, %v", output, err)
+	}
+	if _, err := runCommand(t, "verify-commit", "v1"); err == nil {
+		t.Error("verify-commit accepted a tag")
+	}
+}
+
+func TestVerifySignatureFailingProgram(t *testing.T) {
+	testRepository(t)
+	if err := os.WriteFile(gitPath("config"), []byte("[gpg]\n\tprogram = false\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if check, err := VerifySignature(context.Background(), []byte("payload"), []byte("signature"), ""); err == nil {
+		t.Errorf("a program printing no status gave %+v", check)
+	}
+}
@@ -0,0 +1,245 @@
+package mygit
+
+// https://git-scm.com/docs/git-sparse-checkout#_internalsfull_pattern_set
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	InvalidSparsePattern = errors.New("Invalid sparse-checkout pattern")
+)
+
+// the patterns written by sparse-checkout init: the files at the root only
+var defaultSparsePatterns = []string{"/*", "!/*/"}
+
+type sparsePattern struct {
+	text    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// SparsePatterns decides which files of the tree are written to the
+// worktree, the others are skipped like git's skip-worktree entries. A nil
+// *SparsePatterns includes every file.
+type SparsePatterns struct {
+	patterns []sparsePattern
+}
+
+// ParseSparsePatterns reads patterns with the gitignore syntax, blank lines
+// and comments are skipped
+func ParseSparsePatterns(data []byte) (*SparsePatterns, error) {
+	sparse := &SparsePatterns{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), " \t")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		p := sparsePattern{text: text}
+		glob := text
+		if glob[0] == '!' {
+			p.negate, glob = true, glob[1:]
+		} else if strings.HasPrefix(glob, `\!`) || strings.HasPrefix(glob, `\#`) {
+			glob = glob[1:]
+		}
+		if strings.HasSuffix(glob, "/") {
+			p.dirOnly, glob = true, strings.TrimRight(glob, "/")
+		}
+		// without a slash the pattern matches a name at any depth, else
+		// it is relative to the root
+		if !strings.Contains(glob, "/") {
+			glob = "**/" + glob
+		}
+		re, err := globToRegexp(strings.TrimPrefix(glob, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", InvalidSparsePattern, text, err)
+		}
+		p.re = re
+		sparse.patterns = append(sparse.patterns, p)
+	}
+	return sparse, nil
+}
+
+// match is the verdict of the last pattern matching name, ok is false when
+// none does
+func (s *SparsePatterns) match(name string, isDir bool) (included, ok bool) {
+	for i := len(s.patterns) - 1; i >= 0; i-- {
+		p := s.patterns[i]
+		if (isDir || !p.dirOnly) && p.re.MatchString(name) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
+
+// Includes tells whether the file at name, relative to the root, is written
+// to the worktree. Its directories are matched first, from the root down,
+// the deepest verdict wins so that including a directory includes its files
+// unless a pattern for them says otherwise.
+func (s *SparsePatterns) Includes(name string) bool {
+	if s == nil {
+		return true
+	}
+	included := false
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		if verdict, ok := s.match(strings.Join(parts[:i+1], "/"), i < len(parts)-1); ok {
+			included = verdict
+		}
+	}
+	return included
+}
+
+// Patterns returns the patterns as they were written
+func (s *SparsePatterns) Patterns() []string {
+	patterns := []string{}
+	for _, p := range s.patterns {
+		patterns = append(patterns, p.text)
+	}
+	return patterns
+}
+
+func (s *SparsePatterns) String() string {
+	lines := strings.Builder{}
+	for _, p := range s.patterns {
+		lines.WriteString(p.text + "\n")
+	}
+	return lines.String()
+}
+
+func sparseCheckoutFile() string {
+	return gitPath("info", "sparse-checkout")
+}
+
+// LoadSparseCheckout returns the patterns of info/sparse-checkout, nil when
+// core.sparseCheckout is not set or the file is missing
+func LoadSparseCheckout() (*SparsePatterns, error) {
+	cfg, err := LoadConfig(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := cfg.GetBool("core.sparsecheckout", false)
+	if err != nil || !enabled {
+		return nil, err
+	}
+	data, err := os.ReadFile(sparseCheckoutFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSparsePatterns(data)
+}
+
+// SetSparseCheckout enables sparse checkout with patterns, nil patterns
+// disable it, then updates the worktree to match
+func SetSparseCheckout(patterns []string) error {
+	if patterns != nil {
+		if err := os.MkdirAll(gitPath("info"), 0o755); err != nil {
+			return err
+		}
+		data := strings.Join(patterns, "\n") + "\n"
+		if _, err := ParseSparsePatterns([]byte(data)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(sparseCheckoutFile(), []byte(data), 0o644); err != nil {
+			return err
+		}
+	}
+	if err := SetConfig(gitPath("config"), "core.sparseCheckout", fmt.Sprint(patterns != nil)); err != nil {
+		return err
+	}
+	return ReapplySparseCheckout()
+}
+
+// ReapplySparseCheckout writes the staged files the patterns include and
+// removes the others, marking them skip-worktree in the index so that add
+// and write-tree keep them. A file with local changes is left in place and
+// stays tracked. Without an index, the one of HEAD is written.
+func ReapplySparseCheckout() error {
+	sparse, err := LoadSparseCheckout()
+	if err != nil {
+		return err
+	}
+	idx, err := sparseIndex()
+	if err != nil {
+		return err
+	}
+
+	for i := range idx.Entries {
+		e := &idx.Entries[i]
+		// unmerged paths and submodules are left alone
+		if e.Stage() != 0 || e.Mode == 0o160000 {
+			continue
+		}
+		info, err := os.Lstat(e.Path)
+		exists := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		included := sparse.Includes(e.Path)
+		switch {
+		case included && !exists:
+			if err := writeWorktreeFile(e.Path, e.entry()); err != nil {
+				return err
+			}
+		case !included && exists:
+			sha, err := hashWorktreeFile(e.Path, info)
+			if err != nil {
+				return err
+			}
+			if sha != e.Hash {
+				Infof("warning: %s has local changes and was left despite the sparse patterns\n", e.Path)
+				included = true
+				break
+			}
+			if err := removeWorktreeFile(e.Path); err != nil {
+				return err
+			}
+		}
+		if included {
+			e.ExtendedFlags &^= IndexSkipWorktree
+		} else {
+			e.ExtendedFlags |= IndexSkipWorktree
+		}
+	}
+	return idx.Write()
+}
+
+// sparseIndex reads the index, or stages the files of HEAD when there is
+// none yet
+func sparseIndex() (*Index, error) {
+	if HasIndex() {
+		return ReadIndex()
+	}
+	head, err := ResolveRef("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	files, err := FlattenTree(head)
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{Version: 2}
+	for name, e := range files {
+		mode, err := strconv.ParseUint(e.mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: mode %q of %s", InvalidTree, e.mode, name)
+		}
+		idx.Entries = append(idx.Entries, IndexEntry{Mode: uint32(mode), Hash: e.hash, Path: name})
+	}
+	slices.SortFunc(idx.Entries, func(a, b IndexEntry) int { return strings.Compare(a.Path, b.Path) })
+	return idx, nil
+}
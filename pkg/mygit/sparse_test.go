@@ -0,0 +1,159 @@
+package mygit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSparsePatternsIncludes(t *testing.T) {
+	sparse, err := ParseSparsePatterns([]byte("# comment\n/*\n!/*/\n/docs/\n!/docs/drafts/\n*.md\n/données/\n/[é]t?/\n\\!bang\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"top":                   true,
+		"src/main.go":           false,
+		"docs/guide.txt":        true,
+		"docs/drafts/guide.txt": false,
+		"docs/drafts/readme.md": true,
+		"src/readme.md":         true,
+		"données/fichier":       true,
+		"donnees/fichier":       false,
+		"été/fichier":           true,
+		"eta/fichier":           false,
+		"src/!bang":             true,
+	} {
+		if got := sparse.Includes(name); got != want {
+			t.Errorf("%s included: %v, want %v", name, got, want)
+		}
+	}
+	if got := fmt.Sprint(sparse.Patterns()); got != `[/* !/*/ /docs/ !/docs/drafts/ *.md /données/ /[é]t?/ \!bang]` {
+		t.Errorf("the patterns are %s", got)
+	}
+	var all *SparsePatterns
+	if !all.Includes("anything/at/all") {
+		t.Error("no patterns left a file out")
+	}
+}
+
+// stagedFlags maps the staged paths to whether they are skip-worktree
+func stagedFlags(t *testing.T) map[string]bool {
+	t.Helper()
+	idx, err := ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := map[string]bool{}
+	for _, e := range idx.Entries {
+		flags[e.Path] = e.ExtendedFlags&IndexSkipWorktree != 0
+	}
+	return flags
+}
+
+func TestSparseCheckoutSkipWorktree(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"a/x": "x\n", "a/é": "é\n", "b/y": "y\n", "top": "top\n"})
+	commit := commitWorktree(t, "first")
+	tree := treeOf(t, commit)
+	if _, err := runCommand(t, "add", "."); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCommand(t, "sparse-checkout", "set", "/a/"); err != nil {
+		t.Fatal(err)
+	}
+	files := readWorktree(t, "a/x", "a/é", "b/y", "top")
+	if files["a/x"] != "x\n" || files["a/é"] != "é\n" || files["b/y"] != "" || files["top"] != "" {
+		t.Errorf("the sparse worktree is %q", files)
+	}
+	if got := fmt.Sprint(stagedFlags(t)); got != "map[a/x:false a/é:false b/y:true top:true]" {
+		t.Errorf("the index is %s", got)
+	}
+
+	// the files left out stay staged
+	if _, err := runCommand(t, "add", "."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "add", "*"); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCommand(t, "write-tree"); err != nil || output != tree+"\n" {
+		t.Errorf("write-tree printed %q, %v, want %s", output, err, tree)
+	}
+	if _, err := runCommand(t, "add", "b/y"); err == nil {
+		t.Error("a file outside of the sparse checkout was staged")
+	}
+	output, err := runCommand(t, "diff")
+	if err != nil || output != "" {
+		t.Errorf("diff printed %q, %v", output, err)
+	}
+
+	// a file with local changes is left and stays tracked
+	writeFiles(t, map[string]string{"a/x": "changed\n"})
+	if _, err := runCommand(t, "sparse-checkout", "set", "/b/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(stagedFlags(t)); got != "map[a/x:false a/é:true b/y:false top:true]" {
+		t.Errorf("the index is %s", got)
+	}
+	if err := os.WriteFile("a/x", []byte("x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCommand(t, "sparse-checkout", "disable"); err != nil {
+		t.Fatal(err)
+	}
+	files = readWorktree(t, "a/x", "a/é", "b/y", "top")
+	if files["a/x"] != "x\n" || files["a/é"] != "é\n" || files["b/y"] != "y\n" || files["top"] != "top\n" {
+		t.Errorf("the worktree after disable is %q", files)
+	}
+	if got := fmt.Sprint(stagedFlags(t)); got != "map[a/x:false a/é:false b/y:false top:false]" {
+		t.Errorf("the index after disable is %s", got)
+	}
+}
+
+func TestSparseCheckoutWithoutIndex(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"a/x": "x\n", "b/y": "y\n"})
+	tree := treeOf(t, commitWorktree(t, "first"))
+	if _, err := runCommand(t, "sparse-checkout", "set", "/a/"); err != nil {
+		t.Fatal(err)
+	}
+	// the index is HEAD's, with what was left out marked
+	if got := fmt.Sprint(stagedFlags(t)); got != "map[a/x:false b/y:true]" {
+		t.Errorf("the index is %s", got)
+	}
+	if output, err := runCommand(t, "write-tree"); err != nil || output != tree+"\n" {
+		t.Errorf("write-tree printed %q, %v, want %s", output, err, tree)
+	}
+	if output, err := runCommand(t, "sparse-checkout", "list"); err != nil || output != "/a/\n" {
+		t.Errorf("list printed %q, %v", output, err)
+	}
+}
+
+func TestSparseCheckoutRejectsInvalidPatterns(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"a/x": "x\n"})
+	commitWorktree(t, "first")
+
+	if _, err := runCommand(t, "sparse-checkout", "set", "/a/", "[z-a]"); !errors.Is(err, InvalidSparsePattern) {
+		t.Errorf("set an invalid pattern: %v", err)
+	}
+	if _, err := os.Stat(sparseCheckoutFile()); !os.IsNotExist(err) {
+		t.Errorf("the patterns were written: %v", err)
+	}
+	if sparse, err := LoadSparseCheckout(); sparse != nil || err != nil {
+		t.Errorf("sparse checkout enabled with %v, %v", sparse, err)
+	}
+
+	// one written by hand is reported when read
+	writeFiles(t, map[string]string{".git/info/sparse-checkout": "/a/\n[z-a]\n"})
+	if err := SetConfig(gitPath("config"), "core.sparseCheckout", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSparseCheckout(); !errors.Is(err, InvalidSparsePattern) {
+		t.Errorf("read an invalid pattern: %v", err)
+	}
+}
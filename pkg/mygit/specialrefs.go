@@ -0,0 +1,114 @@
+package mygit
+
+// https://git-scm.com/docs/gitrevisions#Documentation/gitrevisions.txt-emFETCHHEADem
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// FetchHeadEntry is a line of FETCH_HEAD, one ref the last fetch got
+type FetchHeadEntry struct {
+	Hash        string
+	NotForMerge bool   // only the branches the current one merges from are for merge
+	Description string // like "branch 'master' of <url>"
+}
+
+func (e FetchHeadEntry) String() string {
+	flag := ""
+	if e.NotForMerge {
+		flag = "not-for-merge"
+	}
+	return e.Hash + "\t" + flag + "\t" + e.Description
+}
+
+// ReadFetchHead parses FETCH_HEAD, a missing file lists nothing
+func ReadFetchHead() ([]FetchHeadEntry, error) {
+	lines, err := readSpecialRef("FETCH_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	entries := []FetchHeadEntry{}
+	for _, line := range lines {
+		hash, rest, _ := strings.Cut(line, "\t")
+		flag, description, found := strings.Cut(rest, "\t")
+		if !isHash(hash) || !found || (flag != "" && flag != "not-for-merge") {
+			return nil, fmt.Errorf("%w: FETCH_HEAD line %q", InvalidRef, line)
+		}
+		entries = append(entries, FetchHeadEntry{Hash: hash, NotForMerge: flag != "", Description: description})
+	}
+	return entries, nil
+}
+
+// WriteFetchHead replaces FETCH_HEAD, git lists the refs for merge first
+// since FETCH_HEAD alone names the first line
+func WriteFetchHead(entries []FetchHeadEntry) error {
+	var b strings.Builder
+	for _, forMerge := range []bool{true, false} {
+		for _, e := range entries {
+			if e.NotForMerge != forMerge {
+				b.WriteString(e.String() + "\n")
+			}
+		}
+	}
+	return os.WriteFile(gitPath("FETCH_HEAD"), []byte(b.String()), 0o644)
+}
+
+// fetchHeadURL is how git shows url in FETCH_HEAD: without credentials,
+// trailing slashes or .git
+func fetchHeadURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.User != nil {
+		u.User = nil
+		raw = u.String()
+	}
+	raw = strings.TrimRight(raw, "/")
+	return strings.TrimSuffix(raw, ".git")
+}
+
+// ReadMergeHeads lists the commits MERGE_HEAD holds, the other parents of
+// the merge in progress. No merge is in progress without the file.
+func ReadMergeHeads() ([]string, error) {
+	lines, err := readSpecialRef("MERGE_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if !isHash(line) {
+			return nil, fmt.Errorf("%w: MERGE_HEAD line %q", InvalidRef, line)
+		}
+	}
+	return lines, nil
+}
+
+// WriteMergeHeads records the commits merged into HEAD by a merge waiting
+// to be committed, none removes MERGE_HEAD once the merge is done
+func WriteMergeHeads(shas []string) error {
+	if len(shas) == 0 {
+		return removeSpecialRef("MERGE_HEAD")
+	}
+	return os.WriteFile(gitPath("MERGE_HEAD"), []byte(strings.Join(shas, "\n")+"\n"), 0o644)
+}
+
+func readSpecialRef(name string) ([]string, error) {
+	content, err := os.ReadFile(gitPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+	return lines, nil
+}
+
+func removeSpecialRef(name string) error {
+	if err := os.Remove(gitPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
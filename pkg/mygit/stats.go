@@ -0,0 +1,122 @@
+package mygit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RepoStats summarizes a repository
+type RepoStats struct {
+	Head     string `json:"head"`   // empty while the current branch has no commit
+	Branch   string `json:"branch"` // empty when HEAD is detached
+	Branches int    `json:"branches"`
+	Tags     int    `json:"tags"`
+	Commits  int    `json:"commits"` // reachable from HEAD and the refs
+	Objects  struct {
+		Commits int `json:"commits"`
+		Trees   int `json:"trees"`
+		Blobs   int `json:"blobs"`
+		Tags    int `json:"tags"`
+		Total   int `json:"total"`
+		Loose   int `json:"loose"`
+		Packed  int `json:"packed"` // an object in several packs counts once
+	} `json:"objects"`
+}
+
+// CollectRepoStats counts the refs and the objects of the repository, every
+// object being typed from its header only
+func CollectRepoStats() (stats RepoStats, _ error) {
+	if target, ok, err := ReadSymbolicRef("HEAD"); err != nil {
+		return stats, err
+	} else if ok {
+		stats.Branch = strings.TrimPrefix(target, "refs/heads/")
+	}
+	tips := []string{}
+	if head, err := readRef("HEAD"); err == nil {
+		stats.Head = head
+		tips = append(tips, head)
+	}
+
+	refs, err := ListRefs()
+	if err != nil {
+		return stats, err
+	}
+	for _, ref := range refs {
+		switch {
+		case strings.HasPrefix(ref.Name, "refs/heads/"):
+			stats.Branches++
+		case strings.HasPrefix(ref.Name, "refs/tags/"):
+			stats.Tags++
+		}
+		tips = append(tips, ref.Hash)
+	}
+
+	loose, err := looseObjects()
+	if err != nil {
+		return stats, err
+	}
+	stats.Objects.Loose = len(loose)
+	packed, err := packedObjects()
+	if err != nil {
+		return stats, err
+	}
+	distinct := map[[20]byte]bool{}
+	for _, sha := range packed {
+		distinct[sha] = true
+	}
+	stats.Objects.Packed = len(distinct)
+
+	all, err := AllObjects()
+	if err != nil {
+		return stats, err
+	}
+	kinds := map[string]ObjectKind{}
+	for _, sha := range all {
+		kind, _, err := ObjectInfo(sha)
+		if err != nil {
+			return stats, fmt.Errorf("%s: %w", sha, err)
+		}
+		kinds[sha] = kind
+		switch kind {
+		case CommitKind:
+			stats.Objects.Commits++
+		case TreeKind:
+			stats.Objects.Trees++
+		case BlobKind:
+			stats.Objects.Blobs++
+		case TagKind:
+			stats.Objects.Tags++
+		}
+	}
+	stats.Objects.Total = len(all)
+
+	reachable, err := ReachableObjects(tips)
+	if err != nil {
+		return stats, err
+	}
+	for _, sha := range reachable {
+		if kinds[sha] == CommitKind {
+			stats.Commits++
+		}
+	}
+	return stats, nil
+}
+
+func (s RepoStats) Write(w io.Writer) error {
+	head := s.Head
+	switch {
+	case head == "":
+		head = "none, " + s.Branch + " has no commit"
+	case s.Branch != "":
+		head += " (" + s.Branch + ")"
+	default:
+		head += " (detached)"
+	}
+	_, err := fmt.Fprintf(w, "head:     %s\nbranches: %d\ntags:     %d\ncommits:  %d\n"+
+		"objects:  %d (%d commits, %d trees, %d blobs, %d tags)\nloose:    %d\npacked:   %d\n",
+		head, s.Branches, s.Tags, s.Commits,
+		s.Objects.Total, s.Objects.Commits, s.Objects.Trees, s.Objects.Blobs, s.Objects.Tags,
+		s.Objects.Loose, s.Objects.Packed)
+	return err
+}
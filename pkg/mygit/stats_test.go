@@ -0,0 +1,70 @@
+package mygit
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRepoStatsMatchesGit(t *testing.T) {
+	testRepository(t)
+	packedHistory(t, 10)
+	runGit(t, "tag", "-a", "-m", "annotated", "v1", "HEAD~3")
+	runGit(t, "branch", "side", "HEAD~1")
+	// loose objects next to the pack
+	writeFiles(t, map[string]string{"loose": "loose\n"})
+	runGit(t, "add", "loose")
+	runGit(t, "commit", "-q", "-m", "loose")
+
+	output, err := runCommand(t, "--json", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats RepoStats
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		t.Fatalf("%v in\n%s", err, output)
+	}
+
+	count := func(args ...string) int {
+		t.Helper()
+		output := runGit(t, args...)
+		if output == "" {
+			return 0
+		}
+		return len(strings.Split(output, "\n"))
+	}
+	countObjects := map[string]int{}
+	for _, line := range strings.Split(runGit(t, "count-objects", "-v"), "\n") {
+		name, value, _ := strings.Cut(line, ": ")
+		countObjects[name], _ = strconv.Atoi(value)
+	}
+	kinds := map[string]int{}
+	for _, kind := range strings.Fields(runGit(t, "cat-file", "--batch-all-objects", "--batch-check=%(objecttype)")) {
+		kinds[kind]++
+	}
+	commits, _ := strconv.Atoi(runGit(t, "rev-list", "--all", "--count"))
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"head", stats.Head, runGit(t, "rev-parse", "HEAD")},
+		{"branch", stats.Branch, runGit(t, "branch", "--show-current")},
+		{"branches", stats.Branches, count("for-each-ref", "refs/heads")},
+		{"tags", stats.Tags, count("for-each-ref", "refs/tags")},
+		{"commits", stats.Commits, commits},
+		{"objects", stats.Objects.Total, count("cat-file", "--batch-all-objects", "--batch-check")},
+		{"commit objects", stats.Objects.Commits, kinds["commit"]},
+		{"trees", stats.Objects.Trees, kinds["tree"]},
+		{"blobs", stats.Objects.Blobs, kinds["blob"]},
+		{"tag objects", stats.Objects.Tags, kinds["tag"]},
+		{"loose", stats.Objects.Loose, countObjects["count"]},
+		{"packed", stats.Objects.Packed, countObjects["in-pack"]},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: stats has %v, git %v", check.name, check.got, check.want)
+		}
+	}
+}
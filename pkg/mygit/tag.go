@@ -0,0 +1,81 @@
+package mygit
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	InvalidTag = errors.New("File at path cannot be parsed into a tag.")
+)
+
+type Tag struct {
+	content []byte
+}
+
+func (t *Tag) Kind() ObjectKind {
+	return TagKind
+}
+
+func (t *Tag) Content() []byte {
+	return t.content
+}
+
+func (t *Tag) String() string {
+	return string(t.content)
+}
+
+// header returns the value of the first header line starting with name
+func (t *Tag) header(name string) string {
+	headers, _, _ := strings.Cut(string(t.content), "\n\n")
+	for _, line := range strings.Split(headers, "\n") {
+		if value, found := strings.CutPrefix(line, name+" "); found {
+			return value
+		}
+	}
+	return ""
+}
+
+// Object returns the sha of the tagged object
+func (t *Tag) Object() (string, error) {
+	sha := t.header("object")
+	if !isHash(sha) {
+		return "", InvalidTag
+	}
+	return sha, nil
+}
+
+func (t *Tag) TargetKind() ObjectKind {
+	return ObjectKind(t.header("type"))
+}
+
+func (t *Tag) Name() string {
+	return t.header("tag")
+}
+
+func (t *Tag) Message() string {
+	_, message, _ := strings.Cut(string(t.content), "\n\n")
+	return message
+}
+
+func (t *Tag) Subject() string {
+	return messageSubject(t.Message())
+}
+
+// PeelObject follows annotated tags starting at sha until it reaches an
+// object that is not a tag, returning it with its sha
+func PeelObject(sha string) (string, GitObject, error) {
+	for {
+		gitObj, err := ReadGitObject(sha)
+		if err != nil {
+			return "", nil, err
+		}
+		tag, ok := gitObj.(*Tag)
+		if !ok {
+			return sha, gitObj, nil
+		}
+		if sha, err = tag.Object(); err != nil {
+			return "", nil, err
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package mygit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	RefLocked   = errors.New("Reference is locked by another process")
+	RefMismatch = errors.New("Reference does not have the expected value")
+)
+
+type refUpdate struct {
+	name   string
+	hash   string // new value, empty with target for a symbolic ref
+	target string // ref a symbolic ref points to
+	old    string // expected value, empty for any and zeroHash for a missing ref
+	delete bool
+	verify bool // only check old, the ref is left untouched
+}
+
+// RefTransaction queues updates to refs and applies them all or none. Like
+// git, every ref is locked by creating <ref>.lock exclusively before the
+// expected old values are checked, the new values are written to the lock
+// files which are then renamed over the refs. A failure undoes the renames
+// already made.
+//
+// Refs are updated as named, symbolic refs like HEAD are not followed.
+type RefTransaction struct {
+	updates []refUpdate
+}
+
+// Update sets name to hash, when old is not empty the ref must hold it, or
+// not exist when it is zeroHash
+func (t *RefTransaction) Update(name, hash, old string) {
+	t.updates = append(t.updates, refUpdate{name: name, hash: hash, old: old})
+}
+
+// Create adds name, which must not exist
+func (t *RefTransaction) Create(name, hash string) {
+	t.Update(name, hash, zeroHash)
+}
+
+// Delete removes name, loose or packed, checking old like Update
+func (t *RefTransaction) Delete(name, old string) {
+	t.updates = append(t.updates, refUpdate{name: name, old: old, delete: true})
+}
+
+// Verify checks that name holds old while the transaction commits
+func (t *RefTransaction) Verify(name, old string) {
+	t.updates = append(t.updates, refUpdate{name: name, old: old, verify: true})
+}
+
+// UpdateSymbolic points the symbolic ref name to target
+func (t *RefTransaction) UpdateSymbolic(name, target string) {
+	t.updates = append(t.updates, refUpdate{name: name, target: target})
+}
+
+// lockedRef is an update whose ref is locked, with what the ref held before
+// so that it can be restored
+type lockedRef struct {
+	refUpdate
+	lock    string
+	before  []byte // loose file content, nil when there was none
+	applied bool
+}
+
+// Commit applies the updates, on error none of them is visible
+func (t *RefTransaction) Commit() error {
+	updates := append([]refUpdate{}, t.updates...)
+	// always locking in the same order keeps two transactions from each
+	// waiting on a lock the other holds
+	sort.SliceStable(updates, func(i, j int) bool { return updates[i].name < updates[j].name })
+	for i := 1; i < len(updates); i++ {
+		if updates[i].name == updates[i-1].name {
+			return fmt.Errorf("%w: multiple updates for %s", InvalidRef, updates[i].name)
+		}
+	}
+
+	locked := []*lockedRef{}
+	defer func() {
+		for _, ref := range locked {
+			os.Remove(ref.lock) // gone already once renamed
+		}
+	}()
+	deleted := map[string]bool{}
+	for _, u := range updates {
+		if err := checkRefName(u.name); err != nil {
+			return err
+		}
+		ref, err := lockRef(u)
+		if err != nil {
+			return err
+		}
+		locked = append(locked, ref)
+		if err := ref.check(); err != nil {
+			return err
+		}
+		if u.delete {
+			deleted[u.name] = true
+		}
+	}
+
+	// packed copies of deleted refs go first, the only file not restored
+	// by a rollback is then the one that failed to change
+	restorePacked, err := deletePackedRefs(deleted)
+	if err != nil {
+		return err
+	}
+	for _, ref := range locked {
+		if err := ref.apply(); err != nil {
+			errs := []error{err}
+			for _, done := range locked {
+				if done.applied {
+					errs = append(errs, done.restore())
+				}
+			}
+			errs = append(errs, restorePacked())
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+// checkRefName rejects the names git would not accept, those that could
+// escape the repository or collide with lock files among them
+func checkRefName(name string) error {
+	if name != "HEAD" && !strings.HasPrefix(name, "refs/") && !strings.HasSuffix(name, "_HEAD") {
+		return fmt.Errorf("%w: %s is not under refs/", InvalidRef, name)
+	}
+	_, err := CheckRefFormat(name, RefNameOptions{AllowOneLevel: true})
+	return err
+}
+
+func lockRef(u refUpdate) (*lockedRef, error) {
+	ref := &lockedRef{refUpdate: u, lock: gitPath(u.name) + ".lock"}
+	if err := os.MkdirAll(path.Dir(ref.lock), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(ref.lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s exists, remove it if no other process is running", RefLocked, ref.lock)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content := ""
+	switch {
+	case u.target != "":
+		content = "ref: " + u.target + "\n"
+	case u.hash != "":
+		content = u.hash + "\n"
+	}
+	_, err = file.WriteString(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(ref.lock)
+		return nil, err
+	}
+
+	ref.before, err = os.ReadFile(gitPath(u.name))
+	if err != nil && !os.IsNotExist(err) {
+		os.Remove(ref.lock)
+		return nil, err
+	}
+	return ref, nil
+}
+
+// check compares the ref with its expected value, it must be locked
+func (ref *lockedRef) check() error {
+	if ref.old == "" {
+		return nil
+	}
+	current := ""
+	if ref.before != nil {
+		current = strings.TrimSpace(string(ref.before))
+	} else if strings.HasPrefix(ref.name, "refs/") {
+		hash, err := readPackedRef(ref.name)
+		if err != nil && err != RefNotFound {
+			return err
+		}
+		current = hash
+	}
+
+	switch {
+	case ref.old == zeroHash && current != "":
+		return fmt.Errorf("%w: %s already exists", RefMismatch, ref.name)
+	case ref.old != zeroHash && current != ref.old:
+		if current == "" {
+			current = "missing"
+		}
+		return fmt.Errorf("%w: %s is %s but expected %s", RefMismatch, ref.name, current, ref.old)
+	}
+	return nil
+}
+
+func (ref *lockedRef) apply() error {
+	switch {
+	case ref.verify:
+		return nil
+	case ref.delete:
+		if err := os.Remove(gitPath(ref.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// the lock would keep its directory from being empty
+		os.Remove(ref.lock)
+		removeEmptyRefDirs(ref.name)
+	default:
+		if err := os.Rename(ref.lock, gitPath(ref.name)); err != nil {
+			return err
+		}
+	}
+	ref.applied = true
+	return nil
+}
+
+// restore puts back the loose ref as it was before apply
+func (ref *lockedRef) restore() error {
+	if ref.before == nil {
+		err := os.Remove(gitPath(ref.name))
+		removeEmptyRefDirs(ref.name)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(gitPath(ref.name)), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(gitPath(ref.name), ref.before, 0o644)
+}
+
+// removeEmptyRefDirs drops the directories under refs/<kind>/ a deleted ref
+// leaves empty
+func removeEmptyRefDirs(name string) {
+	for dir := path.Dir(name); strings.Count(dir, "/") >= 2; dir = path.Dir(dir) {
+		if os.Remove(gitPath(dir)) != nil {
+			return
+		}
+	}
+}
+
+// deletePackedRefs rewrites packed-refs without the names, under its own
+// lock, and returns how to put the previous file back
+func deletePackedRefs(names map[string]bool) (restore func() error, _ error) {
+	noop := func() error { return nil }
+	before, err := os.ReadFile(gitPath("packed-refs"))
+	if os.IsNotExist(err) || len(names) == 0 {
+		return noop, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a "^<sha>" line peels the tag above it and goes with it
+	kept, dropping, changed := bytes.Buffer{}, false, false
+	scanner := bufio.NewScanner(bytes.NewReader(before))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "^") && dropping {
+			continue
+		}
+		_, name, _ := strings.Cut(line, " ")
+		dropping = line != "" && line[0] != '#' && line[0] != '^' && names[name]
+		if dropping {
+			changed = true
+			continue
+		}
+		kept.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !changed {
+		return noop, nil
+	}
+
+	lock := gitPath("packed-refs.lock")
+	file, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s exists, remove it if no other process is running", RefLocked, lock)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(lock)
+	_, err = file.Write(kept.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(lock, gitPath("packed-refs"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return os.WriteFile(gitPath("packed-refs"), before, 0o644)
+	}, nil
+}
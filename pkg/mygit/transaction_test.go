@@ -0,0 +1,129 @@
+package mygit
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readRefFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(gitPath(name))
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestRefTransaction(t *testing.T) {
+	testRepository(t)
+
+	create := RefTransaction{}
+	create.Create("refs/heads/main", shaA)
+	create.Create("refs/tags/release/v1", shaA)
+	create.UpdateSymbolic("HEAD", "refs/heads/main")
+	if err := create.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRefFile(t, "refs/heads/main"); got != shaA {
+		t.Errorf("main = %q, want %s", got, shaA)
+	}
+	if got := readRefFile(t, "HEAD"); got != "ref: refs/heads/main" {
+		t.Errorf("HEAD = %q", got)
+	}
+
+	again := RefTransaction{}
+	again.Create("refs/heads/main", shaB)
+	if err := again.Commit(); !errors.Is(err, RefMismatch) {
+		t.Errorf("creating an existing ref: %v, want RefMismatch", err)
+	}
+
+	update := RefTransaction{}
+	update.Update("refs/heads/main", shaB, shaA)
+	update.Delete("refs/tags/release/v1", shaA)
+	update.Verify("HEAD", "ref: refs/heads/main")
+	if err := update.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRefFile(t, "refs/heads/main"); got != shaB {
+		t.Errorf("main = %q, want %s", got, shaB)
+	}
+	if _, err := os.Stat(gitPath("refs/tags/release")); !os.IsNotExist(err) {
+		t.Errorf("the empty refs/tags/release/ directory was left behind: %v", err)
+	}
+	if got := readRefFile(t, "refs/heads/main.lock"); got != "" {
+		t.Errorf("a lock was left behind: %q", got)
+	}
+}
+
+func TestRefTransactionAllOrNothing(t *testing.T) {
+	testRepository(t)
+	if err := UpdateRef("refs/heads/main", shaA); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second update fails its check after the first locked its ref
+	t1 := RefTransaction{}
+	t1.Create("refs/heads/new", shaB)
+	t1.Update("refs/heads/main", shaB, shaB)
+	if err := t1.Commit(); !errors.Is(err, RefMismatch) {
+		t.Fatalf("commit: %v, want RefMismatch", err)
+	}
+	if got := readRefFile(t, "refs/heads/new"); got != "" {
+		t.Errorf("new was created by a failed transaction: %q", got)
+	}
+	if got := readRefFile(t, "refs/heads/main"); got != shaA {
+		t.Errorf("main = %q, want %s", got, shaA)
+	}
+
+	// a held lock fails the whole transaction
+	if err := os.WriteFile(gitPath("refs/heads/main.lock"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t2 := RefTransaction{}
+	t2.Update("refs/heads/main", shaB, "")
+	t2.Create("refs/heads/other", shaB)
+	if err := t2.Commit(); !errors.Is(err, RefLocked) {
+		t.Errorf("commit: %v, want RefLocked", err)
+	}
+	if got := readRefFile(t, "refs/heads/other"); got != "" {
+		t.Errorf("other was created by a failed transaction: %q", got)
+	}
+
+	t3 := RefTransaction{}
+	t3.Update("refs/heads/x", shaA, "")
+	t3.Delete("refs/heads/x", "")
+	if err := t3.Commit(); !errors.Is(err, InvalidRef) {
+		t.Errorf("two updates of a ref: %v, want InvalidRef", err)
+	}
+}
+
+func TestRefTransactionPackedRefs(t *testing.T) {
+	testRepository(t)
+	packed := "# pack-refs with: peeled fully-peeled sorted \n" +
+		shaA + " refs/heads/packed\n" +
+		shaA + " refs/tags/annotated\n^" + shaB + "\n"
+	if err := os.WriteFile(gitPath("packed-refs"), []byte(packed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	create := RefTransaction{}
+	create.Create("refs/heads/packed", shaB)
+	if err := create.Commit(); !errors.Is(err, RefMismatch) {
+		t.Errorf("creating a packed ref: %v, want RefMismatch", err)
+	}
+
+	del := RefTransaction{}
+	del.Delete("refs/tags/annotated", shaA)
+	if err := del.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	want := "# pack-refs with: peeled fully-peeled sorted \n" + shaA + " refs/heads/packed\n"
+	if got, _ := os.ReadFile(gitPath("packed-refs")); string(got) != want {
+		t.Errorf("packed-refs:\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package mygit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration // doubled after every failed attempt
+	MaxDelay    time.Duration // Retry-After included
+	Sleep       func(context.Context, time.Duration) error
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    8 * time.Second,
+	Sleep:       sleepContext,
+}
+
+// sleepContext waits for d, returning early when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// every request to a remote goes through this client
+var httpClient = NewHTTPClient(DefaultRetryPolicy)
+
+func NewHTTPClient(policy RetryPolicy) *http.Client {
+	return &http.Client{
+		Transport: &retryTransport{policy: policy, next: http.DefaultTransport},
+	}
+}
+
+// retryTransport retries network errors and transient status codes
+type retryTransport struct {
+	policy RetryPolicy
+	next   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		try := req
+		if attempt > 1 && req.Body != nil {
+			if req.GetBody == nil { // the body cannot be replayed
+				return t.next.RoundTrip(req)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(try)
+		if (err == nil && !isTransientStatus(resp.StatusCode)) || attempt >= t.policy.MaxAttempts {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if resp != nil {
+			// a server asking for a longer wait must not stall the clone
+			if after, ok := retryAfter(resp); ok {
+				delay = min(after, t.policy.MaxDelay)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := t.policy.Sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Retry-After holds either a number of seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(when); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+package mygit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyHandler fails the first failures requests of each method with status,
+// asking to retry after retryAfter when it is set, then lets next answer
+type flakyHandler struct {
+	next       http.Handler
+	failures   int
+	status     int
+	retryAfter string
+
+	mu     sync.Mutex
+	failed map[string]int
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	fail := h.failed[r.Method] < h.failures
+	h.failed[r.Method]++
+	h.mu.Unlock()
+	if fail {
+		if h.retryAfter != "" {
+			w.Header().Set("Retry-After", h.retryAfter)
+		}
+		http.Error(w, "try again", h.status)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// useRetryPolicy sends the requests of the test through a client retrying
+// with policy, whose sleeps are recorded instead of waited
+func useRetryPolicy(t *testing.T, policy RetryPolicy) *[]time.Duration {
+	t.Helper()
+	sleeps := &[]time.Duration{}
+	mu := sync.Mutex{}
+	policy.Sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		*sleeps = append(*sleeps, d)
+		return ctx.Err()
+	}
+	saved := httpClient
+	httpClient = NewHTTPClient(policy)
+	t.Cleanup(func() { httpClient = saved })
+	return sleeps
+}
+
+func TestCloneRetriesTransientFailures(t *testing.T) {
+	remote := newFixtureRemote(t)
+	for _, test := range []struct {
+		status     int
+		retryAfter string
+		sleeps     string
+	}{
+		{http.StatusBadGateway, "", "[1s 2s 1s 2s]"},
+		{http.StatusServiceUnavailable, "1", "[1s 1s 1s 1s]"},
+		// a longer wait than the policy allows is cut short
+		{http.StatusTooManyRequests, "3600", "[5s 5s 5s 5s]"},
+		{http.StatusTooManyRequests, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), "[5s 5s 5s 5s]"},
+	} {
+		t.Run(fmt.Sprint(test.status, test.retryAfter != ""), func(t *testing.T) {
+			sleeps := useRetryPolicy(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+			server := httptest.NewServer(&flakyHandler{
+				next: remote, failures: 2, status: test.status, retryAfter: test.retryAfter, failed: map[string]int{},
+			})
+			defer server.Close()
+
+			result := cloneFixture(t, CloneOptions{URL: server.URL + "/repo.git"})
+			if result.Head != remote.refs[0].Hash {
+				t.Errorf("cloned %s, want %s", result.Head, remote.refs[0].Hash)
+			}
+			if fmt.Sprint(*sleeps) != test.sleeps {
+				t.Errorf("slept %v, want %s", *sleeps, test.sleeps)
+			}
+		})
+	}
+}
+
+func TestCloneGivesUpAfterMaxAttempts(t *testing.T) {
+	remote := newFixtureRemote(t)
+	sleeps := useRetryPolicy(t, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	server := httptest.NewServer(&flakyHandler{next: remote, failures: 3, status: http.StatusInternalServerError, failed: map[string]int{}})
+	defer server.Close()
+
+	_, err := Clone(context.Background(), CloneOptions{URL: server.URL + "/repo.git", Dir: t.TempDir() + "/clone"})
+	if err == nil {
+		t.Fatal("the clone succeeded")
+	}
+	if len(*sleeps) != 2 {
+		t.Errorf("slept %v between 3 attempts", *sleeps)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 500 * time.Millisecond, MaxDelay: 3 * time.Second}
+	got := []time.Duration{}
+	for attempt := 1; attempt <= 5; attempt++ {
+		got = append(got, policy.backoff(attempt))
+	}
+	if fmt.Sprint(got) != "[500ms 1s 2s 3s 3s]" {
+		t.Errorf("backoff %v", got)
+	}
+}
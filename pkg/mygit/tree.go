@@ -0,0 +1,390 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	InvalidTree = errors.New("File at path cannot be parsed into a tree.")
+)
+
+type Tree struct {
+	content []byte
+}
+
+type entry struct {
+	mode string
+	kind ObjectKind
+	hash string
+	name string
+}
+
+func (e *entry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s", e.mode, e.kind, e.hash, e.name)
+}
+
+func (t *Tree) Kind() ObjectKind {
+	return TreeKind
+}
+func (t *Tree) Content() []byte {
+	return t.content
+}
+
+func toType(mode []byte) (string, ObjectKind) {
+	if bytes.HasPrefix(mode, []byte("40000")) { // directory
+		return "040000", TreeKind
+	} else if bytes.HasPrefix(mode, []byte("100644")) { // regular file
+		return "100644", BlobKind
+	} else if bytes.HasPrefix(mode, []byte("100755")) { // executable
+		return "100755", BlobKind
+	} else if bytes.HasPrefix(mode, []byte("120000")) { // link
+		return "120000", BlobKind
+	} else if bytes.HasPrefix(mode, []byte("160000")) { // gitlink, a submodule commit
+		return "160000", CommitKind
+	} else {
+		return "", ""
+	}
+}
+
+// Entries parses the tree, a malformed entry stops the parsing with an
+// InvalidTree error giving its offset, the entries before it are returned.
+func (t *Tree) Entries() ([]entry, error) {
+	lines := []entry{}
+
+	for start, size := 0, len(t.content); start < size; {
+		// parse mode
+		line := entry{}
+		space := bytes.IndexByte(t.content[start:], ' ')
+		if space == -1 {
+			return lines, fmt.Errorf("%w: missing mode in entry at offset %d", InvalidTree, start)
+		}
+		line.mode, line.kind = toType(t.content[start : start+space])
+		nameStart := start + space + 1
+
+		// parse name
+		nul := bytes.IndexByte(t.content[nameStart:], 0)
+		if nul == -1 {
+			return lines, fmt.Errorf("%w: unterminated name in entry at offset %d", InvalidTree, start)
+		}
+		line.name = string(t.content[nameStart : nameStart+nul])
+		hashStart := nameStart + nul + 1
+
+		// parse hash
+		if hashStart+20 > size {
+			return lines, fmt.Errorf("%w: truncated hash in entry at offset %d", InvalidTree, start)
+		}
+		line.hash = fmt.Sprintf("%x", t.content[hashStart:hashStart+20])
+		start = hashStart + 20
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+type TreeFormat struct {
+	NameOnly  bool
+	Zero      bool // end each entry with NUL instead of a newline, names are never quoted
+	QuotePath bool // also quote bytes above 0x7f, like core.quotepath
+}
+
+func (t *Tree) Format(format TreeFormat) (string, error) {
+	lines, err := t.Entries()
+
+	terminator := "\n"
+	if format.Zero {
+		terminator = "\x00"
+	}
+	formatted := strings.Builder{}
+	for _, line := range lines {
+		if !format.Zero {
+			line.name = QuotePath(line.name, format.QuotePath)
+		}
+		if format.NameOnly {
+			formatted.WriteString(line.name)
+		} else {
+			formatted.WriteString(line.String())
+		}
+		formatted.WriteString(terminator)
+	}
+	return formatted.String(), err
+}
+
+// QuotePath quotes name C-style like git when it holds control characters,
+// double quotes or backslashes, and bytes above 0x7f when quoteHigh is set.
+// Other names are returned untouched.
+func QuotePath(name string, quoteHigh bool) string {
+	needsQuote := func(b byte) bool {
+		return b < 0x20 || b == '"' || b == '\\' || b == 0x7f || (quoteHigh && b >= 0x80)
+	}
+	quote := false
+	for i := 0; i < len(name); i++ {
+		if needsQuote(name[i]) {
+			quote = true
+			break
+		}
+	}
+	if !quote {
+		return name
+	}
+
+	quoted := strings.Builder{}
+	quoted.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if !needsQuote(b) {
+			quoted.WriteByte(b)
+			continue
+		}
+		switch b {
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\v':
+			quoted.WriteString(`\v`)
+		case '\f':
+			quoted.WriteString(`\f`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '"', '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(b)
+		default:
+			fmt.Fprintf(&quoted, "\\%03o", b)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// String shows the entries that could be parsed
+func (t *Tree) String() string {
+	formatted, _ := t.Format(TreeFormat{})
+	return formatted
+}
+
+// ReadTreeish reads the tree at sha, peeling commits to their root tree
+func ReadTreeish(sha string) (*Tree, error) {
+	gitObj, err := ReadGitObject(sha)
+	if err != nil {
+		return nil, err
+	}
+
+	if commit, ok := gitObj.(*CommitAsBytes); ok {
+		treeSha, err := commit.TreeHash()
+		if err != nil {
+			return nil, err
+		}
+		if gitObj, err = ReadGitObject(treeSha); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, ok := gitObj.(*Tree)
+	if !ok {
+		return nil, InvalidTree
+	}
+	return tree, nil
+}
+
+// BuildTreeFromDir hashes dir recursively, objects are only written when write
+// is set. The files of a directory are hashed by Jobs() workers. Cancelling
+// ctx stops it between two entries.
+func BuildTreeFromDir(ctx context.Context, dir string, write bool) (_ *Tree, nilSha [20]byte, _ error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nilSha, err
+	}
+
+	type treeLine struct {
+		mode string
+		name string
+		sha  [20]byte
+	}
+	lines := []treeLine{}
+	files := []int{} // lines of regular files, hashed by parallel workers once listed
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nilSha, err
+		}
+		var sha [20]byte
+
+		next := path.Join(dir, entry.Name())
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil, nilSha, infoErr
+		}
+		mode := info.Mode()
+
+		filetype := ""
+		if entry.IsDir() { // tree
+			filetype = "40000"
+			var subtree *Tree
+			subtree, sha, err = BuildTreeFromDir(ctx, next, write)
+			// git does not track directories, one without files is left
+			// out, a .gitkeep file is the usual way to keep it
+			if err == nil && len(subtree.Content()) == 0 {
+				continue
+			}
+		} else if mode & fs.ModeSymlink != 0 { // symlink, stored as the path it points to
+			filetype = "120000"
+			target, linkErr := os.Readlink(next)
+			if linkErr != nil {
+				return nil, nilSha, linkErr
+			}
+			sha, err = storeObject(&Blob{content: []byte(target)}, write)
+		} else if mode.IsRegular() { // obj
+			filetype = "100644"
+			if mode.Perm() & 0o111 != 0 { // --x--x--x // executable
+				filetype = "100755"
+			}
+			files = append(files, len(lines))
+		} else { // unknown
+			return nil, nilSha, InvalidBlob
+		}
+		if err != nil {
+			return nil, nilSha, err
+		}
+		lines = append(lines, treeLine{mode: filetype, name: entry.Name(), sha: sha})
+	}
+	err = forEachParallel(ctx, len(files), func(i int) (err error) {
+		line := &lines[files[i]]
+		line.sha, err = HashFile(path.Join(dir, line.name), write)
+		return err
+	})
+	if err != nil {
+		return nil, nilSha, err
+	}
+
+	// git sorts directories as if their name ended with a slash
+	sortKey := func(line treeLine) string {
+		if line.mode == "40000" {
+			return line.name + "/"
+		}
+		return line.name
+	}
+	sort.Slice(lines, func(i, j int) bool { return sortKey(lines[i]) < sortKey(lines[j]) })
+
+	content := bytes.Buffer{}
+	for _, line := range lines {
+		content.WriteString(line.mode + " " + line.name)
+		content.WriteByte(0)
+		content.Write(line.sha[:])
+	}
+
+	tree := &Tree{
+		content: content.Bytes(),
+	}
+	sha, err := storeObject(tree, write)
+	if err != nil {
+		return nil, nilSha, err
+	}
+	if write {
+		Verbosef("wrote tree %x for %s\n", sha, dir)
+	}
+
+	return tree, sha, nil
+} 
+
+// FlattenTree lists every non-tree entry reachable from the tree-ish at sha,
+// keyed and named by its path from the root
+func FlattenTree(sha string) (map[string]entry, error) {
+	files := map[string]entry{}
+	var walk func(sha, prefix string) error
+	walk = func(sha, prefix string) error {
+		tree, err := ReadTreeish(sha)
+		if err != nil {
+			return err
+		}
+		entries, err := tree.Entries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			e.name = path.Join(prefix, e.name)
+			if e.kind == TreeKind {
+				if err := walk(e.hash, e.name); err != nil {
+					return err
+				}
+				continue
+			}
+			files[e.name] = e
+		}
+		return nil
+	}
+	return files, walk(sha, "")
+}
+
+// WriteTreeFromFiles is the inverse of FlattenTree, it builds the nested
+// trees holding files and returns the sha of the root one, objects are only
+// written when write is set
+func WriteTreeFromFiles(files map[string]entry, write bool) (nilSha [20]byte, _ error) {
+	blobs := map[string]entry{}
+	subdirs := map[string]map[string]entry{}
+	for name, e := range files {
+		dir, rest, found := strings.Cut(name, "/")
+		if !found {
+			blobs[name] = e
+			continue
+		}
+		if subdirs[dir] == nil {
+			subdirs[dir] = map[string]entry{}
+		}
+		subdirs[dir][rest] = e
+	}
+
+	type treeLine struct {
+		mode string
+		name string
+		hash [20]byte
+	}
+	lines := []treeLine{}
+	for name, e := range blobs {
+		line := treeLine{mode: e.mode, name: name}
+		if _, err := hex.Decode(line.hash[:], []byte(e.hash)); err != nil {
+			return nilSha, fmt.Errorf("%w: bad sha for %s", InvalidTree, e.name)
+		}
+		lines = append(lines, line)
+	}
+	for name, subfiles := range subdirs {
+		hash, err := WriteTreeFromFiles(subfiles, write)
+		if err != nil {
+			return nilSha, err
+		}
+		lines = append(lines, treeLine{mode: "40000", name: name, hash: hash})
+	}
+
+	// git sorts directories as if their name ended with a slash
+	sortKey := func(line treeLine) string {
+		if line.mode == "40000" {
+			return line.name + "/"
+		}
+		return line.name
+	}
+	sort.Slice(lines, func(i, j int) bool { return sortKey(lines[i]) < sortKey(lines[j]) })
+
+	content := bytes.Buffer{}
+	for _, line := range lines {
+		content.WriteString(line.mode + " " + line.name)
+		content.WriteByte(0)
+		content.Write(line.hash[:])
+	}
+	return storeObject(&Tree{content: content.Bytes()}, write)
+}
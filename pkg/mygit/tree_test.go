@@ -0,0 +1,300 @@
+package mygit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTreeEntriesMalformed(t *testing.T) {
+	id := bytes.Repeat([]byte{0xab}, 20)
+	first := append([]byte("100644 file\x00"), id...)
+	second := append([]byte("40000 dir\x00"), id...)
+	whole := append(append([]byte{}, first...), second...)
+
+	entries, err := (&Tree{content: whole}).Entries()
+	if err != nil || len(entries) != 2 || entries[1].name != "dir" || entries[1].kind != TreeKind {
+		t.Fatalf("parsed %v, %v", entries, err)
+	}
+
+	for _, test := range []struct {
+		content []byte
+		message string
+		parsed  int // the entries before the bad one are still returned
+	}{
+		{whole[:len(whole)-1], "truncated hash in entry at offset 32", 1},
+		{whole[:len(first)+7], "unterminated name in entry at offset 32", 1},
+		{append(append([]byte{}, first...), "100644"...), "missing mode in entry at offset 32", 1},
+		{[]byte("100644 file"), "unterminated name in entry at offset 0", 0},
+		{[]byte("100644 file\x00abc"), "truncated hash in entry at offset 0", 0},
+	} {
+		tree := &Tree{content: test.content}
+		entries, err := tree.Entries()
+		if !errors.Is(err, InvalidTree) || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q: %v, want %q", test.content, err, test.message)
+		}
+		if len(entries) != test.parsed {
+			t.Errorf("%q: parsed %d entries, want %d", test.content, len(entries), test.parsed)
+		}
+		if _, err := tree.Format(TreeFormat{}); !errors.Is(err, InvalidTree) {
+			t.Errorf("formatted %q: %v", test.content, err)
+		}
+	}
+}
+
+func TestListNulTerminated(t *testing.T) {
+	testRepository(t)
+	names := []string{"plain", "with space", "new\nline", "tab\there", "quote\"d"}
+	files := map[string]string{}
+	for _, name := range names {
+		files[name] = name + "\n"
+	}
+	writeFiles(t, files)
+	commit := commitWorktree(t, "odd names")
+	if _, err := runCommand(t, append([]string{"add"}, names...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	// sorted like git, verbatim between the NULs
+	sorted := "new\nline\x00plain\x00quote\"d\x00tab\there\x00with space\x00"
+	if output, err := runCommand(t, "ls-tree", "--name-only", "-z", commit); err != nil || output != sorted {
+		t.Errorf("ls-tree --name-only -z printed %q, %v, want %q", output, err, sorted)
+	}
+	if output, err := runCommand(t, "ls-files", "-z"); err != nil || output != sorted {
+		t.Errorf("ls-files -z printed %q, %v, want %q", output, err, sorted)
+	}
+
+	output, err := runCommand(t, "ls-tree", "-z", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	if len(records) != len(names) {
+		t.Fatalf("ls-tree -z printed %d records: %q", len(records), output)
+	}
+	for _, record := range records {
+		_, name, _ := strings.Cut(record, "\t")
+		if want := "100644 blob " + blobSha(name+"\n") + "\t" + name; record != want {
+			t.Errorf("ls-tree -z printed %q, want %q", record, want)
+		}
+	}
+
+	// without -z the unusual names are quoted, one per line
+	if output, err := runCommand(t, "ls-tree", "--name-only", commit); err != nil || output != "\"new\\nline\"\nplain\n\"quote\\\"d\"\n\"tab\\there\"\nwith space\n" {
+		t.Errorf("ls-tree --name-only printed %q, %v", output, err)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	for _, test := range []struct {
+		name, quoted, quotedHigh string
+	}{
+		{"plain", "plain", "plain"},
+		{"with space", "with space", "with space"},
+		{"tab\there", `"tab\there"`, `"tab\there"`},
+		{"back\\slash", `"back\\slash"`, `"back\\slash"`},
+		{"bell\a\x7f", `"bell\a\177"`, `"bell\a\177"`},
+		{"café", "café", `"caf\303\251"`},
+	} {
+		if got := QuotePath(test.name, false); got != test.quoted {
+			t.Errorf("%q quoted to %s, want %s", test.name, got, test.quoted)
+		}
+		if got := QuotePath(test.name, true); got != test.quotedHigh {
+			t.Errorf("%q quoted with high bytes to %s, want %s", test.name, got, test.quotedHigh)
+		}
+	}
+}
+
+func TestListTreeQuotePathConfig(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{"café": "utf-8\n", "tab\there": "tab\n"})
+	commit := commitWorktree(t, "quoted names")
+
+	if output, err := runCommand(t, "ls-tree", "--name-only", commit); err != nil || output != "\"caf\\303\\251\"\n\"tab\\there\"\n" {
+		t.Errorf("ls-tree printed %q, %v", output, err)
+	}
+	if err := os.WriteFile(gitPath("config"), []byte("[core]\n\tquotepath = false\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := runCommand(t, "ls-tree", "--name-only", commit); err != nil || output != "café\n\"tab\\there\"\n" {
+		t.Errorf("ls-tree with core.quotepath=false printed %q, %v", output, err)
+	}
+}
+
+// cancelAfter is a context cancelled once its Err was checked n times, to
+// stop an operation in the middle
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestBuildTreeFromDirCancelled(t *testing.T) {
+	testRepository(t)
+	files := map[string]string{}
+	for i := range 20 {
+		files[fmt.Sprintf("dir%d/file", i)] = fmt.Sprintf("file %d\n", i)
+	}
+	writeFiles(t, files)
+
+	_, _, err := BuildTreeFromDir(&cancelAfter{Context: context.Background(), n: 10}, ".", true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled build: %v", err)
+	}
+	// it stopped where it was, the objects of the rest were never written
+	written := objectFiles(t)
+	if written == 0 || written >= 40 {
+		t.Errorf("the cancelled build wrote %d of the 41 objects", written)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := BuildTreeFromDir(ctx, ".", false); !errors.Is(err, context.Canceled) {
+		t.Errorf("build with a cancelled context: %v", err)
+	}
+}
+
+func TestCheckoutCancelled(t *testing.T) {
+	testRepository(t)
+	files := map[string]string{}
+	for i := range 20 {
+		files[fmt.Sprintf("dir%d/file", i)] = fmt.Sprintf("file %d\n", i)
+	}
+	writeFiles(t, files)
+	commit := commitWorktree(t, "many directories")
+	worktree := t.TempDir()
+
+	err := Checkout(&cancelAfter{Context: context.Background(), n: 10}, worktree, commit)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled checkout: %v", err)
+	}
+	entries, err := os.ReadDir(worktree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) >= 20 {
+		t.Errorf("the cancelled checkout wrote %d of the 20 directories", len(entries))
+	}
+}
+
+func TestWriteTreeMatchesGit(t *testing.T) {
+	testRepository(t)
+	// names sorting differently as trees, an empty file, binary data, no
+	// trailing newline, an executable and a symlink
+	writeFiles(t, map[string]string{
+		"a/file":        "inside a\n",
+		"a/nested/file": "deeper\n",
+		"a-b/file":      "dash\n",
+		"a.b":           "dot\n",
+		"a-c":           "dash file\n",
+		"empty":         "",
+		"partial":       "no trailing newline",
+		"binary":        "bin\x00ary\r\n\x00\xffdata",
+		"script":        "#!/bin/sh\necho hi\n",
+	})
+	if err := os.Chmod("script", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a/file", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// without an index mygit hashes the worktree, git hashes its index
+	output, err := runCommand(t, "write-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	tree := runGit(t, "write-tree")
+	if strings.TrimSpace(output) != tree {
+		t.Errorf("write-tree printed %s, git wrote %s", output, tree)
+	}
+	matchGit(t, "write-tree")
+	_, content, err := readObject(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gitOutput(t, nil, "cat-file", "tree", tree); !bytes.Equal(content, want) {
+		t.Errorf("tree bytes\n%q\ngit wrote\n%q", content, want)
+	}
+	matchGit(t, "ls-tree", tree)
+	matchGit(t, "ls-tree", "--name-only", tree)
+}
+
+func TestWriteTreeEmptyDirsMatchGit(t *testing.T) {
+	testRepository(t)
+	writeFiles(t, map[string]string{
+		"file":          "tracked\n",
+		"kept/.gitkeep": "",
+	})
+	if err := os.MkdirAll("hollow/nested", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// git leaves out the empty directories, the .gitkeep keeps its own
+	output, err := runCommand(t, "write-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	tree := runGit(t, "write-tree")
+	if strings.TrimSpace(output) != tree {
+		t.Errorf("write-tree printed %s, git wrote %s", output, tree)
+	}
+	matchGit(t, "ls-tree", tree)
+	matchGit(t, "ls-tree", tree+":kept")
+}
+
+func TestManifestMatchesGit(t *testing.T) {
+	testRepository(t)
+	// "dir.txt" sorts before "dir/" like in the index, and a name git quotes
+	writeFiles(t, map[string]string{
+		"dir/sub/old": "old\n",
+		"dir/file":    "file\n",
+		"dir.txt":     "text\n",
+		"run.sh":      "#!/bin/sh\n",
+		"naïve":       "quoted\n",
+	})
+	if err := os.Chmod("run.sh", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir/sub/old", "link"); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "manifest")
+
+	output, err := runCommand(t, "manifest", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gitOutput(t, nil, "ls-tree", "-r", "--format=%(objectmode) %(objectname)%x09%(path)", "HEAD"); output != string(want) {
+		t.Errorf("manifest printed\n%s\ngit ls-tree printed\n%s", output, want)
+	}
+
+	// git quotes %(path) even with -z, its default format does not
+	output, err = runCommand(t, "manifest", "--null", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	for _, entry := range strings.SplitAfter(runGit(t, "ls-tree", "-r", "-z", "HEAD"), "\x00") {
+		if mode, rest, found := strings.Cut(entry, " "); found {
+			_, rest, _ = strings.Cut(rest, " ")
+			want.WriteString(mode + " " + rest)
+		}
+	}
+	if output != want.String() {
+		t.Errorf("manifest --null printed\n%q\ngit ls-tree -z printed\n%q", output, want.String())
+	}
+}